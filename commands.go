@@ -1,31 +1,112 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/raesene/pasgan/internal/distro"
 	"github.com/raesene/pasgan/internal/docker"
 	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/pkg/pasgan"
+	"github.com/raesene/pasgan/pkg/source"
+	"github.com/raesene/pasgan/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 var (
-	outputFile   string
-	outputFormat string
-	verbose      bool
+	outputFile       string
+	outputFormat     string
+	verbose          bool
+	redact           bool
+	verifyImage      bool
+	fingerprintsFile string
+	fromBoundary     string
+	timeout          time.Duration
+	maxExtractSize   string
+	maxFileSize      string
+	extractLimits    utils.Limits
+	// stopTimeout releases the timer of --timeout once the command is done
+	stopTimeout context.CancelFunc = func() {}
 )
 
 // Initialize all commands
 func initCommands() {
+	// Add the flags shared by all commands
+	addGlobalFlags()
+	
 	// Add version command
 	rootCmd.AddCommand(createVersionCmd())
 	
 	// Add analyze command
 	rootCmd.AddCommand(createAnalyzeCmd())
+	
+	// Add secrets command
+	rootCmd.AddCommand(createSecretsCmd())
+	
+	// Add lint command
+	rootCmd.AddCommand(createLintCmd())
+	
+	// Add diff command
+	rootCmd.AddCommand(createDiffCmd())
+	
+	// Add changelog command
+	rootCmd.AddCommand(createChangelogCmd())
+	
+	// Add export-rootfs command
+	rootCmd.AddCommand(createExportRootfsCmd())
+	
+	// Add blame command
+	rootCmd.AddCommand(createBlameCmd())
+	
+	// Add ls, cat and grep commands
+	rootCmd.AddCommand(createLsCmd())
+	rootCmd.AddCommand(createCatCmd())
+	rootCmd.AddCommand(createGrepCmd())
+	
+	// Add efficiency command
+	rootCmd.AddCommand(createEfficiencyCmd())
+	
+	// Add distro command
+	rootCmd.AddCommand(createDistroCmd())
+	
+	// Add verify command
+	rootCmd.AddCommand(createVerifyCmd())
+}
+
+// addGlobalFlags adds the timeout and extraction limit flags to every command
+func addGlobalFlags() {
+	flags := rootCmd.PersistentFlags()
+	flags.DurationVar(&timeout, "timeout", 0, "Stop the command after this long, e.g. 5m (default: no timeout)")
+	flags.StringVar(&maxExtractSize, "max-extract-size", "",
+		fmt.Sprintf("Largest total `size` extracted from an image archive, e.g. 10GiB, -1 for no limit (default %s)", utils.FormatSize(utils.DefaultLimits.MaxTotalSize)))
+	flags.StringVar(&maxFileSize, "max-file-size", "",
+		fmt.Sprintf("Largest `size` of a file extracted from an image archive, -1 for no limit (default %s)", utils.FormatSize(utils.DefaultLimits.MaxFileSize)))
+	flags.IntVar(&extractLimits.MaxEntries, "max-extract-entries", 0,
+		fmt.Sprintf("Most entries read from an image archive, -1 for no limit (default %d)", utils.DefaultLimits.MaxEntries))
+	
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		var err error
+		if maxExtractSize != "" {
+			if extractLimits.MaxTotalSize, err = utils.ParseSize(maxExtractSize); err != nil {
+				return fmt.Errorf("invalid --max-extract-size: %w", err)
+			}
+		}
+		if maxFileSize != "" {
+			if extractLimits.MaxFileSize, err = utils.ParseSize(maxFileSize); err != nil {
+				return fmt.Errorf("invalid --max-file-size: %w", err)
+			}
+		}
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			cmd.SetContext(ctx)
+			stopTimeout = cancel
+		}
+		return nil
+	}
 }
 
 // Create the version command
@@ -45,46 +126,83 @@ func createVersionCmd() *cobra.Command {
 // Create the analyze command
 func createAnalyzeCmd() *cobra.Command {
 	analyzeCmd := &cobra.Command{
-		Use:   "analyze [image_tar]",
+		Use:   "analyze [image | metadata_json...]",
 		Short: "Analyze a Docker image and generate a Dockerfile",
 		Long: `Analyze takes a saved Docker image (.tar file) and analyzes its structure
 to reconstruct a Dockerfile that could have been used to create it.
 
+The image may also be given as a URI naming where it is stored:
+docker-archive:image.tar, oci:layout_dir[:name], oci-archive:image.tar[:name],
+dir:extracted_dir or stdin: (also written -). A plain path is read as a
+docker save or OCI archive, or as a directory if it is one.
+
+Without the image, analyze also accepts the output of docker image inspect,
+docker history --no-trunc --format '{{json .}}', crane config or skopeo
+inspect --config. Give inspect and history output together to combine the
+config with the history. Layers and files are then not available.
+
 Example:
-  pasgan analyze nginx.tar`,
-		Args: cobra.ExactArgs(1),
+  pasgan analyze nginx.tar
+  pasgan analyze oci:./nginx-layout:1.25
+  docker save nginx | pasgan analyze -
+  pasgan analyze inspect.json history.json`,
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			imagePath := args[0]
 			
-			// Ensure the file exists
-			if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-				return fmt.Errorf("image file not found: %s", imagePath)
-			}
-			
-			// Get the absolute path
-			absPath, err := filepath.Abs(imagePath)
-			if err != nil {
-				return fmt.Errorf("failed to get absolute path: %w", err)
+			// Get the absolute path, unless the image is given as a source URI
+			absPath := imagePath
+			var err error
+			if scheme, _ := source.Split(imagePath); scheme == "" && imagePath != "-" {
+				absPath, err = filepath.Abs(imagePath)
+				if err != nil {
+					return fmt.Errorf("failed to get absolute path: %w", err)
+				}
 			}
 			
 			fmt.Printf("Analyzing Docker image: %s\n", absPath)
 			
-			// Create a parser for the image
-			parser, err := docker.NewParser(absPath)
+			options, err := analyzeOptions()
 			if err != nil {
-				return fmt.Errorf("failed to create parser: %w", err)
+				return err
 			}
-			defer parser.Cleanup()
 			
-			// Parse the image
-			metadata, err := parser.Parse()
-			if err != nil {
-				return fmt.Errorf("failed to parse image: %w", err)
+			// Analyze the image, or its metadata alone from JSON
+			var result *pasgan.Result
+			if isJSONFile(absPath) {
+				metadata, err := readMetadataFiles(args)
+				if err != nil {
+					return err
+				}
+				for _, warning := range metadataWarnings(metadata) {
+					fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+				}
+				result, err = pasgan.AnalyzeMetadata(cmd.Context(), metadata, options)
+				if err != nil {
+					return err
+				}
+			} else {
+				if len(args) > 1 {
+					return fmt.Errorf("only JSON metadata files can be combined, %s is an image archive", imagePath)
+				}
+				if err := checkImage(absPath); err != nil {
+					return err
+				}
+				result, err = pasgan.AnalyzeURI(cmd.Context(), absPath, options)
+				if err != nil {
+					return err
+				}
+			}
+			for _, warning := range result.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+			}
+			if report := result.Verification; report != nil {
+				fmt.Fprintf(os.Stderr, "Verified %d digests, %d blobs have none to check\n", report.Count(pasgan.VerifyOK), report.Count(pasgan.VerifyUnverified))
 			}
 			
 			// Print image info if verbose
 			if verbose {
-				printImageInfo(metadata)
+				printImageInfo(result)
 			}
 			
 			// Determine where to write the output
@@ -101,23 +219,26 @@ Example:
 			
 			// Generate the Dockerfile
 			if strings.ToLower(outputFormat) == "dockerfile" {
-				// Create a Dockerfile generator
-				generator := dockerfile.NewGenerator(metadata)
-				
-				// Generate the Dockerfile
-				if err := generator.Generate(out); err != nil {
+				if err := result.WriteDockerfile(out); err != nil {
 					return fmt.Errorf("failed to generate Dockerfile: %w", err)
 				}
 				
 				if outputFile != "" {
 					fmt.Printf("Dockerfile written to: %s\n", outputFile)
 				}
+			} else if strings.ToLower(outputFormat) == "native" {
+				// Write the configuration of the tool that built the image
+				if err := result.WriteNative(out); err != nil {
+					return fmt.Errorf("failed to write the build configuration: %w", err)
+				}
+				
+				if outputFile != "" {
+					fmt.Printf("%s configuration written to: %s\n", result.Builder.Name(), outputFile)
+				}
 			} else if strings.ToLower(outputFormat) == "json" {
 				// Output as JSON (for debugging or further processing)
-				encoder := json.NewEncoder(out)
-				encoder.SetIndent("", "  ")
-				if err := encoder.Encode(metadata); err != nil {
-					return fmt.Errorf("failed to encode metadata as JSON: %w", err)
+				if err := result.WriteJSON(out); err != nil {
+					return err
 				}
 				
 				if outputFile != "" {
@@ -133,14 +254,108 @@ Example:
 	
 	// Add flags to the analyze command
 	analyzeCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file for the Dockerfile (default: stdout)")
-	analyzeCmd.Flags().StringVarP(&outputFormat, "format", "f", "dockerfile", "Output format (dockerfile, json)")
+	analyzeCmd.Flags().StringVarP(&outputFormat, "format", "f", "dockerfile", "Output format (dockerfile, native, json)")
 	analyzeCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	analyzeCmd.Flags().StringVar(&eolDataFile, "eol-data", "", "JSON file with additional or updated end-of-life data")
+	analyzeCmd.Flags().StringVar(&fingerprintsFile, "fingerprints", "", "JSON file with additional or updated base image fingerprints")
+	analyzeCmd.Flags().StringVar(&fromBoundary, "from-boundary", "", "Omit the base image history up to a boundary: auto, a number of base layers, or the diffID of the last base layer")
+	analyzeCmd.Flags().BoolVar(&redact, "redact", false, "Mask detected secrets in the output")
+	analyzeCmd.Flags().BoolVar(&verifyImage, "verify", false, "Check every blob against its digest first, and fail if the image was tampered with or truncated")
 	
 	return analyzeCmd
 }
 
-// printImageInfo prints basic information about the parsed image
-func printImageInfo(metadata *docker.ImageMetadata) {
+// openImage checks that the image exists and parses it. The caller must call
+// Cleanup on the returned parser once it is done with the image.
+func openImage(ctx context.Context, imagePath string) (*docker.Parser, *docker.ImageMetadata, error) {
+	if err := checkImage(imagePath); err != nil {
+		return nil, nil, err
+	}
+	
+	// Create a parser for the image
+	parser, err := docker.NewParser(imagePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create parser: %w", err)
+	}
+	
+	// Parse the image
+	metadata, err := parser.ParseContext(ctx, source.Options{Limits: extractLimits})
+	if err != nil {
+		parser.Cleanup()
+		return nil, nil, fmt.Errorf("failed to parse image: %w", err)
+	}
+	for _, skipped := range parser.Skipped() {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s in the image archive: %s\n", skipped.Path, skipped.Reason)
+	}
+	
+	return parser, metadata, nil
+}
+
+// checkImage checks that the image file exists, when it is not a source URI
+// or standard input
+func checkImage(imagePath string) error {
+	scheme, _ := source.Split(imagePath)
+	if _, err := os.Stat(imagePath); scheme == "" && imagePath != "-" && os.IsNotExist(err) {
+		return fmt.Errorf("image file not found: %s", imagePath)
+	}
+	return nil
+}
+
+// analyzeOptions returns the analysis options set by flags, with the
+// end-of-life data and fingerprints extended from --eol-data and --fingerprints
+func analyzeOptions() (pasgan.Options, error) {
+	table := pasgan.DefaultEOLTable()
+	if eolDataFile != "" {
+		file, err := os.Open(eolDataFile)
+		if err != nil {
+			return pasgan.Options{}, fmt.Errorf("failed to open EOL data: %w", err)
+		}
+		defer file.Close()
+		extra, err := pasgan.LoadEOLTable(file)
+		if err != nil {
+			return pasgan.Options{}, err
+		}
+		table.Merge(extra)
+	}
+
+	database := pasgan.DefaultFingerprints()
+	if fingerprintsFile != "" {
+		file, err := os.Open(fingerprintsFile)
+		if err != nil {
+			return pasgan.Options{}, fmt.Errorf("failed to open fingerprints: %w", err)
+		}
+		defer file.Close()
+		extra, err := pasgan.LoadFingerprints(file)
+		if err != nil {
+			return pasgan.Options{}, err
+		}
+		database.Merge(extra)
+	}
+
+	return pasgan.Options{
+		FromBoundary: fromBoundary,
+		Redact:       redact,
+		EOLTable:     table,
+		Fingerprints: database,
+		Limits:       pasgan.Limits(extractLimits),
+		Verify:       verifyImage,
+	}, nil
+}
+
+// layerInstruction returns the instruction that created layer i, or an empty
+// string if the history does not cover it
+func layerInstruction(metadata *docker.ImageMetadata, i int) string {
+	indexes := metadata.LayerHistoryIndexes()
+	if i < 0 || i >= len(indexes) || indexes[i] < 0 {
+		return ""
+	}
+	return dockerfile.HistoryInstruction(metadata.History[indexes[i]].CreatedBy)
+}
+
+// printImageInfo prints basic information about the parsed image, along with
+// the distribution, base image and builder found for the generator
+func printImageInfo(result *pasgan.Result) {
+	metadata := result.Metadata
 	fmt.Println("Image Information:")
 	fmt.Println("==================")
 	
@@ -149,12 +364,47 @@ func printImageInfo(metadata *docker.ImageMetadata) {
 		fmt.Printf("Repository Tags: %s\n", strings.Join(metadata.RepoTags, ", "))
 	}
 	
-	// Print image creation date
-	fmt.Printf("Created: %s\n", metadata.Created.Format(time.RFC3339))
+	// Flat filesystems have no creation date or config
+	if metadata.Flat {
+		fmt.Println("Format: flat filesystem (docker export), no image metadata")
+	} else {
+		fmt.Printf("Created: %s\n", metadata.Created.Format(time.RFC3339))
+	}
+	if metadata.Legacy {
+		fmt.Println("Format: legacy v1 docker save, history rebuilt from the layer parent chain")
+	}
 	
 	// Print architecture and OS
 	fmt.Printf("Architecture: %s, OS: %s\n", metadata.Architecture, metadata.OS)
 	
+	// Print the distribution and its support status
+	if result.Distro != nil {
+		fmt.Printf("Distribution: %s\n", result.Distro)
+		fmt.Printf("Support: %s\n", eolDescription((*distro.Status)(result.Support)))
+	}
+	
+	// Print the identified official base image
+	if match := result.Fingerprint; match != nil {
+		fmt.Printf("Base Image: %s (identified from %s)\n", match.Image, strings.Join(match.Evidence, ", "))
+	}
+	
+	// Print the tool that built the image
+	if b := result.Builder; b != nil && b.Kind != pasgan.BuilderDockerfile {
+		fmt.Printf("Builder: %s (%s)\n", b.Name(), strings.Join(b.Evidence, ", "))
+		if b.Buildpacks != nil {
+			name := "<image>"
+			if len(metadata.RepoTags) > 0 {
+				name = metadata.RepoTags[0]
+			}
+			fmt.Printf("Rebuild: %s\n", b.Buildpacks.PackCommand(name))
+		}
+	}
+	
+	// Print the layers made by docker commit
+	for _, c := range result.Commits {
+		fmt.Printf("Committed Layer %d: %s\n", c.LayerIndex, c.Summary())
+	}
+	
 	// Print exposed ports
 	if len(metadata.Config.ExposedPorts) > 0 {
 		var ports []string
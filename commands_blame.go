@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/raesene/pasgan/internal/blame"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var blameJSON bool
+
+// Create the blame command
+func createBlameCmd() *cobra.Command {
+	blameCmd := &cobra.Command{
+		Use:   "blame [image_tar] [path]",
+		Short: "Show which layers created, modified or deleted a path",
+		Long: `Blame walks every layer of an image and reports each layer that created,
+modified or deleted the given path, with the reconstructed instruction, history
+timestamp and any size, mode, ownership or content changes.
+
+The path may be a glob: "*" and "?" match within a directory and "**" matches
+across directories. Quote patterns so the shell does not expand them.
+
+Example:
+  pasgan blame image.tar /usr/local/bin/foo
+  pasgan blame image.tar '/etc/**/*.conf' --json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pattern, err := blame.Compile(args[1])
+			if err != nil {
+				return err
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			// Instructions are reported, so mask any secrets they contain
+			trails, err := blame.Blame(parser, secrets.NewScanner().Redact(metadata), pattern)
+			if err != nil {
+				return fmt.Errorf("failed to read layers: %w", err)
+			}
+
+			if blameJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(trails); err != nil {
+					return fmt.Errorf("failed to encode blame as JSON: %w", err)
+				}
+				return nil
+			}
+
+			if len(trails) == 0 {
+				fmt.Printf("No layer touches %s\n", args[1])
+				return nil
+			}
+			return blame.WriteText(os.Stdout, trails)
+		},
+	}
+
+	blameCmd.Flags().BoolVar(&blameJSON, "json", false, "Output the history of each path as JSON")
+
+	return blameCmd
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/packages"
+	"github.com/spf13/cobra"
+)
+
+var (
+	changelogFormat string
+	changelogOutput string
+)
+
+// changelogEntry is a package change with the instructions that caused it
+type changelogEntry struct {
+	packages.Change
+	OldInstruction string `json:"old_instruction,omitempty"`
+	NewInstruction string `json:"new_instruction,omitempty"`
+}
+
+// changelogReport is the JSON output of the changelog command
+type changelogReport struct {
+	OldTags  []string         `json:"old_tags"`
+	NewTags  []string         `json:"new_tags"`
+	Changes  []changelogEntry `json:"changes"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// Create the changelog command
+func createChangelogCmd() *cobra.Command {
+	changelogCmd := &cobra.Command{
+		Use:   "changelog [old_image_tar] [new_image_tar]",
+		Short: "List package changes between two image versions",
+		Long: `Changelog reads the OS package databases (dpkg, apk and rpm manifests) and
+language package manifests (npm, PyPI, RubyGems) of two images and lists the
+packages added, removed, upgraded and downgraded, with the layer responsible.
+
+Example:
+  pasgan changelog app-1.0.tar app-1.1.tar
+  pasgan changelog app-1.0.tar app-1.1.tar --format json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			oldParser, oldMetadata, oldInventory, err := loadInventory(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer oldParser.Cleanup()
+
+			newParser, newMetadata, newInventory, err := loadInventory(cmd.Context(), args[1])
+			if err != nil {
+				return err
+			}
+			defer newParser.Cleanup()
+
+			changes := packages.Changelog(oldInventory, newInventory)
+
+			var warnings []string
+			for _, warning := range oldInventory.Warnings {
+				warnings = append(warnings, "old image: "+warning)
+			}
+			for _, warning := range newInventory.Warnings {
+				warnings = append(warnings, "new image: "+warning)
+			}
+
+			// Determine where to write the output
+			out := os.Stdout
+			if changelogOutput != "" {
+				out, err = os.Create(changelogOutput)
+				if err != nil {
+					return fmt.Errorf("failed to create output file: %w", err)
+				}
+				defer out.Close()
+			}
+
+			switch strings.ToLower(changelogFormat) {
+			case "markdown", "md":
+				describe := func(metadata *docker.ImageMetadata) packages.LayerDescriber {
+					return func(i int) string {
+						if instruction := layerInstruction(metadata, i); instruction != "" {
+							return fmt.Sprintf("%d: `%s`", i, truncate(instruction, 60))
+						}
+						return fmt.Sprintf("%d", i)
+					}
+				}
+				title := fmt.Sprintf("Package changes: %s → %s", imageName(oldMetadata, args[0]), imageName(newMetadata, args[1]))
+				if err := packages.WriteMarkdown(out, title, changes, describe(oldMetadata), describe(newMetadata)); err != nil {
+					return err
+				}
+				for _, warning := range warnings {
+					fmt.Fprintf(out, "\n> **Warning:** %s\n", warning)
+				}
+			case "json":
+				report := changelogReport{
+					OldTags:  oldMetadata.RepoTags,
+					NewTags:  newMetadata.RepoTags,
+					Changes:  []changelogEntry{},
+					Warnings: warnings,
+				}
+				for _, change := range changes {
+					report.Changes = append(report.Changes, changelogEntry{
+						Change:         change,
+						OldInstruction: layerInstruction(oldMetadata, change.OldLayer),
+						NewInstruction: layerInstruction(newMetadata, change.NewLayer),
+					})
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return fmt.Errorf("failed to encode changelog as JSON: %w", err)
+				}
+			default:
+				return fmt.Errorf("unsupported output format: %s", changelogFormat)
+			}
+
+			if changelogOutput != "" {
+				fmt.Printf("Changelog written to: %s\n", changelogOutput)
+			}
+			return nil
+		},
+	}
+
+	changelogCmd.Flags().StringVarP(&changelogFormat, "format", "f", "markdown", "Output format (markdown, json)")
+	changelogCmd.Flags().StringVarP(&changelogOutput, "output", "o", "", "Output file for the changelog (default: stdout)")
+
+	return changelogCmd
+}
+
+// loadInventory parses an image and lists its installed packages
+func loadInventory(ctx context.Context, imagePath string) (*docker.Parser, *docker.ImageMetadata, *packages.Inventory, error) {
+	parser, metadata, err := openImage(ctx, imagePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	inventory, err := packages.Scan(parser, len(metadata.Layers))
+	if err != nil {
+		parser.Cleanup()
+		return nil, nil, nil, fmt.Errorf("failed to read packages from %s: %w", imagePath, err)
+	}
+
+	return parser, metadata, inventory, nil
+}
+
+// imageName returns the first tag of an image, or its file name if untagged
+func imageName(metadata *docker.ImageMetadata, imagePath string) string {
+	if len(metadata.RepoTags) > 0 {
+		return metadata.RepoTags[0]
+	}
+	return imagePath
+}
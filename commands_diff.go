@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/diff"
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFormat  string
+	diffVerbose bool
+	diffRedact  bool
+)
+
+// Create the diff command
+func createDiffCmd() *cobra.Command {
+	diffCmd := &cobra.Command{
+		Use:   "diff [old_image_tar] [new_image_tar]",
+		Short: "Compare two images instruction by instruction",
+		Long: `Diff reconstructs the instructions of two images and aligns them to show
+which base layers are shared, which instructions were added, removed or changed,
+how the runtime config differs and how layer sizes changed.
+
+Example:
+  pasgan diff app-1.0.tar app-1.1.tar
+  pasgan diff app-1.0.tar app-1.1.tar --format json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			oldParser, oldImage, err := loadDiffImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer oldParser.Cleanup()
+
+			newParser, newImage, err := loadDiffImage(cmd.Context(), args[1])
+			if err != nil {
+				return err
+			}
+			defer newParser.Cleanup()
+
+			report := diff.Compare(oldImage, newImage)
+
+			switch strings.ToLower(diffFormat) {
+			case "text":
+				return diff.WriteText(os.Stdout, report, diffVerbose)
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return fmt.Errorf("failed to encode diff as JSON: %w", err)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unsupported output format: %s", diffFormat)
+			}
+		},
+	}
+
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "f", "text", "Output format (text, json)")
+	diffCmd.Flags().BoolVarP(&diffVerbose, "verbose", "v", false, "Also list unchanged instructions")
+	diffCmd.Flags().BoolVar(&diffRedact, "redact", false, "Mask detected secrets in the output")
+
+	return diffCmd
+}
+
+// loadDiffImage parses an image and collects what diff needs to compare it
+func loadDiffImage(ctx context.Context, imagePath string) (*docker.Parser, diff.Image, error) {
+	parser, metadata, err := openImage(ctx, imagePath)
+	if err != nil {
+		return nil, diff.Image{}, err
+	}
+
+	sizes, err := layer.Sizes(parser, len(metadata.Layers))
+	if err != nil {
+		parser.Cleanup()
+		return nil, diff.Image{}, fmt.Errorf("failed to read layers of %s: %w", imagePath, err)
+	}
+
+	if diffRedact {
+		metadata = secrets.NewScanner().Redact(metadata)
+	}
+
+	return parser, diff.Image{
+		Metadata:     metadata,
+		Instructions: dockerfile.NewGenerator(metadata).Instructions(),
+		LayerSizes:   sizes,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/raesene/pasgan/internal/distro"
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	distroJSON  bool
+	eolDataFile string
+)
+
+// distroReport is the JSON output of the distro command
+type distroReport struct {
+	Release   *distro.Release `json:"release"`
+	Status    *distro.Status  `json:"status,omitempty"`
+	BaseImage string          `json:"base_image,omitempty"`
+}
+
+// Create the distro command
+func createDistroCmd() *cobra.Command {
+	distroCmd := &cobra.Command{
+		Use:   "distro [image_tar]",
+		Short: "Detect the Linux distribution and its end-of-life status",
+		Long: `Distro reads /etc/os-release and distribution specific files such as
+/etc/alpine-release, /etc/debian_version and /etc/redhat-release from the final
+filesystem of an image, and reports the distribution, version and codename.
+
+The release is compared to an embedded end-of-life table. Use --eol-data to
+add or correct entries with a JSON file in the same format.
+
+Example:
+  pasgan distro image.tar
+  pasgan distro image.tar --eol-data eol.json --json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			table, err := loadEOLTable()
+			if err != nil {
+				return err
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			release, err := detectDistro(parser, metadata)
+			if err != nil {
+				return err
+			}
+
+			report := distroReport{Release: release}
+			if release != nil {
+				if status, ok := table.Lookup(release, time.Now()); ok {
+					report.Status = &status
+				}
+				report.BaseImage = table.BaseImage(release)
+			}
+
+			if distroJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return fmt.Errorf("failed to encode distro as JSON: %w", err)
+				}
+				return nil
+			}
+
+			if release == nil {
+				fmt.Println("No Linux distribution detected")
+				return nil
+			}
+			fmt.Printf("Distribution: %s\n", release)
+			fmt.Printf("ID: %s, version: %s, codename: %s\n", release.ID, orUnknown(release.Version), orUnknown(release.Codename))
+			fmt.Printf("Detected from: %s\n", strings.Join(release.Sources, ", "))
+			fmt.Printf("Support: %s\n", eolDescription(report.Status))
+			if report.BaseImage != "" {
+				fmt.Printf("Official base image: %s\n", report.BaseImage)
+			}
+			return nil
+		},
+	}
+
+	distroCmd.Flags().BoolVar(&distroJSON, "json", false, "Output the release and EOL status as JSON")
+	distroCmd.Flags().StringVar(&eolDataFile, "eol-data", "", "JSON file with additional or updated end-of-life data")
+
+	return distroCmd
+}
+
+// loadEOLTable returns the embedded end-of-life table, merged with --eol-data if set
+func loadEOLTable() (distro.Table, error) {
+	table := distro.DefaultTable()
+	if eolDataFile == "" {
+		return table, nil
+	}
+
+	file, err := os.Open(eolDataFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EOL data: %w", err)
+	}
+	defer file.Close()
+
+	extra, err := distro.LoadTable(file)
+	if err != nil {
+		return nil, err
+	}
+	table.Merge(extra)
+	return table, nil
+}
+
+// detectDistro identifies the distribution in the final filesystem of an image
+func detectDistro(parser *docker.Parser, metadata *docker.ImageMetadata) (*distro.Release, error) {
+	view, err := layer.NewView(parser, len(metadata.Layers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layers: %w", err)
+	}
+	return distro.Detect(view), nil
+}
+
+// eolDescription describes a support status for humans
+func eolDescription(status *distro.Status) string {
+	switch {
+	case status == nil:
+		return "unknown (not in the EOL table)"
+	case status.EndOfLife:
+		return fmt.Sprintf("END OF LIFE since %s", status.EOL)
+	}
+	return fmt.Sprintf("supported until %s (%d days left)", status.EOL, status.DaysLeft)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/efficiency"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	efficiencyFormat    string
+	efficiencyTop       int
+	efficiencyFailUnder float64
+)
+
+// Create the efficiency command
+func createEfficiencyCmd() *cobra.Command {
+	efficiencyCmd := &cobra.Command{
+		Use:     "efficiency [image_tar]",
+		Aliases: []string{"waste"},
+		Short:   "Find space wasted on deleted, overwritten and duplicate files",
+		Long: `Efficiency compares the layers of an image to find files that are stored in
+one layer but deleted or overwritten by a later one, such as an apt cache removed
+in a separate RUN, and identical files added by several layers. It reports an
+efficiency score, the largest offenders with the instructions that created them,
+and suggestions such as merging RUN instructions or cleaning up caches.
+
+The score is the fraction of layer bytes that end up in the final filesystem,
+from 0 to 1, as printed in the text report and in the score field of the JSON
+report. Use --fail-under to fail CI when it drops below a budget.
+
+Example:
+  pasgan efficiency image.tar
+  pasgan efficiency image.tar --fail-under 0.95 --format json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if efficiencyFailUnder < 0 || efficiencyFailUnder > 1 {
+				return fmt.Errorf("--fail-under must be between 0 and 1")
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			// Instructions are reported, so mask any secrets they contain
+			report, err := efficiency.Analyze(parser, secrets.NewScanner().Redact(metadata))
+			if err != nil {
+				return fmt.Errorf("failed to read layers: %w", err)
+			}
+
+			switch strings.ToLower(efficiencyFormat) {
+			case "text":
+				err = efficiency.WriteText(os.Stdout, report, efficiencyTop)
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			default:
+				return fmt.Errorf("unsupported output format: %s", efficiencyFormat)
+			}
+			if err != nil {
+				return err
+			}
+
+			if report.Score < efficiencyFailUnder {
+				return fmt.Errorf("efficiency score %.3f is below %.3f", report.Score, efficiencyFailUnder)
+			}
+			return nil
+		},
+	}
+
+	efficiencyCmd.Flags().StringVarP(&efficiencyFormat, "format", "f", "text", "Output format (text, json)")
+	efficiencyCmd.Flags().IntVar(&efficiencyTop, "top", 10, "Number of wasted files to list in text output")
+	efficiencyCmd.Flags().Float64Var(&efficiencyFailUnder, "fail-under", 0, "Fail if the efficiency score, a fraction from 0 to 1, is below this value")
+
+	return efficiencyCmd
+}
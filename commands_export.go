@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportTar     string
+	exportVerbose bool
+)
+
+// Create the export-rootfs command
+func createExportRootfsCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export-rootfs [image_tar] [output_dir]",
+		Short: "Flatten an image into its final root filesystem",
+		Long: `Export-rootfs applies the layers of an image in order and writes the resulting
+root filesystem to a directory, or to a single tar archive with --tar. Whiteouts
+and opaque directories are honoured, and hardlinks, symlinks, modes, ownership
+and extended attributes are preserved. No container runtime is needed.
+
+Ownership, device nodes and some extended attributes can only be restored when
+running as root. Entries that cannot be restored are reported. The
+--max-extract-size, --max-file-size and --max-extract-entries limits also bound
+the root filesystem that is written.
+
+Example:
+  pasgan export-rootfs image.tar rootfs/
+  pasgan export-rootfs image.tar --tar rootfs.tar
+  pasgan export-rootfs image.tar --tar - | tar -tvf -`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if exportTar == "" && len(args) != 2 {
+				return fmt.Errorf("an output directory or --tar is required")
+			}
+			if exportTar != "" && len(args) == 2 {
+				return fmt.Errorf("use either an output directory or --tar, not both")
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			// Status goes to stderr so the archive can be written to stdout
+			status := os.Stderr
+			var result *layer.ApplyResult
+
+			if exportTar != "" {
+				var out io.Writer = os.Stdout
+				if exportTar != "-" {
+					file, err := os.Create(exportTar)
+					if err != nil {
+						return fmt.Errorf("failed to create output file: %w", err)
+					}
+					defer file.Close()
+					out = file
+				}
+
+				result, err = layer.WriteMerged(parser, len(metadata.Layers), out, extractLimits)
+				if err != nil {
+					return fmt.Errorf("failed to write root filesystem: %w", err)
+				}
+			} else {
+				if err := checkEmptyDir(args[1]); err != nil {
+					return err
+				}
+
+				result, err = layer.Apply(parser, len(metadata.Layers), args[1], extractLimits)
+				if err != nil {
+					return fmt.Errorf("failed to apply layers: %w", err)
+				}
+			}
+
+			fmt.Fprintf(status, "Applied %d layers: wrote %d files, %d directories, %d symlinks, %d hardlinks and %d devices, removed %d paths\n",
+				len(metadata.Layers), result.Files, result.Dirs, result.Symlinks, result.Hardlinks, result.Devices, result.Removed)
+			if !result.OwnershipPreserved {
+				fmt.Fprintln(status, "Note: not running as root, so file ownership was not preserved")
+			}
+			if len(result.Skipped) > 0 {
+				fmt.Fprintf(status, "Warning: %d entries could not be fully restored", len(result.Skipped))
+				if !exportVerbose {
+					fmt.Fprintln(status, " (use --verbose to list them)")
+				} else {
+					fmt.Fprintln(status, ":")
+					for _, skipped := range result.Skipped {
+						fmt.Fprintf(status, "  layer %d: %s: %s\n", skipped.Layer, skipped.Path, skipped.Reason)
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+
+	exportCmd.Flags().StringVar(&exportTar, "tar", "", "Write the root filesystem to a tar archive instead of a directory (- for stdout)")
+	exportCmd.Flags().BoolVarP(&exportVerbose, "verbose", "v", false, "List entries that could not be fully restored")
+
+	return exportCmd
+}
+
+// checkEmptyDir refuses to export into a directory that already has content,
+// since whiteouts would delete files that did not come from the image
+func checkEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read output directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output directory %s is not empty", dir)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// errNoMatch is returned by grep when no file matches
+var errNoMatch = errors.New("no matches found")
+
+var (
+	lsLayer       int
+	lsRecursive   bool
+	catLayer      int
+	grepLayer     int
+	grepIgnore    bool
+	grepFilesOnly bool
+	grepMaxSize   int64
+)
+
+// Create the ls command
+func createLsCmd() *cobra.Command {
+	lsCmd := &cobra.Command{
+		Use:   "ls [image_tar] [path]",
+		Short: "List files in the image or in a single layer",
+		Long: `Ls lists the files in the merged filesystem of an image, as a container
+would see it, or in a single layer with --layer. Each entry shows its mode,
+owner, size, modification time and the layer it comes from. In a single layer,
+files deleted by the layer are marked as whiteouts.
+
+Example:
+  pasgan ls image.tar /etc
+  pasgan ls image.tar --layer 2 -R /`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "/"
+			if len(args) == 2 {
+				dir = args[1]
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			view, err := openView(parser, metadata, lsLayer)
+			if err != nil {
+				return err
+			}
+
+			entry, ok := view.Lookup(dir)
+			if !ok {
+				return fmt.Errorf("%s: no such file or directory", dir)
+			}
+			if !entry.IsDir() {
+				printEntry(entry)
+				return nil
+			}
+			for _, entry := range view.List(dir, lsRecursive) {
+				printEntry(entry)
+			}
+			return nil
+		},
+	}
+
+	lsCmd.Flags().IntVar(&lsLayer, "layer", -1, "Only list the contents of this layer, or -1 for the merged view")
+	lsCmd.Flags().BoolVarP(&lsRecursive, "recursive", "R", false, "List subdirectories recursively")
+
+	return lsCmd
+}
+
+// Create the cat command
+func createCatCmd() *cobra.Command {
+	catCmd := &cobra.Command{
+		Use:   "cat [image_tar] [path]",
+		Short: "Print a file from the image or from a single layer",
+		Long: `Cat prints the contents of a file from the merged filesystem of an image,
+following symlinks as a container would, or from a single layer with --layer.
+The file is streamed from its layer blob.
+
+Example:
+  pasgan cat image.tar /etc/os-release
+  pasgan cat image.tar --layer 3 /app/config.yaml`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			view, err := openView(parser, metadata, catLayer)
+			if err != nil {
+				return err
+			}
+
+			entry, err := view.Resolve(args[1])
+			if err != nil {
+				return err
+			}
+			if entry.Deleted {
+				return fmt.Errorf("%s was deleted by layer %d", args[1], entry.Layer)
+			}
+			if entry.IsDir() {
+				return fmt.Errorf("%s is a directory", args[1])
+			}
+
+			rc, err := view.Open(entry)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			if _, err := io.Copy(os.Stdout, rc); err != nil {
+				return fmt.Errorf("failed to read %s: %w", args[1], err)
+			}
+			return nil
+		},
+	}
+
+	catCmd.Flags().IntVar(&catLayer, "layer", -1, "Read the file from this layer, or -1 for the merged view")
+
+	return catCmd
+}
+
+// Create the grep command
+func createGrepCmd() *cobra.Command {
+	grepCmd := &cobra.Command{
+		Use:   "grep [image_tar] [pattern] [path]",
+		Short: "Search file contents in the image or in a single layer",
+		Long: `Grep searches the regular files of the merged filesystem, or of a single
+layer with --layer, for lines matching a regular expression. Hardlinks are
+searched through their targets. An optional path limits the search to a
+directory. Each layer blob is read once. Like grep, the command fails when no
+file matches.
+
+Example:
+  pasgan grep image.tar 'PermitRootLogin' /etc
+  pasgan grep image.tar -i -l 'password' --layer 4`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			expr := args[1]
+			if grepIgnore {
+				expr = "(?i)" + expr
+			}
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+			dir := "/"
+			if len(args) == 3 {
+				dir = args[2]
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			view, err := openView(parser, metadata, grepLayer)
+			if err != nil {
+				return err
+			}
+
+			out := bufio.NewWriter(os.Stdout)
+			defer out.Flush()
+
+			// Hardlinks record no size, so the limit is applied while reading
+			matched := false
+			err = view.WalkFiles(dir, func(entry layer.Entry, r io.Reader) error {
+				if entry.Header.Size > grepMaxSize {
+					return nil
+				}
+				data, err := io.ReadAll(io.LimitReader(r, grepMaxSize+1))
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", entry.Path, err)
+				}
+				if int64(len(data)) > grepMaxSize {
+					return nil
+				}
+				if grepFile(out, pattern, entry.Path, data) {
+					matched = true
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// Like grep, finding nothing is a failure
+			if !matched {
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return errNoMatch
+			}
+			return nil
+		},
+	}
+
+	grepCmd.Flags().IntVar(&grepLayer, "layer", -1, "Only search this layer, or -1 for the merged view")
+	grepCmd.Flags().BoolVarP(&grepIgnore, "ignore-case", "i", false, "Ignore case when matching")
+	grepCmd.Flags().BoolVarP(&grepFilesOnly, "files-with-matches", "l", false, "Only print the names of matching files")
+	grepCmd.Flags().Int64Var(&grepMaxSize, "max-size", 10<<20, "Skip files larger than this many bytes")
+
+	return grepCmd
+}
+
+// openView returns the merged view of an image, or the view of a single layer
+// if layerIndex is not negative
+func openView(parser *docker.Parser, metadata *docker.ImageMetadata, layerIndex int) (*layer.View, error) {
+	if layerIndex < 0 {
+		view, err := layer.NewView(parser, len(metadata.Layers))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layers: %w", err)
+		}
+		return view, nil
+	}
+
+	if layerIndex >= len(metadata.Layers) {
+		return nil, fmt.Errorf("layer %d out of range, image has %d layers", layerIndex, len(metadata.Layers))
+	}
+	view, err := layer.NewLayerView(parser, layerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer: %w", err)
+	}
+	return view, nil
+}
+
+// printEntry prints a single ls line
+func printEntry(entry layer.Entry) {
+	if entry.Header == nil {
+		fmt.Printf("%-10s  %-11s  %10s  %-16s  %-3s  %s/\n", "d?????????", "-", "-", "-", "-", entry.Path)
+		return
+	}
+	if entry.Deleted {
+		fmt.Printf("%-10s  %-11s  %10s  %-16s  L%-2d  %s (whiteout)\n", "----------", "-", "-", "-", entry.Layer, entry.Path)
+		return
+	}
+
+	hdr := entry.Header
+	size := "-"
+	if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+		size = utils.FormatSize(hdr.Size)
+	}
+	mode := hdr.FileInfo().Mode().String()
+	name := entry.Path
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		name += "/"
+	case tar.TypeSymlink:
+		name += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		// Marked as tar tv marks hardlinks, rather than as a second regular file
+		mode = "h" + mode[1:]
+		name += " link to /" + strings.TrimPrefix(hdr.Linkname, "/")
+	}
+
+	fmt.Printf("%-10s  %-11s  %10s  %-16s  L%-2d  %s\n",
+		mode, fmt.Sprintf("%d:%d", hdr.Uid, hdr.Gid), size,
+		hdr.ModTime.UTC().Format("2006-01-02 15:04"), entry.Layer, name)
+}
+
+// grepFile prints the lines of data matching pattern and reports whether any did
+func grepFile(w io.Writer, pattern *regexp.Regexp, name string, data []byte) bool {
+	if !pattern.Match(data) {
+		return false
+	}
+	if grepFilesOnly {
+		fmt.Fprintln(w, name)
+		return true
+	}
+	// Like grep, do not print lines from binary files
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		fmt.Fprintf(w, "Binary file %s matches\n", name)
+		return true
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if pattern.Match(scanner.Bytes()) {
+			fmt.Fprintf(w, "%s:%d:%s\n", name, lineNumber, scanner.Text())
+		}
+	}
+	return true
+}
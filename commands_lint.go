@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/lint"
+	"github.com/raesene/pasgan/pkg/pasgan"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintFormat   string
+	lintOutput   string
+	lintArtifact string
+	lintFailOn   string
+)
+
+// Create the lint command
+func createLintCmd() *cobra.Command {
+	lintCmd := &cobra.Command{
+		Use:   "lint [image_tar]",
+		Short: "Check the reconstructed Dockerfile against best-practice rules",
+		Long: `Lint reconstructs the Dockerfile for an image and checks it against a set of
+best-practice rules, such as running as root, apt-get without cleanup, ADD of
+remote URLs, unpinned base images and secrets in the build.
+
+Rules can also use the image config and layer sizes, which a plain Dockerfile
+linter cannot see. Results can be written as SARIF for code scanning.
+
+Example:
+  pasgan lint app.tar
+  pasgan lint app.tar --format sarif -o pasgan.sarif --artifact Dockerfile`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failOn := lint.Level("")
+			if lintFailOn != "none" {
+				level, ok := lint.ParseLevel(lintFailOn)
+				if !ok {
+					return fmt.Errorf("unsupported level: %s", lintFailOn)
+				}
+				failOn = level
+			}
+
+			if err := checkImage(args[0]); err != nil {
+				return err
+			}
+			options, err := analyzeOptions()
+			if err != nil {
+				return err
+			}
+			// Generate from redacted metadata so the output never includes secrets;
+			// the secret rule still sees the original metadata. The Dockerfile is
+			// built as analyze builds it, so the line numbers match its output.
+			options.Redact = true
+			options.Lint = true
+			result, err := pasgan.AnalyzeURI(cmd.Context(), args[0], options)
+			if err != nil {
+				return err
+			}
+			for _, warning := range result.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+			}
+			findings, instructions := lintResult(result)
+			rules := lint.DefaultRules()
+
+			// Determine where to write the output
+			out := os.Stdout
+			if lintOutput != "" {
+				out, err = os.Create(lintOutput)
+				if err != nil {
+					return fmt.Errorf("failed to create output file: %w", err)
+				}
+				defer out.Close()
+			}
+
+			switch strings.ToLower(lintFormat) {
+			case "text":
+				err = lint.WriteText(out, lintArtifact, findings, instructions)
+			case "sarif":
+				err = lint.WriteSARIF(out, lintArtifact, version, rules, findings)
+			default:
+				return fmt.Errorf("unsupported output format: %s", lintFormat)
+			}
+			if err != nil {
+				return err
+			}
+
+			if failOn != "" {
+				failures := 0
+				for _, finding := range findings {
+					if finding.Level.AtLeast(failOn) {
+						failures++
+					}
+				}
+				if failures > 0 {
+					return fmt.Errorf("%d finding(s) at or above %s", failures, lintFailOn)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	lintCmd.Flags().StringVarP(&lintFormat, "format", "f", "text", "Output format (text, sarif)")
+	lintCmd.Flags().StringVarP(&lintOutput, "output", "o", "", "Output file for the results (default: stdout)")
+	lintCmd.Flags().StringVar(&lintArtifact, "artifact", "Dockerfile", "Path of the generated Dockerfile used in result locations")
+	lintCmd.Flags().StringVar(&eolDataFile, "eol-data", "", "JSON file with additional or updated end-of-life data")
+	lintCmd.Flags().StringVar(&fingerprintsFile, "fingerprints", "", "JSON file with additional or updated base image fingerprints")
+	lintCmd.Flags().StringVar(&fromBoundary, "from-boundary", "", "Omit the base image history up to a boundary: auto, a number of base layers, or the diffID of the last base layer")
+	lintCmd.Flags().StringVar(&lintFailOn, "fail-on", "none", "Exit with an error if any finding is at or above this level (error, warning, info, none)")
+
+	return lintCmd
+}
+
+// lintResult returns the lint findings of an analysis, with the instructions
+// they refer to
+func lintResult(result *pasgan.Result) ([]lint.Finding, []dockerfile.Instruction) {
+	findings := make([]lint.Finding, len(result.LintFindings))
+	for i, finding := range result.LintFindings {
+		findings[i] = lint.Finding{
+			RuleID:      finding.RuleID,
+			Level:       lint.Level(finding.Level),
+			Message:     finding.Message,
+			Instruction: finding.Instruction,
+			Line:        finding.Line,
+		}
+	}
+	instructions := make([]dockerfile.Instruction, len(result.Instructions))
+	for i, instruction := range result.Instructions {
+		instructions[i] = dockerfile.Instruction{
+			Node:         instruction.Node,
+			Time:         instruction.Time,
+			EmptyLayer:   instruction.EmptyLayer,
+			HistoryIndex: instruction.HistoryIndex,
+		}
+	}
+	return findings, instructions
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/pkg/pasgan"
+)
+
+// isJSONFile reports whether the file at path starts like a JSON document
+// rather than an image archive
+func isJSONFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return docker.IsJSON(head[:n])
+}
+
+// readMetadataFiles reads image metadata from docker image inspect, docker
+// history and image config JSON files, merging them in order
+func readMetadataFiles(paths []string) (*pasgan.Metadata, error) {
+	var metadata *pasgan.Metadata
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		parsed, kind, err := pasgan.ParseMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata from %s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Read %s output from %s\n", kind, path)
+
+		if metadata == nil {
+			metadata = parsed
+		} else {
+			metadata.Merge(parsed)
+		}
+	}
+	return metadata, nil
+}
+
+// metadataWarnings describes what cannot be analyzed without the image itself
+func metadataWarnings(metadata *pasgan.Metadata) []string {
+	warnings := []string{
+		"only the image metadata is available: the distribution, packages, files, committed layers and builders recognised from files cannot be analyzed",
+	}
+	if len(metadata.History) == 0 {
+		warnings = append(warnings, "the metadata has no history, the Dockerfile is synthesised from the image config; add docker history output for the instructions")
+	}
+	if metadata.TruncatedHistory() {
+		warnings = append(warnings, "the history commands are truncated, run docker history with --no-trunc")
+	}
+	if len(metadata.Config.Env) == 0 && len(metadata.Config.Cmd) == 0 && len(metadata.Config.Entrypoint) == 0 {
+		warnings = append(warnings, "the metadata has no image config, the environment, entrypoint and command are unknown; add docker image inspect output")
+	}
+	return warnings
+}
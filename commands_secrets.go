@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretsFormat      string
+	secretsRulesFile   string
+	secretsMinSeverity string
+	secretsSkipFiles   bool
+)
+
+// secretsReport is the JSON output of the secrets command
+type secretsReport struct {
+	Findings     []secrets.Finding     `json:"findings"`
+	FileFindings []secrets.FileFinding `json:"file_findings"`
+}
+
+// Create the secrets command
+func createSecretsCmd() *cobra.Command {
+	secretsCmd := &cobra.Command{
+		Use:   "secrets [image_tar]",
+		Short: "Find secrets leaked through image history and config",
+		Long: `Secrets scans the image history, environment variables, labels and
+healthcheck command for credentials such as tokens, passwords and keys.
+
+It also scans the files in every layer for credential files such as SSH keys,
+.env files and registry configs. Files deleted by a later layer are still
+reported, because they remain in the earlier layer blob.
+
+Example:
+  pasgan secrets app.tar
+  pasgan secrets app.tar --rules extra-rules.json --format json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			minSeverity := secrets.Severity(strings.ToLower(secretsMinSeverity))
+			if !minSeverity.AtLeast(secrets.SeverityLow) {
+				return fmt.Errorf("unsupported severity: %s", secretsMinSeverity)
+			}
+
+			// Build the rule set, adding any custom rules to the defaults
+			rules := secrets.DefaultRules()
+			if secretsRulesFile != "" {
+				file, err := os.Open(secretsRulesFile)
+				if err != nil {
+					return fmt.Errorf("failed to open rules file: %w", err)
+				}
+				custom, err := secrets.LoadRules(file)
+				file.Close()
+				if err != nil {
+					return err
+				}
+				rules = append(rules, custom...)
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+
+			report := secretsReport{
+				Findings:     []secrets.Finding{},
+				FileFindings: []secrets.FileFinding{},
+			}
+			scanner := secrets.NewScanner(rules...)
+			for _, finding := range scanner.Scan(metadata) {
+				if finding.Severity.AtLeast(minSeverity) {
+					report.Findings = append(report.Findings, finding)
+				}
+			}
+
+			// Scan the layer filesystems unless disabled
+			if !secretsSkipFiles {
+				// Use redacted metadata so the reported instructions do not leak secrets
+				fileFindings, err := secrets.NewFileScanner().ScanLayers(parser, scanner.Redact(metadata))
+				if err != nil {
+					return fmt.Errorf("failed to scan layers: %w", err)
+				}
+				for _, finding := range fileFindings {
+					if finding.Severity.AtLeast(minSeverity) {
+						report.FileFindings = append(report.FileFindings, finding)
+					}
+				}
+			}
+
+			switch strings.ToLower(secretsFormat) {
+			case "text":
+				printSecretFindings(report.Findings)
+				if !secretsSkipFiles {
+					fmt.Println()
+					printFileFindings(report.FileFindings)
+				}
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return fmt.Errorf("failed to encode findings as JSON: %w", err)
+				}
+			default:
+				return fmt.Errorf("unsupported output format: %s", secretsFormat)
+			}
+
+			return nil
+		},
+	}
+
+	secretsCmd.Flags().StringVarP(&secretsFormat, "format", "f", "text", "Output format (text, json)")
+	secretsCmd.Flags().StringVar(&secretsRulesFile, "rules", "", "JSON file with additional rules")
+	secretsCmd.Flags().BoolVar(&secretsSkipFiles, "skip-files", false, "Only scan image metadata, not layer filesystems")
+	secretsCmd.Flags().StringVar(&secretsMinSeverity, "min-severity", "low", "Only report findings at or above this severity (low, medium, high, critical)")
+
+	return secretsCmd
+}
+
+// printSecretFindings prints secret findings in a human readable form
+func printSecretFindings(findings []secrets.Finding) {
+	if len(findings) == 0 {
+		fmt.Println("No secrets found in image metadata")
+		return
+	}
+
+	fmt.Printf("Found %d potential secret(s) in image metadata:\n", len(findings))
+	for _, finding := range findings {
+		fmt.Println()
+		fmt.Printf("[%s] %s: %s\n", strings.ToUpper(string(finding.Severity)), finding.RuleID, finding.Description)
+
+		location := string(finding.Source)
+		if finding.HistoryIndex >= 0 {
+			location = fmt.Sprintf("%s[%d]", finding.Source, finding.HistoryIndex)
+		} else if finding.Key != "" {
+			location = fmt.Sprintf("%s %s", finding.Source, finding.Key)
+		}
+		fmt.Printf("  Location: %s\n", location)
+		fmt.Printf("  Context:  %s\n", finding.Context)
+	}
+}
+
+// printFileFindings prints filesystem findings in a human readable form
+func printFileFindings(findings []secrets.FileFinding) {
+	if len(findings) == 0 {
+		fmt.Println("No secrets found in layer filesystems")
+		return
+	}
+
+	fmt.Printf("Found %d potential secret file(s) in layer filesystems:\n", len(findings))
+	for _, finding := range findings {
+		fmt.Println()
+		fmt.Printf("[%s] %s: %s\n", strings.ToUpper(string(finding.Severity)), finding.RuleID, finding.Description)
+		fmt.Printf("  Path:     %s\n", finding.Path)
+		fmt.Printf("  Layer:    %d %s\n", finding.Layer, finding.DiffID)
+		if finding.Instruction != "" {
+			fmt.Printf("  Added by: %s\n", truncate(finding.Instruction, 100))
+		}
+
+		switch {
+		case finding.Visible:
+			fmt.Println("  Status:   present in final image")
+		case finding.Overwritten:
+			fmt.Printf("  Status:   overwritten in layer %d, still recoverable from layer %d\n", finding.HiddenByLayer, finding.Layer)
+		default:
+			fmt.Printf("  Status:   deleted in layer %d, still recoverable from layer %d\n", finding.HiddenByLayer, finding.Layer)
+		}
+	}
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
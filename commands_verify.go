@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/verify"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyFormat string
+	verifyKey    string
+)
+
+// Create the verify command
+func createVerifyCmd() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify [image]",
+		Short: "Check that the blobs of an image match their digests",
+		Long: `Verify checks that an image archive is the image its digests describe, to
+detect tampered or truncated archives before their contents are trusted. It
+checks:
+
+  - each layer blob against its manifest digest (OCI) or file name
+  - each uncompressed layer against its diffID in the config
+  - the config against the image ID, and the manifest against index.json
+
+Blobs with no digest recorded, such as the layers of older docker save
+archives, are reported as unverified. Verify fails if any check does not match.
+Use analyze --verify to verify an image before analyzing it.
+
+Verify also lists the cosign signatures, attestations such as SBOMs and
+provenance, and attached SBOMs stored alongside the image in an OCI layout:
+tagged sha256-<digest>.sig, .att or .sbom, saved by cosign save, or referrers
+whose subject is the image. Image sources registered by other programs can
+provide them too; pasgan has no registry source of its own.
+
+With --key, their signatures are verified offline with the public key, and
+verify fails unless a signature verifies and names the image. Certificates
+and transparency log entries of keyless signatures are not checked.
+
+Example:
+  pasgan verify image.tar
+  pasgan verify oci:./layout:1.0 --format json
+  pasgan verify oci:./layout:1.0 --key cosign.pub`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key crypto.PublicKey
+			if verifyKey != "" {
+				var err error
+				key, err = verify.LoadKey(verifyKey)
+				if err != nil {
+					return err
+				}
+			}
+
+			parser, metadata, err := openImage(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer parser.Cleanup()
+			if metadata.Flat {
+				return fmt.Errorf("%s is a flat filesystem, which records no digests to verify", args[0])
+			}
+
+			report, err := verify.Image(cmd.Context(), parser.Source())
+			if err != nil {
+				return err
+			}
+			if err := verify.Attached(cmd.Context(), parser.Source(), key, report); err != nil {
+				return err
+			}
+
+			switch strings.ToLower(verifyFormat) {
+			case "text":
+				fmt.Printf("Verifying %s\n\n", args[0])
+				err = verify.WriteText(os.Stdout, report)
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			default:
+				return fmt.Errorf("unsupported output format: %s", verifyFormat)
+			}
+			if err != nil {
+				return err
+			}
+
+			if failures := len(report.Failures()); failures > 0 {
+				return fmt.Errorf("%d of %d checks failed", failures, len(report.Checks))
+			}
+			return nil
+		},
+	}
+
+	verifyCmd.Flags().StringVarP(&verifyFormat, "format", "f", "text", "Output format (text, json)")
+	verifyCmd.Flags().StringVar(&verifyKey, "key", "", "Public key, such as cosign.pub, to verify the signatures stored alongside the image")
+
+	return verifyCmd
+}
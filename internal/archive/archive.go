@@ -0,0 +1,34 @@
+// Package archive holds the platform calls and PAX record handling shared by
+// the tar extractors in pkg/utils and internal/layer
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// XattrPrefix is the PAX record prefix used for extended attributes
+const XattrPrefix = "SCHILY.xattr."
+
+// ErrUnsupported is returned for metadata that cannot be applied on this platform
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// Xattr is an extended attribute recorded in a PAX header
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// Xattrs returns the extended attributes recorded in hdr, sorted by name
+func Xattrs(hdr *tar.Header) []Xattr {
+	var attrs []Xattr
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, XattrPrefix); ok {
+			attrs = append(attrs, Xattr{Name: name, Value: []byte(value)})
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Name < attrs[j].Name })
+	return attrs
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"syscall"
+)
+
+// Mknod creates a device node or fifo described by hdr
+func Mknod(target string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= syscall.S_IFCHR
+	case tar.TypeBlock:
+		mode |= syscall.S_IFBLK
+	case tar.TypeFifo:
+		mode |= syscall.S_IFIFO
+	default:
+		return fmt.Errorf("unexpected entry type %q", hdr.Typeflag)
+	}
+	return syscall.Mknod(target, mode, int(mkdev(hdr.Devmajor, hdr.Devminor)))
+}
+
+// mkdev encodes a device number the way glibc's makedev does
+func mkdev(major, minor int64) uint64 {
+	ma, mi := uint64(major), uint64(minor)
+	return (mi & 0xff) | ((ma & 0xfff) << 8) | ((mi &^ 0xff) << 12) | ((ma &^ 0xfff) << 32)
+}
+
+// SetXattr sets an extended attribute on target
+func SetXattr(target, attr string, value []byte) error {
+	return syscall.Setxattr(target, attr, value, 0)
+}
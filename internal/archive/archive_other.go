@@ -0,0 +1,15 @@
+//go:build !linux
+
+package archive
+
+import "archive/tar"
+
+// Mknod creates a device node or fifo described by hdr
+func Mknod(target string, hdr *tar.Header) error {
+	return ErrUnsupported
+}
+
+// SetXattr sets an extended attribute on target
+func SetXattr(target, attr string, value []byte) error {
+	return ErrUnsupported
+}
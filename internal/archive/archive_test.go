@@ -0,0 +1,25 @@
+package archive
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	hdr := &tar.Header{PAXRecords: map[string]string{
+		"SCHILY.xattr.user.b":        "2",
+		"SCHILY.xattr.security.capa": "cap",
+		"GNU.sparse.major":           "1",
+	}}
+
+	attrs := Xattrs(hdr)
+	if len(attrs) != 2 {
+		t.Fatalf("Xattrs() = %+v, want 2 attributes", attrs)
+	}
+	if attrs[0].Name != "security.capa" || string(attrs[0].Value) != "cap" || attrs[1].Name != "user.b" || string(attrs[1].Value) != "2" {
+		t.Errorf("Xattrs() = %+v", attrs)
+	}
+	if attrs := Xattrs(&tar.Header{}); len(attrs) != 0 {
+		t.Errorf("Xattrs() without records = %+v", attrs)
+	}
+}
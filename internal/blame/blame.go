@@ -0,0 +1,322 @@
+// Package blame reports which layers created, modified or deleted a path,
+// together with the instruction that produced each layer.
+package blame
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/layer"
+)
+
+// Action describes what a layer did to a path
+type Action string
+
+// Supported actions
+const (
+	Created  Action = "created"
+	Modified Action = "modified"
+	Deleted  Action = "deleted"
+)
+
+// Event is a single change a layer made to a path
+type Event struct {
+	Layer        int    `json:"layer"`
+	DiffID       string `json:"diff_id,omitempty"`
+	HistoryIndex int    `json:"history_index"`
+	Instruction  string `json:"instruction,omitempty"`
+	Created      string `json:"created,omitempty"`
+	Action       Action `json:"action"`
+	// Type, Size, Mode, Owner, Linkname and Digest describe the entry after
+	// the change and are empty for deletions
+	Type     string `json:"type,omitempty"`
+	Size     int64  `json:"size"`
+	Mode     string `json:"mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Linkname string `json:"linkname,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	// Changes lists what differs from the previous version for modifications,
+	// or how the path was removed for deletions
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Trail is the history of a single path across every layer
+type Trail struct {
+	Path   string  `json:"path"`
+	Events []Event `json:"events"`
+	// Exists reports whether the path is present in the final filesystem
+	Exists bool `json:"exists"`
+}
+
+// Pattern matches paths against a glob. "*" and "?" do not cross "/", while
+// "**" matches any number of directories.
+type Pattern struct {
+	regex *regexp.Regexp
+}
+
+// Compile parses a glob pattern. Paths are matched in their absolute form, so
+// "usr/bin/*" and "/usr/bin/*" are equivalent.
+func Compile(glob string) (*Pattern, error) {
+	glob = layer.Clean(glob)
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "/**/" also matches a single "/"
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unterminated character class", glob)
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	regex, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", glob, err)
+	}
+	return &Pattern{regex: regex}, nil
+}
+
+// Match reports whether the cleaned path p matches the pattern
+func (p *Pattern) Match(path string) bool {
+	return p.regex.MatchString(path)
+}
+
+// state is the current version of a tracked path
+type state struct {
+	hdr    *tar.Header
+	digest string
+}
+
+// Blame walks every layer of an image and returns the history of each path
+// matching pattern, sorted by path
+func Blame(o layer.Opener, metadata *docker.ImageMetadata, pattern *Pattern) ([]Trail, error) {
+	// digests holds the content digest of matching regular files, by layer and path
+	digests := make(map[int]map[string]string)
+	index, err := layer.BuildIndex(o, len(metadata.Layers), func(layerIndex int, hdr *tar.Header, r io.Reader) error {
+		name := layer.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !pattern.Match(name) {
+			return nil
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if digests[layerIndex] == nil {
+			digests[layerIndex] = make(map[string]string)
+		}
+		digests[layerIndex][name] = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trails := make(map[string]*Trail)
+	present := make(map[string]state)
+	record := func(p string, event Event) {
+		trail, ok := trails[p]
+		if !ok {
+			trail = &Trail{Path: p}
+			trails[p] = trail
+		}
+		trail.Events = append(trail.Events, event)
+	}
+
+	historyIndexes := metadata.LayerHistoryIndexes()
+	for i, changes := range index.Layers {
+		base := layerEvent(metadata, historyIndexes, i)
+
+		// Removals apply to lower layers, so handle them before this layer's entries
+		for _, p := range sortedKeys(present) {
+			reason, removed := removal(changes, p)
+			if !removed {
+				continue
+			}
+			event := base
+			event.Action = Deleted
+			event.Changes = []string{reason}
+			record(p, event)
+			delete(present, p)
+		}
+
+		var names []string
+		for name := range changes.Entries {
+			if pattern.Match(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			hdr := changes.Entries[name]
+			current := state{hdr: hdr, digest: digests[i][name]}
+
+			event := base
+			describe(&event, current)
+			if previous, ok := present[name]; ok {
+				event.Action = Modified
+				event.Changes = differences(previous, current)
+				// Directories are listed again whenever a layer touches their contents
+				if len(event.Changes) == 0 && hdr.Typeflag == tar.TypeDir {
+					present[name] = current
+					continue
+				}
+				if len(event.Changes) == 0 {
+					event.Changes = []string{"rewritten without changes"}
+				}
+			} else {
+				event.Action = Created
+			}
+			record(name, event)
+			present[name] = current
+		}
+	}
+
+	result := make([]Trail, 0, len(trails))
+	for _, p := range sortedKeys(trails) {
+		trail := trails[p]
+		_, trail.Exists = present[p]
+		result = append(result, *trail)
+	}
+	return result, nil
+}
+
+// layerEvent returns an event describing layer i, without an action
+func layerEvent(metadata *docker.ImageMetadata, historyIndexes []int, i int) Event {
+	event := Event{Layer: i, HistoryIndex: -1}
+	if i < len(metadata.RootFS.DiffIDs) {
+		event.DiffID = metadata.RootFS.DiffIDs[i]
+	}
+	if i < len(historyIndexes) && historyIndexes[i] >= 0 {
+		entry := metadata.History[historyIndexes[i]]
+		event.HistoryIndex = historyIndexes[i]
+		event.Instruction = dockerfile.HistoryInstruction(entry.CreatedBy)
+		event.Created = entry.Created
+	}
+	return event
+}
+
+// removal reports whether a layer removes p, and how
+func removal(changes *layer.Changes, p string) (string, bool) {
+	if changes.Whiteouts[p] {
+		return "whiteout", true
+	}
+	for _, dir := range layer.Ancestors(p) {
+		if changes.Whiteouts[dir] {
+			return fmt.Sprintf("parent %s deleted", dir), true
+		}
+		if hdr, ok := changes.Entries[dir]; ok && hdr.Typeflag != tar.TypeDir {
+			return fmt.Sprintf("parent %s replaced by a %s", dir, typeName(hdr.Typeflag)), true
+		}
+		if changes.Opaque[dir] {
+			if _, readded := changes.Entries[p]; !readded {
+				return fmt.Sprintf("opaque directory %s", dir), true
+			}
+		}
+	}
+	return "", false
+}
+
+// describe fills in the entry details of an event
+func describe(event *Event, s state) {
+	event.Type = typeName(s.hdr.Typeflag)
+	if s.hdr.Typeflag == tar.TypeReg || s.hdr.Typeflag == tar.TypeRegA {
+		event.Size = s.hdr.Size
+	}
+	event.Mode = modeString(s.hdr)
+	event.Owner = fmt.Sprintf("%d:%d", s.hdr.Uid, s.hdr.Gid)
+	event.Linkname = s.hdr.Linkname
+	event.Digest = s.digest
+}
+
+// differences lists the metadata and content changes between two versions of a path
+func differences(previous, current state) []string {
+	var changes []string
+	oldHdr, newHdr := previous.hdr, current.hdr
+
+	if typeName(oldHdr.Typeflag) != typeName(newHdr.Typeflag) {
+		changes = append(changes, fmt.Sprintf("type %s -> %s", typeName(oldHdr.Typeflag), typeName(newHdr.Typeflag)))
+	}
+	if oldHdr.Size != newHdr.Size {
+		changes = append(changes, fmt.Sprintf("size %d -> %d (%+d bytes)", oldHdr.Size, newHdr.Size, newHdr.Size-oldHdr.Size))
+	} else if previous.digest != current.digest {
+		changes = append(changes, "content")
+	}
+	if modeString(oldHdr) != modeString(newHdr) {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", modeString(oldHdr), modeString(newHdr)))
+	}
+	if oldHdr.Uid != newHdr.Uid || oldHdr.Gid != newHdr.Gid {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", oldHdr.Uid, oldHdr.Gid, newHdr.Uid, newHdr.Gid))
+	}
+	if oldHdr.Linkname != newHdr.Linkname {
+		changes = append(changes, fmt.Sprintf("target %s -> %s", oldHdr.Linkname, newHdr.Linkname))
+	}
+	return changes
+}
+
+// modeString formats the permission bits of an entry, e.g. "-rwxr-xr-x"
+func modeString(hdr *tar.Header) string {
+	return hdr.FileInfo().Mode().String()
+}
+
+func typeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return "other"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package blame
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+func TestPatternMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/usr/local/bin/foo", "/usr/local/bin/foo", true},
+		{"usr/local/bin/foo", "/usr/local/bin/foo", true},
+		{"/usr/local/bin/*", "/usr/local/bin/foo", true},
+		{"/usr/local/*", "/usr/local/bin/foo", false},
+		{"/usr/**", "/usr/local/bin/foo", true},
+		{"/etc/**/*.conf", "/etc/nginx.conf", true},
+		{"/etc/**/*.conf", "/etc/nginx/conf.d/site.conf", true},
+		{"/etc/passw?", "/etc/passwd", true},
+		{"/etc/[a-m]*", "/etc/hosts", true},
+		{"/etc/[!a-m]*", "/etc/hosts", false},
+		{"/etc/a+b", "/etc/aab", false},
+	}
+
+	for _, tc := range testCases {
+		pattern, err := Compile(tc.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error = %v", tc.pattern, err)
+		}
+		if got := pattern.Match(tc.path); got != tc.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+
+	if _, err := Compile("/etc/[abc"); err == nil {
+		t.Errorf("Expected an error for an unterminated character class")
+	}
+}
+
+func TestBlame(t *testing.T) {
+	tool := testimage.Reg("usr/local/bin/tool", "v1")
+	chmodded := testimage.Reg("usr/local/bin/tool", "v2")
+	chmodded.Mode = 0755
+
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Dir("usr/local/bin/"),
+			tool,
+			testimage.Reg("opt/app/config", "a"),
+		),
+		testimage.Layer(
+			testimage.Dir("usr/local/bin/"),
+			chmodded,
+			testimage.Opaque("opt/app"),
+		),
+		testimage.Layer(
+			testimage.Whiteout("usr/local/bin/tool"),
+		),
+	}
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"0/layer.tar", "1/layer.tar", "2/layer.tar"},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-02T00:00:00Z", CreatedBy: "RUN /bin/sh -c install-tool # buildkit"},
+			{Created: "2024-01-03T00:00:00Z", CreatedBy: "RUN /bin/sh -c rm /usr/local/bin/tool # buildkit"},
+		},
+		RootFS: docker.RootFS{DiffIDs: []string{"sha256:a", "sha256:b", "sha256:c"}},
+	}
+
+	pattern, _ := Compile("/**")
+	trails, err := Blame(layers, metadata, pattern)
+	if err != nil {
+		t.Fatalf("Blame() error = %v", err)
+	}
+
+	byPath := make(map[string]Trail)
+	for _, trail := range trails {
+		byPath[trail.Path] = trail
+	}
+
+	toolTrail := byPath["/usr/local/bin/tool"]
+	if toolTrail.Exists || len(toolTrail.Events) != 3 {
+		t.Fatalf("Unexpected tool trail %+v", toolTrail)
+	}
+	created, modified, deleted := toolTrail.Events[0], toolTrail.Events[1], toolTrail.Events[2]
+	if created.Action != Created || created.Layer != 0 || created.DiffID != "sha256:a" || created.Size != 2 {
+		t.Errorf("Unexpected created event %+v", created)
+	}
+	wantChanges := []string{"content", "mode -rw-r--r-- -> -rwxr-xr-x"}
+	if modified.Action != Modified || modified.Created != "2024-01-02T00:00:00Z" || !reflect.DeepEqual(modified.Changes, wantChanges) {
+		t.Errorf("Unexpected modified event %+v", modified)
+	}
+	if deleted.Action != Deleted || deleted.Layer != 2 || deleted.Instruction == "" || deleted.Changes[0] != "whiteout" {
+		t.Errorf("Unexpected deleted event %+v", deleted)
+	}
+
+	// Directories listed again without changes are not reported
+	if dirTrail := byPath["/usr/local/bin"]; len(dirTrail.Events) != 1 || !dirTrail.Exists {
+		t.Errorf("Unexpected directory trail %+v", dirTrail)
+	}
+
+	configTrail := byPath["/opt/app/config"]
+	if len(configTrail.Events) != 2 || configTrail.Events[1].Changes[0] != "opaque directory /opt/app" {
+		t.Errorf("Unexpected config trail %+v", configTrail)
+	}
+}
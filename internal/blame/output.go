@@ -0,0 +1,64 @@
+package blame
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// WriteText writes the history of each path in a human readable form
+func WriteText(w io.Writer, trails []Trail) error {
+	var b strings.Builder
+
+	for i, trail := range trails {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		state := "present in final image"
+		if !trail.Exists {
+			state = "not in final image"
+		}
+		fmt.Fprintf(&b, "%s (%s)\n", trail.Path, state)
+
+		for _, event := range trail.Events {
+			created := event.Created
+			if created == "" {
+				created = "unknown time"
+			}
+			fmt.Fprintf(&b, "  layer %d  %-8s  %s\n", event.Layer, event.Action, created)
+
+			switch event.Action {
+			case Deleted:
+				fmt.Fprintf(&b, "    %s\n", strings.Join(event.Changes, ", "))
+			default:
+				fmt.Fprintf(&b, "    %s\n", entrySummary(event))
+				if event.Action == Modified {
+					fmt.Fprintf(&b, "    changed: %s\n", strings.Join(event.Changes, ", "))
+				}
+			}
+
+			instruction := event.Instruction
+			if instruction == "" {
+				instruction = "<no history for this layer>"
+			}
+			fmt.Fprintf(&b, "    %s\n", instruction)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// entrySummary describes the entry created or modified by an event
+func entrySummary(event Event) string {
+	parts := []string{event.Type, event.Mode, event.Owner}
+	switch event.Type {
+	case "file":
+		parts = append(parts, utils.FormatSize(event.Size))
+	case "symlink", "hardlink":
+		parts = append(parts, "-> "+event.Linkname)
+	}
+	return strings.Join(parts, " ")
+}
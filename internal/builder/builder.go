@@ -0,0 +1,381 @@
+// Package builder recognises images built without a Dockerfile, such as those
+// produced by Jib, ko, Bazel, Nix, apko and Cloud Native Buildpacks, and writes
+// a build configuration for the tool that built them.
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/layer"
+)
+
+// Kind is the tool an image was built with
+type Kind string
+
+// Supported builders
+const (
+	Dockerfile Kind = "dockerfile"
+	Kaniko     Kind = "kaniko"
+	Jib        Kind = "jib"
+	Ko         Kind = "ko"
+	Bazel      Kind = "bazel"
+	Nix        Kind = "nix"
+	Apko       Kind = "apko"
+	Buildpacks Kind = "buildpacks"
+)
+
+// maxWorldSize bounds the apk world and repositories files that are read
+const maxWorldSize = 1 << 20
+
+// Builder describes how an image was built
+type Builder struct {
+	Kind Kind `json:"kind"`
+	// Tool is the name the builder records, e.g. jib-maven-plugin
+	Tool    string `json:"tool,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Evidence lists the history entries, labels and files that identified the builder
+	Evidence []string `json:"evidence"`
+	// LayerRoots is the deepest directory holding every file of each layer.
+	// It is only set when the layers were read.
+	LayerRoots []string `json:"layer_roots,omitempty"`
+	// Packages and Repositories are the apk world and repositories of apko images
+	Packages     []string `json:"packages,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+	// StorePaths are the top-level /nix/store paths of Nix images
+	StorePaths []string `json:"store_paths,omitempty"`
+	// LayerOwners describes what each layer holds, for builders that record it
+	LayerOwners []string `json:"layer_owners,omitempty"`
+	// Buildpacks is the build recorded by the buildpacks lifecycle
+	Buildpacks *BuildpacksBuild `json:"buildpacks,omitempty"`
+}
+
+var jibToolRegex = regexp.MustCompile(`^jib-(maven-plugin|gradle-plugin|core|cli)(?::(\S+))?`)
+
+// Detect identifies the builder of an image from its history, labels and
+// environment. If o is not nil the layers are read as well, which recognises
+// Nix and apko images that record no history and fills in LayerRoots.
+func Detect(metadata *docker.ImageMetadata, o layer.Opener) (*Builder, error) {
+	b := &Builder{Kind: Dockerfile}
+	var contents *layerContents
+	if o != nil {
+		var err error
+		contents, err = readLayers(o, len(metadata.Layers))
+		if err != nil {
+			return nil, err
+		}
+		b.LayerRoots = contents.roots
+	}
+
+	switch {
+	case b.detectBuildpacks(metadata):
+	case b.detectJib(metadata):
+	case b.detectKo(metadata):
+	case b.detectBazel(metadata):
+	case b.detectApko(metadata, contents):
+	case b.detectNix(metadata, contents):
+	case b.detectKaniko(metadata):
+	}
+	return b, nil
+}
+
+// Dockerless reports whether the image was built without a Dockerfile
+func (b *Builder) Dockerless() bool {
+	return b.Kind != Dockerfile && b.Kind != Kaniko
+}
+
+// Name describes the builder for humans, e.g. "jib-maven-plugin 3.4.0"
+func (b *Builder) Name() string {
+	name := b.Tool
+	if name == "" {
+		name = string(b.Kind)
+	}
+	if b.Version != "" {
+		name += " " + b.Version
+	}
+	return name
+}
+
+// Owns reports whether a history entry was written by the builder itself
+// rather than inherited from a base image built with a Dockerfile
+func (b *Builder) Owns(entry docker.History) bool {
+	switch b.Kind {
+	case Jib:
+		return jibToolRegex.MatchString(entry.CreatedBy) || strings.EqualFold(entry.Author, "jib")
+	case Ko:
+		return isKoEntry(entry)
+	case Bazel:
+		return isBazelEntry(entry)
+	case Nix:
+		return isNixEntry(entry)
+	case Apko:
+		return isApkoEntry(entry)
+	case Buildpacks:
+		return isLifecycleEntry(entry)
+	}
+	return false
+}
+
+// BaseHistoryCount returns the number of leading history entries that belong
+// to a base image, before the first entry written by a Dockerless builder
+func (b *Builder) BaseHistoryCount(metadata *docker.ImageMetadata) int {
+	if !b.Dockerless() {
+		return 0
+	}
+	// The lifecycle does not always write history, but records the run image layers
+	if b.Buildpacks != nil && b.Buildpacks.BaseLayers > 0 {
+		count := 0
+		for count < len(metadata.History) && !b.Owns(metadata.History[count]) {
+			count++
+		}
+		return count
+	}
+	for i, entry := range metadata.History {
+		if b.Owns(entry) {
+			return i
+		}
+	}
+	return 0
+}
+
+func (b *Builder) detectJib(metadata *docker.ImageMetadata) bool {
+	for _, entry := range metadata.History {
+		if match := jibToolRegex.FindStringSubmatch(entry.CreatedBy); match != nil {
+			b.Kind, b.Tool, b.Version = Jib, "jib-"+match[1], match[2]
+			b.Evidence = append(b.Evidence, fmt.Sprintf("history: %s", entry.CreatedBy))
+			break
+		}
+	}
+	if b.Kind != Jib {
+		return false
+	}
+	// Jib names its layers after their contents
+	for _, entry := range metadata.History {
+		if b.Owns(entry) && entry.Comment != "" {
+			b.Evidence = append(b.Evidence, fmt.Sprintf("%s layer", entry.Comment))
+		}
+	}
+	return true
+}
+
+func (b *Builder) detectKo(metadata *docker.ImageMetadata) bool {
+	for _, entry := range metadata.History {
+		if isKoEntry(entry) {
+			b.Kind, b.Tool = Ko, "ko"
+			b.Evidence = append(b.Evidence, fmt.Sprintf("history: %s", orComment(entry)))
+			break
+		}
+	}
+	if hasEnv(metadata, "KO_DATA_PATH") {
+		b.Kind, b.Tool = Ko, "ko"
+		b.Evidence = append(b.Evidence, "KO_DATA_PATH environment variable")
+	}
+	return b.Kind == Ko
+}
+
+func (b *Builder) detectBazel(metadata *docker.ImageMetadata) bool {
+	for _, entry := range metadata.History {
+		if isBazelEntry(entry) {
+			b.Kind, b.Tool = Bazel, "bazel"
+			b.Evidence = append(b.Evidence, fmt.Sprintf("history: %s", orComment(entry)))
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Builder) detectApko(metadata *docker.ImageMetadata, contents *layerContents) bool {
+	for _, entry := range metadata.History {
+		if isApkoEntry(entry) {
+			b.Kind, b.Tool = Apko, "apko"
+			b.Evidence = append(b.Evidence, fmt.Sprintf("history: %s", orComment(entry)))
+			break
+		}
+	}
+	if b.Kind != Apko && strings.Contains(metadata.Author, "apko") {
+		b.Kind, b.Tool = Apko, "apko"
+		b.Evidence = append(b.Evidence, "author: "+metadata.Author)
+	}
+	// apko writes a single layer from an apk world and records no history
+	if b.Kind != Apko && contents != nil && len(metadata.History) == 0 && len(contents.world) > 0 {
+		b.Kind, b.Tool = Apko, "apko"
+		b.Evidence = append(b.Evidence, "no history and an apk world in /etc/apk/world")
+	}
+	if b.Kind != Apko {
+		return false
+	}
+	if contents != nil {
+		b.Packages = contents.world
+		b.Repositories = contents.repositories
+	}
+	return true
+}
+
+func (b *Builder) detectNix(metadata *docker.ImageMetadata, contents *layerContents) bool {
+	for _, entry := range metadata.History {
+		if isNixEntry(entry) {
+			b.Kind, b.Tool = Nix, "nix"
+			b.Evidence = append(b.Evidence, fmt.Sprintf("history: %s", orComment(entry)))
+			break
+		}
+	}
+	// dockerTools images contain little more than a Nix store, and no shell history
+	if b.Kind != Nix && contents != nil && len(contents.storePaths) > 0 && !hasShellHistory(metadata) {
+		b.Kind, b.Tool = Nix, "nix"
+		b.Evidence = append(b.Evidence, fmt.Sprintf("%d paths in /nix/store", len(contents.storePaths)))
+	}
+	if b.Kind != Nix {
+		return false
+	}
+	if contents != nil {
+		b.StorePaths = contents.storePaths
+	}
+	return true
+}
+
+func (b *Builder) detectKaniko(metadata *docker.ImageMetadata) bool {
+	for _, entry := range metadata.History {
+		if strings.EqualFold(entry.Author, "kaniko") {
+			b.Kind, b.Tool = Kaniko, "kaniko"
+			b.Evidence = append(b.Evidence, "history author: kaniko")
+			return true
+		}
+	}
+	return false
+}
+
+func isKoEntry(entry docker.History) bool {
+	return entry.CreatedBy == "ko" || strings.HasPrefix(entry.CreatedBy, "ko build") ||
+		strings.HasPrefix(entry.CreatedBy, "ko publish") || strings.HasPrefix(entry.CreatedBy, "ko resolve") ||
+		entry.Author == "ko" || strings.Contains(entry.Author, "ko-build/ko")
+}
+
+func isBazelEntry(entry docker.History) bool {
+	return strings.HasPrefix(entry.CreatedBy, "bazel build") || strings.Contains(entry.CreatedBy, "rules_oci") ||
+		strings.Contains(entry.CreatedBy, "rules_docker") || strings.EqualFold(entry.Author, "bazel")
+}
+
+func isNixEntry(entry docker.History) bool {
+	return strings.Contains(entry.CreatedBy, "/nix/store/") || strings.Contains(entry.Comment, "/nix/store/") ||
+		strings.EqualFold(entry.CreatedBy, "nix") || strings.EqualFold(entry.Author, "nix")
+}
+
+func isApkoEntry(entry docker.History) bool {
+	return entry.CreatedBy == "apko" || strings.Contains(entry.Author, "apko") || strings.Contains(entry.Comment, "apko")
+}
+
+// hasShellHistory reports whether any history entry ran a shell command, as
+// Dockerfile builds do
+func hasShellHistory(metadata *docker.ImageMetadata) bool {
+	for _, entry := range metadata.History {
+		if strings.Contains(entry.CreatedBy, "/bin/sh -c") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnv(metadata *docker.ImageMetadata, key string) bool {
+	for _, variable := range metadata.Config.Env {
+		if name, _, _ := strings.Cut(variable, "="); name == key {
+			return true
+		}
+	}
+	return false
+}
+
+func orComment(entry docker.History) string {
+	if entry.CreatedBy != "" {
+		return entry.CreatedBy
+	}
+	return entry.Comment
+}
+
+// layerContents is what detection needs from the layers
+type layerContents struct {
+	roots        []string
+	world        []string
+	repositories []string
+	storePaths   []string
+}
+
+// readLayers reads the layers once, recording the root of each layer, the apk
+// world and the top-level Nix store paths
+func readLayers(o layer.Opener, count int) (*layerContents, error) {
+	contents := &layerContents{}
+	storePaths := make(map[string]bool)
+	index, err := layer.BuildIndex(o, count, func(_ int, hdr *tar.Header, r io.Reader) error {
+		name := layer.Clean(hdr.Name)
+		if rest, ok := strings.CutPrefix(name, "/nix/store/"); ok {
+			storePaths["/nix/store/"+strings.SplitN(rest, "/", 2)[0]] = true
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxWorldSize {
+			return nil
+		}
+		switch name {
+		case "/etc/apk/world", "/etc/apk/repositories":
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			lines := nonEmptyLines(string(data))
+			if name == "/etc/apk/world" {
+				contents.world = lines
+			} else {
+				contents.repositories = lines
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, changes := range index.Layers {
+		contents.roots = append(contents.roots, layerRoot(changes))
+	}
+	for p := range storePaths {
+		contents.storePaths = append(contents.storePaths, p)
+	}
+	sort.Strings(contents.storePaths)
+	return contents, nil
+}
+
+// layerRoot returns the deepest directory containing every non-directory
+// entry of a layer
+func layerRoot(changes *layer.Changes) string {
+	root := ""
+	for name, hdr := range changes.Entries {
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		dir := path.Dir(name)
+		if root == "" {
+			root = dir
+			continue
+		}
+		for root != "/" && dir != root && !strings.HasPrefix(dir, root+"/") {
+			root = path.Dir(root)
+		}
+	}
+	if root == "" {
+		return "/"
+	}
+	return root
+}
+
+func nonEmptyLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,281 @@
+package builder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+// jibImage is a Maven project built by Jib on a base image with a Dockerfile history
+func jibImage() (*docker.ImageMetadata, testimage.Layers) {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/service:1.0"},
+		Layers:   []string{"base", "deps", "resources", "classes"},
+		Config: docker.Config{
+			Env:          []string{"PATH=/usr/bin", "JAVA_HOME=/opt/java/openjdk", "SPRING_PROFILES_ACTIVE=prod"},
+			Entrypoint:   []string{"java", "-Xmx512m", "-cp", "@/app/jib-classpath-file", "com.example.Main"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "1970-01-01T00:00:00Z", CreatedBy: "jib-maven-plugin:3.4.0", Author: "Jib", Comment: "dependencies"},
+			{Created: "1970-01-01T00:00:00Z", CreatedBy: "jib-maven-plugin:3.4.0", Author: "Jib", Comment: "resources"},
+			{Created: "1970-01-01T00:00:00Z", CreatedBy: "jib-maven-plugin:3.4.0", Author: "Jib", Comment: "classes"},
+		},
+	}
+	layers := testimage.Layers{
+		testimage.Layer(testimage.Reg("etc/os-release", "ID=ubuntu\n"), testimage.Reg("usr/bin/java", "")),
+		testimage.Layer(testimage.Dir("app"), testimage.Dir("app/libs"), testimage.Reg("app/libs/spring.jar", "jar")),
+		testimage.Layer(testimage.Reg("app/resources/application.yaml", "port: 8080\n")),
+		testimage.Layer(testimage.Reg("app/classes/com/example/Main.class", "class"), testimage.Reg("app/classes/com/example/App.class", "class")),
+	}
+	return metadata, layers
+}
+
+// buildpacksImage is a Node.js app built by Paketo buildpacks on the jammy base run image
+func buildpacksImage() *docker.ImageMetadata {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/web:latest"},
+		Layers:   []string{"run-1", "run-2", "node", "modules", "app", "config", "launcher"},
+		Config: docker.Config{
+			Labels: map[string]string{
+				StackIDLabel: "io.buildpacks.stacks.jammy",
+				LifecycleMetadataLabel: `{"app":[{"sha":"sha256:app"}],"config":{"sha":"sha256:config"},"launcher":{"sha":"sha256:launcher"},` +
+					`"buildpacks":[{"key":"paketo-buildpacks/node-engine","version":"4.1.0","layers":{"node":{"sha":"sha256:node","launch":true}}},` +
+					`{"key":"paketo-buildpacks/npm-install","version":"1.4.0","layers":{"launch-modules":{"sha":"sha256:modules","launch":true}}}],` +
+					`"runImage":{"topLayer":"sha256:run2","reference":"sha256:runref","image":"index.docker.io/paketobuildpacks/run-jammy-base:latest"}}`,
+				BuildMetadataLabel: `{"buildpacks":[{"id":"paketo-buildpacks/node-engine","version":"4.1.0"},{"id":"paketo-buildpacks/npm-install","version":"1.4.0"}],` +
+					`"processes":[{"type":"web","command":["node"],"args":["server.js"],"direct":true,"default":true,"buildpackID":"paketo-buildpacks/npm-start"}],` +
+					`"launcher":{"version":"0.20.0"}}`,
+			},
+		},
+		RootFS: docker.RootFS{DiffIDs: []string{"sha256:run1", "sha256:run2", "sha256:node", "sha256:modules", "sha256:app", "sha256:config", "sha256:launcher"}},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:10Z", CreatedBy: "/bin/sh -c apt-get update"},
+		},
+	}
+	return metadata
+}
+
+func TestDetect(t *testing.T) {
+	jibMetadata, jibLayers := jibImage()
+
+	testCases := []struct {
+		name      string
+		metadata  *docker.ImageMetadata
+		layers    testimage.Layers
+		wantKind  Kind
+		wantTool  string
+		wantRoots []string
+	}{
+		{
+			name:      "jib",
+			metadata:  jibMetadata,
+			layers:    jibLayers,
+			wantKind:  Jib,
+			wantTool:  "jib-maven-plugin 3.4.0",
+			wantRoots: []string{"/", "/app/libs", "/app/resources", "/app/classes/com/example"},
+		},
+		{
+			name:     "buildpacks",
+			metadata: buildpacksImage(),
+			wantKind: Buildpacks,
+			wantTool: "buildpacks",
+		},
+		{
+			name: "ko",
+			metadata: &docker.ImageMetadata{
+				Config:  docker.Config{Env: []string{"KO_DATA_PATH=/var/run/ko"}, Entrypoint: []string{"/ko-app/server"}},
+				History: []docker.History{{CreatedBy: "ko build ko://github.com/example/server", Author: "github.com/ko-build/ko"}},
+			},
+			wantKind: Ko,
+			wantTool: "ko",
+		},
+		{
+			name: "bazel",
+			metadata: &docker.ImageMetadata{
+				History: []docker.History{{CreatedBy: "bazel build //app:image", Author: "Bazel"}},
+			},
+			wantKind: Bazel,
+			wantTool: "bazel",
+		},
+		{
+			name:     "nix store without history",
+			metadata: &docker.ImageMetadata{Layers: []string{"store"}},
+			layers: testimage.Layers{testimage.Layer(
+				testimage.Reg("nix/store/0c5hc4d6jq7k9y3n5xkz8p4k1r0m2a7s-hello-2.12.1/bin/hello", "hello"),
+				testimage.Reg("nix/store/1h3k7lwb6dlxmqp0c8vd2a9cjz7m5ybn-glibc-2.38/lib/libc.so.6", "libc"),
+			)},
+			wantKind: Nix,
+			wantTool: "nix",
+		},
+		{
+			name:     "apko without history",
+			metadata: &docker.ImageMetadata{Layers: []string{"rootfs"}},
+			layers: testimage.Layers{testimage.Layer(
+				testimage.Reg("etc/apk/world", "wolfi-baselayout\nca-certificates-bundle\nnginx\n"),
+				testimage.Reg("etc/apk/repositories", "https://packages.wolfi.dev/os\n"),
+			)},
+			wantKind: Apko,
+			wantTool: "apko",
+		},
+		{
+			name: "kaniko",
+			metadata: &docker.ImageMetadata{
+				History: []docker.History{{CreatedBy: "RUN apk add curl", Author: "kaniko"}},
+			},
+			wantKind: Kaniko,
+			wantTool: "kaniko",
+		},
+		{
+			name: "dockerfile",
+			metadata: &docker.ImageMetadata{
+				History: []docker.History{{CreatedBy: "/bin/sh -c apk add curl"}},
+			},
+			wantKind: Dockerfile,
+			wantTool: "dockerfile",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b *Builder
+			var err error
+			if tc.layers != nil {
+				b, err = Detect(tc.metadata, tc.layers)
+			} else {
+				b, err = Detect(tc.metadata, nil)
+			}
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if b.Kind != tc.wantKind || b.Name() != tc.wantTool {
+				t.Errorf("Detect() = %s (%s), want %s (%s)", b.Kind, b.Name(), tc.wantKind, tc.wantTool)
+			}
+			if tc.wantRoots != nil && strings.Join(b.LayerRoots, " ") != strings.Join(tc.wantRoots, " ") {
+				t.Errorf("LayerRoots = %v, want %v", b.LayerRoots, tc.wantRoots)
+			}
+		})
+	}
+}
+
+func TestWriteNative(t *testing.T) {
+	jibMetadata, jibLayers := jibImage()
+	jib, err := Detect(jibMetadata, jibLayers)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	apkoMetadata := &docker.ImageMetadata{
+		Layers:       []string{"rootfs"},
+		Architecture: "arm64",
+		Config:       docker.Config{Entrypoint: []string{"/usr/sbin/nginx", "-g", "daemon off;"}, User: "65532"},
+	}
+	apko, err := Detect(apkoMetadata, testimage.Layers{testimage.Layer(testimage.Reg("etc/apk/world", "nginx\n"))})
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	nixMetadata := &docker.ImageMetadata{
+		Layers:   []string{"store"},
+		RepoTags: []string{"hello:latest"},
+		Config:   docker.Config{Cmd: []string{"/nix/store/0c5hc4d6jq7k9y3n5xkz8p4k1r0m2a7s-hello-2.12.1/bin/hello"}},
+	}
+	nix, err := Detect(nixMetadata, testimage.Layers{testimage.Layer(
+		testimage.Reg("nix/store/0c5hc4d6jq7k9y3n5xkz8p4k1r0m2a7s-hello-2.12.1/bin/hello", "hello"),
+		testimage.Reg("nix/store/1h3k7lwb6dlxmqp0c8vd2a9cjz7m5ybn-glibc-2.38/lib/libc.so.6", "libc"),
+	)})
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	buildpacksMetadata := buildpacksImage()
+	buildpacks, err := Detect(buildpacksMetadata, nil)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		builder  *Builder
+		metadata *docker.ImageMetadata
+		want     []string
+	}{
+		{
+			name:     "jib maven",
+			builder:  jib,
+			metadata: jibMetadata,
+			want: []string{
+				"<artifactId>jib-maven-plugin</artifactId>",
+				"<version>3.4.0</version>",
+				"<image>eclipse-temurin:21-jre</image>",
+				"<mainClass>com.example.Main</mainClass>",
+				"<jvmFlag>-Xmx512m</jvmFlag>",
+				"<SPRING_PROFILES_ACTIVE>prod</SPRING_PROFILES_ACTIVE>",
+				"<port>8080/tcp</port>",
+			},
+		},
+		{
+			name:     "buildpacks",
+			builder:  buildpacks,
+			metadata: buildpacksMetadata,
+			want: []string{
+				"#   pack build example/web:latest --builder paketobuildpacks/builder-jammy-base --run-image index.docker.io/paketobuildpacks/run-jammy-base:latest " +
+					"--buildpack paketo-buildpacks/node-engine@4.1.0 --buildpack paketo-buildpacks/npm-install@1.4.0 --default-process web --path .\n",
+				"[io.buildpacks]\nbuilder = \"paketobuildpacks/builder-jammy-base\"\n",
+				"[[io.buildpacks.group]]\nid = \"paketo-buildpacks/npm-install\"\nversion = \"1.4.0\"\n",
+				"#   web: node server.js (default)\n",
+				"sha256:run2          run image\n",
+				"sha256:modules       paketo-buildpacks/npm-install (launch-modules)\n",
+				"sha256:launcher      launcher\n",
+			},
+		},
+		{
+			name:     "apko",
+			builder:  apko,
+			metadata: apkoMetadata,
+			want: []string{
+				"  packages:\n    - \"nginx\"\n",
+				"  command: \"/usr/sbin/nginx -g 'daemon off;'\"\n",
+				"  run-as: \"65532\"\n",
+				"archs:\n  - aarch64\n",
+			},
+		},
+		{
+			name:     "nix",
+			builder:  nix,
+			metadata: nixMetadata,
+			want: []string{
+				"name = \"hello\";",
+				"pkgs.hello # /nix/store/0c5hc4d6jq7k9y3n5xkz8p4k1r0m2a7s-hello-2.12.1",
+				"#   /nix/store/1h3k7lwb6dlxmqp0c8vd2a9cjz7m5ybn-glibc-2.38",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteNative(&buf, tc.builder, tc.metadata, ""); err != nil {
+				t.Fatalf("WriteNative() error = %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("Expected %q in:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+
+	if got := buildpacks.BaseHistoryCount(buildpacksMetadata); got != 2 {
+		t.Errorf("BaseHistoryCount() = %d, want 2", got)
+	}
+
+	if err := WriteNative(&bytes.Buffer{}, &Builder{Kind: Dockerfile}, &docker.ImageMetadata{}, ""); err != ErrNoNative {
+		t.Errorf("WriteNative() error = %v, want ErrNoNative", err)
+	}
+}
@@ -0,0 +1,380 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+)
+
+// Labels written by the Cloud Native Buildpacks lifecycle
+const (
+	LifecycleMetadataLabel = "io.buildpacks.lifecycle.metadata"
+	BuildMetadataLabel     = "io.buildpacks.build.metadata"
+	ProjectMetadataLabel   = "io.buildpacks.project.metadata"
+	StackIDLabel           = "io.buildpacks.stack.id"
+)
+
+// Buildpack is a buildpack that took part in the build
+type Buildpack struct {
+	ID       string `json:"id"`
+	Version  string `json:"version,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+}
+
+// Process is a process type the launcher can start
+type Process struct {
+	Type        string   `json:"type"`
+	Command     []string `json:"command"`
+	Args        []string `json:"args,omitempty"`
+	Direct      bool     `json:"direct"`
+	Default     bool     `json:"default,omitempty"`
+	BuildpackID string   `json:"buildpack_id,omitempty"`
+}
+
+// BuildpacksBuild is the build recorded in the labels of a buildpacks image
+type BuildpacksBuild struct {
+	StackID         string   `json:"stack_id,omitempty"`
+	RunImage        string   `json:"run_image,omitempty"`
+	RunImageMirrors []string `json:"run_image_mirrors,omitempty"`
+	// Builder is inferred from the run image, as the lifecycle does not record it
+	Builder         string      `json:"builder,omitempty"`
+	Buildpacks      []Buildpack `json:"buildpacks"`
+	Processes       []Process   `json:"processes,omitempty"`
+	LauncherVersion string      `json:"launcher_version,omitempty"`
+	SourceRepo      string      `json:"source_repository,omitempty"`
+	SourceCommit    string      `json:"source_commit,omitempty"`
+	// BaseLayers is the number of layers that come from the run image
+	BaseLayers int `json:"base_layers"`
+}
+
+// runImageBuilders maps well-known run images to the builder that uses them
+var runImageBuilders = []struct {
+	runImage string
+	builder  string
+}{
+	{"paketobuildpacks/run-jammy-tiny", "paketobuildpacks/builder-jammy-tiny"},
+	{"paketobuildpacks/run-jammy-base", "paketobuildpacks/builder-jammy-base"},
+	{"paketobuildpacks/run-jammy-full", "paketobuildpacks/builder-jammy-full"},
+	{"paketobuildpacks/run-noble-base", "paketobuildpacks/builder-noble-java-tiny"},
+	{"paketobuildpacks/run:tiny", "paketobuildpacks/builder:tiny"},
+	{"paketobuildpacks/run:base", "paketobuildpacks/builder:base"},
+	{"paketobuildpacks/run:full", "paketobuildpacks/builder:full"},
+	{"heroku/heroku:24", "heroku/builder:24"},
+	{"heroku/heroku:22", "heroku/builder:22"},
+	{"heroku/heroku:20", "heroku/builder:20"},
+	{"gcr.io/buildpacks/gcp/run", "gcr.io/buildpacks/builder"},
+}
+
+// layerSHA is a layer reference in the lifecycle metadata, by diffID
+type layerSHA struct {
+	SHA string `json:"sha"`
+}
+
+// lifecycleMetadata is the io.buildpacks.lifecycle.metadata label
+type lifecycleMetadata struct {
+	App        json.RawMessage `json:"app"`
+	Config     layerSHA        `json:"config"`
+	Launcher   layerSHA        `json:"launcher"`
+	SBOM       *layerSHA       `json:"sbom"`
+	Buildpacks []struct {
+		Key     string `json:"key"`
+		Version string `json:"version"`
+		Layers  map[string]struct {
+			SHA    string `json:"sha"`
+			Launch bool   `json:"launch"`
+		} `json:"layers"`
+	} `json:"buildpacks"`
+	RunImage struct {
+		TopLayer  string   `json:"topLayer"`
+		Reference string   `json:"reference"`
+		Image     string   `json:"image"`
+		Mirrors   []string `json:"mirrors"`
+	} `json:"runImage"`
+	Stack struct {
+		RunImage struct {
+			Image   string   `json:"image"`
+			Mirrors []string `json:"mirrors"`
+		} `json:"runImage"`
+	} `json:"stack"`
+}
+
+// buildMetadata is the io.buildpacks.build.metadata label
+type buildMetadata struct {
+	Buildpacks []Buildpack `json:"buildpacks"`
+	Processes  []struct {
+		Type        string          `json:"type"`
+		Command     json.RawMessage `json:"command"`
+		Args        []string        `json:"args"`
+		Direct      bool            `json:"direct"`
+		Default     bool            `json:"default"`
+		BuildpackID string          `json:"buildpackID"`
+	} `json:"processes"`
+	Launcher struct {
+		Version string `json:"version"`
+	} `json:"launcher"`
+}
+
+// projectMetadata is the io.buildpacks.project.metadata label
+type projectMetadata struct {
+	Source struct {
+		Version  map[string]string `json:"version"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"source"`
+}
+
+func (b *Builder) detectBuildpacks(metadata *docker.ImageMetadata) bool {
+	labels := metadata.Config.Labels
+	lifecycleLabel, hasLifecycle := labels[LifecycleMetadataLabel]
+	buildLabel, hasBuild := labels[BuildMetadataLabel]
+	if !hasLifecycle && !hasBuild {
+		return false
+	}
+
+	b.Kind, b.Tool = Buildpacks, "buildpacks"
+	info := &BuildpacksBuild{StackID: labels[StackIDLabel]}
+	owners := make(map[string]string)
+
+	var lifecycle lifecycleMetadata
+	if hasLifecycle {
+		b.Evidence = append(b.Evidence, LifecycleMetadataLabel+" label")
+		if err := json.Unmarshal([]byte(lifecycleLabel), &lifecycle); err != nil {
+			b.Evidence = append(b.Evidence, fmt.Sprintf("unreadable %s: %v", LifecycleMetadataLabel, err))
+		}
+	}
+	info.RunImage, info.RunImageMirrors = lifecycle.RunImage.Image, lifecycle.RunImage.Mirrors
+	if info.RunImage == "" {
+		info.RunImage, info.RunImageMirrors = lifecycle.Stack.RunImage.Image, lifecycle.Stack.RunImage.Mirrors
+	}
+	if info.RunImage == "" {
+		info.RunImage = lifecycle.RunImage.Reference
+	}
+	for _, bp := range lifecycle.Buildpacks {
+		info.Buildpacks = append(info.Buildpacks, Buildpack{ID: bp.Key, Version: bp.Version})
+		names := sortedKeys(bp.Layers)
+		for _, name := range names {
+			owners[bp.Layers[name].SHA] = fmt.Sprintf("%s (%s)", bp.Key, name)
+		}
+	}
+	for _, sha := range appLayers(lifecycle.App) {
+		owners[sha] = "application"
+	}
+	owners[lifecycle.Config.SHA] = "launch configuration"
+	owners[lifecycle.Launcher.SHA] = "launcher"
+	if lifecycle.SBOM != nil {
+		owners[lifecycle.SBOM.SHA] = "software bill of materials"
+	}
+	delete(owners, "")
+
+	if hasBuild {
+		b.Evidence = append(b.Evidence, BuildMetadataLabel+" label")
+		var build buildMetadata
+		if err := json.Unmarshal([]byte(buildLabel), &build); err == nil {
+			// The build metadata has the homepages the lifecycle metadata lacks
+			if len(build.Buildpacks) > 0 {
+				info.Buildpacks = build.Buildpacks
+			}
+			for _, p := range build.Processes {
+				info.Processes = append(info.Processes, Process{
+					Type: p.Type, Command: stringOrList(p.Command), Args: p.Args,
+					Direct: p.Direct, Default: p.Default, BuildpackID: p.BuildpackID,
+				})
+			}
+			info.LauncherVersion = build.Launcher.Version
+		}
+	}
+
+	if projectLabel, ok := labels[ProjectMetadataLabel]; ok {
+		var project projectMetadata
+		if json.Unmarshal([]byte(projectLabel), &project) == nil {
+			info.SourceRepo = project.Source.Metadata["repository"]
+			info.SourceCommit = project.Source.Version["commit"]
+		}
+	}
+
+	// Layers up to the run image's top layer belong to the run image
+	b.LayerOwners = make([]string, len(metadata.RootFS.DiffIDs))
+	for i, diffID := range metadata.RootFS.DiffIDs {
+		if diffID == lifecycle.RunImage.TopLayer {
+			info.BaseLayers = i + 1
+		}
+		b.LayerOwners[i] = owners[diffID]
+	}
+	for i := 0; i < info.BaseLayers; i++ {
+		b.LayerOwners[i] = "run image"
+	}
+
+	info.Builder = inferBuilder(info.RunImage)
+	b.Buildpacks = info
+	return true
+}
+
+// appLayers reads the app layers, a list in current lifecycles and a single
+// layer in old ones
+func appLayers(raw json.RawMessage) []string {
+	var layers []layerSHA
+	if json.Unmarshal(raw, &layers) != nil {
+		var single layerSHA
+		if json.Unmarshal(raw, &single) != nil {
+			return nil
+		}
+		layers = []layerSHA{single}
+	}
+	shas := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		shas = append(shas, layer.SHA)
+	}
+	return shas
+}
+
+// stringOrList decodes a process command, a string before platform API 0.10
+// and a list since
+func stringOrList(raw json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var single string
+	if json.Unmarshal(raw, &single) == nil && single != "" {
+		return []string{single}
+	}
+	return nil
+}
+
+// inferBuilder returns the builder that uses a well-known run image
+func inferBuilder(runImage string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(runImage, "index.docker.io/"), "docker.io/")
+	for _, known := range runImageBuilders {
+		if strings.HasPrefix(name, known.runImage) {
+			return known.builder
+		}
+	}
+	return ""
+}
+
+// isLifecycleEntry reports whether a history entry was written by the lifecycle
+func isLifecycleEntry(entry docker.History) bool {
+	for _, marker := range []string{"Buildpacks Application Launcher", "Buildpacks Launcher Config", "Application Layer", "Software Bill-of-Materials", "Created by buildpack"} {
+		if strings.Contains(entry.CreatedBy, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// PackCommand returns the pack build command line that reproduces the build
+func (info *BuildpacksBuild) PackCommand(image string) string {
+	builder := info.Builder
+	if builder == "" {
+		builder = "<builder-image>"
+	}
+	args := []string{"pack", "build", image, "--builder", builder}
+	if info.RunImage != "" {
+		args = append(args, "--run-image", info.RunImage)
+	}
+	for _, bp := range info.Buildpacks {
+		ref := bp.ID
+		if bp.Version != "" {
+			ref += "@" + bp.Version
+		}
+		args = append(args, "--buildpack", ref)
+	}
+	if process := info.defaultProcess(); process != "" {
+		args = append(args, "--default-process", process)
+	}
+	args = append(args, "--path", ".")
+	return shellJoin(args)
+}
+
+// defaultProcess returns the process type started by default
+func (info *BuildpacksBuild) defaultProcess() string {
+	for _, p := range info.Processes {
+		if p.Default {
+			return p.Type
+		}
+	}
+	return ""
+}
+
+func writeBuildpacks(w io.Writer, b *Builder, metadata *docker.ImageMetadata) error {
+	info := b.Buildpacks
+	image := firstOr(metadata.RepoTags, "app")
+	name, _, _ := strings.Cut(image, ":")
+
+	lines := []string{
+		"# project.toml reconstructed by pasgan from a Cloud Native Buildpacks image",
+		"# Build with:",
+		"#   " + info.PackCommand(image),
+	}
+	if info.Builder == "" {
+		lines = append(lines, "# The builder is not recorded in the image; use one that provides "+orStack(info))
+	}
+	if info.SourceRepo != "" || info.SourceCommit != "" {
+		lines = append(lines, fmt.Sprintf("# Built from %s %s", info.SourceRepo, info.SourceCommit))
+	}
+	lines = append(lines,
+		"",
+		"[_]",
+		`schema-version = "0.2"`,
+		"id = "+strconv.Quote(name),
+		"",
+		"[io.buildpacks]",
+	)
+	if info.Builder != "" {
+		lines = append(lines, "builder = "+strconv.Quote(info.Builder))
+	}
+	for _, bp := range info.Buildpacks {
+		lines = append(lines, "", "[[io.buildpacks.group]]", "id = "+strconv.Quote(bp.ID))
+		if bp.Version != "" {
+			lines = append(lines, "version = "+strconv.Quote(bp.Version))
+		}
+	}
+	if info.RunImage != "" {
+		lines = append(lines, "", "[io.buildpacks.run]", "image = "+strconv.Quote(info.RunImage))
+	}
+
+	if len(info.Processes) > 0 {
+		lines = append(lines, "", "# Processes:")
+		for _, p := range info.Processes {
+			command := shellJoin(append(append([]string{}, p.Command...), p.Args...))
+			suffix := ""
+			if p.Default {
+				suffix = " (default)"
+			}
+			lines = append(lines, fmt.Sprintf("#   %s: %s%s", p.Type, command, suffix))
+		}
+	}
+	lines = append(lines, "", "# Layers:")
+	lines = append(lines, layerBreakdown(b, metadata)...)
+	return writeLines(w, lines)
+}
+
+// layerBreakdown describes the owner of every layer, as comment lines
+func layerBreakdown(b *Builder, metadata *docker.ImageMetadata) []string {
+	var lines []string
+	for i, diffID := range metadata.RootFS.DiffIDs {
+		owner := "unknown"
+		if i < len(b.LayerOwners) && b.LayerOwners[i] != "" {
+			owner = b.LayerOwners[i]
+		}
+		lines = append(lines, fmt.Sprintf("#   %2d  %-19s  %s", i, shortDigest(diffID), owner))
+	}
+	return lines
+}
+
+func orStack(info *BuildpacksBuild) string {
+	if info.StackID != "" {
+		return "the " + info.StackID + " stack"
+	}
+	return "these buildpacks"
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
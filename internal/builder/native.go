@@ -0,0 +1,542 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+)
+
+// ErrNoNative is returned by WriteNative for images built from a Dockerfile
+var ErrNoNative = errors.New("the image was built from a Dockerfile, use the dockerfile format")
+
+// defaultBaseImages are the base images each builder uses when none is configured
+var defaultBaseImages = map[Kind]string{
+	Jib: "eclipse-temurin:21-jre",
+	Ko:  "cgr.dev/chainguard/static:latest",
+}
+
+// storeNameRegex splits a Nix store path into its hash, name and version
+var storeNameRegex = regexp.MustCompile(`^/nix/store/[0-9a-z]{32}-(.+?)(?:-(\d[^-]*))?$`)
+
+// WriteNative writes the build configuration of the tool that built the
+// image: a Jib plugin configuration, a .ko.yaml, a Bazel rules_oci target, a
+// Nix expression, an apko configuration or a buildpacks project.toml. baseImage is the base image
+// identified by other means, or empty.
+func WriteNative(w io.Writer, b *Builder, metadata *docker.ImageMetadata, baseImage string) error {
+	if baseImage == "" {
+		baseImage = defaultBaseImages[b.Kind]
+	}
+	switch b.Kind {
+	case Jib:
+		if b.Tool == "jib-gradle-plugin" {
+			return writeJibGradle(w, b, metadata, baseImage)
+		}
+		return writeJibMaven(w, b, metadata, baseImage)
+	case Ko:
+		return writeKo(w, b, metadata, baseImage)
+	case Bazel:
+		return writeBazel(w, b, metadata, baseImage)
+	case Nix:
+		return writeNix(w, b, metadata)
+	case Apko:
+		return writeApko(w, b, metadata)
+	case Buildpacks:
+		return writeBuildpacks(w, b, metadata)
+	}
+	return ErrNoNative
+}
+
+// jibEntrypoint splits a Jib java entrypoint into JVM flags and the main class
+func jibEntrypoint(entrypoint []string) (flags []string, mainClass string) {
+	if len(entrypoint) < 2 || path.Base(entrypoint[0]) != "java" {
+		return nil, ""
+	}
+	args := entrypoint[1:]
+	mainClass = args[len(args)-1]
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-cp" || args[i] == "-classpath" {
+			i++
+			continue
+		}
+		flags = append(flags, args[i])
+	}
+	return flags, mainClass
+}
+
+// appRoot returns the directory Jib copied the application to
+func appRoot(b *Builder, metadata *docker.ImageMetadata) string {
+	for i, historyIndex := range metadata.LayerHistoryIndexes() {
+		if historyIndex < 0 || i >= len(b.LayerRoots) || !b.Owns(metadata.History[historyIndex]) {
+			continue
+		}
+		// Layers hold app/libs, app/classes and so on
+		if root := b.LayerRoots[i]; root != "/" {
+			return path.Dir(root)
+		}
+	}
+	return "/app"
+}
+
+func writeJibMaven(w io.Writer, b *Builder, metadata *docker.ImageMetadata, baseImage string) error {
+	version := b.Version
+	if version == "" {
+		version = "3.4.0"
+	}
+	config := metadata.Config
+	flags, mainClass := jibEntrypoint(config.Entrypoint)
+
+	lines := []string{
+		"<!-- Reconstructed by pasgan from an image built with " + b.Name() + " -->",
+		"<plugin>",
+		"  <groupId>com.google.cloud.tools</groupId>",
+		"  <artifactId>jib-maven-plugin</artifactId>",
+		"  <version>" + xmlEscape(version) + "</version>",
+		"  <configuration>",
+		"    <from>",
+		"      <image>" + xmlEscape(baseImage) + "</image>",
+		"    </from>",
+	}
+	if len(metadata.RepoTags) > 0 {
+		lines = append(lines, "    <to>", "      <image>"+xmlEscape(metadata.RepoTags[0])+"</image>", "    </to>")
+	}
+	lines = append(lines, "    <container>")
+	if mainClass != "" {
+		lines = append(lines, "      <mainClass>"+xmlEscape(mainClass)+"</mainClass>")
+	} else if len(config.Entrypoint) > 0 {
+		lines = append(lines, "      <entrypoint>")
+		for _, arg := range config.Entrypoint {
+			lines = append(lines, "        <arg>"+xmlEscape(arg)+"</arg>")
+		}
+		lines = append(lines, "      </entrypoint>")
+	}
+	if len(flags) > 0 {
+		lines = append(lines, "      <jvmFlags>")
+		for _, flag := range flags {
+			lines = append(lines, "        <jvmFlag>"+xmlEscape(flag)+"</jvmFlag>")
+		}
+		lines = append(lines, "      </jvmFlags>")
+	}
+	if len(config.Cmd) > 0 {
+		lines = append(lines, "      <args>")
+		for _, arg := range config.Cmd {
+			lines = append(lines, "        <arg>"+xmlEscape(arg)+"</arg>")
+		}
+		lines = append(lines, "      </args>")
+	}
+	if root := appRoot(b, metadata); root != "/app" {
+		lines = append(lines, "      <appRoot>"+xmlEscape(root)+"</appRoot>")
+	}
+	if ports := sortedKeys(config.ExposedPorts); len(ports) > 0 {
+		lines = append(lines, "      <ports>")
+		for _, port := range ports {
+			lines = append(lines, "        <port>"+xmlEscape(port)+"</port>")
+		}
+		lines = append(lines, "      </ports>")
+	}
+	if env := jibEnvironment(config.Env); len(env) > 0 {
+		lines = append(lines, "      <environment>")
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+			lines = append(lines, fmt.Sprintf("        <%s>%s</%s>", key, xmlEscape(value), key))
+		}
+		lines = append(lines, "      </environment>")
+	}
+	if labels := sortedKeys(config.Labels); len(labels) > 0 {
+		lines = append(lines, "      <labels>")
+		for _, key := range labels {
+			lines = append(lines, fmt.Sprintf("        <%s>%s</%s>", key, xmlEscape(config.Labels[key]), key))
+		}
+		lines = append(lines, "      </labels>")
+	}
+	if config.User != "" {
+		lines = append(lines, "      <user>"+xmlEscape(config.User)+"</user>")
+	}
+	if config.WorkingDir != "" {
+		lines = append(lines, "      <workingDirectory>"+xmlEscape(config.WorkingDir)+"</workingDirectory>")
+	}
+	lines = append(lines, "    </container>", "  </configuration>", "</plugin>")
+	return writeLines(w, lines)
+}
+
+func writeJibGradle(w io.Writer, b *Builder, metadata *docker.ImageMetadata, baseImage string) error {
+	config := metadata.Config
+	flags, mainClass := jibEntrypoint(config.Entrypoint)
+
+	lines := []string{
+		"// Reconstructed by pasgan from an image built with " + b.Name(),
+		"jib {",
+		"    from {",
+		"        image = " + strconv.Quote(baseImage),
+		"    }",
+	}
+	if len(metadata.RepoTags) > 0 {
+		lines = append(lines, "    to {", "        image = "+strconv.Quote(metadata.RepoTags[0]), "    }")
+	}
+	lines = append(lines, "    container {")
+	if mainClass != "" {
+		lines = append(lines, "        mainClass = "+strconv.Quote(mainClass))
+	} else if len(config.Entrypoint) > 0 {
+		lines = append(lines, "        entrypoint = "+groovyList(config.Entrypoint))
+	}
+	if len(flags) > 0 {
+		lines = append(lines, "        jvmFlags = "+groovyList(flags))
+	}
+	if len(config.Cmd) > 0 {
+		lines = append(lines, "        args = "+groovyList(config.Cmd))
+	}
+	if root := appRoot(b, metadata); root != "/app" {
+		lines = append(lines, "        appRoot = "+strconv.Quote(root))
+	}
+	if ports := sortedKeys(config.ExposedPorts); len(ports) > 0 {
+		lines = append(lines, "        ports = "+groovyList(ports))
+	}
+	if env := jibEnvironment(config.Env); len(env) > 0 {
+		var entries []string
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+			entries = append(entries, strconv.Quote(key)+": "+strconv.Quote(value))
+		}
+		lines = append(lines, "        environment = ["+strings.Join(entries, ", ")+"]")
+	}
+	if config.User != "" {
+		lines = append(lines, "        user = "+strconv.Quote(config.User))
+	}
+	if config.WorkingDir != "" {
+		lines = append(lines, "        workingDirectory = "+strconv.Quote(config.WorkingDir))
+	}
+	lines = append(lines, "    }", "}")
+	return writeLines(w, lines)
+}
+
+// jibEnvironment drops the variables that come from the base image
+func jibEnvironment(env []string) []string {
+	var result []string
+	for _, variable := range env {
+		key, _, _ := strings.Cut(variable, "=")
+		switch key {
+		case "PATH", "JAVA_HOME", "JAVA_VERSION", "LANG", "LANGUAGE", "LC_ALL", "SSL_CERT_FILE":
+			continue
+		}
+		result = append(result, variable)
+	}
+	return result
+}
+
+func writeKo(w io.Writer, b *Builder, metadata *docker.ImageMetadata, baseImage string) error {
+	name := "app"
+	if len(metadata.Config.Entrypoint) > 0 {
+		name = path.Base(metadata.Config.Entrypoint[0])
+	}
+	main := "./cmd/" + name
+	for _, entry := range metadata.History {
+		if _, importPath, ok := strings.Cut(entry.CreatedBy, "ko://"); ok {
+			main = strings.Fields(importPath)[0]
+			break
+		}
+	}
+
+	lines := []string{
+		"# .ko.yaml reconstructed by pasgan from an image built with " + b.Name(),
+		"# Build with: ko build " + main,
+		"defaultBaseImage: " + strconv.Quote(baseImage),
+		"builds:",
+		"  - id: " + strconv.Quote(name),
+		"    main: " + strconv.Quote(main),
+	}
+	if main == "./cmd/"+name {
+		lines = append(lines, "    # The import path is not recorded in the image, adjust main to your module layout")
+	}
+	if env := withoutKeys(metadata.Config.Env, "PATH", "KO_DATA_PATH", "SSL_CERT_FILE"); len(env) > 0 {
+		lines = append(lines, "    # ko does not set runtime environment variables; the image had:")
+		for _, variable := range env {
+			lines = append(lines, "    #   "+variable)
+		}
+	}
+	if metadata.Architecture != "" {
+		lines = append(lines, "defaultPlatforms:", "  - "+strconv.Quote(metadata.OS+"/"+metadata.Architecture))
+	}
+	return writeLines(w, lines)
+}
+
+func writeBazel(w io.Writer, b *Builder, metadata *docker.ImageMetadata, baseImage string) error {
+	config := metadata.Config
+	lines := []string{
+		"# Reconstructed by pasgan from an image built with " + b.Name(),
+		`load("@rules_oci//oci:defs.bzl", "oci_image")`,
+		"",
+		"oci_image(",
+		`    name = "image",`,
+	}
+	if baseImage != "" {
+		lines = append(lines, fmt.Sprintf(`    base = "@base_image",  # pull %s with oci.pull in MODULE.bazel`, baseImage))
+	}
+
+	var tars []string
+	for i, historyIndex := range metadata.LayerHistoryIndexes() {
+		if historyIndex >= 0 && !b.Owns(metadata.History[historyIndex]) {
+			continue
+		}
+		tar := fmt.Sprintf(`        ":layer_%d",`, i)
+		if i < len(b.LayerRoots) {
+			tar += "  # files under " + b.LayerRoots[i]
+		}
+		tars = append(tars, tar)
+	}
+	if len(tars) > 0 {
+		lines = append(lines, "    tars = [")
+		lines = append(lines, tars...)
+		lines = append(lines, "    ],")
+	}
+	if len(config.Entrypoint) > 0 {
+		lines = append(lines, "    entrypoint = "+starlarkList(config.Entrypoint)+",")
+	}
+	if len(config.Cmd) > 0 {
+		lines = append(lines, "    cmd = "+starlarkList(config.Cmd)+",")
+	}
+	if env := withoutKeys(config.Env, "PATH"); len(env) > 0 {
+		lines = append(lines, "    env = {")
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+			lines = append(lines, fmt.Sprintf("        %s: %s,", strconv.Quote(key), strconv.Quote(value)))
+		}
+		lines = append(lines, "    },")
+	}
+	if ports := sortedKeys(config.ExposedPorts); len(ports) > 0 {
+		lines = append(lines, "    exposed_ports = "+starlarkList(ports)+",")
+	}
+	if config.User != "" {
+		lines = append(lines, "    user = "+strconv.Quote(config.User)+",")
+	}
+	if config.WorkingDir != "" {
+		lines = append(lines, "    workdir = "+strconv.Quote(config.WorkingDir)+",")
+	}
+	if labels := sortedKeys(config.Labels); len(labels) > 0 {
+		lines = append(lines, "    labels = {")
+		for _, key := range labels {
+			lines = append(lines, fmt.Sprintf("        %s: %s,", strconv.Quote(key), strconv.Quote(config.Labels[key])))
+		}
+		lines = append(lines, "    },")
+	}
+	lines = append(lines, ")")
+	return writeLines(w, lines)
+}
+
+func writeNix(w io.Writer, b *Builder, metadata *docker.ImageMetadata) error {
+	config := metadata.Config
+	name, tag := "image", "latest"
+	if len(metadata.RepoTags) > 0 {
+		repository, repoTag, ok := strings.Cut(metadata.RepoTags[0], ":")
+		name = path.Base(repository)
+		if ok {
+			tag = repoTag
+		}
+	}
+
+	// Store paths referenced by the entrypoint are the roots of the closure
+	referenced := strings.Join(append(append([]string{}, config.Entrypoint...), config.Cmd...), " ")
+	var roots, dependencies []string
+	for _, storePath := range b.StorePaths {
+		if strings.Contains(referenced, storePath) {
+			roots = append(roots, storePath)
+		} else {
+			dependencies = append(dependencies, storePath)
+		}
+	}
+
+	lines := []string{
+		"# Reconstructed by pasgan from an image built with Nix dockerTools",
+		"# Build with: nix-build image.nix && docker load < result",
+		"{ pkgs ? import <nixpkgs> { } }:",
+		"",
+		"pkgs.dockerTools.buildLayeredImage {",
+		"  name = " + strconv.Quote(name) + ";",
+		"  tag = " + strconv.Quote(tag) + ";",
+		"  contents = [",
+	}
+	for _, storePath := range roots {
+		lines = append(lines, fmt.Sprintf("    pkgs.%s # %s", nixAttribute(storePath), storePath))
+	}
+	lines = append(lines, "  ];")
+	if len(dependencies) > 0 {
+		lines = append(lines, "  # Other store paths in the image, normally pulled in as dependencies:")
+		for _, storePath := range dependencies {
+			lines = append(lines, "  #   "+storePath)
+		}
+	}
+
+	lines = append(lines, "  config = {")
+	if len(config.Entrypoint) > 0 {
+		lines = append(lines, "    Entrypoint = "+nixList(config.Entrypoint)+";")
+	}
+	if len(config.Cmd) > 0 {
+		lines = append(lines, "    Cmd = "+nixList(config.Cmd)+";")
+	}
+	if len(config.Env) > 0 {
+		lines = append(lines, "    Env = "+nixList(config.Env)+";")
+	}
+	if ports := sortedKeys(config.ExposedPorts); len(ports) > 0 {
+		var entries []string
+		for _, port := range ports {
+			entries = append(entries, strconv.Quote(port)+" = { };")
+		}
+		lines = append(lines, "    ExposedPorts = { "+strings.Join(entries, " ")+" };")
+	}
+	if config.User != "" {
+		lines = append(lines, "    User = "+strconv.Quote(config.User)+";")
+	}
+	if config.WorkingDir != "" {
+		lines = append(lines, "    WorkingDir = "+strconv.Quote(config.WorkingDir)+";")
+	}
+	lines = append(lines, "  };", "}")
+	return writeLines(w, lines)
+}
+
+// nixAttribute guesses the nixpkgs attribute of a store path from its name
+func nixAttribute(storePath string) string {
+	if match := storeNameRegex.FindStringSubmatch(storePath); match != nil {
+		return match[1]
+	}
+	return path.Base(storePath)
+}
+
+func writeApko(w io.Writer, b *Builder, metadata *docker.ImageMetadata) error {
+	config := metadata.Config
+	lines := []string{
+		"# Reconstructed by pasgan from an image built with apko",
+		"# Build with: apko build apko.yaml " + firstOr(metadata.RepoTags, "image:latest") + " image.tar",
+		"contents:",
+	}
+	if len(b.Repositories) > 0 {
+		lines = append(lines, "  repositories:")
+		for _, repository := range b.Repositories {
+			lines = append(lines, "    - "+strconv.Quote(repository))
+		}
+	}
+	lines = append(lines, "  packages:")
+	if len(b.Packages) == 0 {
+		lines = append(lines, "    # The apk world was not found in the image")
+	}
+	for _, pkg := range b.Packages {
+		lines = append(lines, "    - "+strconv.Quote(pkg))
+	}
+
+	if len(config.Entrypoint) > 0 {
+		lines = append(lines, "entrypoint:", "  command: "+strconv.Quote(shellJoin(config.Entrypoint)))
+	}
+	if len(config.Cmd) > 0 {
+		lines = append(lines, "cmd: "+strconv.Quote(shellJoin(config.Cmd)))
+	}
+	if config.WorkingDir != "" {
+		lines = append(lines, "work-dir: "+strconv.Quote(config.WorkingDir))
+	}
+	if env := withoutKeys(config.Env, "PATH"); len(env) > 0 {
+		lines = append(lines, "environment:")
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+			lines = append(lines, fmt.Sprintf("  %s: %s", key, strconv.Quote(value)))
+		}
+	}
+	if config.User != "" {
+		lines = append(lines, "accounts:", "  run-as: "+strconv.Quote(config.User))
+	}
+	if arch := apkArch(metadata.Architecture); arch != "" {
+		lines = append(lines, "archs:", "  - "+arch)
+	}
+	return writeLines(w, lines)
+}
+
+// apkArch converts an OCI architecture to the name apk uses
+func apkArch(arch string) string {
+	switch arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	}
+	return arch
+}
+
+func withoutKeys(env []string, keys ...string) []string {
+	var result []string
+	for _, variable := range env {
+		key, _, _ := strings.Cut(variable, "=")
+		skip := false
+		for _, k := range keys {
+			if key == k {
+				skip = true
+			}
+		}
+		if !skip {
+			result = append(result, variable)
+		}
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return quoted
+}
+
+func groovyList(values []string) string {
+	return "[" + strings.Join(quoteAll(values), ", ") + "]"
+}
+
+func starlarkList(values []string) string {
+	return "[" + strings.Join(quoteAll(values), ", ") + "]"
+}
+
+func nixList(values []string) string {
+	return "[ " + strings.Join(quoteAll(values), " ") + " ]"
+}
+
+// shellJoin joins arguments, quoting those that need it
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'$\\") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+func firstOr(values []string, fallback string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return fallback
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+// Package diff compares two images instruction by instruction, including
+// their config and layers.
+package diff
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+)
+
+// Op describes how an item changed between the two images
+type Op string
+
+// Supported change operations
+const (
+	OpSame    Op = "same"
+	OpAdded   Op = "added"
+	OpRemoved Op = "removed"
+	OpChanged Op = "changed"
+)
+
+// Image is one side of a comparison
+type Image struct {
+	Metadata     *docker.ImageMetadata
+	Instructions []dockerfile.Instruction
+	// LayerSizes holds the uncompressed size of each layer, or nil if unknown
+	LayerSizes []int64
+}
+
+// InstructionChange is one row of the aligned instruction lists
+type InstructionChange struct {
+	Op  Op     `json:"op"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+	// OldSize and NewSize are the sizes of the layers the instructions created, or -1
+	OldSize int64 `json:"old_size"`
+	NewSize int64 `json:"new_size"`
+}
+
+// ConfigChange is a difference in the image config
+type ConfigChange struct {
+	Op    Op     `json:"op"`
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// LayerChange describes a layer present in either image
+type LayerChange struct {
+	Op     Op     `json:"op"`
+	DiffID string `json:"diff_id"`
+	// OldIndex and NewIndex are the positions of the layer in each image, or -1
+	OldIndex int   `json:"old_index"`
+	NewIndex int   `json:"new_index"`
+	Size     int64 `json:"size"`
+	// Shared is true for layers in the common base prefix of both images
+	Shared bool `json:"shared"`
+}
+
+// Report is the result of comparing two images
+type Report struct {
+	OldTags []string `json:"old_tags"`
+	NewTags []string `json:"new_tags"`
+	// SharedLayers is the number of leading layers both images have in common
+	SharedLayers int                 `json:"shared_layers"`
+	Instructions []InstructionChange `json:"instructions"`
+	Config       []ConfigChange      `json:"config"`
+	Layers       []LayerChange       `json:"layers"`
+	// SizeDelta is the change in total uncompressed size
+	SizeDelta int64 `json:"size_delta"`
+}
+
+// Compare aligns two images and reports what changed
+func Compare(old, new Image) *Report {
+	report := &Report{
+		OldTags:      old.Metadata.RepoTags,
+		NewTags:      new.Metadata.RepoTags,
+		Instructions: compareInstructions(old, new),
+		Config:       compareConfig(old.Metadata.Config, new.Metadata.Config),
+	}
+
+	report.SharedLayers, report.Layers = compareLayers(old, new)
+	report.SizeDelta = total(new.LayerSizes) - total(old.LayerSizes)
+
+	return report
+}
+
+// instructionKey is the text used to align instructions
+func instructionKey(instruction dockerfile.Instruction) string {
+	text := strings.ReplaceAll(dockerfile.FormatInstruction(instruction), "\\\n", " ")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// layerSizes maps each instruction to the size of the layer it created
+func layerSizes(image Image) []int64 {
+	layerByHistory := make(map[int]int)
+	for layer, historyIndex := range image.Metadata.LayerHistoryIndexes() {
+		if historyIndex >= 0 {
+			layerByHistory[historyIndex] = layer
+		}
+	}
+
+	sizes := make([]int64, len(image.Instructions))
+	for i, instruction := range image.Instructions {
+		sizes[i] = -1
+		if instruction.EmptyLayer {
+			continue
+		}
+		if layer, ok := layerByHistory[instruction.HistoryIndex]; ok && layer < len(image.LayerSizes) {
+			sizes[i] = image.LayerSizes[layer]
+		}
+	}
+	return sizes
+}
+
+// compareInstructions aligns the two instruction lists using their longest
+// common subsequence. A removal directly followed by an addition of the same
+// instruction type is reported as a change.
+func compareInstructions(old, new Image) []InstructionChange {
+	var oldKeys, newKeys []string
+	var oldCommands, newCommands []string
+	for _, instruction := range old.Instructions {
+		oldKeys = append(oldKeys, instructionKey(instruction))
+		oldCommands = append(oldCommands, instruction.Node.Keyword())
+	}
+	for _, instruction := range new.Instructions {
+		newKeys = append(newKeys, instructionKey(instruction))
+		newCommands = append(newCommands, instruction.Node.Keyword())
+	}
+	oldSizes := layerSizes(old)
+	newSizes := layerSizes(new)
+
+	// lcs[i][j] is the length of the LCS of oldKeys[i:] and newKeys[j:]
+	lcs := make([][]int, len(oldKeys)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newKeys)+1)
+	}
+	for i := len(oldKeys) - 1; i >= 0; i-- {
+		for j := len(newKeys) - 1; j >= 0; j-- {
+			if oldKeys[i] == newKeys[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var changes []InstructionChange
+	i, j := 0, 0
+	for i < len(oldKeys) || j < len(newKeys) {
+		switch {
+		case i < len(oldKeys) && j < len(newKeys) && oldKeys[i] == newKeys[j]:
+			changes = append(changes, InstructionChange{Op: OpSame, Old: oldKeys[i], New: newKeys[j], OldSize: oldSizes[i], NewSize: newSizes[j]})
+			i++
+			j++
+		case i < len(oldKeys) && j < len(newKeys) && oldCommands[i] == newCommands[j] && lcs[i+1][j+1] == lcs[i][j]:
+			// Neither side is part of the common subsequence here, so pair them up
+			changes = append(changes, InstructionChange{Op: OpChanged, Old: oldKeys[i], New: newKeys[j], OldSize: oldSizes[i], NewSize: newSizes[j]})
+			i++
+			j++
+		case j < len(newKeys) && (i == len(oldKeys) || lcs[i][j+1] >= lcs[i+1][j]):
+			changes = append(changes, InstructionChange{Op: OpAdded, New: newKeys[j], OldSize: -1, NewSize: newSizes[j]})
+			j++
+		default:
+			changes = append(changes, InstructionChange{Op: OpRemoved, Old: oldKeys[i], OldSize: oldSizes[i], NewSize: -1})
+			i++
+		}
+	}
+
+	return changes
+}
+
+// compareLayers reports the shared base prefix and every added or removed layer
+func compareLayers(old, new Image) (int, []LayerChange) {
+	oldIDs := old.Metadata.RootFS.DiffIDs
+	newIDs := new.Metadata.RootFS.DiffIDs
+
+	shared := 0
+	for shared < len(oldIDs) && shared < len(newIDs) && oldIDs[shared] == newIDs[shared] {
+		shared++
+	}
+
+	sizeOf := func(sizes []int64, i int) int64 {
+		if i < len(sizes) {
+			return sizes[i]
+		}
+		return -1
+	}
+
+	oldIndex := make(map[string]int)
+	for i, id := range oldIDs {
+		if _, ok := oldIndex[id]; !ok {
+			oldIndex[id] = i
+		}
+	}
+	newSet := make(map[string]bool)
+	for _, id := range newIDs {
+		newSet[id] = true
+	}
+
+	var changes []LayerChange
+	for i, id := range newIDs {
+		change := LayerChange{DiffID: id, OldIndex: -1, NewIndex: i, Size: sizeOf(new.LayerSizes, i), Shared: i < shared}
+		if index, ok := oldIndex[id]; ok {
+			change.Op = OpSame
+			change.OldIndex = index
+		} else {
+			change.Op = OpAdded
+		}
+		changes = append(changes, change)
+	}
+	for i, id := range oldIDs {
+		if !newSet[id] {
+			changes = append(changes, LayerChange{Op: OpRemoved, DiffID: id, OldIndex: i, NewIndex: -1, Size: sizeOf(old.LayerSizes, i)})
+		}
+	}
+
+	return shared, changes
+}
+
+// compareConfig reports differences in the runtime config
+func compareConfig(old, new docker.Config) []ConfigChange {
+	var changes []ConfigChange
+
+	compareValue := func(field, oldValue, newValue string) {
+		switch {
+		case oldValue == newValue:
+			return
+		case oldValue == "":
+			changes = append(changes, ConfigChange{Op: OpAdded, Field: field, New: newValue})
+		case newValue == "":
+			changes = append(changes, ConfigChange{Op: OpRemoved, Field: field, Old: oldValue})
+		default:
+			changes = append(changes, ConfigChange{Op: OpChanged, Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	compareMaps := func(prefix string, oldMap, newMap map[string]string) {
+		keys := make(map[string]bool)
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			compareValue(prefix+" "+key, oldMap[key], newMap[key])
+		}
+	}
+
+	compareValue("user", old.User, new.User)
+	compareValue("workdir", old.WorkingDir, new.WorkingDir)
+	compareValue("entrypoint", joinArgs(old.Entrypoint), joinArgs(new.Entrypoint))
+	compareValue("cmd", joinArgs(old.Cmd), joinArgs(new.Cmd))
+	compareValue("stopsignal", old.StopSignal, new.StopSignal)
+	compareMaps("env", envMap(old.Env), envMap(new.Env))
+	compareMaps("port", setMap(old.ExposedPorts), setMap(new.ExposedPorts))
+	compareMaps("volume", setMap(old.Volumes), setMap(new.Volumes))
+	compareMaps("label", old.Labels, new.Labels)
+
+	return changes
+}
+
+// joinArgs renders an exec-form argument list
+func joinArgs(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// envMap converts KEY=value entries into a map
+func envMap(env []string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		// Keep empty values distinguishable from missing ones
+		if value == "" {
+			value = `""`
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// setMap converts a set such as ExposedPorts into a map with placeholder values
+func setMap(set map[string]struct{}) map[string]string {
+	values := make(map[string]string)
+	for key := range set {
+		values[key] = "present"
+	}
+	return values
+}
+
+// total sums sizes, ignoring unknown entries
+func total(sizes []int64) int64 {
+	var sum int64
+	for _, size := range sizes {
+		if size > 0 {
+			sum += size
+		}
+	}
+	return sum
+}
@@ -0,0 +1,112 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+)
+
+func testImage(history []string, diffIDs []string, sizes []int64, config docker.Config) Image {
+	metadata := &docker.ImageMetadata{Config: config}
+	for _, createdBy := range history {
+		nop := strings.HasPrefix(createdBy, "/bin/sh -c #(nop)")
+		metadata.History = append(metadata.History, docker.History{
+			Created:    "2024-01-01T00:00:00Z",
+			CreatedBy:  createdBy,
+			EmptyLayer: nop && !strings.Contains(createdBy, "ADD") && !strings.Contains(createdBy, "COPY"),
+		})
+	}
+	metadata.RootFS.DiffIDs = diffIDs
+	metadata.Layers = diffIDs
+	return Image{
+		Metadata:     metadata,
+		Instructions: dockerfile.NewGenerator(metadata).Instructions(),
+		LayerSizes:   sizes,
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := testImage(
+		[]string{
+			"/bin/sh -c #(nop) ADD file:base in / ",
+			"/bin/sh -c #(nop) COPY dir:app in /app ",
+			"/bin/sh -c make",
+			"/bin/sh -c #(nop)  CMD [\"/app/run\"]",
+		},
+		[]string{"sha256:base", "sha256:app1", "sha256:make1"},
+		[]int64{100, 200, 300},
+		docker.Config{User: "root", Env: []string{"VERSION=1.0", "PATH=/bin"}, Labels: map[string]string{"a": "1"}},
+	)
+	new := testImage(
+		[]string{
+			"/bin/sh -c #(nop) ADD file:base in / ",
+			"/bin/sh -c #(nop) COPY dir:app in /app ",
+			"/bin/sh -c make release",
+			"/bin/sh -c #(nop)  EXPOSE 8080",
+			"/bin/sh -c #(nop)  CMD [\"/app/run\"]",
+		},
+		[]string{"sha256:base", "sha256:app1", "sha256:make2"},
+		[]int64{100, 200, 350},
+		docker.Config{User: "app", Env: []string{"VERSION=1.1", "PATH=/bin"}, ExposedPorts: map[string]struct{}{"8080/tcp": {}}},
+	)
+
+	report := Compare(old, new)
+
+	if report.SharedLayers != 2 {
+		t.Errorf("SharedLayers = %d, want 2", report.SharedLayers)
+	}
+	if report.SizeDelta != 50 {
+		t.Errorf("SizeDelta = %d, want 50", report.SizeDelta)
+	}
+
+	var ops []string
+	for _, change := range report.Instructions {
+		ops = append(ops, string(change.Op))
+	}
+	want := "same,same,changed,added,same"
+	if strings.Join(ops, ",") != want {
+		t.Errorf("Instruction ops = %v, want %s", ops, want)
+	}
+	if changed := report.Instructions[2]; changed.OldSize != 300 || changed.NewSize != 350 {
+		t.Errorf("Unexpected sizes for changed instruction: %+v", changed)
+	}
+
+	fields := make(map[string]ConfigChange)
+	for _, change := range report.Config {
+		fields[change.Field] = change
+	}
+	if change := fields["user"]; change.Op != OpChanged || change.Old != "root" || change.New != "app" {
+		t.Errorf("Unexpected user change: %+v", change)
+	}
+	if change := fields["env VERSION"]; change.Op != OpChanged || change.New != "1.1" {
+		t.Errorf("Unexpected env change: %+v", change)
+	}
+	if change := fields["port 8080/tcp"]; change.Op != OpAdded {
+		t.Errorf("Unexpected port change: %+v", change)
+	}
+	if change := fields["label a"]; change.Op != OpRemoved {
+		t.Errorf("Unexpected label change: %+v", change)
+	}
+	if _, ok := fields["env PATH"]; ok {
+		t.Error("Unchanged env reported as a change")
+	}
+
+	layerOps := make(map[string]LayerChange)
+	for _, change := range report.Layers {
+		layerOps[change.DiffID] = change
+	}
+	if !layerOps["sha256:base"].Shared || layerOps["sha256:make2"].Op != OpAdded || layerOps["sha256:make1"].Op != OpRemoved {
+		t.Errorf("Unexpected layer changes: %+v", report.Layers)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, report, false); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "~ RUN make") || !strings.Contains(buf.String(), "+ EXPOSE 8080") {
+		t.Errorf("Unexpected text output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// opSymbols prefixes each row in text output
+var opSymbols = map[Op]string{
+	OpSame:    " ",
+	OpAdded:   "+",
+	OpRemoved: "-",
+	OpChanged: "~",
+}
+
+// WriteText writes a human readable report. Unchanged instructions are only
+// listed when verbose is set.
+func WriteText(w io.Writer, report *Report, verbose bool) error {
+	p := &printer{w: w}
+
+	p.printf("Old: %s\n", tagsOrUnknown(report.OldTags))
+	p.printf("New: %s\n", tagsOrUnknown(report.NewTags))
+	p.printf("Shared base layers: %d\n", report.SharedLayers)
+	p.printf("Size change: %s\n", signedSize(report.SizeDelta))
+
+	p.printf("\nInstructions:\n")
+	changed := 0
+	for _, change := range report.Instructions {
+		if change.Op == OpSame {
+			if verbose {
+				p.printf("  %s %s\n", opSymbols[change.Op], change.New)
+			}
+			continue
+		}
+		changed++
+		switch change.Op {
+		case OpAdded:
+			p.printf("  + %s%s\n", change.New, sizeNote(change.NewSize))
+		case OpRemoved:
+			p.printf("  - %s%s\n", change.Old, sizeNote(change.OldSize))
+		case OpChanged:
+			p.printf("  ~ %s%s\n", change.Old, sizeNote(change.OldSize))
+			p.printf("    -> %s%s\n", change.New, sizeNote(change.NewSize))
+		}
+	}
+	if changed == 0 {
+		p.printf("  No instruction changes\n")
+	}
+
+	p.printf("\nConfig:\n")
+	if len(report.Config) == 0 {
+		p.printf("  No config changes\n")
+	}
+	for _, change := range report.Config {
+		switch change.Op {
+		case OpAdded:
+			p.printf("  + %s: %s\n", change.Field, change.New)
+		case OpRemoved:
+			p.printf("  - %s: %s\n", change.Field, change.Old)
+		case OpChanged:
+			p.printf("  ~ %s: %s -> %s\n", change.Field, change.Old, change.New)
+		}
+	}
+
+	p.printf("\nLayers:\n")
+	for _, change := range report.Layers {
+		note := ""
+		switch {
+		case change.Shared:
+			note = "shared base"
+		case change.Op == OpSame:
+			note = fmt.Sprintf("reused from old layer %d", change.OldIndex)
+		}
+		index := change.NewIndex
+		if change.Op == OpRemoved {
+			index = change.OldIndex
+		}
+		line := fmt.Sprintf("  %s %2d %s", opSymbols[change.Op], index, shortDigest(change.DiffID))
+		if change.Size >= 0 {
+			line += fmt.Sprintf("  %10s", utils.FormatSize(change.Size))
+		}
+		if note != "" {
+			line += "  " + note
+		}
+		p.printf("%s\n", line)
+	}
+
+	return p.err
+}
+
+// printer writes formatted output and remembers the first error
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func tagsOrUnknown(tags []string) string {
+	if len(tags) == 0 {
+		return "<untagged>"
+	}
+	return strings.Join(tags, ", ")
+}
+
+func sizeNote(size int64) string {
+	if size < 0 {
+		return ""
+	}
+	return fmt.Sprintf("  (%s)", utils.FormatSize(size))
+}
+
+func signedSize(size int64) string {
+	if size > 0 {
+		return "+" + utils.FormatSize(size)
+	}
+	return utils.FormatSize(size)
+}
+
+// shortDigest trims a digest for display
+func shortDigest(digest string) string {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || len(hex) <= 12 {
+		return digest
+	}
+	return algorithm + ":" + hex[:12]
+}
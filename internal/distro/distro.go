@@ -0,0 +1,225 @@
+// Package distro identifies the Linux distribution of an image from release
+// files in its final filesystem and reports its end-of-life status.
+package distro
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/layer"
+)
+
+// maxReleaseFileSize bounds the release files that are read
+const maxReleaseFileSize = 64 << 10
+
+// Release describes the distribution installed in an image
+type Release struct {
+	// ID is the lower-case distribution identifier from os-release, e.g. "debian"
+	ID         string   `json:"id"`
+	IDLike     []string `json:"id_like,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	PrettyName string   `json:"pretty_name,omitempty"`
+	// Version is the most precise version found, e.g. "12.5" or "3.19.1"
+	Version  string `json:"version,omitempty"`
+	Codename string `json:"codename,omitempty"`
+	// Sources lists the files the release was read from
+	Sources []string `json:"sources"`
+}
+
+// Major returns the first component of the version
+func (r *Release) Major() string {
+	major, _, _ := strings.Cut(r.Version, ".")
+	return major
+}
+
+// MajorMinor returns the first two components of the version
+func (r *Release) MajorMinor() string {
+	parts := strings.SplitN(r.Version, ".", 3)
+	if len(parts) < 2 {
+		return r.Version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// String returns a human readable description of the release
+func (r *Release) String() string {
+	if r.PrettyName != "" {
+		return r.PrettyName
+	}
+	description := r.Name
+	if description == "" {
+		description = r.ID
+	}
+	if r.Version != "" {
+		description += " " + r.Version
+	}
+	if r.Codename != "" {
+		description += " (" + r.Codename + ")"
+	}
+	return description
+}
+
+var (
+	versionRegex       = regexp.MustCompile(`\d+(\.\d+)*`)
+	redhatReleaseRegex = regexp.MustCompile(`^(.+?) release (\d+(?:\.\d+)*)(?: \((.+)\))?`)
+)
+
+// redhatIDs maps the names used in /etc/redhat-release to os-release IDs
+var redhatIDs = map[string]string{
+	"centos":                          "centos",
+	"centos linux":                    "centos",
+	"centos stream":                   "centos",
+	"red hat enterprise linux":        "rhel",
+	"red hat enterprise linux server": "rhel",
+	"rocky linux":                     "rocky",
+	"almalinux":                       "almalinux",
+	"fedora":                          "fedora",
+	"oracle linux server":             "ol",
+}
+
+// Detect reads the release files in the final filesystem of an image. It
+// returns nil if no distribution could be identified.
+func Detect(view *layer.View) *Release {
+	release := &Release{}
+
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if data, ok := readFile(view, p); ok {
+			release.parseOSRelease(data)
+			release.Sources = append(release.Sources, p)
+			break
+		}
+	}
+	// Older images and minimal ones without os-release still ship lsb-release
+	if release.ID == "" {
+		if data, ok := readFile(view, "/etc/lsb-release"); ok {
+			release.parseLSBRelease(data)
+			release.Sources = append(release.Sources, "/etc/lsb-release")
+		}
+	}
+
+	// Distribution specific files are more precise than os-release
+	if data, ok := readFile(view, "/etc/alpine-release"); ok {
+		if version := strings.TrimSpace(data); versionRegex.MatchString(version) {
+			release.setDefault("alpine", "Alpine Linux")
+			release.Version = version
+			release.Sources = append(release.Sources, "/etc/alpine-release")
+		}
+	}
+	if data, ok := readFile(view, "/etc/debian_version"); ok && (release.ID == "" || release.ID == "debian") {
+		version := strings.TrimSpace(data)
+		release.setDefault("debian", "Debian GNU/Linux")
+		// Testing and unstable report a codename such as "trixie/sid" instead
+		if versionRegex.FindString(version) == version {
+			release.Version = version
+		} else if release.Codename == "" {
+			release.Codename, _, _ = strings.Cut(version, "/")
+		}
+		release.Sources = append(release.Sources, "/etc/debian_version")
+	}
+	for _, p := range []string{"/etc/redhat-release", "/etc/centos-release", "/etc/system-release"} {
+		data, ok := readFile(view, p)
+		if !ok {
+			continue
+		}
+		match := redhatReleaseRegex.FindStringSubmatch(strings.TrimSpace(data))
+		if match == nil {
+			continue
+		}
+		if id, known := redhatIDs[strings.ToLower(match[1])]; known {
+			release.setDefault(id, match[1])
+		}
+		if release.ID != "" && len(match[2]) > len(release.Version) {
+			release.Version = match[2]
+		}
+		release.Sources = append(release.Sources, p)
+		break
+	}
+
+	if release.ID == "" {
+		return nil
+	}
+	return release
+}
+
+// setDefault fills in the ID and name if os-release did not provide them
+func (r *Release) setDefault(id, name string) {
+	if r.ID == "" {
+		r.ID = id
+	}
+	if r.Name == "" {
+		r.Name = name
+	}
+}
+
+// parseOSRelease reads the KEY=value format of os-release(5)
+func (r *Release) parseOSRelease(data string) {
+	fields := parseKeyValues(data)
+	r.ID = strings.ToLower(fields["ID"])
+	r.IDLike = strings.Fields(strings.ToLower(fields["ID_LIKE"]))
+	r.Name = fields["NAME"]
+	r.PrettyName = fields["PRETTY_NAME"]
+	r.Version = fields["VERSION_ID"]
+	r.Codename = fields["VERSION_CODENAME"]
+	if r.Codename == "" {
+		r.Codename = fields["UBUNTU_CODENAME"]
+	}
+	// Older releases only put the codename in VERSION, e.g. "9 (stretch)"
+	if r.Codename == "" {
+		if _, rest, ok := strings.Cut(fields["VERSION"], "("); ok {
+			r.Codename = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(rest), ")"))
+		}
+	}
+}
+
+// parseLSBRelease reads /etc/lsb-release
+func (r *Release) parseLSBRelease(data string) {
+	fields := parseKeyValues(data)
+	r.ID = strings.ToLower(fields["DISTRIB_ID"])
+	r.Name = fields["DISTRIB_ID"]
+	r.PrettyName = fields["DISTRIB_DESCRIPTION"]
+	r.Version = fields["DISTRIB_RELEASE"]
+	r.Codename = fields["DISTRIB_CODENAME"]
+}
+
+// parseKeyValues parses shell-style KEY=value lines, removing quotes
+func parseKeyValues(data string) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		fields[strings.TrimSpace(key)] = value
+	}
+	return fields
+}
+
+// readFile returns the contents of a small file from the view, following symlinks
+func readFile(view *layer.View, p string) (string, bool) {
+	entry, err := view.Resolve(p)
+	if err != nil || entry.Deleted || entry.IsDir() || entry.Header.Size > maxReleaseFileSize {
+		return "", false
+	}
+	rc, err := view.Open(entry)
+	if err != nil {
+		return "", false
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxReleaseFileSize))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
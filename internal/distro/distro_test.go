@@ -0,0 +1,155 @@
+package distro
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+func detect(t *testing.T, files ...testimage.File) *Release {
+	t.Helper()
+	layers := testimage.Layers{testimage.Layer(files...)}
+	view, err := layer.NewView(layers, len(layers))
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+	return Detect(view)
+}
+
+func TestDetect(t *testing.T) {
+	testCases := []struct {
+		name         string
+		files        []testimage.File
+		wantID       string
+		wantVersion  string
+		wantCodename string
+	}{
+		{
+			name: "debian with symlinked os-release",
+			files: []testimage.File{
+				testimage.Reg("usr/lib/os-release", "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\nVERSION_CODENAME=bookworm\n"),
+				testimage.Symlink("etc/os-release", "../usr/lib/os-release"),
+				testimage.Reg("etc/debian_version", "12.5\n"),
+			},
+			wantID: "debian", wantVersion: "12.5", wantCodename: "bookworm",
+		},
+		{
+			name: "debian testing",
+			files: []testimage.File{
+				testimage.Reg("etc/os-release", "ID=debian\nVERSION_CODENAME=trixie\n"),
+				testimage.Reg("etc/debian_version", "trixie/sid\n"),
+			},
+			wantID: "debian", wantCodename: "trixie",
+		},
+		{
+			name: "alpine",
+			files: []testimage.File{
+				testimage.Reg("etc/os-release", "ID=alpine\nVERSION_ID=3.19.1\n"),
+				testimage.Reg("etc/alpine-release", "3.19.1\n"),
+			},
+			wantID: "alpine", wantVersion: "3.19.1",
+		},
+		{
+			name: "ubuntu ignores debian_version",
+			files: []testimage.File{
+				testimage.Reg("etc/os-release", "ID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\nUBUNTU_CODENAME=jammy\n"),
+				testimage.Reg("etc/debian_version", "bookworm/sid\n"),
+			},
+			wantID: "ubuntu", wantVersion: "22.04", wantCodename: "jammy",
+		},
+		{
+			name: "centos without os-release",
+			files: []testimage.File{
+				testimage.Reg("etc/centos-release", "CentOS Linux release 7.9.2009 (Core)\n"),
+			},
+			wantID: "centos", wantVersion: "7.9.2009",
+		},
+		{
+			name: "old os-release with codename in VERSION",
+			files: []testimage.File{
+				testimage.Reg("etc/os-release", "ID=debian\nVERSION_ID=\"9\"\nVERSION=\"9 (stretch)\"\n"),
+			},
+			wantID: "debian", wantVersion: "9", wantCodename: "stretch",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			release := detect(t, tc.files...)
+			if release == nil {
+				t.Fatalf("Detect() = nil")
+			}
+			if release.ID != tc.wantID || release.Version != tc.wantVersion || release.Codename != tc.wantCodename {
+				t.Errorf("Detect() = %+v, want %s %s %s", release, tc.wantID, tc.wantVersion, tc.wantCodename)
+			}
+		})
+	}
+
+	if release := detect(t, testimage.Reg("app", "binary")); release != nil {
+		t.Errorf("Expected no release for a scratch image, got %+v", release)
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := DefaultTable()
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		release       Release
+		wantCycle     string
+		wantEndOfLife bool
+		wantImage     string
+	}{
+		{Release{ID: "debian", Version: "12.5"}, "12", false, "debian:12-slim"},
+		{Release{ID: "debian", Version: "10.13"}, "10", true, "debian:10-slim"},
+		{Release{ID: "debian", Codename: "trixie"}, "13", false, "debian:13-slim"},
+		{Release{ID: "debian", Codename: "forky"}, "", false, "debian:forky-slim"},
+		{Release{ID: "alpine", Version: "3.19.1"}, "3.19", true, "alpine:3.19"},
+		{Release{ID: "ubuntu", Version: "22.04"}, "22.04", false, "ubuntu:22.04"},
+		{Release{ID: "centos", Version: "7.9.2009"}, "7", true, "centos:7"},
+	}
+
+	for _, tc := range testCases {
+		status, ok := table.Lookup(&tc.release, now)
+		if ok != (tc.wantCycle != "") || status.Cycle != tc.wantCycle || status.EndOfLife != tc.wantEndOfLife {
+			t.Errorf("Lookup(%+v) = %+v, %v; want cycle %s, end of life %v", tc.release, status, ok, tc.wantCycle, tc.wantEndOfLife)
+		}
+		if image := table.BaseImage(&tc.release); image != tc.wantImage {
+			t.Errorf("BaseImage(%+v) = %s, want %s", tc.release, image, tc.wantImage)
+		}
+	}
+
+	if _, ok := table.Lookup(&Release{ID: "plan9", Version: "4"}, now); ok {
+		t.Errorf("Expected no status for an unknown distribution")
+	}
+}
+
+func TestTableMerge(t *testing.T) {
+	table := DefaultTable()
+	extra, err := LoadTable(strings.NewReader(`{
+		"debian": {"cycles": [{"cycle": "12", "codename": "bookworm", "eol": "2026-01-01"}]},
+		"wolfi": {"image": "cgr.dev/chainguard/wolfi-base", "cycles": [{"cycle": "20230201", "eol": "2099-01-01"}]}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	table.Merge(extra)
+
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	if status, _ := table.Lookup(&Release{ID: "debian", Version: "12"}, now); !status.EndOfLife {
+		t.Errorf("Expected the override to mark Debian 12 as end of life, got %+v", status)
+	}
+	if table.BaseImage(&Release{ID: "debian", Version: "12"}) != "debian:12-slim" {
+		t.Errorf("Merging cycles should keep the existing image")
+	}
+	if _, ok := table.Lookup(&Release{ID: "wolfi", Version: "20230201"}, now); !ok {
+		t.Errorf("Expected the new distribution to be added")
+	}
+
+	if _, err := LoadTable(strings.NewReader(`{"debian": {"cycles": [{"cycle": "12", "eol": "soon"}]}}`)); err == nil {
+		t.Errorf("Expected an error for an invalid date")
+	}
+}
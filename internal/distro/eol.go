@@ -0,0 +1,176 @@
+package distro
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// defaultTable is the end-of-life data shipped with pasgan. It can be
+// extended or corrected at runtime with LoadTable and Merge.
+//
+//go:embed eol.json
+var defaultTable []byte
+
+// Cycle is a release series of a distribution, such as Debian 12
+type Cycle struct {
+	Cycle    string `json:"cycle"`
+	Codename string `json:"codename,omitempty"`
+	// EOL is the date security support ends, as YYYY-MM-DD
+	EOL string `json:"eol"`
+}
+
+// Distro holds the release cycles of a distribution and the official image
+// that provides it
+type Distro struct {
+	// Image is a template for the official base image. {version}, {major},
+	// {majorminor} and {codename} are replaced from the detected release.
+	Image  string  `json:"image,omitempty"`
+	Cycles []Cycle `json:"cycles"`
+}
+
+// Table maps os-release IDs to end-of-life data
+type Table map[string]*Distro
+
+// Status is the support status of a detected release
+type Status struct {
+	Cycle string `json:"cycle"`
+	EOL   string `json:"eol"`
+	// EndOfLife is true when the EOL date has passed
+	EndOfLife bool `json:"end_of_life"`
+	// DaysLeft is the number of days until the EOL date, negative once it has passed
+	DaysLeft int `json:"days_left"`
+}
+
+// DefaultTable returns the embedded end-of-life table
+func DefaultTable() Table {
+	table, err := LoadTable(strings.NewReader(string(defaultTable)))
+	if err != nil {
+		panic(fmt.Sprintf("distro: invalid embedded EOL table: %v", err))
+	}
+	return table
+}
+
+// LoadTable reads an end-of-life table in the same JSON format as the embedded one
+func LoadTable(r io.Reader) (Table, error) {
+	var table Table
+	if err := json.NewDecoder(r).Decode(&table); err != nil {
+		return nil, fmt.Errorf("failed to parse EOL table: %w", err)
+	}
+	for id, distro := range table {
+		if distro == nil {
+			return nil, fmt.Errorf("EOL table entry %s is empty", id)
+		}
+		for _, cycle := range distro.Cycles {
+			if _, err := time.Parse(time.DateOnly, cycle.EOL); err != nil {
+				return nil, fmt.Errorf("EOL table entry %s %s: invalid date %q", id, cycle.Cycle, cycle.EOL)
+			}
+		}
+	}
+	return table, nil
+}
+
+// Merge adds the distributions and cycles of other to the table. Cycles and
+// images in other replace existing ones.
+func (t Table) Merge(other Table) {
+	for id, distro := range other {
+		existing, ok := t[id]
+		if !ok {
+			t[id] = distro
+			continue
+		}
+		if distro.Image != "" {
+			existing.Image = distro.Image
+		}
+		for _, cycle := range distro.Cycles {
+			replaced := false
+			for i := range existing.Cycles {
+				if existing.Cycles[i].Cycle == cycle.Cycle {
+					existing.Cycles[i] = cycle
+					replaced = true
+				}
+			}
+			if !replaced {
+				existing.Cycles = append(existing.Cycles, cycle)
+			}
+		}
+	}
+}
+
+// Lookup returns the support status of a release on the given date
+func (t Table) Lookup(release *Release, now time.Time) (Status, bool) {
+	cycle, ok := t.cycle(release)
+	if !ok {
+		return Status{}, false
+	}
+	eol, _ := time.Parse(time.DateOnly, cycle.EOL)
+	today := now.UTC().Truncate(24 * time.Hour)
+	return Status{
+		Cycle:     cycle.Cycle,
+		EOL:       cycle.EOL,
+		EndOfLife: !today.Before(eol.AddDate(0, 0, 1)),
+		DaysLeft:  int(eol.Sub(today).Hours() / 24),
+	}, true
+}
+
+// cycle finds the release cycle matching a release by version, or by codename
+// for releases such as Debian testing that have no version
+func (t Table) cycle(release *Release) (Cycle, bool) {
+	distro, ok := t[release.ID]
+	if !ok {
+		return Cycle{}, false
+	}
+	// Prefer the longest match so "3.1" does not match "3.19"
+	best := -1
+	for i, cycle := range distro.Cycles {
+		if release.Version == cycle.Cycle || strings.HasPrefix(release.Version, cycle.Cycle+".") {
+			if best < 0 || len(cycle.Cycle) > len(distro.Cycles[best].Cycle) {
+				best = i
+			}
+		}
+	}
+	if best >= 0 {
+		return distro.Cycles[best], true
+	}
+	if release.Version == "" && release.Codename != "" {
+		for _, cycle := range distro.Cycles {
+			if strings.EqualFold(cycle.Codename, release.Codename) {
+				return cycle, true
+			}
+		}
+	}
+	return Cycle{}, false
+}
+
+// BaseImage proposes the official image a release most likely came from, or
+// an empty string if the distribution or version is unknown
+func (t Table) BaseImage(release *Release) string {
+	distro, ok := t[release.ID]
+	if !ok || distro.Image == "" {
+		return ""
+	}
+	// Prefer the version of the matching cycle so "12.5" becomes "debian:12-slim"
+	version := release.Version
+	if cycle, ok := t.cycle(release); ok {
+		version = cycle.Cycle
+	}
+	// Releases without a version, such as Debian testing, are tagged by codename
+	if version == "" {
+		if release.Codename == "" {
+			return ""
+		}
+		return strings.NewReplacer("{version}", release.Codename, "{major}", release.Codename,
+			"{majorminor}", release.Codename, "{codename}", release.Codename).Replace(distro.Image)
+	}
+
+	versioned := &Release{Version: version}
+	return strings.NewReplacer(
+		"{version}", version,
+		"{major}", versioned.Major(),
+		"{majorminor}", versioned.MajorMinor(),
+		"{codename}", release.Codename,
+	).Replace(distro.Image)
+}
@@ -0,0 +1,207 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Shapes of the metadata JSON accepted by ParseJSON
+const (
+	JSONInspect = "docker image inspect"
+	JSONHistory = "docker history"
+	JSONConfig  = "image config"
+)
+
+// inspectJSON is an image as printed by docker image inspect
+type inspectJSON struct {
+	ID            string    `json:"Id"`
+	RepoTags      []string  `json:"RepoTags"`
+	Created       time.Time `json:"Created"`
+	DockerVersion string    `json:"DockerVersion"`
+	Author        string    `json:"Author"`
+	Config        *Config   `json:"Config"`
+	Architecture  string    `json:"Architecture"`
+	Os            string    `json:"Os"`
+	RootFS        struct {
+		Type   string   `json:"Type"`
+		Layers []string `json:"Layers"`
+	} `json:"RootFS"`
+}
+
+// historyJSON is a line of docker history --no-trunc --format '{{json .}}'
+type historyJSON struct {
+	ID        string `json:"ID"`
+	CreatedAt string `json:"CreatedAt"`
+	CreatedBy string `json:"CreatedBy"`
+	Size      string `json:"Size"`
+	Comment   string `json:"Comment"`
+}
+
+// historyTimeLayouts are the CreatedAt formats of current and older docker clients
+var historyTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05 -0700 MST"}
+
+// IsJSON reports whether data looks like a JSON document rather than an archive
+func IsJSON(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// ParseJSON reads image metadata from the output of docker image inspect,
+// docker history --no-trunc --format '{{json .}}', crane config or skopeo
+// inspect --config, for when the image itself is not available. It returns
+// the shape that was recognised. The metadata has no layers to read.
+func ParseJSON(data []byte) (*ImageMetadata, string, error) {
+	data = bytes.TrimSpace(data)
+	if !IsJSON(data) {
+		return nil, "", fmt.Errorf("not a JSON document")
+	}
+
+	// docker image inspect prints an array, docker history one object per line
+	var objects []json.RawMessage
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, "", fmt.Errorf("failed to parse JSON: %w", err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var object json.RawMessage
+			if err := decoder.Decode(&object); err != nil {
+				return nil, "", fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			objects = append(objects, object)
+		}
+	}
+	if len(objects) == 0 {
+		return nil, "", fmt.Errorf("the JSON document is empty")
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(objects[0], &keys); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	switch {
+	case keys["Id"] != nil && keys["RootFS"] != nil:
+		metadata, err := parseInspect(objects[0])
+		return metadata, JSONInspect, err
+	case keys["CreatedBy"] != nil:
+		metadata, err := parseHistory(objects)
+		return metadata, JSONHistory, err
+	case keys["rootfs"] != nil || keys["history"] != nil || keys["config"] != nil:
+		var metadata ImageMetadata
+		if err := json.Unmarshal(objects[0], &metadata); err != nil {
+			return nil, "", fmt.Errorf("failed to parse image config: %w", err)
+		}
+		return &metadata, JSONConfig, nil
+	}
+	return nil, "", fmt.Errorf("unrecognised JSON, expected %s, %s or %s output", JSONInspect, JSONHistory, JSONConfig)
+}
+
+func parseInspect(data []byte) (*ImageMetadata, error) {
+	var inspect inspectJSON
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to parse %s output: %w", JSONInspect, err)
+	}
+	metadata := &ImageMetadata{
+		ID:            inspect.ID,
+		Author:        inspect.Author,
+		RepoTags:      inspect.RepoTags,
+		Architecture:  inspect.Architecture,
+		OS:            inspect.Os,
+		Created:       inspect.Created,
+		DockerVersion: inspect.DockerVersion,
+		RootFS:        RootFS{Type: inspect.RootFS.Type, DiffIDs: inspect.RootFS.Layers},
+	}
+	if inspect.Config != nil {
+		metadata.Config = *inspect.Config
+	}
+	return metadata, nil
+}
+
+func parseHistory(objects []json.RawMessage) (*ImageMetadata, error) {
+	metadata := &ImageMetadata{}
+	for _, object := range objects {
+		var line historyJSON
+		if err := json.Unmarshal(object, &line); err != nil {
+			return nil, fmt.Errorf("failed to parse %s output: %w", JSONHistory, err)
+		}
+		entry := History{
+			Created:    line.CreatedAt,
+			CreatedBy:  line.CreatedBy,
+			Comment:    line.Comment,
+			EmptyLayer: isZeroSize(line.Size),
+		}
+		for _, layout := range historyTimeLayouts {
+			if created, err := time.Parse(layout, line.CreatedAt); err == nil {
+				entry.Created = created.UTC().Format(time.RFC3339Nano)
+				break
+			}
+		}
+		metadata.History = append(metadata.History, entry)
+	}
+
+	// docker history lists the newest entry first
+	for i, j := 0, len(metadata.History)-1; i < j; i, j = i+1, j-1 {
+		metadata.History[i], metadata.History[j] = metadata.History[j], metadata.History[i]
+	}
+	if n := len(metadata.History); n > 0 {
+		metadata.Created, _ = time.Parse(time.RFC3339Nano, metadata.History[n-1].Created)
+	}
+	return metadata, nil
+}
+
+// isZeroSize reports whether a docker history size such as "0B" is empty
+func isZeroSize(size string) bool {
+	size = strings.TrimSpace(size)
+	return size == "0" || size == "0B" || size == "0 B"
+}
+
+// Merge fills the fields of m that are empty from other, such as the history
+// of docker history output into docker image inspect output
+func (m *ImageMetadata) Merge(other *ImageMetadata) {
+	if m.ID == "" {
+		m.ID = other.ID
+	}
+	if m.Author == "" {
+		m.Author = other.Author
+	}
+	if reflect.ValueOf(m.Config).IsZero() {
+		m.Config = other.Config
+	}
+	if len(m.RepoTags) == 0 {
+		m.RepoTags = other.RepoTags
+	}
+	if m.Architecture == "" {
+		m.Architecture = other.Architecture
+	}
+	if m.OS == "" {
+		m.OS = other.OS
+	}
+	if m.Created.IsZero() {
+		m.Created = other.Created
+	}
+	if m.DockerVersion == "" {
+		m.DockerVersion = other.DockerVersion
+	}
+	if len(m.History) == 0 {
+		m.History = other.History
+	}
+	if len(m.RootFS.DiffIDs) == 0 {
+		m.RootFS = other.RootFS
+	}
+}
+
+// TruncatedHistory reports whether any history command was shortened by
+// docker history run without --no-trunc
+func (m *ImageMetadata) TruncatedHistory() bool {
+	for _, entry := range m.History {
+		if strings.HasSuffix(entry.CreatedBy, "…") {
+			return true
+		}
+	}
+	return false
+}
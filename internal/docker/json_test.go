@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"testing"
+)
+
+const inspectOutput = `[
+    {
+        "Id": "sha256:1f1b3e2c",
+        "RepoTags": ["example/web:1.0"],
+        "Created": "2024-03-01T00:00:08Z",
+        "Config": {"User": "node", "Env": ["NODE_VERSION=20.11.1"], "Cmd": ["node", "server.js"], "WorkingDir": "/app"},
+        "Architecture": "arm64",
+        "Os": "linux",
+        "RootFS": {"Type": "layers", "Layers": ["sha256:aaa", "sha256:bbb"]}
+    }
+]`
+
+const historyOutput = `{"Comment":"buildkit.dockerfile.v0","CreatedAt":"2024-03-01T00:00:08Z","CreatedBy":"CMD [\"node\" \"server.js\"]","ID":"sha256:1f1b3e2c","Size":"0B"}
+{"Comment":"buildkit.dockerfile.v0","CreatedAt":"2024-03-01T00:00:07Z","CreatedBy":"COPY . /app # buildkit","ID":"<missing>","Size":"1.2MB"}
+{"Comment":"","CreatedAt":"2024-02-13 00:00:00 +0000 UTC","CreatedBy":"/bin/sh -c #(nop) ADD file:abc in / ","ID":"<missing>","Size":"74.8MB"}
+`
+
+const configOutput = `{"architecture":"amd64","os":"linux","config":{"Entrypoint":["/server"]},
+"rootfs":{"type":"layers","diff_ids":["sha256:aaa"]},
+"history":[{"created":"2024-01-01T00:00:00Z","created_by":"COPY server /server # buildkit"}]}`
+
+func TestParseJSON(t *testing.T) {
+	inspect, kind, err := ParseJSON([]byte(inspectOutput))
+	if err != nil || kind != JSONInspect {
+		t.Fatalf("ParseJSON(inspect) = %s, %v", kind, err)
+	}
+	if inspect.Config.User != "node" || inspect.Architecture != "arm64" || len(inspect.RootFS.DiffIDs) != 2 || inspect.RepoTags[0] != "example/web:1.0" {
+		t.Errorf("ParseJSON(inspect) = %+v", inspect)
+	}
+
+	history, kind, err := ParseJSON([]byte(historyOutput))
+	if err != nil || kind != JSONHistory {
+		t.Fatalf("ParseJSON(history) = %s, %v", kind, err)
+	}
+	if len(history.History) != 3 {
+		t.Fatalf("ParseJSON(history) has %d entries, want 3", len(history.History))
+	}
+	first, last := history.History[0], history.History[2]
+	if first.CreatedBy != "/bin/sh -c #(nop) ADD file:abc in / " || first.Created != "2024-02-13T00:00:00Z" || first.EmptyLayer {
+		t.Errorf("History[0] = %+v, want the oldest ADD entry", first)
+	}
+	if !last.EmptyLayer || last.CreatedBy != `CMD ["node" "server.js"]` {
+		t.Errorf("History[2] = %+v, want the empty CMD entry", last)
+	}
+
+	config, kind, err := ParseJSON([]byte(configOutput))
+	if err != nil || kind != JSONConfig {
+		t.Fatalf("ParseJSON(config) = %s, %v", kind, err)
+	}
+	if len(config.History) != 1 || config.Config.Entrypoint[0] != "/server" {
+		t.Errorf("ParseJSON(config) = %+v", config)
+	}
+
+	inspect.Merge(history)
+	if len(inspect.History) != 3 || inspect.Config.User != "node" {
+		t.Errorf("Merge() = %+v, want the inspect config with the history", inspect)
+	}
+
+	for _, invalid := range []string{`{"name": "not an image"}`, `not json`, `[]`} {
+		if _, _, err := ParseJSON([]byte(invalid)); err == nil {
+			t.Errorf("ParseJSON(%q) succeeded, want an error", invalid)
+		}
+	}
+}
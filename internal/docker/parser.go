@@ -1,25 +1,26 @@
 package docker
 
 import (
+	"archive/tar"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/pkg/source"
 	"github.com/raesene/pasgan/pkg/utils"
 )
 
-// ManifestItem represents an item in the manifest.json file
-type ManifestItem struct {
-	Config   string   `json:"Config"`
-	RepoTags []string `json:"RepoTags"`
-	Layers   []string `json:"Layers"`
-}
-
 // ImageMetadata represents Docker image metadata
 type ImageMetadata struct {
 	ID           string              `json:"id,omitempty"`
+	Author       string              `json:"author,omitempty"`
 	Config       Config              `json:"config"`
 	RepoTags     []string            `json:"RepoTags"`
 	Architecture string              `json:"architecture"`
@@ -29,7 +30,14 @@ type ImageMetadata struct {
 	History      []History           `json:"history"`
 	RootFS       RootFS              `json:"rootfs"`
 	Layers       []string            `json:"layers"`
-	LayerConfigs map[string]*LayerConfig `json:"-"`
+	// Annotations are the OCI manifest annotations, when the archive has an index.json
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Flat is set for filesystem tarballs written by docker export, which have
+	// a single layer and no image config or history
+	Flat bool `json:"flat,omitempty"`
+	// Legacy is set for v1 docker save archives, which have no manifest.json
+	// and whose history is rebuilt from the parent chain of layer json files
+	Legacy bool `json:"legacy,omitempty"`
 }
 
 // RootFS represents the rootfs configuration
@@ -51,120 +59,280 @@ type Config struct {
 	Labels       map[string]string   `json:"Labels"`
 	StopSignal   string              `json:"StopSignal,omitempty"`
 	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"`
+}
+
+// HealthConfig represents the HEALTHCHECK settings stored in the image config
+type HealthConfig struct {
+	Test        []string `json:"Test,omitempty"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
 }
 
 // History represents a layer history entry
 type History struct {
 	Created    string `json:"created"`
 	CreatedBy  string `json:"created_by"`
+	Author     string `json:"author,omitempty"`
 	EmptyLayer bool   `json:"empty_layer,omitempty"`
 	Comment    string `json:"comment,omitempty"`
 }
 
-// LayerConfig represents the configuration for a specific layer
-type LayerConfig struct {
-	ID      string    `json:"id"`
-	Created time.Time `json:"created"`
-	Parent  string    `json:"parent,omitempty"`
-	Config  Config    `json:"config,omitempty"`
-}
-
 // Parser provides functionality to parse Docker images
 type Parser struct {
+	uri    string
+	source source.ImageSource
+	// flat is set when the image is itself a root filesystem tar
+	flat      bool
 	imagePath string
-	workDir   string
+	layers    []string
+	// ctx is the context of ParseContext, which also stops layer reads
+	ctx context.Context
 }
 
-// NewParser creates a new Docker image parser
-func NewParser(imagePath string) (*Parser, error) {
-	// Create a temporary directory for extraction
-	workDir, err := os.MkdirTemp("", "pasgan-")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
-	}
+// NewParser creates a parser for the image at uri, which is a path or a
+// source URI such as oci:path, read with the registered image sources
+func NewParser(uri string) (*Parser, error) {
+	return &Parser{uri: uri}, nil
+}
 
-	return &Parser{
-		imagePath: imagePath,
-		workDir:   workDir,
-	}, nil
+// NewSourceParser creates a parser for an image source that is already open.
+// Cleanup closes the source.
+func NewSourceParser(src source.ImageSource) *Parser {
+	return &Parser{source: src}
 }
 
-// Parse extracts and analyzes a Docker image
+// Parse reads the metadata of the image
 func (p *Parser) Parse() (*ImageMetadata, error) {
-	// Extract the tar file
-	if err := utils.ExtractTar(p.imagePath, p.workDir); err != nil {
-		return nil, fmt.Errorf("failed to extract image archive: %w", err)
-	}
-
-	// Read manifest.json
-	manifestPath := filepath.Join(p.workDir, "manifest.json")
-	manifestData, err := os.ReadFile(manifestPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read manifest.json: %w", err)
-	}
+	return p.ParseContext(context.Background(), source.Options{})
+}
 
-	var manifest []ManifestItem
-	if err := json.Unmarshal(manifestData, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
-	}
+// ParseContext reads the metadata of the image, opening it with opts. It
+// stops once ctx is done, as do reads from the layers it opens.
+func (p *Parser) ParseContext(ctx context.Context, opts source.Options) (*ImageMetadata, error) {
+	p.ctx = ctx
+	if p.source == nil {
+		// docker export writes the container filesystem, not an image archive
+		if imagePath, ok := archivePath(p.uri); ok {
+			flat, err := isRootFS(ctx, imagePath, opts.Limits)
+			if err != nil {
+				return nil, err
+			}
+			if flat {
+				return p.parseRootFS(imagePath), nil
+			}
+		}
 
-	if len(manifest) == 0 {
-		return nil, fmt.Errorf("manifest.json contains no images")
+		src, err := source.OpenContext(ctx, p.uri, opts)
+		if err != nil {
+			return nil, err
+		}
+		p.source = src
 	}
 
-	// Get the first image from the manifest
-	item := manifest[0]
-
 	// Read the image config
-	configPath := filepath.Join(p.workDir, item.Config)
-	configData, err := os.ReadFile(configPath)
+	configData, err := p.source.Config()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
-
 	var imageMetadata ImageMetadata
 	if err := json.Unmarshal(configData, &imageMetadata); err != nil {
 		return nil, fmt.Errorf("failed to parse image config: %w", err)
 	}
 
 	// Set layer paths and repo tags
-	imageMetadata.Layers = item.Layers
-	imageMetadata.RepoTags = item.RepoTags
-
-	// Parse layer configs
-	imageMetadata.LayerConfigs = make(map[string]*LayerConfig)
-	for _, layerPath := range item.Layers {
-		layerDir := filepath.Dir(layerPath)
-		jsonPath := filepath.Join(p.workDir, filepath.Join(layerDir, "json"))
-		
-		// Skip if json file does not exist
-		if _, err := os.Stat(jsonPath); os.IsNotExist(err) {
-			continue
+	layers, err := p.source.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers: %w", err)
+	}
+	for _, descriptor := range layers {
+		imageMetadata.Layers = append(imageMetadata.Layers, descriptor.Name())
+	}
+	p.layers = imageMetadata.Layers
+	if tagged, ok := p.source.(source.Tagged); ok {
+		imageMetadata.RepoTags = tagged.RepoTags()
+	}
+	if legacy, ok := p.source.(source.Legacy); ok {
+		imageMetadata.Legacy = legacy.Legacy()
+	}
+
+	// Configs of some builders leave out the platform that the index records
+	platform := p.source.Platform()
+	if imageMetadata.OS == "" {
+		imageMetadata.OS = platform.OS
+	}
+	if imageMetadata.Architecture == "" {
+		imageMetadata.Architecture = platform.Architecture
+	}
+
+	imageMetadata.Annotations = p.source.Annotations()
+
+	return &imageMetadata, nil
+}
+
+// archivePath returns the path of a file that may be a docker export
+// tarball: a plain path or a docker-archive: URI
+func archivePath(uri string) (string, bool) {
+	scheme, ref := source.Split(uri)
+	if scheme != "" && scheme != source.DockerArchive {
+		return "", false
+	}
+	if info, err := os.Stat(ref); err != nil || info.IsDir() {
+		return "", false
+	}
+	return ref, true
+}
+
+// imageFiles are the top-level files and directories of docker save and OCI
+// image archives
+var imageFiles = map[string]bool{"manifest.json": true, "index.json": true, "oci-layout": true, "repositories": true, "blobs": true}
+
+// rootFSDirs are top-level directories found in every root filesystem
+var rootFSDirs = map[string]bool{"etc": true, "usr": true, "bin": true}
+
+// isRootFS reports whether the tar at imagePath is a flat root filesystem,
+// such as docker export writes, rather than an image archive. It reads up to
+// the first top-level entry that tells them apart, which image archives
+// write first, and no further than limits allow.
+func isRootFS(ctx context.Context, imagePath string, limits utils.Limits) (bool, error) {
+	r, err := layer.Open(imagePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open image archive: %w", err)
+	}
+	defer r.Close()
+
+	rootfs := false
+	total, entries := int64(0), 0
+	maxTotal, maxEntries := limits.TotalSize(), limits.Entries()
+	err = layer.Walk(utils.ContextReader(ctx, r), func(hdr *tar.Header, _ io.Reader) error {
+		top, _, _ := strings.Cut(strings.TrimPrefix(layer.Clean(hdr.Name), "/"), "/")
+		switch {
+		case imageFiles[top], isImageID(strings.TrimSuffix(top, ".json")):
+			return errDecided
+		case rootFSDirs[top]:
+			rootfs = true
+			return errDecided
+		}
+		// Leave archives too large to tell apart to the image source, which
+		// reports the limit
+		total += hdr.Size
+		entries++
+		if (maxTotal >= 0 && total > maxTotal) || (maxEntries >= 0 && entries > maxEntries) {
+			return errDecided
+		}
+		return nil
+	})
+	if err != nil && err != errDecided {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
 		}
+		return false, fmt.Errorf("failed to read image archive: %w", err)
+	}
+	return rootfs, nil
+}
 
-		jsonData, err := os.ReadFile(jsonPath)
+// isImageID reports whether name is a 64 character hex image or layer ID,
+// which older docker save archives name their layer directories and config
+// after
+func isImageID(name string) bool {
+	return len(name) == 64 && strings.Trim(name, "0123456789abcdef") == ""
+}
+
+// errDecided stops isRootFS once the kind of archive is known
+var errDecided = errors.New("archive kind decided")
+
+// parseRootFS returns the metadata of a root filesystem tar, which is its
+// only layer
+func (p *Parser) parseRootFS(imagePath string) *ImageMetadata {
+	p.flat = true
+	p.imagePath = imagePath
+	p.layers = []string{filepath.Base(imagePath)}
+	return &ImageMetadata{
+		OS:     "linux",
+		RootFS: RootFS{Type: "layers"},
+		Layers: p.layers,
+		Flat:   true,
+	}
+}
+
+// OpenLayer returns the uncompressed tar stream of layer i. It is only valid
+// after Parse has succeeded.
+func (p *Parser) OpenLayer(i int) (io.ReadCloser, error) {
+	if i < 0 || i >= len(p.layers) {
+		return nil, fmt.Errorf("layer %d out of range", i)
+	}
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var r io.ReadCloser
+	if p.flat {
+		var err error
+		if r, err = layer.Open(p.imagePath); err != nil {
+			return nil, err
+		}
+	} else {
+		blob, err := p.source.OpenLayer(i)
 		if err != nil {
-			// Skip if can't read
-			continue
+			return nil, fmt.Errorf("failed to open layer: %w", err)
+		}
+		if r, err = layer.OpenBlob(blob); err != nil {
+			return nil, err
 		}
+	}
+	if p.ctx == nil {
+		return r, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{utils.ContextReader(p.ctx, r), r}, nil
+}
+
+// LayerHistoryIndexes maps each layer to the index of the history entry that
+// created it. Entries are -1 when the history does not cover the layer.
+func (m *ImageMetadata) LayerHistoryIndexes() []int {
+	indexes := make([]int, len(m.Layers))
+	for i := range indexes {
+		indexes[i] = -1
+	}
 
-		var layerConfig LayerConfig
-		if err := json.Unmarshal(jsonData, &layerConfig); err != nil {
-			// Skip if can't parse
+	next := 0
+	for i, entry := range m.History {
+		if entry.EmptyLayer {
 			continue
 		}
-
-		id := filepath.Base(layerDir)
-		imageMetadata.LayerConfigs[id] = &layerConfig
+		if next >= len(indexes) {
+			break
+		}
+		indexes[next] = i
+		next++
 	}
 
-	return &imageMetadata, nil
+	return indexes
+}
+
+// Source returns the image source the image was read from, or nil for root
+// filesystem tarballs, which are not images
+func (p *Parser) Source() source.ImageSource {
+	return p.source
+}
+
+// Skipped returns the entries of the image archive that were not extracted
+func (p *Parser) Skipped() []utils.SkippedEntry {
+	if extracted, ok := p.source.(source.Extracted); ok {
+		return extracted.Skipped()
+	}
+	return nil
 }
 
 // Cleanup removes temporary files
 func (p *Parser) Cleanup() error {
-	if p.workDir != "" {
-		return os.RemoveAll(p.workDir)
+	if p.source != nil {
+		return p.source.Close()
 	}
 	return nil
-}
\ No newline at end of file
+}
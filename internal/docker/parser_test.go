@@ -1,9 +1,20 @@
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/testimage"
+	"github.com/raesene/pasgan/pkg/source"
+	"github.com/raesene/pasgan/pkg/utils"
 )
 
 func TestParser(t *testing.T) {
@@ -82,4 +93,169 @@ func TestParser(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestParseRootFS(t *testing.T) {
+	dir := t.TempDir()
+	exported := filepath.Join(dir, "exported.tar")
+	rootfs := testimage.Layer(testimage.Dir("etc"), testimage.Reg("etc/os-release", "ID=alpine\n"), testimage.Reg("app/server", "binary"))
+	if err := os.WriteFile(exported, rootfs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := NewParser(exported)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	defer parser.Cleanup()
+
+	metadata, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !metadata.Flat || len(metadata.Layers) != 1 || len(metadata.History) != 0 {
+		t.Fatalf("Parse() = %+v, want a flat image with one layer", metadata)
+	}
+
+	var names []string
+	err = layer.WalkLayer(parser, 0, func(hdr *tar.Header, _ io.Reader) error {
+		names = append(names, hdr.Name)
+		return nil
+	})
+	if err != nil || len(names) != 3 {
+		t.Errorf("OpenLayer(0) entries = %v, error = %v, want the exported files", names, err)
+	}
+
+	// Image archives are told apart by their first entries, without reading
+	// the rest: the trailing garbage would fail the walk
+	id := strings.Repeat("ab", 32)
+	for name, files := range map[string][]testimage.File{
+		"blobs":    {testimage.Dir("blobs"), testimage.Reg("blobs/sha256/"+id, "layer")},
+		"legacy":   {testimage.Dir(id), testimage.Reg(id+"/layer.tar", "layer")},
+		"config":   {testimage.Reg(id+".json", "{}")},
+		"manifest": {testimage.Reg("manifest.json", "[]"), testimage.Dir("etc")},
+	} {
+		archive := filepath.Join(dir, name+".tar")
+		data := testimage.Layer(files...)
+		// Replace the end of archive marker
+		data = append(data[:len(data)-1024], bytes.Repeat([]byte{0xff}, 4096)...)
+		if err := os.WriteFile(archive, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if flat, err := isRootFS(context.Background(), archive, utils.Limits{}); err != nil || flat {
+			t.Errorf("isRootFS(%s) = %v, %v, want false for an image archive", name, flat, err)
+		}
+	}
+
+	// Archives too large to tell apart are left to the image source
+	archive := filepath.Join(dir, "many.tar")
+	if err := os.WriteFile(archive, testimage.Layer(testimage.Reg("a", "1"), testimage.Reg("b", "2"), testimage.Dir("etc")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if flat, err := isRootFS(context.Background(), archive, utils.Limits{MaxEntries: 1}); err != nil || flat {
+		t.Errorf("isRootFS() = %v, %v, want false past the entry limit", flat, err)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	exported := filepath.Join(t.TempDir(), "export.tar")
+	if err := os.WriteFile(exported, testimage.Layer(testimage.Dir("etc"), testimage.Reg("etc/hostname", "app")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	parser, _ := NewParser(exported)
+	if _, err := parser.ParseContext(ctx, source.Options{}); err != nil {
+		t.Fatalf("ParseContext() error = %v", err)
+	}
+	defer parser.Cleanup()
+
+	// Layers opened after the context is done cannot be read
+	cancel()
+	if _, err := parser.OpenLayer(0); !errors.Is(err, context.Canceled) {
+		t.Errorf("OpenLayer() error = %v, want context.Canceled", err)
+	}
+	parser, _ = NewParser(exported)
+	if _, err := parser.ParseContext(ctx, source.Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "legacy.tar")
+	base := testimage.Layer(testimage.Dir("etc"), testimage.Reg("etc/os-release", "ID=debian\n"))
+	app := testimage.Layer(testimage.Dir("app"), testimage.Reg("app/server", "binary"))
+	empty := testimage.Layer()
+	data := testimage.Layer(
+		testimage.Reg("repositories", `{"example/app":{"1.0":"ccc"}}`),
+		testimage.Reg("aaa/VERSION", "1.0"),
+		testimage.Reg("aaa/json", `{"id":"aaa","created":"2015-06-01T10:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file:0123 in /"]}}`),
+		testimage.Reg("aaa/layer.tar", string(base)),
+		testimage.Reg("bbb/json", `{"id":"bbb","parent":"aaa","created":"2015-06-02T10:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) COPY dir:4567 in /app"]}}`),
+		testimage.Reg("bbb/layer.tar", string(app)),
+		testimage.Reg("ccc/json", `{"id":"ccc","parent":"bbb","created":"2015-06-03T10:00:00Z","author":"dev","container_config":{"Cmd":["/bin/sh","-c","#(nop) CMD [\"/app/server\"]"]},"config":{"Cmd":["/app/server"]},"architecture":"amd64","os":"linux","docker_version":"1.9.1"}`),
+		testimage.Reg("ccc/layer.tar", string(empty)),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := NewParser(archive)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	defer parser.Cleanup()
+
+	metadata, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !metadata.Legacy || metadata.ID != "ccc" || len(metadata.RepoTags) != 1 || metadata.RepoTags[0] != "example/app:1.0" {
+		t.Errorf("Parse() = ID %q, tags %v, legacy %v, want ccc tagged example/app:1.0", metadata.ID, metadata.RepoTags, metadata.Legacy)
+	}
+	if metadata.DockerVersion != "1.9.1" || len(metadata.Config.Cmd) != 1 || metadata.Config.Cmd[0] != "/app/server" {
+		t.Errorf("Parse() config = %+v, docker %q, want the config of the top layer", metadata.Config, metadata.DockerVersion)
+	}
+
+	wantHistory := []string{
+		"/bin/sh -c #(nop) ADD file:0123 in /",
+		"/bin/sh -c #(nop) COPY dir:4567 in /app",
+		`/bin/sh -c #(nop) CMD ["/app/server"]`,
+	}
+	if len(metadata.History) != len(wantHistory) || len(metadata.Layers) != len(wantHistory) {
+		t.Fatalf("Parse() history = %+v, layers = %v, want %d of each", metadata.History, metadata.Layers, len(wantHistory))
+	}
+	for i, want := range wantHistory {
+		if metadata.History[i].CreatedBy != want {
+			t.Errorf("History[%d] = %q, want %q", i, metadata.History[i].CreatedBy, want)
+		}
+	}
+	if metadata.History[2].Author != "dev" || metadata.History[0].Created != "2015-06-01T10:00:00Z" {
+		t.Errorf("History = %+v, want the author and creation time of each layer", metadata.History)
+	}
+
+	var names []string
+	err = layer.WalkLayer(parser, 1, func(hdr *tar.Header, _ io.Reader) error {
+		names = append(names, hdr.Name)
+		return nil
+	})
+	if err != nil || len(names) != 2 {
+		t.Errorf("OpenLayer(1) entries = %v, error = %v, want the files of the second layer", names, err)
+	}
+}
+
+func TestParseLegacyMissing(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "empty.tar")
+	if err := os.WriteFile(archive, testimage.Layer(testimage.Reg("VERSION", "1.0")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parser, err := NewParser(archive)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	defer parser.Cleanup()
+
+	if _, err := parser.Parse(); err == nil {
+		t.Error("Parse() error = nil, want an error without manifest.json or repositories")
+	}
+}
@@ -0,0 +1,258 @@
+// Package dockercommit recognises layers created by docker commit, whose history
+// records the container's command rather than a Dockerfile instruction, and
+// summarises the filesystem changes they make.
+package dockercommit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/packages"
+)
+
+// maxFileListSize bounds the package file lists that are read
+const maxFileListSize = 64 << 20
+
+// Layer is a layer created by docker commit
+type Layer struct {
+	LayerIndex int `json:"layer"`
+	// HistoryIndex is the history entry of the layer, or -1 if it has none
+	HistoryIndex int `json:"history_index"`
+	// Command is the container command recorded by docker commit
+	Command string `json:"command,omitempty"`
+	// Message is the commit message, recorded as the history comment
+	Message string `json:"message,omitempty"`
+	// Reason explains why the layer is thought to be committed
+	Reason string `json:"reason"`
+	// Packages are the packages the layer installed or upgraded
+	Packages []packages.Package `json:"packages,omitempty"`
+	// Added, Modified and Deleted are the changed paths that no package owns,
+	// leaving out caches, logs and package databases
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Deleted  []string `json:"deleted,omitempty"`
+	// Root is the deepest directory holding every added and modified file
+	Root string `json:"root"`
+}
+
+// shells are the commands of containers started for an interactive session
+var shells = map[string]bool{
+	"sh": true, "bash": true, "ash": true, "zsh": true, "dash": true,
+	"/bin/sh": true, "/bin/bash": true, "/bin/ash": true, "/bin/zsh": true, "/bin/dash": true,
+	"/usr/bin/bash": true, "/usr/bin/zsh": true,
+}
+
+// instructions are the Dockerfile instructions a history entry may start with
+var instructions = []string{
+	"FROM", "RUN", "CMD", "LABEL", "MAINTAINER", "EXPOSE", "ENV", "ADD", "COPY",
+	"ENTRYPOINT", "VOLUME", "USER", "WORKDIR", "ARG", "ONBUILD", "STOPSIGNAL", "HEALTHCHECK", "SHELL",
+}
+
+// noise lists paths whose changes are side effects of running commands
+var noise = []string{
+	"/tmp", "/var/tmp", "/var/cache", "/var/log", "/run", "/var/run",
+	"/var/lib/apt/lists", "/var/lib/dpkg", "/lib/apk/db", "/var/lib/rpm", "/usr/lib/sysimage/rpm",
+	"/var/lib/dnf", "/var/lib/yum", "/etc/ld.so.cache", "/etc/hostname", "/etc/hosts", "/etc/resolv.conf",
+	"/.dockerenv", "/root/.bash_history", "/root/.ash_history", "/root/.zsh_history", "/root/.cache",
+	"/root/.wget-hsts", "/root/.npm", "/root/.viminfo", "/root/.lesshst",
+}
+
+// Committed reports whether a history entry looks like it was written by
+// docker commit rather than a Dockerfile build, and why
+func Committed(entry docker.History) (string, bool) {
+	if entry.EmptyLayer {
+		return "", false
+	}
+	command := strings.TrimSpace(entry.CreatedBy)
+	switch {
+	case command == "":
+		return "the history records no command", true
+	case shells[command]:
+		return fmt.Sprintf("the history records the interactive shell %q", command), true
+	case strings.HasPrefix(command, "/bin/sh -c "), strings.HasPrefix(command, "cmd /S /C "),
+		strings.Contains(command, "buildkit.dockerfile.v0"), strings.HasPrefix(command, "|"):
+		return "", false
+	}
+	for _, instruction := range instructions {
+		if strings.HasPrefix(command, instruction+" ") || command == instruction {
+			return "", false
+		}
+	}
+	// Dockerfile builds run commands through a shell, docker commit records the container command
+	if entry.Comment != "" && !strings.Contains(strings.ToLower(entry.Comment), "buildkit") {
+		return fmt.Sprintf("the history records the container command %q with the message %q", command, entry.Comment), true
+	}
+	return fmt.Sprintf("the history records the container command %q", command), true
+}
+
+// Detect finds the committed layers of an image and reads the layers to
+// summarise their changes. Layers without a history entry are committed if
+// the rest of the image has history.
+func Detect(metadata *docker.ImageMetadata, o layer.Opener) ([]*Layer, error) {
+	var committed []*Layer
+	for i, historyIndex := range metadata.LayerHistoryIndexes() {
+		if historyIndex < 0 {
+			if len(metadata.History) > 0 {
+				committed = append(committed, &Layer{LayerIndex: i, HistoryIndex: -1, Reason: "the layer has no history entry"})
+			}
+			continue
+		}
+		entry := metadata.History[historyIndex]
+		if reason, ok := Committed(entry); ok {
+			committed = append(committed, &Layer{
+				LayerIndex:   i,
+				HistoryIndex: historyIndex,
+				Command:      strings.TrimSpace(entry.CreatedBy),
+				Message:      entry.Comment,
+				Reason:       reason,
+			})
+		}
+	}
+	if len(committed) == 0 {
+		return nil, nil
+	}
+
+	inventory, err := packages.Scan(o, len(metadata.Layers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packages: %w", err)
+	}
+
+	// owned records the files listed by the package databases of each committed layer
+	owned := make(map[int]map[string]bool)
+	for _, c := range committed {
+		owned[c.LayerIndex] = make(map[string]bool)
+	}
+	index, err := layer.BuildIndex(o, len(metadata.Layers), func(i int, hdr *tar.Header, r io.Reader) error {
+		files, ok := owned[i]
+		if !ok || hdr.Typeflag != tar.TypeReg || hdr.Size > maxFileListSize {
+			return nil
+		}
+		return packages.ReadFileList(layer.Clean(hdr.Name), r, files)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layers: %w", err)
+	}
+
+	for _, c := range committed {
+		c.Packages = inventory.InstalledBy(c.LayerIndex)
+		c.summarise(index, owned[c.LayerIndex])
+	}
+	return committed, nil
+}
+
+// summarise sorts the changes of the layer into added, modified and deleted paths
+func (c *Layer) summarise(index *layer.Index, owned map[string]bool) {
+	changes := index.Layers[c.LayerIndex]
+	for name, hdr := range changes.Entries {
+		if hdr.Typeflag == tar.TypeDir || owned[name] || isNoise(name) {
+			continue
+		}
+		if existedBefore(index, name, c.LayerIndex) {
+			c.Modified = append(c.Modified, name)
+		} else {
+			c.Added = append(c.Added, name)
+		}
+	}
+	for name := range changes.Whiteouts {
+		if !isNoise(name) {
+			c.Deleted = append(c.Deleted, name)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Modified)
+	sort.Strings(c.Deleted)
+	c.Root = commonRoot(append(append([]string{}, c.Added...), c.Modified...))
+}
+
+// existedBefore reports whether p was present below layer i
+func existedBefore(index *layer.Index, p string, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		if _, ok := index.Layers[j].Entries[p]; !ok {
+			continue
+		}
+		removal, shadowed := index.Shadowed(p, j)
+		return !shadowed || removal.Layer >= i || !removal.Deleted
+	}
+	return false
+}
+
+// Summary describes the changes in a few words, e.g. "installed 2 deb packages, added 3 files"
+func (c *Layer) Summary() string {
+	var parts []string
+	counts := make(map[string]int)
+	var ecosystems []string
+	for _, pkg := range c.Packages {
+		if counts[pkg.Ecosystem] == 0 {
+			ecosystems = append(ecosystems, pkg.Ecosystem)
+		}
+		counts[pkg.Ecosystem]++
+	}
+	for _, ecosystem := range ecosystems {
+		parts = append(parts, fmt.Sprintf("installed %s", plural(counts[ecosystem], ecosystem+" package")))
+	}
+	if len(c.Added) > 0 {
+		parts = append(parts, "added "+plural(len(c.Added), "file"))
+	}
+	if len(c.Modified) > 0 {
+		parts = append(parts, "modified "+plural(len(c.Modified), "file"))
+	}
+	if len(c.Deleted) > 0 {
+		parts = append(parts, "deleted "+plural(len(c.Deleted), "path"))
+	}
+	if len(parts) == 0 {
+		return "made no changes besides caches and logs"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Commands returns shell commands that would plausibly make the same package
+// changes and deletions. Added and modified files are left to a COPY.
+func (c *Layer) Commands() []string {
+	commands := packages.InstallCommands(c.Packages)
+	if len(c.Deleted) > 0 {
+		commands = append(commands, "rm -rf "+strings.Join(c.Deleted, " "))
+	}
+	return commands
+}
+
+func isNoise(p string) bool {
+	for _, prefix := range noise {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	// Python bytecode is written as a side effect of running scripts
+	return strings.HasSuffix(p, ".pyc") || strings.Contains(p, "/__pycache__/")
+}
+
+// commonRoot returns the deepest directory holding every path
+func commonRoot(paths []string) string {
+	root := ""
+	for _, p := range paths {
+		dir := path.Dir(p)
+		if root == "" {
+			root = dir
+			continue
+		}
+		for root != "/" && dir != root && !strings.HasPrefix(dir, root+"/") {
+			root = path.Dir(root)
+		}
+	}
+	if root == "" {
+		return "/"
+	}
+	return root
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
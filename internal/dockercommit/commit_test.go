@@ -0,0 +1,110 @@
+package dockercommit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+func TestCommitted(t *testing.T) {
+	testCases := []struct {
+		name  string
+		entry docker.History
+		want  bool
+	}{
+		{"interactive shell", docker.History{CreatedBy: "bash"}, true},
+		{"no command", docker.History{}, true},
+		{"container command", docker.History{CreatedBy: "python setup.py install", Comment: "install the tool"}, true},
+		{"shell form RUN", docker.History{CreatedBy: "/bin/sh -c apt-get update"}, false},
+		{"nop", docker.History{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "}, false},
+		{"buildkit", docker.History{CreatedBy: "RUN /bin/sh -c make # buildkit", Comment: "buildkit.dockerfile.v0"}, false},
+		{"buildkit COPY", docker.History{CreatedBy: "COPY . /app # buildkit"}, false},
+		{"empty layer", docker.History{CreatedBy: "bash", EmptyLayer: true}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, got := Committed(tc.entry); got != tc.want {
+				t.Errorf("Committed() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"base", "commit", "untracked"},
+		History: []docker.History{
+			{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{CreatedBy: "/bin/sh -c #(nop)  CMD [\"bash\"]", EmptyLayer: true},
+			{CreatedBy: "bash", Comment: "add the app"},
+		},
+	}
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Reg("var/lib/dpkg/status", "Package: libc6\nStatus: install ok installed\nVersion: 2.36-9\n"),
+			testimage.Reg("etc/passwd", "root:x:0:0::/root:/bin/bash\n"),
+			testimage.Reg("etc/motd", "hello\n"),
+		),
+		testimage.Layer(
+			testimage.Reg("var/lib/dpkg/status", "Package: libc6\nStatus: install ok installed\nVersion: 2.36-9\n\nPackage: curl\nStatus: install ok installed\nVersion: 7.88.1-10\n"),
+			testimage.Reg("var/lib/dpkg/info/curl.list", "/.\n/usr\n/usr/bin\n/usr/bin/curl\n"),
+			testimage.Reg("var/lib/apt/lists/deb.debian.org_dists_bookworm_InRelease", "lists"),
+			testimage.Reg("usr/bin/curl", "curl"),
+			testimage.Dir("opt/app"),
+			testimage.Reg("opt/app/run.sh", "#!/bin/sh\n"),
+			testimage.Reg("opt/app/config/app.yaml", "port: 8080\n"),
+			testimage.Reg("etc/passwd", "root:x:0:0::/root:/bin/bash\napp:x:1000:1000::/opt/app:/bin/sh\n"),
+			testimage.Reg("root/.bash_history", "apt-get install curl\n"),
+			testimage.Whiteout("etc/motd"),
+		),
+		testimage.Layer(testimage.Reg("srv/data.txt", "data")),
+	}
+
+	committed, err := Detect(metadata, layers)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if len(committed) != 2 {
+		t.Fatalf("Detect() found %d layers, want 2: %+v", len(committed), committed)
+	}
+
+	c := committed[0]
+	if c.LayerIndex != 1 || c.HistoryIndex != 2 || c.Command != "bash" || c.Message != "add the app" {
+		t.Errorf("Detect() = %+v, want layer 1 from history entry 2", c)
+	}
+	if len(c.Packages) != 1 || c.Packages[0].Name != "curl" {
+		t.Errorf("Packages = %+v, want curl", c.Packages)
+	}
+	for name, got := range map[string][]string{"Added": c.Added, "Modified": c.Modified, "Deleted": c.Deleted} {
+		want := map[string]string{
+			"Added":    "/opt/app/config/app.yaml /opt/app/run.sh",
+			"Modified": "/etc/passwd",
+			"Deleted":  "/etc/motd",
+		}[name]
+		if strings.Join(got, " ") != want {
+			t.Errorf("%s = %v, want %s", name, got, want)
+		}
+	}
+	if c.Root != "/" {
+		t.Errorf("Root = %s, want /", c.Root)
+	}
+	if want := "installed 1 deb package, added 2 files, modified 1 file, deleted 1 path"; c.Summary() != want {
+		t.Errorf("Summary() = %q, want %q", c.Summary(), want)
+	}
+	wantCommands := []string{
+		"apt-get update",
+		"apt-get install -y --no-install-recommends curl=7.88.1-10",
+		"rm -rf /var/lib/apt/lists/*",
+		"rm -rf /etc/motd",
+	}
+	if strings.Join(c.Commands(), "\n") != strings.Join(wantCommands, "\n") {
+		t.Errorf("Commands() = %q, want %q", c.Commands(), wantCommands)
+	}
+
+	untracked := committed[1]
+	if untracked.LayerIndex != 2 || untracked.HistoryIndex != -1 || untracked.Root != "/srv" {
+		t.Errorf("Detect() = %+v, want layer 2 without history rooted at /srv", untracked)
+	}
+}
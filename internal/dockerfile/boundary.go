@@ -0,0 +1,200 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/fingerprint"
+)
+
+// Base image annotations defined by the OCI image spec. They are also read
+// from labels, where some build tools record them.
+const (
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// minBaseGap is the shortest pause between the base image and the app
+// instructions that automatic boundary detection accepts
+const minBaseGap = time.Hour
+
+// maxOmittedLength bounds the omitted base instructions listed in comments
+const maxOmittedLength = 120
+
+// Boundary splits the history into the base image and the app's own instructions
+type Boundary struct {
+	// HistoryCount is the number of leading history entries that belong to the base image
+	HistoryCount int
+	// Reason explains how the boundary was found
+	Reason string
+}
+
+// ParseBoundary resolves a --from-boundary value. It is "auto", the number of
+// layers that belong to the base image, or the diffID of the last base layer.
+// A nil boundary is returned if auto detection finds none.
+func ParseBoundary(spec string, metadata *docker.ImageMetadata, match *fingerprint.Match) (*Boundary, error) {
+	if spec == "auto" {
+		return DetectBoundary(metadata, match), nil
+	}
+
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 || n > len(metadata.Layers) {
+			return nil, fmt.Errorf("boundary %d out of range, image has %d layers", n, len(metadata.Layers))
+		}
+		return layerBoundary(metadata, n, fmt.Sprintf("first %d layer(s)", n))
+	}
+
+	digest := strings.ToLower(spec)
+	if !strings.Contains(digest, ":") {
+		digest = "sha256:" + digest
+	}
+	found := -1
+	for i, diffID := range metadata.RootFS.DiffIDs {
+		if !strings.HasPrefix(diffID, digest) {
+			continue
+		}
+		if found >= 0 {
+			return nil, fmt.Errorf("diffID %s is ambiguous", spec)
+		}
+		found = i
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("invalid boundary %q: expected auto, a layer count or a diffID in the image", spec)
+	}
+	return layerBoundary(metadata, found+1, fmt.Sprintf("layer %d (%s)", found, metadata.RootFS.DiffIDs[found]))
+}
+
+// DetectBoundary finds the end of the base image. The history of an
+// identified official image is used when known, otherwise the boundary is the
+// largest pause between two history entries, as base images are built well
+// before the apps that use them. It returns nil if there is no clear pause.
+func DetectBoundary(metadata *docker.ImageMetadata, match *fingerprint.Match) *Boundary {
+	if match != nil && match.BaseHistoryCount > 0 {
+		return &Boundary{
+			HistoryCount: match.BaseHistoryCount,
+			Reason:       fmt.Sprintf("end of the %s history", match.Image),
+		}
+	}
+
+	best, bestGap := -1, time.Duration(0)
+	previous, err := time.Parse(time.RFC3339Nano, firstCreated(metadata.History))
+	if err != nil {
+		return nil
+	}
+	for i := 1; i < len(metadata.History); i++ {
+		created, err := time.Parse(time.RFC3339Nano, metadata.History[i].Created)
+		if err != nil {
+			continue
+		}
+		if gap := created.Sub(previous); gap > bestGap {
+			best, bestGap = i, gap
+		}
+		previous = created
+	}
+	if best < 0 || bestGap < minBaseGap {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%s pause in the history", formatGap(bestGap))
+	if isRootfsAdd(metadata.History[0].CreatedBy) {
+		reason += " after a base image starting with ADD file:... in /"
+	}
+	return &Boundary{HistoryCount: best, Reason: reason}
+}
+
+// layerBoundary returns the boundary after the first n layers, including the
+// metadata-only entries the base image recorded after its last layer
+func layerBoundary(metadata *docker.ImageMetadata, n int, reason string) (*Boundary, error) {
+	if n == 0 {
+		return &Boundary{Reason: reason}, nil
+	}
+	last := metadata.LayerHistoryIndexes()[n-1]
+	if last < 0 {
+		return nil, fmt.Errorf("the history does not cover layer %d", n-1)
+	}
+
+	return &Boundary{HistoryCount: baseEnd(metadata, last), Reason: reason}, nil
+}
+
+// rootFSBoundary returns the boundary after a history that starts with the
+// root filesystem of a base image, ADD file:... in /, or nil if it does not
+func rootFSBoundary(metadata *docker.ImageMetadata) *Boundary {
+	if len(metadata.History) == 0 || !isRootfsAdd(metadata.History[0].CreatedBy) {
+		return nil
+	}
+	count := baseEnd(metadata, 0)
+	// Official images end with the CMD right after the root filesystem, which
+	// cannot be told apart by time when the history has no timestamps
+	if count == 1 && len(metadata.History) > 1 {
+		next := metadata.History[1]
+		if next.EmptyLayer && next.Created == "" && strings.HasPrefix(HistoryInstruction(next.CreatedBy), "CMD ") {
+			count++
+		}
+	}
+	return &Boundary{HistoryCount: count, Reason: "root filesystem of the base image"}
+}
+
+// baseEnd returns the number of history entries up to the base layer created
+// by entry last, including the metadata-only entries recorded right after it
+func baseEnd(metadata *docker.ImageMetadata, last int) int {
+	count := last + 1
+	previous, err := time.Parse(time.RFC3339Nano, metadata.History[last].Created)
+	for ; err == nil && count < len(metadata.History); count++ {
+		entry := metadata.History[count]
+		created, parseErr := time.Parse(time.RFC3339Nano, entry.Created)
+		if !entry.EmptyLayer || parseErr != nil || created.Sub(previous) >= minBaseGap {
+			break
+		}
+		previous = created
+	}
+	return count
+}
+
+// omittedComments lists the base image instructions cut by the boundary
+func omittedComments(history []docker.History, count int, reason string) []string {
+	comments := []string{fmt.Sprintf("%d history entries of the base image were omitted (%s):", count, reason)}
+	for _, entry := range history[:count] {
+		instruction := strings.Join(strings.Fields(HistoryInstruction(entry.CreatedBy)), " ")
+		if instruction == "" {
+			continue
+		}
+		if len(instruction) > maxOmittedLength {
+			instruction = instruction[:maxOmittedLength-3] + "..."
+		}
+		comments = append(comments, "  "+instruction)
+	}
+	return comments
+}
+
+// baseAnnotation returns an OCI base image annotation, falling back to labels
+func baseAnnotation(metadata *docker.ImageMetadata, key string) string {
+	if value := metadata.Annotations[key]; value != "" {
+		return value
+	}
+	return metadata.Config.Labels[key]
+}
+
+func firstCreated(history []docker.History) string {
+	if len(history) == 0 {
+		return ""
+	}
+	return history[0].Created
+}
+
+// isRootfsAdd reports whether a history entry adds a root filesystem, which
+// is how most distribution base images start
+func isRootfsAdd(createdBy string) bool {
+	instruction := HistoryInstruction(createdBy)
+	return strings.HasPrefix(instruction, "ADD file:") && strings.HasSuffix(instruction, " in /")
+}
+
+// formatGap describes a pause in days or hours
+func formatGap(gap time.Duration) string {
+	if gap >= 48*time.Hour {
+		return fmt.Sprintf("%d day", int(gap.Hours()/24))
+	}
+	return fmt.Sprintf("%d hour", int(gap.Hours()))
+}
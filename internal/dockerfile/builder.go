@@ -0,0 +1,156 @@
+package dockerfile
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/builder"
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/pkg/ast"
+)
+
+// builderComments explain that the image was not built from this Dockerfile
+func (g *Generator) builderComments() []Instruction {
+	b := g.options.Builder
+	var comments []string
+	if b.Dockerless() {
+		comments = append(comments,
+			fmt.Sprintf("Built with %s, not from a Dockerfile (%s)", b.Name(), strings.Join(b.Evidence, ", ")),
+			fmt.Sprintf("This Dockerfile approximates the %s build: its layers are shown as COPY instructions", b.Kind))
+	} else {
+		comments = append(comments, fmt.Sprintf("Built with %s from a Dockerfile (%s)", b.Name(), strings.Join(b.Evidence, ", ")))
+	}
+
+	instructions := make([]Instruction, 0, len(comments))
+	for _, text := range comments {
+		instructions = append(instructions, comment(text, -1))
+	}
+	return instructions
+}
+
+// builderLayer turns a layer written by a builder into a COPY of its files
+func (g *Generator) builderLayer(entry docker.History, historyIndex, layerIndex int) []Instruction {
+	b := g.options.Builder
+	// The packages of apko images are installed by apkInstructions instead
+	if b.Kind == builder.Apko && len(b.Packages) > 0 {
+		return nil
+	}
+	root := "/"
+	if layerIndex < len(b.LayerRoots) {
+		root = b.LayerRoots[layerIndex]
+	}
+
+	source := entry.Comment
+	if source == "" || strings.Contains(source, "/") {
+		source = path.Base(root)
+	}
+	if source == "" || source == "/" || source == "." {
+		source = fmt.Sprintf("layer-%d", layerIndex)
+	}
+	source = strings.ReplaceAll(strings.ToLower(source), " ", "-")
+	// Copy the directory named after the layer rather than its deepest subdirectory
+	if i := strings.Index(root+"/", "/"+source+"/"); i >= 0 {
+		root = root[:i+len(source)+1]
+	}
+
+	description := fmt.Sprintf("Layer %d added by %s", layerIndex, b.Name())
+	if layerIndex < len(b.LayerOwners) && b.LayerOwners[layerIndex] != "" {
+		description += ": " + b.LayerOwners[layerIndex]
+	} else if entry.Comment != "" {
+		description += ": " + entry.Comment
+	}
+	destination := root
+	if destination != "/" {
+		destination += "/"
+	}
+	return []Instruction{
+		comment(description, historyIndex),
+		{Node: &ast.Copy{Sources: []string{source + "/"}, Dest: destination}, HistoryIndex: historyIndex},
+	}
+}
+
+// uncoveredLayers returns COPY instructions for the layers of a builder that
+// are not in the history, such as those the buildpacks lifecycle writes
+func (g *Generator) uncoveredLayers() []Instruction {
+	b := g.options.Builder
+	first := 0
+	if b.Buildpacks != nil {
+		first = b.Buildpacks.BaseLayers
+	}
+
+	var instructions []Instruction
+	for i, historyIndex := range g.metadata.LayerHistoryIndexes() {
+		if i < first || historyIndex >= 0 {
+			continue
+		}
+		entry := docker.History{}
+		if i < len(b.LayerOwners) {
+			entry.Comment = b.LayerOwners[i]
+		}
+		instructions = append(instructions, g.builderLayer(entry, -1, i)...)
+	}
+	return instructions
+}
+
+// apkInstructions installs the apk world of apko images
+func (g *Generator) apkInstructions() []Instruction {
+	b := g.options.Builder
+	if b.Kind != builder.Apko || len(b.Packages) == 0 {
+		return nil
+	}
+	return []Instruction{{
+		Node:         &ast.Run{Command: ast.Command{Shell: "apk add --no-cache " + strings.Join(b.Packages, " ")}},
+		HistoryIndex: -1,
+	}}
+}
+
+// configInstructions returns the instructions that reproduce the image config
+func (g *Generator) configInstructions() []Instruction {
+	config := g.metadata.Config
+	var instructions []Instruction
+	add := func(node ast.Node) {
+		instructions = append(instructions, Instruction{Node: node, EmptyLayer: true, HistoryIndex: -1})
+	}
+
+	for _, variable := range config.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		add(&ast.Env{Pairs: []ast.Pair{{Key: key, Value: literal(value)}}})
+	}
+	labels := make([]string, 0, len(config.Labels))
+	for key := range config.Labels {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+	for _, key := range labels {
+		add(&ast.Label{Pairs: []ast.Pair{{Key: key, Value: literal(config.Labels[key])}}})
+	}
+	if config.WorkingDir != "" {
+		add(&ast.Workdir{Path: config.WorkingDir})
+	}
+	if config.User != "" {
+		add(&ast.User{User: config.User})
+	}
+	ports := make([]string, 0, len(config.ExposedPorts))
+	for port := range config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	if len(ports) > 0 {
+		add(&ast.Expose{Ports: ports})
+	}
+	if len(config.Entrypoint) > 0 {
+		add(&ast.Entrypoint{Command: ast.Command{Exec: config.Entrypoint}})
+	}
+	if len(config.Cmd) > 0 {
+		add(&ast.Cmd{Command: ast.Command{Exec: config.Cmd}})
+	}
+	return instructions
+}
+
+// literal escapes the dollar signs of a config value, which has already
+// been expanded, so they are not read as variables
+func literal(value string) string {
+	return strings.ReplaceAll(value, "$", `\$`)
+}
@@ -0,0 +1,64 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/dockercommit"
+	"github.com/raesene/pasgan/pkg/ast"
+)
+
+// maxListedPaths is the number of changed paths listed for a committed layer
+const maxListedPaths = 10
+
+// committedLayer returns the committed layer created by a history entry, if any
+func (g *Generator) committedLayer(historyIndex int) *dockercommit.Layer {
+	for _, c := range g.options.Commits {
+		if c.HistoryIndex == historyIndex {
+			return c
+		}
+	}
+	return nil
+}
+
+// commitInstructions describes a layer created by docker commit as a RUN
+// guessed from its package changes and deletions, and a COPY of the files it
+// adds or modifies from a layer-N directory of the build context
+func (g *Generator) commitInstructions(c *dockercommit.Layer) []Instruction {
+	var instructions []Instruction
+	note := func(format string, args ...interface{}) {
+		instructions = append(instructions, comment(fmt.Sprintf(format, args...), c.HistoryIndex))
+	}
+
+	note("Layer %d was created by docker commit: %s", c.LayerIndex, c.Reason)
+	note("It %s", c.Summary())
+	if c.Command != "" {
+		note("Container command: %s", c.Command)
+	}
+	if c.Message != "" && !strings.Contains(c.Reason, c.Message) {
+		note("Commit message: %s", c.Message)
+	}
+
+	if commands := c.Commands(); len(commands) > 0 {
+		note("Best guess at the commands run in the container:")
+		instructions = append(instructions, Instruction{Node: &ast.Run{Command: ast.Command{Shell: strings.Join(commands, "\n&& ")}}, HistoryIndex: c.HistoryIndex})
+	}
+
+	files := append(append([]string{}, c.Added...), c.Modified...)
+	if len(files) > 0 {
+		destination := c.Root
+		if destination != "/" {
+			destination += "/"
+		}
+		note("Copy the changed files from the image into layer-%d/ of the build context:", c.LayerIndex)
+		for i, file := range files {
+			if i == maxListedPaths {
+				note("  ... and %d more", len(files)-maxListedPaths)
+				break
+			}
+			note("  %s", file)
+		}
+		instructions = append(instructions, Instruction{Node: &ast.Copy{Sources: []string{fmt.Sprintf("layer-%d/", c.LayerIndex)}, Dest: destination}, HistoryIndex: c.HistoryIndex})
+	}
+	return instructions
+}
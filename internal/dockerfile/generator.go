@@ -1,27 +1,73 @@
 package dockerfile
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	
+	"github.com/raesene/pasgan/internal/builder"
+	"github.com/raesene/pasgan/internal/distro"
 	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockercommit"
+	"github.com/raesene/pasgan/internal/fingerprint"
+	"github.com/raesene/pasgan/internal/rootfs"
+	"github.com/raesene/pasgan/pkg/ast"
 )
 
-// Instruction represents a Dockerfile instruction
+// Instruction is a reconstructed Dockerfile instruction and the history
+// entry it came from
 type Instruction struct {
-	Command   string
-	Arguments string
-	Time      time.Time
+	// Node is the instruction, with the comments written before it
+	Node       ast.Node
+	Time       time.Time
 	EmptyLayer bool
+	// HistoryIndex is the index of the history entry the instruction came from, or -1
+	HistoryIndex int
+
+	// comment is a comment line, set instead of Node while the instructions
+	// are built and attached to the next instruction afterwards
+	comment string
+}
+
+// comment returns a comment line for the instruction list being built
+func comment(text string, historyIndex int) Instruction {
+	return Instruction{comment: text, EmptyLayer: true, HistoryIndex: historyIndex}
+}
+
+// Options holds optional information that improves the reconstruction
+type Options struct {
+	// Distro is the distribution detected in the image's filesystem
+	Distro *distro.Release
+	// BaseImage is the official image proposed for Distro, used when the
+	// history does not record a FROM instruction
+	BaseImage string
+	// Fingerprint is the official image identified from the environment and
+	// history. Its history entries are collapsed into a single FROM.
+	Fingerprint *fingerprint.Match
+	// Boundary cuts the history at the end of the base image, replacing the
+	// boundary of Builder and Fingerprint
+	Boundary *Boundary
+	// Builder is the tool that built the image. The layers of builders that do
+	// not use a Dockerfile become COPY instructions, and the image config is
+	// turned into instructions.
+	Builder *builder.Builder
+	// Commits are the layers created by docker commit. They become a RUN
+	// guessed from their changes and a COPY of the files they add.
+	Commits []*dockercommit.Layer
+	// RootFS is the evidence found in a flat filesystem with no history, from
+	// which the whole Dockerfile is guessed
+	RootFS *rootfs.Evidence
 }
 
 // Generator creates Dockerfile content from Docker image metadata
 type Generator struct {
 	metadata *docker.ImageMetadata
+	options  Options
 }
 
 // NewGenerator creates a new Dockerfile generator
@@ -31,54 +77,121 @@ func NewGenerator(metadata *docker.ImageMetadata) *Generator {
 	}
 }
 
+// NewGeneratorWithOptions creates a new Dockerfile generator with extra information
+func NewGeneratorWithOptions(metadata *docker.ImageMetadata, options Options) *Generator {
+	return &Generator{
+		metadata: metadata,
+		options:  options,
+	}
+}
+
+// header is the comment block written before the instructions
+var header = []string{
+	"Generated by Pasgan",
+	"This is a best-effort reconstruction and may require manual adjustments",
+}
+
+// heredocRegex matches a here-document marker such as <<EOF or <<-'EOF'
+var heredocRegex = regexp.MustCompile(`<<-?["']?[A-Za-z_][A-Za-z0-9_]*["']?`)
+
+// HeaderLines is the number of lines written by Generate before the first instruction
+const HeaderLines = 3
+
 // Generate produces a Dockerfile based on image metadata
 func (g *Generator) Generate(writer io.Writer) error {
 	if g.metadata == nil {
 		return fmt.Errorf("no metadata provided")
 	}
-	
-	// Write header
-	fmt.Fprintln(writer, "# Generated by Pasgan")
-	fmt.Fprintln(writer, "# This is a best-effort reconstruction and may require manual adjustments")
-	fmt.Fprintln(writer)
-	
-	// Process history entries into Dockerfile instructions
-	instructions := g.processHistory()
-	
-	// Write instructions
+	file, _ := g.File()
+	return ast.Format(writer, file)
+}
+
+// File returns the reconstructed Dockerfile and its instructions, which
+// share the same nodes, without writing them
+func (g *Generator) File() (*ast.File, []Instruction) {
+	if g.metadata == nil {
+		return nil, nil
+	}
+	instructions, footer := g.processHistory()
+	file := newFile(instructions)
+	file.Footer = footer
+	return file, instructions
+}
+
+// Instructions returns the reconstructed Dockerfile instructions without writing them
+func (g *Generator) Instructions() []Instruction {
+	_, instructions := g.File()
+	return instructions
+}
+
+// newFile returns a Dockerfile of the instructions with the generated header
+func newFile(instructions []Instruction) *ast.File {
+	file := &ast.File{Header: header}
 	for _, instruction := range instructions {
-		if instruction.Command == "COMMENT" {
-			// Write comments
-			fmt.Fprintf(writer, "# %s\n", instruction.Arguments)
-		} else {
-			// Handle multi-line RUN instructions
-			if instruction.Command == "RUN" && strings.Contains(instruction.Arguments, "\n") {
-				// Fix escaped backslashes in continuation lines
-				args := strings.ReplaceAll(instruction.Arguments, "\n", " \\\n    ")
-				// Remove double backslashes that might appear when a line already ends with a backslash
-				args = strings.ReplaceAll(args, "\\ \\\n", " \\\n")
-				fmt.Fprintf(writer, "%s %s\n", instruction.Command, args)
-			} else {
-				fmt.Fprintf(writer, "%s %s\n", instruction.Command, instruction.Arguments)
-			}
-		}
+		file.Nodes = append(file.Nodes, instruction.Node)
 	}
-	
-	return nil
+	return file
+}
+
+// FormatInstruction renders an instruction as it appears in the generated
+// Dockerfile, without its comments
+func FormatInstruction(instruction Instruction) string {
+	return ast.FormatNode(instruction.Node)
+}
+
+// LineNumbers returns the 1-based line of the generated Dockerfile on which
+// each instruction starts
+func LineNumbers(instructions []Instruction) []int {
+	return newFile(instructions).Lines()
 }
 
-// processHistory converts history entries to Dockerfile instructions
-func (g *Generator) processHistory() []Instruction {
+// processHistory converts history entries to Dockerfile instructions, and
+// returns the comments that come after the last one
+func (g *Generator) processHistory() ([]Instruction, []string) {
+	// Flat filesystems have no history to process
+	if g.options.RootFS != nil {
+		return attachComments(g.rootFSInstructions())
+	}
+	
 	var instructions []Instruction
 	var baseImageFound bool
 	
+	// Map history entries to the layers they created
+	layerOf := make(map[int]int)
+	for i, historyIndex := range g.metadata.LayerHistoryIndexes() {
+		if historyIndex >= 0 {
+			layerOf[historyIndex] = i
+		}
+	}
+	
 	// Process history entries in chronological order
-	for i, entry := range g.historyAscending() {
+	for i, historyIndex := range g.historyOrder() {
+		entry := g.metadata.History[historyIndex]
+		
+		// Layers made by docker commit record the container command, not an instruction
+		if c := g.committedLayer(historyIndex); c != nil && historyIndex >= g.baseBoundary().HistoryCount {
+			instructions = append(instructions, g.commitInstructions(c)...)
+			continue
+		}
+		
 		// Skip empty history entries
 		if entry.CreatedBy == "" {
 			continue
 		}
 		
+		// Skip the history of the base image, it is replaced by a single FROM
+		if historyIndex < g.baseBoundary().HistoryCount {
+			continue
+		}
+		
+		// Entries written by builders such as Jib are not shell commands
+		if b := g.options.Builder; b != nil && b.Dockerless() && b.Owns(entry) {
+			if layerIndex, ok := layerOf[historyIndex]; ok {
+				instructions = append(instructions, g.builderLayer(entry, historyIndex, layerIndex)...)
+			}
+			continue
+		}
+		
 		// Skip pure BuildKit metadata comments (that have no useful content)
 		if strings.Contains(entry.CreatedBy, "buildkit.dockerfile.v0") && 
 		   strings.HasPrefix(entry.CreatedBy, "/bin/sh -c #(nop)") && 
@@ -112,39 +225,39 @@ func (g *Generator) processHistory() []Instruction {
 			// Only use the first FROM instruction
 			if !baseImageFound {
 				instructions = append(instructions, Instruction{
-					Command:    command,
-					Arguments:  args,
-					Time:       timestamp,
-					EmptyLayer: entry.EmptyLayer,
+					Node:         historyNode(command, args),
+					Time:         timestamp,
+					EmptyLayer:   entry.EmptyLayer,
+					HistoryIndex: historyIndex,
 				})
 				baseImageFound = true
 			}
 		case "LABEL", "ENV", "EXPOSE", "WORKDIR", "USER", "VOLUME", "ENTRYPOINT", "CMD", "HEALTHCHECK", "SHELL", "STOPSIGNAL":
 			// These are all standard Dockerfile instructions
 			instructions = append(instructions, Instruction{
-				Command:    command,
-				Arguments:  args,
-				Time:       timestamp,
-				EmptyLayer: entry.EmptyLayer,
+				Node:         historyNode(command, args),
+				Time:         timestamp,
+				EmptyLayer:   entry.EmptyLayer,
+				HistoryIndex: historyIndex,
 			})
 		case "RUN":
 			// For RUN instructions, try to expand package manager commands to make them more readable
 			if i > 0 {
 				expandedArgs := g.expandRun(args)
 				instructions = append(instructions, Instruction{
-					Command:    command,
-					Arguments:  expandedArgs,
-					Time:       timestamp,
-					EmptyLayer: entry.EmptyLayer,
+					Node:         historyNode(command, expandedArgs),
+					Time:         timestamp,
+					EmptyLayer:   entry.EmptyLayer,
+					HistoryIndex: historyIndex,
 				})
 			}
 		case "COPY", "ADD":
 			// Special handling for COPY and ADD commands that might have buildkit references
 			instructions = append(instructions, Instruction{
-				Command:    command,
-				Arguments:  args,
-				Time:       timestamp,
-				EmptyLayer: entry.EmptyLayer,
+				Node:         historyNode(command, args),
+				Time:         timestamp,
+				EmptyLayer:   entry.EmptyLayer,
+				HistoryIndex: historyIndex,
 			})
 		default:
 			// For unknown or complex commands, add as a RUN command if it's not a basic /bin/sh -c
@@ -153,27 +266,56 @@ func (g *Generator) processHistory() []Instruction {
 				cleanCmd := strings.Replace(entry.CreatedBy, "# buildkit", "", -1)
 				cleanCmd = strings.Replace(cleanCmd, "#buildkit", "", -1)
 				instructions = append(instructions, Instruction{
-					Command:    "RUN",
-					Arguments:  cleanCmd,
-					Time:       timestamp,
-					EmptyLayer: entry.EmptyLayer,
+					Node:         historyNode("RUN", strings.TrimSpace(cleanCmd)),
+					Time:         timestamp,
+					EmptyLayer:   entry.EmptyLayer,
+					HistoryIndex: historyIndex,
 				})
 			}
 		}
 		
 		// Add any comment if present, except buildkit comments
 		if entry.Comment != "" && !strings.Contains(strings.ToLower(entry.Comment), "buildkit") {
-			instructions = append(instructions, Instruction{
-				Command:    "COMMENT",
-				Arguments:  entry.Comment,
-				Time:       timestamp,
-				EmptyLayer: true,
-			})
+			instructions = append(instructions, comment(entry.Comment, historyIndex))
+		}
+	}
+	
+	// Committed layers without a history entry come after the recorded history
+	for _, c := range g.options.Commits {
+		if c.HistoryIndex < 0 {
+			instructions = append(instructions, g.commitInstructions(c)...)
 		}
 	}
 	
-	// If we need to add a FROM instruction (none was found in history)
-	if !baseImageFound && len(g.metadata.RepoTags) > 0 {
+	// Builders without a Dockerfile record the image config but no instructions for it
+	dockerless := g.options.Builder != nil && g.options.Builder.Dockerless()
+	if dockerless {
+		instructions = append(instructions, g.uncoveredLayers()...)
+		instructions = append(g.apkInstructions(), instructions...)
+		instructions = append(instructions, g.configInstructions()...)
+	}
+	
+	// Without history, such as docker image inspect output, only the config is known
+	noHistory := len(g.metadata.History) == 0 && !dockerless
+	if noHistory {
+		instructions = append(instructions, comment("The image has no history: only the image config is reconstructed, the instructions that built the layers are unknown", -1))
+		instructions = append(instructions, g.configInstructions()...)
+	}
+	
+	// If we need to add a FROM instruction (none was found in history), prefer
+	// what is known about the base image to the repo tag fallback
+	base := g.baseInstructions()
+	if !baseImageFound && base != nil {
+		instructions = append(base, instructions...)
+	} else if !baseImageFound && dockerless {
+		instructions = append([]Instruction{{Node: &ast.From{Image: "scratch"}, EmptyLayer: true, HistoryIndex: -1}}, instructions...)
+	} else if !baseImageFound && noHistory {
+		instructions = append([]Instruction{
+			comment("The base image could not be identified, set it with --build-arg BASE_IMAGE=...", -1),
+			{Node: &ast.Arg{Vars: []ast.ArgVar{{Name: "BASE_IMAGE"}}}, EmptyLayer: true, HistoryIndex: -1},
+			{Node: &ast.From{Image: "${BASE_IMAGE}"}, EmptyLayer: true, HistoryIndex: -1},
+		}, instructions...)
+	} else if !baseImageFound && len(g.metadata.RepoTags) > 0 {
 		// Use the first repo tag
 		baseImage := "scratch" // Default to scratch
 		if len(g.metadata.RepoTags) > 0 && g.metadata.RepoTags[0] != "" {
@@ -183,61 +325,216 @@ func (g *Generator) processHistory() []Instruction {
 		// Insert at the beginning
 		instructions = append([]Instruction{
 			{
-				Command:    "FROM",
-				Arguments:  baseImage,
-				Time:       time.Time{},
-				EmptyLayer: true,
+				Node:         &ast.From{Image: baseImage},
+				Time:         time.Time{},
+				EmptyLayer:   true,
+				HistoryIndex: -1,
 			},
 		}, instructions...)
 	}
 	
-	// Filter out standalone buildkit comments but preserve actual instructions
-	filteredInstructions := make([]Instruction, 0, len(instructions))
-	for _, inst := range instructions {
-		// Skip instructions that are purely buildkit comments
-		if inst.Command == "COMMENT" && strings.Contains(strings.ToLower(inst.Arguments), "buildkit") {
+	if g.options.Builder != nil && g.options.Builder.Kind != builder.Dockerfile {
+		instructions = append(g.builderComments(), instructions...)
+	}
+	
+	return attachComments(instructions)
+}
+
+// attachComments attaches comment lines to the instruction that follows
+// them, leaving out buildkit comments, and returns the comments after the
+// last instruction
+func attachComments(built []Instruction) ([]Instruction, []string) {
+	instructions := make([]Instruction, 0, len(built))
+	var comments []string
+	for _, inst := range built {
+		if inst.Node == nil {
+			if !strings.Contains(strings.ToLower(inst.comment), "buildkit") {
+				comments = append(comments, inst.comment)
+			}
 			continue
 		}
-		
-		// For other instructions, keep them but clean up any buildkit references
-		if strings.Contains(strings.ToLower(inst.Arguments), "buildkit") {
-			cleanArgs := strings.Replace(inst.Arguments, "# buildkit", "", -1)
-			cleanArgs = strings.Replace(cleanArgs, "#buildkit", "", -1)
-			cleanArgs = strings.TrimSpace(cleanArgs)
-			
-			// Skip if the instruction becomes empty after cleaning
-			if cleanArgs == "" && inst.Command != "FROM" && inst.Command != "CMD" {
-				continue
+		ast.Attach(inst.Node, comments...)
+		comments = nil
+		instructions = append(instructions, inst)
+	}
+	return instructions, comments
+}
+
+// historyNode builds the node of an instruction recorded in the history.
+// Arguments that do not parse, such as HEALTHCHECK structs written by the
+// classic builder, are kept as they are.
+func historyNode(command, args string) ast.Node {
+	switch command {
+	case "RUN":
+		// BuildKit records here-documents with their bodies, one per line
+		if strings.Contains(args, "\n") && heredocRegex.MatchString(args) {
+			if node, err := ast.ParseInstruction("RUN " + args); err == nil {
+				if run, ok := node.(*ast.Run); ok && len(run.Heredocs) > 0 {
+					return run
+				}
 			}
-			
-			inst.Arguments = cleanArgs
 		}
-		
-		filteredInstructions = append(filteredInstructions, inst)
+		return &ast.Run{Command: ast.Command{Shell: args}}
+	case "CMD", "ENTRYPOINT", "SHELL":
+		if exec, ok := historyExec(args); ok {
+			switch command {
+			case "CMD":
+				return &ast.Cmd{Command: ast.Command{Exec: exec}}
+			case "ENTRYPOINT":
+				return &ast.Entrypoint{Command: ast.Command{Exec: exec}}
+			}
+			return &ast.Shell{Shell: exec}
+		}
 	}
-	
-	return filteredInstructions
+	if !strings.Contains(args, "\n") {
+		if node, err := ast.ParseInstruction(command + " " + args); err == nil {
+			return node
+		}
+	}
+	return &ast.Raw{Name: command, Arguments: args}
+}
+
+// historyExec reads an exec form from the history, which records it as a
+// JSON array or as quoted strings without commas, such as ["nginx" "-g"]
+func historyExec(args string) ([]string, bool) {
+	args = strings.TrimSpace(args)
+	if !strings.HasPrefix(args, "[") || !strings.HasSuffix(args, "]") {
+		return nil, false
+	}
+	exec := []string{}
+	if err := json.Unmarshal([]byte(args), &exec); err == nil {
+		return exec, true
+	}
+	exec = []string{}
+	rest := strings.TrimSpace(args[1 : len(args)-1])
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, false
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, false
+		}
+		exec = append(exec, value)
+		rest = strings.TrimLeft(rest[len(quoted):], " ,")
+	}
+	return exec, true
+}
+
+// baseBoundary returns the end of the base image in the history. The
+// boundary has no entries if the base image history is kept.
+func (g *Generator) baseBoundary() Boundary {
+	if g.options.Boundary != nil {
+		return *g.options.Boundary
+	}
+	if b := g.options.Builder; b != nil {
+		if count := b.BaseHistoryCount(g.metadata); count > 0 {
+			return Boundary{HistoryCount: count, Reason: fmt.Sprintf("history before the first %s entry", b.Name())}
+		}
+	}
+	if match := g.options.Fingerprint; match != nil && match.BaseHistoryCount > 0 {
+		return Boundary{HistoryCount: match.BaseHistoryCount, Reason: fmt.Sprintf("end of the %s history", match.Image)}
+	}
+	// The root filesystem of a proposed base image is replaced by its FROM
+	if g.proposesBaseImage() {
+		if boundary := rootFSBoundary(g.metadata); boundary != nil {
+			return *boundary
+		}
+	}
+	return Boundary{}
 }
 
-// historyAscending returns the history entries sorted by creation time (oldest first)
-func (g *Generator) historyAscending() []docker.History {
-	history := make([]docker.History, len(g.metadata.History))
-	copy(history, g.metadata.History)
+// proposesBaseImage reports whether the FROM instruction is the official
+// image proposed for the detected distribution, as nothing better is known
+func (g *Generator) proposesBaseImage() bool {
+	if g.options.BaseImage == "" || g.options.Distro == nil || g.options.Fingerprint != nil {
+		return false
+	}
+	if baseAnnotation(g.metadata, AnnotationBaseName) != "" {
+		return false
+	}
+	if b := g.options.Builder; b != nil && b.Buildpacks != nil && b.Buildpacks.RunImage != "" {
+		return false
+	}
+	// A FROM recorded in the history is kept
+	for _, entry := range g.metadata.History {
+		if command, _ := g.parseHistoryCommand(entry.CreatedBy); command == "FROM" {
+			return false
+		}
+	}
+	return true
+}
+
+// baseInstructions returns the FROM instruction, and comments explaining it,
+// for a base image taken from annotations, an identified official image, the
+// detected distribution or, when the history was cut, a placeholder. It
+// returns nil if nothing is known about the base image.
+func (g *Generator) baseInstructions() []Instruction {
+	boundary := g.baseBoundary()
+	var comments []string
+	var image string
+
+	switch name := baseAnnotation(g.metadata, AnnotationBaseName); {
+	case name != "":
+		image = name
+		if digest := baseAnnotation(g.metadata, AnnotationBaseDigest); digest != "" && !strings.Contains(name, "@") {
+			image += "@" + digest
+		}
+		comments = append(comments, fmt.Sprintf("Base image from the %s annotation", AnnotationBaseName))
+	case g.options.Builder != nil && g.options.Builder.Buildpacks != nil && g.options.Builder.Buildpacks.RunImage != "":
+		image = g.options.Builder.Buildpacks.RunImage
+		comments = append(comments, fmt.Sprintf("Run image from the %s label", builder.LifecycleMetadataLabel))
+	case g.options.Fingerprint != nil:
+		match := g.options.Fingerprint
+		image = match.Image
+		comments = append(comments, fmt.Sprintf("Base image identified as %s from %s", match.Image, strings.Join(match.Evidence, ", ")))
+	case g.options.BaseImage != "" && g.options.Distro != nil:
+		image = g.options.BaseImage
+		comments = append(comments,
+			fmt.Sprintf("Base image not recorded in the history; detected %s from %s", g.options.Distro, strings.Join(g.options.Distro.Sources, ", ")),
+			"The following official image is a plausible base, but the exact tag may differ")
+	case boundary.HistoryCount > 0:
+		image = "${BASE_IMAGE}"
+		comments = append(comments, "The base image could not be identified, set it with --build-arg BASE_IMAGE=...")
+	default:
+		return nil
+	}
+	if boundary.HistoryCount > 0 {
+		comments = append(comments, omittedComments(g.metadata.History, boundary.HistoryCount, boundary.Reason)...)
+	}
+
+	var base []Instruction
+	for _, text := range comments {
+		base = append(base, comment(text, -1))
+	}
+	if image == "${BASE_IMAGE}" {
+		base = append(base, Instruction{Node: &ast.Arg{Vars: []ast.ArgVar{{Name: "BASE_IMAGE"}}}, EmptyLayer: true, HistoryIndex: -1})
+	}
+	return append(base, Instruction{Node: &ast.From{Image: image}, EmptyLayer: true, HistoryIndex: -1})
+}
+
+// historyOrder returns the indexes of the history entries sorted by creation time (oldest first)
+func (g *Generator) historyOrder() []int {
+	order := make([]int, len(g.metadata.History))
+	for i := range order {
+		order[i] = i
+	}
 	
-	// Sort by creation time
-	sort.Slice(history, func(i, j int) bool {
-		timeI, _ := time.Parse(time.RFC3339Nano, history[i].Created)
-		timeJ, _ := time.Parse(time.RFC3339Nano, history[j].Created)
+	// Sort by creation time, keeping the recorded order for equal timestamps
+	sort.SliceStable(order, func(i, j int) bool {
+		timeI, _ := time.Parse(time.RFC3339Nano, g.metadata.History[order[i]].Created)
+		timeJ, _ := time.Parse(time.RFC3339Nano, g.metadata.History[order[j]].Created)
 		return timeI.Before(timeJ)
 	})
 	
-	return history
+	return order
 }
 
 // parseHistoryCommand extracts the Dockerfile instruction and arguments from a history command
 func (g *Generator) parseHistoryCommand(cmd string) (string, string) {
 	// Trim any trailing whitespace
-	cmd = strings.TrimSpace(cmd)
+	cmd = stripBuildArgs(strings.TrimSpace(cmd))
 	
 	// Common prefixes in Docker history
 	if strings.HasPrefix(cmd, "/bin/sh -c #(nop) ") {
@@ -300,6 +597,9 @@ func (g *Generator) parseHistoryCommand(cmd string) (string, string) {
 	for _, inst := range instructions {
 		if strings.HasPrefix(cmd, inst+" ") {
 			parts := strings.SplitN(cmd, " ", 2)
+			if parts[0] == "RUN" {
+				return parts[0], stripBuildArgs(strings.TrimSpace(parts[1]))
+			}
 			return parts[0], strings.TrimSpace(parts[1])
 		}
 	}
@@ -322,8 +622,34 @@ func (g *Generator) parseHistoryCommand(cmd string) (string, string) {
 	return "RUN", cmd
 }
 
+// stripBuildArgs removes the "|N KEY=VAL ..." prefix that records the build
+// args a RUN instruction was given, so their values are never emitted
+func stripBuildArgs(cmd string) string {
+	if !strings.HasPrefix(cmd, "|") {
+		return cmd
+	}
+	fields := strings.Fields(cmd)
+	count, err := strconv.Atoi(strings.TrimPrefix(fields[0], "|"))
+	if err != nil || count < 0 || len(fields) <= count {
+		return cmd
+	}
+	rest := strings.TrimSpace(cmd[len(fields[0]):])
+	for i := 0; i < count; i++ {
+		if !strings.Contains(fields[i+1], "=") {
+			return cmd
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, fields[i+1]))
+	}
+	return rest
+}
+
 // expandRun expands complex RUN commands to make them more readable
 func (g *Generator) expandRun(cmd string) string {
+	// Here-document bodies are kept as they were written
+	if heredocRegex.MatchString(cmd) {
+		return strings.TrimSpace(cmd)
+	}
+	
 	// First, clean up any existing escaped newlines to avoid conflicts
 	cmd = strings.ReplaceAll(cmd, "\\\n", " ")
 	
@@ -340,7 +666,7 @@ func (g *Generator) expandRun(cmd string) string {
 		for i, line := range lines {
 			lines[i] = strings.TrimSpace(line)
 		}
-		return strings.Join(lines, " \\\n    && ")
+		return strings.Join(lines, "\n&& ")
 	}
 	
 	// Handle long lines
@@ -352,11 +678,8 @@ func (g *Generator) expandRun(cmd string) string {
 				for i, part := range parts {
 					parts[i] = strings.TrimSpace(part)
 				}
-				joiner := sep
-				if sep == " ; " {
-					joiner = " ;"
-				}
-				return strings.Join(parts, joiner+" \\\n    ")
+				// Each newline becomes a line continuation
+				return strings.Join(parts, strings.TrimRight(sep, " ")+"\n")
 			}
 		}
 	}
@@ -364,6 +687,22 @@ func (g *Generator) expandRun(cmd string) string {
 	return cmd
 }
 
+// HistoryInstruction renders a single history entry as a one-line Dockerfile
+// instruction, for use when reporting on individual layers
+func HistoryInstruction(createdBy string) string {
+	if strings.TrimSpace(createdBy) == "" {
+		return ""
+	}
+	
+	command, args := (&Generator{}).parseHistoryCommand(createdBy)
+	
+	// Clean up buildkit references from arguments
+	args = strings.Replace(args, "# buildkit", "", -1)
+	args = strings.Replace(args, "#buildkit", "", -1)
+	
+	return strings.TrimSpace(command + " " + strings.TrimSpace(args))
+}
+
 // cleanHistoryCommand extracts the actual command from a history entry
 func cleanHistoryCommand(cmd string) string {
 	// Common prefixes in Docker history
@@ -7,7 +7,14 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/raesene/pasgan/internal/builder"
+	"github.com/raesene/pasgan/internal/distro"
 	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockercommit"
+	"github.com/raesene/pasgan/internal/fingerprint"
+	"github.com/raesene/pasgan/internal/packages"
+	"github.com/raesene/pasgan/internal/rootfs"
+	"github.com/raesene/pasgan/pkg/ast"
 )
 
 func TestGenerator(t *testing.T) {
@@ -111,4 +118,424 @@ func TestGenerator(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestGeneratorProposesBaseImage(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/app:1.0"},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "/bin/sh -c #(nop)  CMD [\"bash\"]", EmptyLayer: true},
+			{Created: "2024-03-01T09:00:00Z", CreatedBy: "RUN /bin/sh -c apt-get update # buildkit"},
+			{Created: "2024-03-01T09:00:10Z", CreatedBy: "COPY . /app # buildkit"},
+		},
+	}
+	release := &distro.Release{ID: "debian", PrettyName: "Debian GNU/Linux 12 (bookworm)", Sources: []string{"/etc/os-release"}}
+
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, Options{Distro: release, BaseImage: "debian:12-slim"}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	if !strings.Contains(result, "FROM debian:12-slim\n") || strings.Contains(result, "FROM example/app") {
+		t.Errorf("Expected the proposed base image, got:\n%s", result)
+	}
+	if !strings.Contains(result, "# Base image not recorded in the history; detected Debian GNU/Linux 12 (bookworm) from /etc/os-release") {
+		t.Errorf("Expected a comment explaining the base image, got:\n%s", result)
+	}
+	// The base history is replaced by the proposed FROM
+	expected := "# 2 history entries of the base image were omitted (root filesystem of the base image):\n" +
+		"#   ADD file:abc in /\n" +
+		"#   CMD [\"bash\"]\n" +
+		"FROM debian:12-slim\n" +
+		"RUN /bin/sh -c apt-get update\n" +
+		"COPY . /app\n"
+	if !strings.HasSuffix(result, expected) {
+		t.Errorf("Expected the base history to be omitted, got:\n%s", result)
+	}
+
+	// Without a detected distribution the existing fallback is kept
+	buf.Reset()
+	if err := NewGenerator(metadata).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "FROM example/app:1.0") {
+		t.Errorf("Expected the repo tag fallback, got:\n%s", buf.String())
+	}
+}
+
+func TestGeneratorCollapsesFingerprintedBase(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/app:1.0"},
+		History: []docker.History{
+			{Created: "2024-02-13T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-02-14T10:00:01Z", CreatedBy: "/bin/sh -c #(nop)  ENV NODE_VERSION=18.19.1", EmptyLayer: true},
+			{Created: "2024-02-14T10:05:00Z", CreatedBy: "/bin/sh -c curl -fsSLO https://nodejs.org/dist/v$NODE_VERSION/node.tar.xz"},
+			{Created: "2024-02-14T10:06:02Z", CreatedBy: "/bin/sh -c #(nop)  CMD [\"node\"]", EmptyLayer: true},
+			{Created: "2024-05-01T09:00:01Z", CreatedBy: "COPY . /app # buildkit"},
+			{Created: "2024-05-01T09:00:30Z", CreatedBy: "RUN /bin/sh -c npm ci # buildkit"},
+		},
+	}
+	match := &fingerprint.Match{Image: "node:18-bookworm-slim", Evidence: []string{"NODE_VERSION=18.19.1"}, BaseHistoryCount: 4}
+
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, Options{Fingerprint: match}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	expected := "# Base image identified as node:18-bookworm-slim from NODE_VERSION=18.19.1\n" +
+		"# 4 history entries of the base image were omitted (end of the node:18-bookworm-slim history):\n" +
+		"#   ADD file:abc in /\n" +
+		"#   ENV NODE_VERSION=18.19.1\n" +
+		"#   RUN curl -fsSLO https://nodejs.org/dist/v$NODE_VERSION/node.tar.xz\n" +
+		"#   CMD [\"node\"]\n" +
+		"FROM node:18-bookworm-slim\n" +
+		"COPY . /app\n" +
+		"RUN /bin/sh -c npm ci\n"
+	if !strings.HasSuffix(result, expected) {
+		t.Errorf("Expected the base history to be collapsed, got:\n%s", result)
+	}
+}
+
+func TestParseBoundary(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"l0/layer.tar", "l1/layer.tar", "l2/layer.tar"},
+		RootFS: docker.RootFS{DiffIDs: []string{"sha256:aaa111", "sha256:bbb222", "sha256:ccc333"}},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "/bin/sh -c #(nop)  CMD [\"bash\"]", EmptyLayer: true},
+			{Created: "2024-03-01T00:00:00Z", CreatedBy: "WORKDIR /app", EmptyLayer: true},
+			{Created: "2024-03-01T00:00:01Z", CreatedBy: "RUN /bin/sh -c apt-get update # buildkit"},
+			{Created: "2024-03-01T00:00:02Z", CreatedBy: "COPY app /app # buildkit"},
+		},
+	}
+
+	testCases := []struct {
+		spec      string
+		wantCount int
+		wantErr   bool
+	}{
+		{spec: "auto", wantCount: 2},
+		{spec: "0", wantCount: 0},
+		{spec: "1", wantCount: 2},
+		{spec: "2", wantCount: 4},
+		{spec: "bbb", wantCount: 4},
+		{spec: "sha256:ccc333", wantCount: 5},
+		{spec: "4", wantErr: true},
+		{spec: "ddd", wantErr: true},
+	}
+	for _, tc := range testCases {
+		boundary, err := ParseBoundary(tc.spec, metadata, nil)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseBoundary(%q) expected an error", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBoundary(%q) error = %v", tc.spec, err)
+			continue
+		}
+		if boundary.HistoryCount != tc.wantCount {
+			t.Errorf("ParseBoundary(%q) = %d entries, want %d", tc.spec, boundary.HistoryCount, tc.wantCount)
+		}
+	}
+
+	// Without an identified base image the FROM is a build argument placeholder
+	boundary, _ := ParseBoundary("auto", metadata, nil)
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, Options{Boundary: boundary}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	expected := "# 2 history entries of the base image were omitted (59 day pause in the history after a base image starting with ADD file:... in /):\n" +
+		"#   ADD file:abc in /\n" +
+		"#   CMD [\"bash\"]\n" +
+		"ARG BASE_IMAGE\n" +
+		"FROM ${BASE_IMAGE}\n" +
+		"WORKDIR /app\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected the base history to be cut, got:\n%s", buf.String())
+	}
+
+	// The base image annotation names the FROM
+	metadata.Annotations = map[string]string{AnnotationBaseName: "docker.io/library/debian:12", AnnotationBaseDigest: "sha256:feed"}
+	buf.Reset()
+	if err := NewGeneratorWithOptions(metadata, Options{Boundary: boundary}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "FROM docker.io/library/debian:12@sha256:feed\nWORKDIR /app\n") {
+		t.Errorf("Expected the annotated base image, got:\n%s", buf.String())
+	}
+}
+
+func TestGeneratorBuilderLayers(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/service:1.0"},
+		Layers:   []string{"base", "deps", "classes"},
+		Config: docker.Config{
+			Env:          []string{"JAVA_TOOL_OPTIONS=-Xmx512m -Xss1m"},
+			Entrypoint:   []string{"java", "-cp", "@/app/jib-classpath-file", "com.example.Main"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "1970-01-01T00:00:00Z", CreatedBy: "jib-maven-plugin:3.4.0", Author: "Jib", Comment: "dependencies"},
+			{Created: "1970-01-01T00:00:00Z", CreatedBy: "jib-maven-plugin:3.4.0", Author: "Jib", Comment: "classes"},
+		},
+	}
+	b := &builder.Builder{
+		Kind: builder.Jib, Tool: "jib-maven-plugin", Version: "3.4.0",
+		Evidence:   []string{"history: jib-maven-plugin:3.4.0"},
+		LayerRoots: []string{"/", "/app/libs", "/app/classes/com/example"},
+	}
+
+	var buf bytes.Buffer
+	options := Options{Builder: b, BaseImage: "ubuntu:22.04", Distro: &distro.Release{ID: "ubuntu", Sources: []string{"/etc/os-release"}}}
+	if err := NewGeneratorWithOptions(metadata, options).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	if strings.Contains(result, "RUN jib") {
+		t.Errorf("Expected no RUN instructions for Jib entries, got:\n%s", result)
+	}
+	for _, want := range []string{
+		"# Built with jib-maven-plugin 3.4.0, not from a Dockerfile (history: jib-maven-plugin:3.4.0)\n",
+		"#   ADD file:abc in /\nFROM ubuntu:22.04\n",
+		"# Layer 1 added by jib-maven-plugin 3.4.0: dependencies\nCOPY dependencies/ /app/libs/\n",
+		"# Layer 2 added by jib-maven-plugin 3.4.0: classes\nCOPY classes/ /app/classes/\n",
+		"ENV JAVA_TOOL_OPTIONS=\"-Xmx512m -Xss1m\"\n",
+		"EXPOSE 8080/tcp\n",
+		"ENTRYPOINT [\"java\",\"-cp\",\"@/app/jib-classpath-file\",\"com.example.Main\"]\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestGeneratorBuildpacksLayers(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers:  []string{"run", "node", "app"},
+		Config:  docker.Config{Entrypoint: []string{"/cnb/process/web"}},
+		RootFS:  docker.RootFS{DiffIDs: []string{"sha256:run", "sha256:node", "sha256:app"}},
+		History: []docker.History{{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "}},
+	}
+	b := &builder.Builder{
+		Kind: builder.Buildpacks, Tool: "buildpacks",
+		Evidence:    []string{builder.LifecycleMetadataLabel + " label"},
+		LayerOwners: []string{"run image", "paketo-buildpacks/node-engine (node)", "application"},
+		Buildpacks:  &builder.BuildpacksBuild{RunImage: "paketobuildpacks/run-jammy-base:latest", BaseLayers: 1},
+	}
+
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, Options{Builder: b}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	for _, want := range []string{
+		"#   ADD file:abc in /\nFROM paketobuildpacks/run-jammy-base:latest\n",
+		"# Layer 1 added by buildpacks: paketo-buildpacks/node-engine (node)\nCOPY layer-1/ /\n",
+		"# Layer 2 added by buildpacks: application\nCOPY application/ /\n",
+		"ENTRYPOINT [\"/cnb/process/web\"]\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestGeneratorCommittedLayers(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"base", "commit"},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-02-01T00:00:00Z", CreatedBy: "bash"},
+		},
+	}
+	c := &dockercommit.Layer{
+		LayerIndex: 1, HistoryIndex: 1, Command: "bash",
+		Reason:   "the history records the interactive shell \"bash\"",
+		Packages: []packages.Package{{Ecosystem: packages.EcosystemApk, Name: "curl", Version: "8.5.0-r0", Layer: 1}},
+		Added:    []string{"/opt/app/run.sh"},
+		Deleted:  []string{"/etc/motd"},
+		Root:     "/opt/app",
+	}
+
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, Options{Commits: []*dockercommit.Layer{c}}).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	if strings.Contains(result, "RUN bash") {
+		t.Errorf("Expected no RUN for the container command, got:\n%s", result)
+	}
+	for _, want := range []string{
+		"# Layer 1 was created by docker commit: the history records the interactive shell \"bash\"\n" +
+			"# It installed 1 apk package, added 1 file, deleted 1 path\n",
+		"RUN apk add --no-cache curl=8.5.0-r0 \\\n    && rm -rf /etc/motd\n",
+		"# Copy the changed files from the image into layer-1/ of the build context:\n#   /opt/app/run.sh\nCOPY layer-1/ /opt/app/\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestGeneratorRootFS(t *testing.T) {
+	metadata := &docker.ImageMetadata{Layers: []string{"rootfs.tar"}, Flat: true}
+	evidence := &rootfs.Evidence{
+		Packages:     []packages.Package{{Ecosystem: packages.EcosystemDeb, Name: "curl", Version: "7.88.1-10"}},
+		BasePackages: 80,
+		Groups:       []rootfs.Group{{Path: "/entrypoint.sh", Files: 1}, {Path: "/srv/app", Files: 2, IsDir: true}},
+		User:         "app",
+	}
+	options := Options{RootFS: evidence, BaseImage: "debian:12-slim", Distro: &distro.Release{ID: "debian", Sources: []string{"/etc/os-release"}}}
+
+	var buf bytes.Buffer
+	if err := NewGeneratorWithOptions(metadata, options).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	for _, want := range []string{
+		"# LOW CONFIDENCE: reconstructed from a flat filesystem",
+		"FROM debian:12-slim\n",
+		"RUN apt-get update \\\n    && apt-get install -y --no-install-recommends curl=7.88.1-10 \\\n",
+		"COPY rootfs/entrypoint.sh /entrypoint.sh\n",
+		"COPY rootfs/srv/app/ /srv/app/\n",
+		"USER app\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestGeneratorWithoutHistory(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		RepoTags: []string{"example/web:1.0"},
+		Config:   docker.Config{User: "app", Cmd: []string{"/server"}},
+	}
+
+	var buf bytes.Buffer
+	if err := NewGenerator(metadata).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	for _, want := range []string{
+		"ARG BASE_IMAGE\nFROM ${BASE_IMAGE}\n# The image has no history",
+		"USER app\nCMD [\"/server\"]\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in:\n%s", want, result)
+		}
+	}
+}
+
+func TestGeneratorNodes(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "ENV GREETING=hello LANG=C.UTF-8", EmptyLayer: true},
+			{Created: "2024-01-01T00:00:02Z", CreatedBy: "RUN /bin/sh -c apt-get update && apt-get install -y curl # buildkit", Comment: "install curl"},
+			{Created: "2024-01-01T00:00:04Z", CreatedBy: "CMD [\"nginx\" \"-g\" \"daemon off;\"]", EmptyLayer: true},
+		},
+	}
+
+	file, instructions := NewGenerator(metadata).File()
+	if len(instructions) != 4 || len(file.Nodes) != 4 {
+		t.Fatalf("File() = %d instructions, %d nodes, want 4", len(instructions), len(file.Nodes))
+	}
+	if env, ok := instructions[1].Node.(*ast.Env); !ok || len(env.Pairs) != 2 || env.Pairs[1].Value != "C.UTF-8" {
+		t.Errorf("ENV node = %#v, want two pairs", instructions[1].Node)
+	}
+	// The history comment is attached to the instruction that follows it
+	if comments := ast.CommentsOf(instructions[3].Node); len(comments) != 1 || comments[0] != "install curl" {
+		t.Errorf("CMD comments = %v, want the history comment", comments)
+	}
+	// The exec form recorded without commas is written as JSON
+	if got := FormatInstruction(instructions[3]); got != `CMD ["nginx","-g","daemon off;"]` {
+		t.Errorf("FormatInstruction() = %s", got)
+	}
+	if lines := LineNumbers(instructions); lines[2] != HeaderLines+3 || lines[3] != HeaderLines+6 {
+		t.Errorf("LineNumbers() = %v, want RUN on two lines and the CMD after its comment", lines)
+	}
+	// Arguments that do not parse are kept as text
+	healthcheck := historyNode("HEALTHCHECK", `&{["CMD-SHELL" "curl -f localhost"] "30s" "0s" "0s" '\x00'}`)
+	if raw, ok := healthcheck.(*ast.Raw); !ok || !strings.HasPrefix(FormatInstruction(Instruction{Node: raw}), "HEALTHCHECK &{") {
+		t.Errorf("historyNode() = %#v, want the struct kept as text", healthcheck)
+	}
+
+	// Here-document bodies are written as they are, without continuations
+	heredoc := historyNode("RUN", "cat <<EOF > /etc/motd && chmod 644 /etc/motd\nWelcome && enjoy your stay\n  indented\nEOF")
+	if got := FormatInstruction(Instruction{Node: heredoc}); got != "RUN cat <<EOF > /etc/motd && chmod 644 /etc/motd\nWelcome && enjoy your stay\n  indented\nEOF" {
+		t.Errorf("FormatInstruction() = %q, want the here-document as it was", got)
+	}
+	if run, ok := heredoc.(*ast.Run); !ok || len(run.Heredocs) != 1 || run.Heredocs[0].Content != "Welcome && enjoy your stay\n  indented\n" {
+		t.Errorf("historyNode() = %#v, want the body in Heredocs", heredoc)
+	}
+
+	// The generated Dockerfile parses back to the same text
+	var buf bytes.Buffer
+	if err := NewGenerator(metadata).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	parsed, err := ast.Parse(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var again bytes.Buffer
+	if err := ast.Format(&again, parsed); err != nil || again.String() != buf.String() {
+		t.Errorf("Format(Parse()) =\n%s\nwant\n%s", again.String(), buf.String())
+	}
+}
+
+func TestGeneratorHeredocs(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "RUN /bin/sh -c cat <<EOF > /etc/apt/sources.list && apt-get update\ndeb http://deb.debian.org/debian bookworm main && contrib\n# not a comment\nEOF # buildkit"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := NewGenerator(metadata).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	want := "cat <<EOF > /etc/apt/sources.list && apt-get update\ndeb http://deb.debian.org/debian bookworm main && contrib\n# not a comment\nEOF\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Generate() =\n%s\nwant the here-document as it was\n%s", buf.String(), want)
+	}
+	parsed, err := ast.Parse(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	run, ok := parsed.Nodes[len(parsed.Nodes)-1].(*ast.Run)
+	if !ok || len(run.Heredocs) != 1 || !strings.HasPrefix(run.Heredocs[0].Content, "deb http://") {
+		t.Errorf("Parse() last node = %#v, want the RUN with its here-document", parsed.Nodes[len(parsed.Nodes)-1])
+	}
+}
+
+func TestGeneratorStripsBuildArgs(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "|1 TOKEN=plain-build-arg /bin/sh -c make install"},
+			{Created: "2024-01-01T00:00:02Z", CreatedBy: "RUN |2 TOKEN=plain-build-arg VERSION=1.2 /bin/sh -c make test # buildkit"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := NewGenerator(metadata).Generate(&buf); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	result := buf.String()
+	if strings.Contains(result, "plain-build-arg") || strings.Contains(result, "|1") || strings.Contains(result, "|2") {
+		t.Errorf("Expected the build args to be stripped, got:\n%s", result)
+	}
+	if !strings.Contains(result, "RUN make install\n") || !strings.Contains(result, "RUN /bin/sh -c make test\n") {
+		t.Errorf("Expected the RUN commands without their build args, got:\n%s", result)
+	}
+	if got := HistoryInstruction(metadata.History[1].CreatedBy); got != "RUN make install" {
+		t.Errorf("HistoryInstruction() = %q, want %q", got, "RUN make install")
+	}
+}
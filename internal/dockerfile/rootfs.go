@@ -0,0 +1,62 @@
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/packages"
+	"github.com/raesene/pasgan/pkg/ast"
+)
+
+// rootFSInstructions synthesises a Dockerfile for a flat root filesystem from
+// the evidence in its files. There is no history to follow, so every
+// instruction is a guess and the result says so.
+func (g *Generator) rootFSInstructions() []Instruction {
+	evidence := g.options.RootFS
+	var instructions []Instruction
+	add := func(node ast.Node) {
+		instructions = append(instructions, Instruction{Node: node, HistoryIndex: -1})
+	}
+	note := func(text string) {
+		instructions = append(instructions, comment(text, -1))
+	}
+
+	note("LOW CONFIDENCE: reconstructed from a flat filesystem, such as docker export writes, with no image config or history")
+	note("The layers, environment, ports, working directory and command are not recorded; every instruction below is inferred from files")
+
+	if base := g.baseInstructions(); base != nil {
+		instructions = append(instructions, base...)
+	} else {
+		note("The distribution could not be identified")
+		add(&ast.From{Image: "scratch"})
+	}
+
+	if len(evidence.Packages) > 0 {
+		note(fmt.Sprintf("Packages installed on top of the base image; %d base packages and automatic dependencies are left out", evidence.BasePackages))
+		add(&ast.Run{Command: ast.Command{Shell: strings.Join(packages.InstallCommands(evidence.Packages), "\n&& ")}})
+	}
+
+	if len(evidence.Groups) > 0 {
+		note("Files that no package owns; extract them into rootfs/ with: pasgan export-rootfs <image> rootfs/")
+		for _, group := range evidence.Groups {
+			source, destination := "rootfs"+group.Path, group.Path
+			if group.IsDir {
+				source, destination = source+"/", destination+"/"
+			}
+			files := fmt.Sprintf("%d files", group.Files)
+			if group.Files == 1 {
+				files = "1 file"
+			}
+			note(group.Path + ": " + files)
+			add(&ast.Copy{Sources: []string{source}, Dest: destination})
+		}
+	}
+
+	if evidence.User != "" {
+		note("User guessed from /etc/passwd")
+		add(&ast.User{User: evidence.User})
+	}
+	note("The command is not recorded by docker export, set it here:")
+	note("CMD [\"...\"]")
+	return instructions
+}
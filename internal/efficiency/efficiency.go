@@ -0,0 +1,303 @@
+// Package efficiency measures the space an image wastes on files that are
+// deleted, overwritten or duplicated by later layers.
+package efficiency
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// Reason describes why a file's space is wasted
+type Reason string
+
+// Supported reasons
+const (
+	Deleted     Reason = "deleted"
+	Overwritten Reason = "overwritten"
+	Duplicate   Reason = "duplicate"
+)
+
+// Waste is a file whose bytes are stored in a layer but do not contribute to
+// the final filesystem
+type Waste struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Reason      Reason `json:"reason"`
+	Layer       int    `json:"layer"`
+	Instruction string `json:"instruction,omitempty"`
+	// ByLayer is the layer that deleted or overwrote the file, or that added
+	// the duplicate. ByPath is the earlier identical file for duplicates.
+	ByLayer       int    `json:"by_layer"`
+	ByInstruction string `json:"by_instruction,omitempty"`
+	ByPath        string `json:"by_path,omitempty"`
+}
+
+// LayerSummary is the size and waste of a single layer
+type LayerSummary struct {
+	Layer       int    `json:"layer"`
+	Instruction string `json:"instruction,omitempty"`
+	Size        int64  `json:"size"`
+	Wasted      int64  `json:"wasted"`
+}
+
+// Suggestion is a change to the build that would save space
+type Suggestion struct {
+	Layer   int    `json:"layer"`
+	Message string `json:"message"`
+	Savings int64  `json:"savings"`
+}
+
+// Report is the result of an efficiency analysis
+type Report struct {
+	// TotalSize is the size of the regular files in every layer
+	TotalSize int64 `json:"total_size"`
+	// WastedSize is the size of deleted, overwritten and duplicated files
+	WastedSize int64 `json:"wasted_size"`
+	// Score is the fraction of TotalSize that is not wasted, between 0 and 1
+	Score       float64        `json:"score"`
+	Wasted      []Waste        `json:"wasted"`
+	Layers      []LayerSummary `json:"layers"`
+	Suggestions []Suggestion   `json:"suggestions"`
+}
+
+// cacheDirs are directories package managers leave behind, with the command
+// that removes them
+var cacheDirs = []struct {
+	dir     string
+	cleanup string
+}{
+	{"/var/lib/apt/lists", "rm -rf /var/lib/apt/lists/*"},
+	{"/var/cache/apt", "apt-get clean"},
+	{"/var/cache/apk", "apk add --no-cache"},
+	{"/var/cache/yum", "yum clean all"},
+	{"/var/cache/dnf", "dnf clean all"},
+	{"/root/.cache/pip", "pip install --no-cache-dir"},
+	{"/root/.npm", "npm cache clean --force"},
+	{"/usr/local/share/.cache/yarn", "yarn cache clean"},
+	{"/root/.cache/go-build", "go clean -cache"},
+	{"/tmp", "rm -rf /tmp/*"},
+}
+
+// file is a regular file stored in a layer
+type file struct {
+	path   string
+	layer  int
+	size   int64
+	digest string
+}
+
+// Analyze reads every layer of an image and reports the space wasted by files
+// that later layers delete, overwrite or store again
+func Analyze(o layer.Opener, metadata *docker.ImageMetadata) (*Report, error) {
+	var files []file
+	index, err := layer.BuildIndex(o, len(metadata.Layers), func(layerIndex int, hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			return nil
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		files = append(files, file{
+			path:   layer.Clean(hdr.Name),
+			layer:  layerIndex,
+			size:   hdr.Size,
+			digest: hex.EncodeToString(hash.Sum(nil)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instructions := layerInstructions(metadata)
+	report := &Report{Wasted: []Waste{}, Suggestions: []Suggestion{}}
+	for i, changes := range index.Layers {
+		report.Layers = append(report.Layers, LayerSummary{Layer: i, Instruction: instructions[i], Size: changes.Size})
+		report.TotalSize += changes.Size
+	}
+
+	// Files hidden by a later layer still take space in the layer that added them
+	var visible []file
+	for _, f := range files {
+		removal, hidden := index.Shadowed(f.path, f.layer)
+		if !hidden {
+			visible = append(visible, f)
+			continue
+		}
+		reason := Overwritten
+		if removal.Deleted {
+			reason = Deleted
+		}
+		report.Wasted = append(report.Wasted, Waste{
+			Path: f.path, Size: f.size, Reason: reason, Layer: f.layer, Instruction: instructions[f.layer],
+			ByLayer: removal.Layer, ByInstruction: instructions[removal.Layer],
+		})
+	}
+
+	// Identical files in the final filesystem that were added by different layers
+	byDigest := make(map[string][]file)
+	for _, f := range visible {
+		byDigest[f.digest] = append(byDigest[f.digest], f)
+	}
+	for _, copies := range byDigest {
+		first := copies[0]
+		for _, f := range copies[1:] {
+			if f.layer == first.layer {
+				continue
+			}
+			report.Wasted = append(report.Wasted, Waste{
+				Path: f.path, Size: f.size, Reason: Duplicate, Layer: f.layer, Instruction: instructions[f.layer],
+				ByLayer: first.layer, ByInstruction: instructions[first.layer], ByPath: first.path,
+			})
+		}
+	}
+
+	for _, waste := range report.Wasted {
+		report.WastedSize += waste.Size
+		report.Layers[waste.Layer].Wasted += waste.Size
+	}
+	report.Score = 1
+	if report.TotalSize > 0 {
+		report.Score = float64(report.TotalSize-report.WastedSize) / float64(report.TotalSize)
+	}
+
+	sort.SliceStable(report.Wasted, func(i, j int) bool {
+		if report.Wasted[i].Size != report.Wasted[j].Size {
+			return report.Wasted[i].Size > report.Wasted[j].Size
+		}
+		return report.Wasted[i].Path < report.Wasted[j].Path
+	})
+
+	report.Suggestions = append(report.Suggestions, wasteSuggestions(report.Wasted)...)
+	report.Suggestions = append(report.Suggestions, cacheSuggestions(visible, instructions)...)
+	sort.SliceStable(report.Suggestions, func(i, j int) bool {
+		return report.Suggestions[i].Savings > report.Suggestions[j].Savings
+	})
+
+	return report, nil
+}
+
+// layerInstructions returns the instruction that created each layer
+func layerInstructions(metadata *docker.ImageMetadata) []string {
+	instructions := make([]string, len(metadata.Layers))
+	for i, historyIndex := range metadata.LayerHistoryIndexes() {
+		if historyIndex >= 0 {
+			instructions[i] = dockerfile.HistoryInstruction(metadata.History[historyIndex].CreatedBy)
+		}
+	}
+	return instructions
+}
+
+// wasteSuggestions proposes fixes for each pair of layers that waste space
+func wasteSuggestions(wasted []Waste) []Suggestion {
+	type key struct {
+		reason      Reason
+		layer       int
+		byLayer     int
+		instruction string
+		by          string
+	}
+	totals := make(map[key]int64)
+	counts := make(map[key]int)
+	var order []key
+	for _, waste := range wasted {
+		k := key{waste.Reason, waste.Layer, waste.ByLayer, waste.Instruction, waste.ByInstruction}
+		if _, ok := totals[k]; !ok {
+			order = append(order, k)
+		}
+		totals[k] += waste.Size
+		counts[k]++
+	}
+
+	var suggestions []Suggestion
+	for _, k := range order {
+		size := utils.FormatSize(totals[k])
+		var message string
+		switch k.reason {
+		case Deleted:
+			message = fmt.Sprintf("Layer %d (%s) deletes %s in %d file(s) added by layer %d (%s). ",
+				k.byLayer, describe(k.by), size, counts[k], k.layer, describe(k.instruction))
+			if isInstruction(k.instruction, "RUN") {
+				message += "Remove the files in the same RUN instruction that creates them."
+			} else {
+				message += "Exclude the files from the build context with .dockerignore or a multi-stage build."
+			}
+		case Overwritten:
+			message = fmt.Sprintf("Layer %d (%s) overwrites %s in %d file(s) added by layer %d (%s). ",
+				k.byLayer, describe(k.by), size, counts[k], k.layer, describe(k.instruction))
+			if isInstruction(k.instruction, "RUN") && isInstruction(k.by, "RUN") {
+				message += "Merge the two RUN instructions."
+			} else {
+				message += "Only add the final version of these files."
+			}
+		case Duplicate:
+			message = fmt.Sprintf("Layer %d (%s) adds %s in %d file(s) identical to files from layer %d. "+
+				"Copy them once, or use a multi-stage build to copy only what is needed.",
+				k.layer, describe(k.instruction), size, counts[k], k.byLayer)
+		}
+		suggestions = append(suggestions, Suggestion{Layer: k.layer, Message: message, Savings: totals[k]})
+	}
+	return suggestions
+}
+
+// cacheSuggestions reports package manager caches left in the final filesystem
+func cacheSuggestions(visible []file, instructions []string) []Suggestion {
+	type key struct {
+		layer int
+		cache int
+	}
+	totals := make(map[key]int64)
+	var order []key
+	for _, f := range visible {
+		for c, cache := range cacheDirs {
+			if !strings.HasPrefix(f.path, cache.dir+"/") {
+				continue
+			}
+			k := key{f.layer, c}
+			if _, ok := totals[k]; !ok {
+				order = append(order, k)
+			}
+			totals[k] += f.size
+			break
+		}
+	}
+
+	var suggestions []Suggestion
+	for _, k := range order {
+		cache := cacheDirs[k.cache]
+		suggestions = append(suggestions, Suggestion{
+			Layer: k.layer,
+			Message: fmt.Sprintf("Layer %d (%s) leaves %s in %s. Clean it up in the same instruction, e.g. with `%s`.",
+				k.layer, describe(instructions[k.layer]), utils.FormatSize(totals[k]), cache.dir, cache.cleanup),
+			Savings: totals[k],
+		})
+	}
+	return suggestions
+}
+
+// describe shortens an instruction for use in a sentence
+func describe(instruction string) string {
+	if instruction == "" {
+		return "unknown instruction"
+	}
+	const limit = 60
+	if len(instruction) > limit {
+		return instruction[:limit-3] + "..."
+	}
+	return instruction
+}
+
+func isInstruction(instruction, keyword string) bool {
+	return strings.HasPrefix(instruction, keyword+" ")
+}
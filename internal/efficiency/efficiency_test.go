@@ -0,0 +1,78 @@
+package efficiency
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+func TestAnalyze(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Reg("bin/sh", strings.Repeat("s", 100)),
+		),
+		testimage.Layer(
+			testimage.Reg("var/lib/apt/lists/main", strings.Repeat("a", 400)),
+			testimage.Reg("etc/config", strings.Repeat("c", 50)),
+		),
+		testimage.Layer(
+			testimage.Whiteout("var/lib/apt/lists"),
+		),
+		testimage.Layer(
+			testimage.Reg("etc/config", strings.Repeat("C", 50)),
+			testimage.Reg("opt/sh-copy", strings.Repeat("s", 100)),
+			testimage.Reg("tmp/build.log", strings.Repeat("l", 10)),
+		),
+	}
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"0/layer.tar", "1/layer.tar", "2/layer.tar", "3/layer.tar"},
+		History: []docker.History{
+			{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{CreatedBy: "RUN /bin/sh -c apt-get update # buildkit"},
+			{CreatedBy: "RUN /bin/sh -c rm -rf /var/lib/apt/lists # buildkit"},
+			{CreatedBy: "RUN /bin/sh -c configure # buildkit"},
+		},
+	}
+
+	report, err := Analyze(layers, metadata)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+
+	if report.TotalSize != 710 || report.WastedSize != 550 {
+		t.Errorf("TotalSize = %d, WastedSize = %d; want 710, 550", report.TotalSize, report.WastedSize)
+	}
+	if want := 160.0 / 710.0; report.Score != want {
+		t.Errorf("Score = %v, want %v", report.Score, want)
+	}
+
+	if len(report.Wasted) != 3 {
+		t.Fatalf("Expected 3 wasted files, got %+v", report.Wasted)
+	}
+	apt, duplicate, config := report.Wasted[0], report.Wasted[1], report.Wasted[2]
+	if apt.Path != "/var/lib/apt/lists/main" || apt.Reason != Deleted || apt.Layer != 1 || apt.ByLayer != 2 {
+		t.Errorf("Unexpected apt waste %+v", apt)
+	}
+	if duplicate.Path != "/opt/sh-copy" || duplicate.Reason != Duplicate || duplicate.ByPath != "/bin/sh" {
+		t.Errorf("Unexpected duplicate waste %+v", duplicate)
+	}
+	if config.Path != "/etc/config" || config.Reason != Overwritten || config.ByLayer != 3 {
+		t.Errorf("Unexpected config waste %+v", config)
+	}
+	if report.Layers[1].Wasted != 450 {
+		t.Errorf("Layer 1 wasted = %d, want 450", report.Layers[1].Wasted)
+	}
+
+	var messages []string
+	for _, suggestion := range report.Suggestions {
+		messages = append(messages, suggestion.Message)
+	}
+	joined := strings.Join(messages, "\n")
+	for _, want := range []string{"same RUN instruction", "Merge the two RUN instructions", "multi-stage build", "rm -rf /tmp/*"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected a suggestion containing %q, got:\n%s", want, joined)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package efficiency
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// WriteText writes a human readable report listing at most top wasted files
+func WriteText(w io.Writer, report *Report, top int) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Efficiency score: %.3f (%.1f%% of layer bytes are in the final filesystem)\n", report.Score, report.Score*100)
+	fmt.Fprintf(&b, "Total layer size: %s\n", utils.FormatSize(report.TotalSize))
+	fmt.Fprintf(&b, "Wasted space: %s\n", utils.FormatSize(report.WastedSize))
+
+	b.WriteString("\nLayers:\n")
+	for _, summary := range report.Layers {
+		fmt.Fprintf(&b, "  %3d  %10s  %10s wasted  %s\n", summary.Layer, utils.FormatSize(summary.Size),
+			utils.FormatSize(summary.Wasted), describe(summary.Instruction))
+	}
+
+	b.WriteString("\nTop wasted files:\n")
+	if len(report.Wasted) == 0 {
+		b.WriteString("  None\n")
+	}
+	for i, waste := range report.Wasted {
+		if i == top {
+			fmt.Fprintf(&b, "  ... and %d more\n", len(report.Wasted)-top)
+			break
+		}
+		fmt.Fprintf(&b, "  %10s  %s (%s)\n", utils.FormatSize(waste.Size), waste.Path, wasteNote(waste))
+		fmt.Fprintf(&b, "              added by layer %d: %s\n", waste.Layer, describe(waste.Instruction))
+	}
+
+	b.WriteString("\nSuggestions:\n")
+	if len(report.Suggestions) == 0 {
+		b.WriteString("  None\n")
+	}
+	for _, suggestion := range report.Suggestions {
+		fmt.Fprintf(&b, "  - %s\n", suggestion.Message)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// wasteNote explains why a file is wasted
+func wasteNote(waste Waste) string {
+	switch waste.Reason {
+	case Deleted:
+		return fmt.Sprintf("deleted by layer %d", waste.ByLayer)
+	case Overwritten:
+		return fmt.Sprintf("overwritten by layer %d", waste.ByLayer)
+	}
+	return fmt.Sprintf("duplicate of %s from layer %d", waste.ByPath, waste.ByLayer)
+}
@@ -0,0 +1,306 @@
+// Package fingerprint identifies the official image an image was built from,
+// such as node or python, from the environment variables and history entries
+// that official images leave behind.
+package fingerprint
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/raesene/pasgan/internal/distro"
+	"github.com/raesene/pasgan/internal/docker"
+)
+
+// defaultDatabase is the fingerprint database shipped with pasgan. It can be
+// extended or corrected at runtime with LoadDatabase and Merge.
+//
+//go:embed fingerprints.json
+var defaultDatabase []byte
+
+// maxBuildGap is the longest pause between two history entries of the same
+// base image build, used when the base image's last instruction is unknown
+const maxBuildGap = time.Hour
+
+// Fingerprint describes the telltale signs of an official image
+type Fingerprint struct {
+	Name       string `json:"name"`
+	Repository string `json:"repository"`
+	// VersionEnv is the variable holding the runtime version, e.g. NODE_VERSION
+	VersionEnv string `json:"version_env"`
+	// VersionRegex extracts the version from VersionEnv, using the first
+	// group if there is one
+	VersionRegex string `json:"version_regex,omitempty"`
+	// Precision is how much of the version the tag uses: major, majorminor or full
+	Precision string `json:"precision"`
+	// RequireEnv and RequireHistory must all be present for the fingerprint
+	// to match. History patterns are substrings of a history entry.
+	RequireEnv     []string `json:"require_env"`
+	RequireHistory []string `json:"require_history,omitempty"`
+	// MarkerEnv and MarkerHistory are optional signs that raise the score
+	MarkerEnv     []string `json:"marker_env,omitempty"`
+	MarkerHistory []string `json:"marker_history,omitempty"`
+	// EndHistory matches the last history entry of the base image
+	EndHistory []string `json:"end_history,omitempty"`
+	// Tags are tag templates keyed by variant: debian, debian-slim, ubuntu,
+	// alpine and default. {version}, {codename} and {alpine} are replaced.
+	Tags map[string]string `json:"tags"`
+
+	versionRegex *regexp.Regexp
+}
+
+// Database is an ordered list of fingerprints. Earlier entries win ties.
+type Database []*Fingerprint
+
+// Match is an official image identified in an image
+type Match struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	Version string `json:"version"`
+	// Evidence lists the environment variables and history entries that matched
+	Evidence []string `json:"evidence"`
+	Score    int      `json:"score"`
+	// BaseHistoryCount is the number of leading history entries that belong
+	// to the base image, or 0 if the boundary could not be found
+	BaseHistoryCount int `json:"base_history_count"`
+}
+
+// DefaultDatabase returns the embedded fingerprint database
+func DefaultDatabase() Database {
+	database, err := LoadDatabase(strings.NewReader(string(defaultDatabase)))
+	if err != nil {
+		panic(fmt.Sprintf("fingerprint: invalid embedded database: %v", err))
+	}
+	return database
+}
+
+// LoadDatabase reads a fingerprint database in the same JSON format as the embedded one
+func LoadDatabase(r io.Reader) (Database, error) {
+	var database Database
+	if err := json.NewDecoder(r).Decode(&database); err != nil {
+		return nil, fmt.Errorf("failed to parse fingerprint database: %w", err)
+	}
+	for i, fp := range database {
+		if fp == nil || fp.Name == "" || fp.Repository == "" {
+			return nil, fmt.Errorf("fingerprint %d: name and repository are required", i)
+		}
+		if len(fp.RequireEnv) == 0 && len(fp.RequireHistory) == 0 {
+			return nil, fmt.Errorf("fingerprint %s: at least one required env or history pattern is needed", fp.Name)
+		}
+		if fp.Tags["default"] == "" {
+			return nil, fmt.Errorf("fingerprint %s: a default tag is required", fp.Name)
+		}
+		switch fp.Precision {
+		case "", "major", "majorminor", "full":
+		default:
+			return nil, fmt.Errorf("fingerprint %s: invalid precision %q", fp.Name, fp.Precision)
+		}
+		if fp.VersionRegex != "" {
+			re, err := regexp.Compile(fp.VersionRegex)
+			if err != nil {
+				return nil, fmt.Errorf("fingerprint %s: invalid version regex: %w", fp.Name, err)
+			}
+			fp.versionRegex = re
+		}
+	}
+	return database, nil
+}
+
+// Merge adds the fingerprints of other to the database. Fingerprints with the
+// same name replace existing ones.
+func (d *Database) Merge(other Database) {
+	for _, fp := range other {
+		replaced := false
+		for i := range *d {
+			if (*d)[i].Name == fp.Name {
+				(*d)[i] = fp
+				replaced = true
+			}
+		}
+		if !replaced {
+			*d = append(*d, fp)
+		}
+	}
+}
+
+// Identify returns the best matching official image, or nil if no fingerprint
+// matches. The release, if known, selects the tag variant.
+func (d Database) Identify(metadata *docker.ImageMetadata, release *distro.Release) *Match {
+	env := make(map[string]string)
+	for _, variable := range metadata.Config.Env {
+		key, value, _ := strings.Cut(variable, "=")
+		env[key] = value
+	}
+
+	var best *Match
+	var bestFingerprint *Fingerprint
+	for _, fp := range d {
+		match := fp.match(env, metadata.History)
+		if match != nil && (best == nil || match.Score > best.Score) {
+			best, bestFingerprint = match, fp
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	best.Version = bestFingerprint.version(env[bestFingerprint.VersionEnv])
+	best.BaseHistoryCount = bestFingerprint.baseHistoryCount(metadata.History)
+	base := metadata.History
+	if best.BaseHistoryCount > 0 {
+		base = base[:best.BaseHistoryCount]
+	}
+	best.Image = bestFingerprint.Repository + ":" + bestFingerprint.tag(best.Version, release, isSlim(base))
+	return best
+}
+
+// match checks the required patterns and scores the markers
+func (fp *Fingerprint) match(env map[string]string, history []docker.History) *Match {
+	match := &Match{Name: fp.Name}
+	for _, key := range fp.RequireEnv {
+		value, ok := env[key]
+		if !ok {
+			return nil
+		}
+		match.Evidence = append(match.Evidence, key+"="+value)
+	}
+	for _, pattern := range fp.RequireHistory {
+		if !historyContains(history, pattern) {
+			return nil
+		}
+		match.Evidence = append(match.Evidence, "history: "+pattern)
+	}
+	match.Score = len(match.Evidence)
+
+	for _, key := range fp.MarkerEnv {
+		if _, ok := env[key]; ok {
+			match.Evidence = append(match.Evidence, key)
+			match.Score++
+		}
+	}
+	for _, pattern := range append(fp.MarkerHistory, fp.EndHistory...) {
+		if historyContains(history, pattern) {
+			match.Evidence = append(match.Evidence, "history: "+pattern)
+			match.Score++
+		}
+	}
+	return match
+}
+
+// version shortens the runtime version to the precision used by the tags
+func (fp *Fingerprint) version(value string) string {
+	if fp.versionRegex != nil {
+		found := fp.versionRegex.FindStringSubmatch(value)
+		switch {
+		case len(found) > 1:
+			value = found[1]
+		case len(found) == 1:
+			value = found[0]
+		}
+	}
+	value = strings.TrimPrefix(value, "v")
+
+	parts := strings.Split(value, ".")
+	switch fp.Precision {
+	case "major":
+		return parts[0]
+	case "majorminor":
+		if len(parts) > 2 {
+			return parts[0] + "." + parts[1]
+		}
+	}
+	return value
+}
+
+// baseHistoryCount finds the end of the base image in the history. The base
+// image starts before the entry that sets VersionEnv and ends with one of
+// EndHistory, or with the last entry built without a long pause.
+func (fp *Fingerprint) baseHistoryCount(history []docker.History) int {
+	start := -1
+	for i, entry := range history {
+		if fp.VersionEnv != "" && strings.Contains(entry.CreatedBy, fp.VersionEnv+"=") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return 0
+	}
+
+	for i := start; i < len(history); i++ {
+		for _, pattern := range fp.EndHistory {
+			if strings.Contains(history[i].CreatedBy, pattern) {
+				return i + 1
+			}
+		}
+	}
+
+	previous, err := time.Parse(time.RFC3339Nano, history[start].Created)
+	if err != nil {
+		return 0
+	}
+	end := start
+	for i := start + 1; i < len(history); i++ {
+		created, err := time.Parse(time.RFC3339Nano, history[i].Created)
+		if err != nil || created.Sub(previous) > maxBuildGap {
+			break
+		}
+		previous = created
+		end = i
+	}
+	// Without a gap the whole history looks like one build and nothing is collapsed
+	if end == len(history)-1 {
+		return 0
+	}
+	return end + 1
+}
+
+// tag expands the tag template for the variant matching the release
+func (fp *Fingerprint) tag(version string, release *distro.Release, slim bool) string {
+	var variants []string
+	if release != nil {
+		switch {
+		case release.ID == "alpine":
+			variants = append(variants, "alpine")
+		case release.ID == "debian" && release.Codename != "":
+			if slim {
+				variants = append(variants, "debian-slim")
+			}
+			variants = append(variants, "debian")
+		case release.ID == "ubuntu" && release.Codename != "":
+			variants = append(variants, "ubuntu")
+		}
+	}
+	template := fp.Tags["default"]
+	for _, variant := range variants {
+		if tag, ok := fp.Tags[variant]; ok {
+			template = tag
+			break
+		}
+	}
+
+	replacements := []string{"{version}", version}
+	if release != nil {
+		replacements = append(replacements, "{codename}", release.Codename, "{alpine}", release.MajorMinor())
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// isSlim reports whether the base image history uses the apt-mark dance of
+// the slim variants, which remove build dependencies after installing
+func isSlim(history []docker.History) bool {
+	return historyContains(history, "apt-mark showmanual")
+}
+
+func historyContains(history []docker.History, pattern string) bool {
+	for _, entry := range history {
+		if strings.Contains(entry.CreatedBy, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package fingerprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/distro"
+	"github.com/raesene/pasgan/internal/docker"
+)
+
+// nodeImage is an application built on node:18-bookworm-slim
+func nodeImage() *docker.ImageMetadata {
+	return &docker.ImageMetadata{
+		Config: docker.Config{
+			Env: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"NODE_VERSION=18.19.1",
+				"YARN_VERSION=1.22.19",
+				"NODE_ENV=production",
+			},
+		},
+		History: []docker.History{
+			{Created: "2024-02-13T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+			{Created: "2024-02-13T00:00:01Z", CreatedBy: "/bin/sh -c #(nop)  CMD [\"bash\"]", EmptyLayer: true},
+			{Created: "2024-02-14T10:00:00Z", CreatedBy: "/bin/sh -c groupadd --gid 1000 node && useradd --uid 1000 --gid node --shell /bin/bash --create-home node"},
+			{Created: "2024-02-14T10:00:01Z", CreatedBy: "/bin/sh -c #(nop)  ENV NODE_VERSION=18.19.1", EmptyLayer: true},
+			{Created: "2024-02-14T10:05:00Z", CreatedBy: "/bin/sh -c savedAptMark=\"$(apt-mark showmanual)\" && apt-get update && curl -fsSLO https://nodejs.org/dist/v$NODE_VERSION/node-v$NODE_VERSION-linux-x64.tar.xz"},
+			{Created: "2024-02-14T10:05:01Z", CreatedBy: "/bin/sh -c #(nop)  ENV YARN_VERSION=1.22.19", EmptyLayer: true},
+			{Created: "2024-02-14T10:06:00Z", CreatedBy: "/bin/sh -c #(nop) COPY file:def in /usr/local/bin/ "},
+			{Created: "2024-02-14T10:06:01Z", CreatedBy: "/bin/sh -c #(nop)  ENTRYPOINT [\"docker-entrypoint.sh\"]", EmptyLayer: true},
+			{Created: "2024-02-14T10:06:02Z", CreatedBy: "/bin/sh -c #(nop)  CMD [\"node\"]", EmptyLayer: true},
+			{Created: "2024-05-01T09:00:00Z", CreatedBy: "WORKDIR /app", EmptyLayer: true},
+			{Created: "2024-05-01T09:00:01Z", CreatedBy: "COPY . . # buildkit"},
+			{Created: "2024-05-01T09:00:30Z", CreatedBy: "RUN /bin/sh -c npm ci --omit=dev # buildkit"},
+			{Created: "2024-05-01T09:00:31Z", CreatedBy: "CMD [\"node\" \"server.js\"]", EmptyLayer: true},
+		},
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	bookworm := &distro.Release{ID: "debian", Version: "12.5", Codename: "bookworm"}
+	alpine := &distro.Release{ID: "alpine", Version: "3.19.1"}
+
+	testCases := []struct {
+		name      string
+		metadata  func() *docker.ImageMetadata
+		release   *distro.Release
+		wantName  string
+		wantImage string
+		wantCount int
+	}{
+		{
+			name:      "node slim on debian",
+			metadata:  nodeImage,
+			release:   bookworm,
+			wantName:  "node",
+			wantImage: "node:18-bookworm-slim",
+			wantCount: 9,
+		},
+		{
+			name:      "node on alpine",
+			metadata:  nodeImage,
+			release:   alpine,
+			wantName:  "node",
+			wantImage: "node:18-alpine3.19",
+			wantCount: 9,
+		},
+		{
+			name:      "node without a distribution",
+			metadata:  nodeImage,
+			wantName:  "node",
+			wantImage: "node:18",
+			wantCount: 9,
+		},
+		{
+			name: "python full variant ends at the time gap",
+			metadata: func() *docker.ImageMetadata {
+				return &docker.ImageMetadata{
+					Config: docker.Config{Env: []string{"PYTHON_VERSION=3.12.2", "GPG_KEY=7169605F62C751356D054A26A821E680E5FA6305"}},
+					History: []docker.History{
+						{Created: "2024-02-13T00:00:00Z", CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+						{Created: "2024-02-14T10:00:00Z", CreatedBy: "ENV PYTHON_VERSION=3.12.2", EmptyLayer: true},
+						{Created: "2024-02-14T10:20:00Z", CreatedBy: "RUN /bin/sh -c set -eux; ./configure && make install # buildkit"},
+						{Created: "2024-03-01T12:00:00Z", CreatedBy: "COPY app.py /app/ # buildkit"},
+					},
+				}
+			},
+			release:   bookworm,
+			wantName:  "python",
+			wantImage: "python:3.12-bookworm",
+			wantCount: 3,
+		},
+		{
+			name: "php-fpm is preferred over php-cli",
+			metadata: func() *docker.ImageMetadata {
+				return &docker.ImageMetadata{
+					Config: docker.Config{Env: []string{"PHP_VERSION=8.3.4", "PHP_INI_DIR=/usr/local/etc/php"}},
+					History: []docker.History{
+						{Created: "2024-02-14T10:00:00Z", CreatedBy: "ENV PHP_VERSION=8.3.4", EmptyLayer: true},
+						{Created: "2024-02-14T10:00:01Z", CreatedBy: "CMD [\"php-fpm\"]", EmptyLayer: true},
+					},
+				}
+			},
+			release:   alpine,
+			wantName:  "php-fpm",
+			wantImage: "php:8.3-fpm-alpine3.19",
+			wantCount: 2,
+		},
+		{
+			name: "temurin version from the JDK release",
+			metadata: func() *docker.ImageMetadata {
+				return &docker.ImageMetadata{
+					Config: docker.Config{Env: []string{"JAVA_HOME=/opt/java/openjdk", "JAVA_VERSION=jdk-21.0.2+13"}},
+				}
+			},
+			release:   &distro.Release{ID: "ubuntu", Version: "22.04", Codename: "jammy"},
+			wantName:  "eclipse-temurin",
+			wantImage: "eclipse-temurin:21-jdk-jammy",
+		},
+		{
+			name: "no match",
+			metadata: func() *docker.ImageMetadata {
+				return &docker.ImageMetadata{Config: docker.Config{Env: []string{"NODE_ENV=production"}}}
+			},
+			release: bookworm,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			match := DefaultDatabase().Identify(tc.metadata(), tc.release)
+			if tc.wantName == "" {
+				if match != nil {
+					t.Fatalf("Identify() = %+v, want nil", match)
+				}
+				return
+			}
+			if match == nil {
+				t.Fatal("Identify() = nil")
+			}
+			if match.Name != tc.wantName || match.Image != tc.wantImage || match.BaseHistoryCount != tc.wantCount {
+				t.Errorf("Identify() = %s %s (%d base entries), want %s %s (%d base entries)",
+					match.Name, match.Image, match.BaseHistoryCount, tc.wantName, tc.wantImage, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestMergeDatabase(t *testing.T) {
+	extra, err := LoadDatabase(strings.NewReader(`[
+		{"name": "node", "repository": "example/node", "version_env": "NODE_VERSION", "precision": "full",
+		 "require_env": ["NODE_VERSION"], "tags": {"default": "{version}"}},
+		{"name": "bun", "repository": "oven/bun", "version_env": "BUN_VERSION", "precision": "full",
+		 "require_env": ["BUN_INSTALL_BIN"], "tags": {"default": "latest"}}
+	]`))
+	if err != nil {
+		t.Fatalf("LoadDatabase() error = %v", err)
+	}
+
+	database := DefaultDatabase()
+	count := len(database)
+	database.Merge(extra)
+	if len(database) != count+1 {
+		t.Errorf("Merge() gave %d fingerprints, want %d", len(database), count+1)
+	}
+	if match := database.Identify(nodeImage(), nil); match == nil || match.Image != "example/node:18.19.1" {
+		t.Errorf("Identify() = %+v, want the replaced node fingerprint", match)
+	}
+
+	if _, err := LoadDatabase(strings.NewReader(`[{"name": "broken", "repository": "x", "require_env": ["X"], "tags": {}}]`)); err == nil {
+		t.Error("LoadDatabase() accepted a fingerprint without a default tag")
+	}
+}
@@ -0,0 +1,491 @@
+package layer
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/raesene/pasgan/internal/archive"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// maxSymlinkHops bounds symlink resolution, matching the Linux limit on nested links
+const maxSymlinkHops = 40
+
+// Skipped describes an entry, or part of an entry, that could not be applied
+type Skipped struct {
+	Layer  int    `json:"layer"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ApplyResult counts the entries written while applying layers. Entries
+// written by one layer and removed by a later one are included, and Removed
+// counts the paths deleted by whiteouts and opaque directories.
+type ApplyResult struct {
+	Files     int       `json:"files"`
+	Dirs      int       `json:"dirs"`
+	Symlinks  int       `json:"symlinks"`
+	Hardlinks int       `json:"hardlinks"`
+	Devices   int       `json:"devices"`
+	Removed   int       `json:"removed"`
+	Skipped   []Skipped `json:"skipped,omitempty"`
+	// OwnershipPreserved is false when files could not be chowned because the
+	// process is not running as root
+	OwnershipPreserved bool `json:"ownership_preserved"`
+}
+
+// dirAttributes are applied to directories once every layer has been written,
+// so read-only modes and modification times are not disturbed by later entries
+type dirAttributes struct {
+	mode    os.FileMode
+	modTime time.Time
+	access  time.Time
+}
+
+// budget counts the entries and bytes written across layers against limits
+type budget struct {
+	limits  utils.Limits
+	entries int
+	total   int64
+}
+
+// add counts an entry of size bytes, failing with utils.ErrLimitExceeded
+// once a limit is passed
+func (b *budget) add(name string, size int64) error {
+	b.entries++
+	if max := b.limits.Entries(); max >= 0 && b.entries > max {
+		return fmt.Errorf("%w: more than %d entries", utils.ErrLimitExceeded, max)
+	}
+	if max := b.limits.FileSize(); max >= 0 && size > max {
+		return fmt.Errorf("%w: %s is larger than %s", utils.ErrLimitExceeded, name, utils.FormatSize(max))
+	}
+	if max := b.limits.TotalSize(); max >= 0 && b.total+size > max {
+		return fmt.Errorf("%w: more than %s in total", utils.ErrLimitExceeded, utils.FormatSize(max))
+	}
+	b.total += size
+	return nil
+}
+
+// applier writes layers on top of each other into a root directory
+type applier struct {
+	root     *os.Root
+	dir      string
+	result   *ApplyResult
+	dirs     map[string]dirAttributes
+	canChown bool
+	budget   budget
+
+	layer   int
+	written map[string]bool
+}
+
+// Apply extracts count layers in order into dest, producing the image's final
+// root filesystem. Whiteouts and opaque directories remove lower-layer content,
+// and hardlinks, symlinks, devices, modes, ownership (when running as root),
+// extended attributes and modification times are preserved. Symlinks are
+// resolved as if dest were "/", as they are in the container, and every entry
+// is written through an os.Root for dest, so no entry can be written outside
+// it. The entries read and the bytes written across all layers are bounded
+// by limits.
+func Apply(o Opener, count int, dest string, limits utils.Limits) (*ApplyResult, error) {
+	dir, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open destination: %w", err)
+	}
+	defer root.Close()
+
+	a := &applier{
+		root:     root,
+		dir:      dir,
+		result:   &ApplyResult{},
+		dirs:     make(map[string]dirAttributes),
+		canChown: os.Geteuid() == 0,
+		budget:   budget{limits: limits},
+	}
+	a.result.OwnershipPreserved = a.canChown
+
+	for i := 0; i < count; i++ {
+		a.layer = i
+		a.written = make(map[string]bool)
+		if err := WalkLayer(o, i, a.apply); err != nil {
+			return a.result, err
+		}
+	}
+
+	a.finishDirs()
+	return a.result, nil
+}
+
+// skip records an entry that could not be fully applied
+func (a *applier) skip(p, reason string) {
+	a.result.Skipped = append(a.result.Skipped, Skipped{Layer: a.layer, Path: p, Reason: reason})
+}
+
+// rel converts a rootfs path into a path relative to the root directory
+func rel(p string) string {
+	if p = strings.TrimPrefix(path.Clean("/"+p), "/"); p == "" {
+		return "."
+	}
+	return filepath.FromSlash(p)
+}
+
+// hostPath converts a rootfs path into a path under the root directory, for
+// the calls os.Root does not provide. Only paths resolved by resolve and
+// created through the root are passed, so they stay inside it.
+func (a *applier) hostPath(p string) string {
+	return filepath.Join(a.dir, rel(p))
+}
+
+// resolve follows symlinks in p as if the root directory were "/", the way
+// the container sees them, so the result has no symlinks for os.Root to
+// refuse. The last component is only followed when followLast is set. It
+// returns the resolved rootfs path.
+func (a *applier) resolve(p string, followLast bool) (string, error) {
+	current := "/"
+	remaining := strings.Split(strings.TrimPrefix(path.Clean("/"+p), "/"), "/")
+	hops := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+		if len(remaining) == 0 && !followLast {
+			current = next
+			break
+		}
+
+		info, err := a.root.Lstat(rel(next))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		target, err := a.root.Readlink(rel(next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	return current, nil
+}
+
+// apply writes a single layer entry
+func (a *applier) apply(hdr *tar.Header, r io.Reader) error {
+	var size int64
+	if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+		size = hdr.Size
+	}
+	if err := a.budget.add(Clean(hdr.Name), size); err != nil {
+		return err
+	}
+	if target, opaque, ok := ParseWhiteout(hdr.Name); ok {
+		if opaque {
+			return a.clearLower(target)
+		}
+		return a.remove(target)
+	}
+
+	name := Clean(hdr.Name)
+	if name == "/" {
+		return nil
+	}
+	a.written[name] = true
+
+	// Resolve the parent through any symlinks, but never the entry itself
+	parent, err := a.resolve(path.Dir(name), true)
+	if err != nil {
+		a.skip(name, err.Error())
+		return nil
+	}
+	if err := a.mkdirParents(parent); err != nil {
+		a.skip(name, err.Error())
+		return nil
+	}
+	rootPath := path.Join(parent, path.Base(name))
+	target := rel(rootPath)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if info, err := a.root.Lstat(target); err == nil && !info.IsDir() {
+			a.forget(rootPath)
+			a.root.RemoveAll(target)
+		}
+		if err := a.root.MkdirAll(target, 0755); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		a.dirs[rootPath] = dirAttributes{mode: hdr.FileInfo().Mode(), modTime: hdr.ModTime, access: hdr.AccessTime}
+		a.result.Dirs++
+
+	case tar.TypeReg, tar.TypeRegA:
+		if err := a.replace(rootPath); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		file, err := a.root.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		_, copyErr := io.Copy(file, r)
+		closeErr := file.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to write %s: %w", name, copyErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to write %s: %w", name, closeErr)
+		}
+		a.result.Files++
+
+	case tar.TypeSymlink:
+		if err := a.replace(rootPath); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		if err := a.root.Symlink(hdr.Linkname, target); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		a.result.Symlinks++
+		a.setOwner(name, target, hdr)
+		// Modes and times of symlinks are not portable, so stop here
+		return nil
+
+	case tar.TypeLink:
+		source, err := a.resolve(Clean(hdr.Linkname), false)
+		if err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		if err := a.replace(rootPath); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		if err := a.root.Link(rel(source), target); err != nil {
+			a.skip(name, fmt.Sprintf("hardlink to %s: %v", hdr.Linkname, err))
+			return nil
+		}
+		a.result.Hardlinks++
+		// A hardlink shares its inode with the source, so there is nothing more to set
+		return nil
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := a.replace(rootPath); err != nil {
+			a.skip(name, err.Error())
+			return nil
+		}
+		if err := archive.Mknod(a.hostPath(rootPath), hdr); err != nil {
+			a.skip(name, fmt.Sprintf("device or fifo: %v", err))
+			return nil
+		}
+		a.result.Devices++
+
+	default:
+		a.skip(name, fmt.Sprintf("unsupported entry type %q", hdr.Typeflag))
+		return nil
+	}
+
+	a.setOwner(name, target, hdr)
+	a.setXattrs(name, rootPath, hdr)
+
+	if hdr.Typeflag != tar.TypeDir {
+		if err := a.root.Chmod(target, hdr.FileInfo().Mode()); err != nil {
+			a.skip(name, fmt.Sprintf("mode: %v", err))
+		}
+		access := hdr.AccessTime
+		if access.IsZero() {
+			access = hdr.ModTime
+		}
+		if err := a.root.Chtimes(target, access, hdr.ModTime); err != nil {
+			a.skip(name, fmt.Sprintf("times: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// mkdirParents creates the parent directories of an entry that were not in the layer
+func (a *applier) mkdirParents(dir string) error {
+	if info, err := a.root.Lstat(rel(dir)); err == nil && info.IsDir() {
+		return nil
+	}
+	return a.root.MkdirAll(rel(dir), 0755)
+}
+
+// replace removes whatever is at the rootfs path p so a new entry can be
+// written, but keeps existing directories since their lower contents must survive
+func (a *applier) replace(p string) error {
+	target := rel(p)
+	info, err := a.root.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	a.forget(p)
+	if info.IsDir() {
+		return a.root.RemoveAll(target)
+	}
+	return a.root.Remove(target)
+}
+
+// remove deletes a path hidden by a whiteout
+func (a *applier) remove(p string) error {
+	if a.written[p] {
+		// Whiteouts only apply to lower layers
+		return nil
+	}
+	parent, err := a.resolve(path.Dir(p), true)
+	if err != nil {
+		a.skip(p, err.Error())
+		return nil
+	}
+	resolved := path.Join(parent, path.Base(p))
+	if _, err := a.root.Lstat(rel(resolved)); err != nil {
+		return nil
+	}
+	a.forget(resolved)
+	if err := a.root.RemoveAll(rel(resolved)); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", p, err)
+	}
+	a.result.Removed++
+	return nil
+}
+
+// clearLower removes the lower-layer contents of an opaque directory, keeping
+// anything the current layer has already written
+func (a *applier) clearLower(dir string) error {
+	resolved, err := a.resolve(dir, true)
+	if err != nil {
+		a.skip(dir, err.Error())
+		return nil
+	}
+	f, err := a.root.Open(rel(resolved))
+	if err != nil {
+		return nil
+	}
+	entries, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		child := path.Join(dir, entry.Name())
+		if a.written[child] {
+			if entry.IsDir() {
+				if err := a.clearLower(child); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		target := path.Join(resolved, entry.Name())
+		a.forget(target)
+		if err := a.root.RemoveAll(rel(target)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", child, err)
+		}
+		a.result.Removed++
+	}
+	return nil
+}
+
+// setOwner applies the entry's ownership when running as root
+func (a *applier) setOwner(name, target string, hdr *tar.Header) {
+	if !a.canChown {
+		return
+	}
+	if err := a.root.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		a.skip(name, fmt.Sprintf("ownership: %v", err))
+	}
+}
+
+// setXattrs applies extended attributes recorded in PAX headers to the
+// resolved rootfs path p
+func (a *applier) setXattrs(name, p string, hdr *tar.Header) {
+	for _, attr := range archive.Xattrs(hdr) {
+		if err := archive.SetXattr(a.hostPath(p), attr.Name, attr.Value); err != nil {
+			a.skip(name, fmt.Sprintf("xattr %s: %v", attr.Name, err))
+		}
+	}
+}
+
+// forget drops the attributes recorded for the rootfs path p and the
+// directories below it, once a later layer removes or replaces it
+func (a *applier) forget(p string) {
+	prefix := p + "/"
+	for dir := range a.dirs {
+		if dir == p || strings.HasPrefix(dir, prefix) {
+			delete(a.dirs, dir)
+		}
+	}
+}
+
+// finishDirs applies directory modes and times, deepest first
+func (a *applier) finishDirs() {
+	dirs := make([]string, 0, len(a.dirs))
+	for dir := range a.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	for _, dir := range dirs {
+		attrs := a.dirs[dir]
+		target := rel(dir)
+		if info, err := a.root.Lstat(target); err != nil || !info.IsDir() {
+			// Removed or replaced by a later layer
+			continue
+		}
+
+		access := attrs.access
+		if access.IsZero() {
+			access = attrs.modTime
+		}
+		if err := a.root.Chtimes(target, access, attrs.modTime); err != nil {
+			a.skip(dir, fmt.Sprintf("times: %v", err))
+		}
+		if err := a.root.Chmod(target, attrs.mode); err != nil {
+			a.skip(dir, fmt.Sprintf("mode: %v", err))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package layer
+
+import (
+	"archive/tar"
+	"io"
+)
+
+// Changes records what a single layer does to the filesystem
+type Changes struct {
+	// Entries maps cleaned paths to the headers added or modified by the layer
+	Entries map[string]*tar.Header
+	// Whiteouts holds the paths deleted by the layer
+	Whiteouts map[string]bool
+	// Opaque holds the directories whose lower contents the layer hides
+	Opaque map[string]bool
+	// Size is the total size of regular files in the layer
+	Size int64
+}
+
+// Index records the changes made by every layer of an image
+type Index struct {
+	Layers []*Changes
+}
+
+// BuildIndex walks count layers in order and records their changes. If visit
+// is not nil it is called for every non-whiteout entry, so callers can inspect
+// file contents without reading the layers a second time.
+func BuildIndex(o Opener, count int, visit func(layer int, hdr *tar.Header, r io.Reader) error) (*Index, error) {
+	index := &Index{}
+
+	for i := 0; i < count; i++ {
+		changes := &Changes{
+			Entries:   make(map[string]*tar.Header),
+			Whiteouts: make(map[string]bool),
+			Opaque:    make(map[string]bool),
+		}
+
+		err := WalkLayer(o, i, func(hdr *tar.Header, r io.Reader) error {
+			if target, opaque, ok := ParseWhiteout(hdr.Name); ok {
+				if opaque {
+					changes.Opaque[target] = true
+				} else {
+					changes.Whiteouts[target] = true
+				}
+				return nil
+			}
+
+			name := Clean(hdr.Name)
+			if name == "/" {
+				return nil
+			}
+			changes.Entries[name] = hdr
+			if hdr.Typeflag == tar.TypeReg {
+				changes.Size += hdr.Size
+			}
+
+			if visit != nil {
+				return visit(i, hdr, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		index.Layers = append(index.Layers, changes)
+	}
+
+	return index, nil
+}
+
+// Removal describes how a path from one layer was hidden by a later layer
+type Removal struct {
+	// Layer is the index of the layer that hid the path
+	Layer int
+	// Deleted is true for whiteouts and false when the path was overwritten
+	Deleted bool
+}
+
+// Shadowed reports whether the path added in layer is hidden by any later
+// layer, either by a whiteout, an opaque parent directory or an overwrite.
+func (x *Index) Shadowed(p string, layer int) (Removal, bool) {
+	ancestors := Ancestors(p)
+
+	for i := layer + 1; i < len(x.Layers); i++ {
+		changes := x.Layers[i]
+
+		if changes.Whiteouts[p] {
+			return Removal{Layer: i, Deleted: true}, true
+		}
+		for _, dir := range ancestors {
+			if changes.Whiteouts[dir] || changes.Opaque[dir] {
+				return Removal{Layer: i, Deleted: true}, true
+			}
+			// A directory replaced by a file or symlink removes its contents
+			if hdr, ok := changes.Entries[dir]; ok && hdr.Typeflag != tar.TypeDir {
+				return Removal{Layer: i, Deleted: true}, true
+			}
+		}
+		if _, ok := changes.Entries[p]; ok {
+			return Removal{Layer: i, Deleted: false}, true
+		}
+	}
+
+	return Removal{}, false
+}
+
+// Sizes returns the total size of the regular files in each of count layers
+func Sizes(o Opener, count int) ([]int64, error) {
+	sizes := make([]int64, count)
+	for i := 0; i < count; i++ {
+		err := WalkLayer(o, i, func(hdr *tar.Header, r io.Reader) error {
+			if hdr.Typeflag == tar.TypeReg {
+				sizes[i] += hdr.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
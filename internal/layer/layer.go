@@ -0,0 +1,153 @@
+// Package layer reads image layer blobs and tracks how layers change the
+// filesystem, including files removed by whiteouts.
+package layer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// WhiteoutPrefix marks a file deleted from a lower layer
+	WhiteoutPrefix = ".wh."
+	// OpaqueWhiteout marks a directory whose lower-layer contents are hidden
+	OpaqueWhiteout = ".wh..wh..opq"
+)
+
+// Opener provides access to the layers of an image
+type Opener interface {
+	// OpenLayer returns the uncompressed tar stream of the layer at index i
+	OpenLayer(i int) (io.ReadCloser, error)
+}
+
+// Open opens a layer blob on disk, transparently decompressing it
+func Open(blobPath string) (io.ReadCloser, error) {
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open layer: %w", err)
+	}
+
+	return OpenBlob(file)
+}
+
+// OpenBlob transparently decompresses a layer blob read from rc. Closing the
+// returned reader closes rc.
+func OpenBlob(rc io.ReadCloser) (io.ReadCloser, error) {
+	reader, err := Decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &layerReader{Reader: reader, closers: []io.Closer{reader, rc}}, nil
+}
+
+// Decompress detects the compression used by a layer blob and returns a
+// reader for the uncompressed tar stream. Closing the returned reader does
+// not close r.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read layer header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip layer: %w", err)
+		}
+		return gz, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("zstd compressed layers are not supported")
+	}
+
+	return io.NopCloser(buffered), nil
+}
+
+// layerReader closes every underlying reader when it is closed
+type layerReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (l *layerReader) Close() error {
+	var firstErr error
+	for _, closer := range l.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Walk calls fn for every entry of an uncompressed layer tar stream. The
+// reader passed to fn is only valid until fn returns.
+func Walk(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading layer: %w", err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// WalkLayer opens layer i and walks its entries
+func WalkLayer(o Opener, i int, fn func(hdr *tar.Header, r io.Reader) error) error {
+	rc, err := o.OpenLayer(i)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := Walk(rc, fn); err != nil {
+		return fmt.Errorf("layer %d: %w", i, err)
+	}
+	return nil
+}
+
+// Clean normalizes a tar entry name to an absolute, slash separated path
+func Clean(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
+
+// ParseWhiteout reports whether name is a whiteout entry. For a regular
+// whiteout it returns the deleted path; for an opaque whiteout it returns
+// the directory whose lower contents are hidden.
+func ParseWhiteout(name string) (target string, opaque bool, ok bool) {
+	cleaned := Clean(name)
+	dir, base := path.Split(cleaned)
+	dir = path.Clean(dir)
+
+	if base == OpaqueWhiteout {
+		return dir, true, true
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		return path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)), false, true
+	}
+	return "", false, false
+}
+
+// Ancestors returns the parent directories of p, nearest first, excluding "/"
+func Ancestors(p string) []string {
+	var dirs []string
+	for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
@@ -0,0 +1,404 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+func TestParseWhiteout(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantTarget string
+		wantOpaque bool
+		wantOK     bool
+	}{
+		{"etc/.wh.passwd", "/etc/passwd", false, true},
+		{"./var/cache/apt/.wh..wh..opq", "/var/cache/apt", true, true},
+		{".wh.tmp", "/tmp", false, true},
+		{"etc/passwd", "", false, false},
+	}
+
+	for _, tc := range testCases {
+		target, opaque, ok := ParseWhiteout(tc.name)
+		if target != tc.wantTarget || opaque != tc.wantOpaque || ok != tc.wantOK {
+			t.Errorf("ParseWhiteout(%q) = %q, %v, %v; want %q, %v, %v", tc.name, target, opaque, ok, tc.wantTarget, tc.wantOpaque, tc.wantOK)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	data := testimage.Layer(testimage.Reg("hello.txt", "hello"))
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(data)
+	gz.Close()
+
+	for name, blob := range map[string][]byte{"plain": data, "gzip": compressed.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			rc, err := Decompress(bytes.NewReader(blob))
+			if err != nil {
+				t.Fatalf("Decompress() error = %v", err)
+			}
+			defer rc.Close()
+
+			var names []string
+			err = Walk(rc, func(hdr *tar.Header, r io.Reader) error {
+				names = append(names, hdr.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if len(names) != 1 || names[0] != "hello.txt" {
+				t.Errorf("Unexpected entries: %v", names)
+			}
+		})
+	}
+}
+
+func TestIndexShadowed(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Dir("etc/"),
+			testimage.Reg("etc/passwd", "root"),
+			testimage.Reg("etc/hosts", "localhost"),
+			testimage.Reg("var/cache/apt/pkgcache.bin", "cache"),
+			testimage.Reg("opt/app/config", "config"),
+			testimage.Reg("srv/data/file", "data"),
+		),
+		testimage.Layer(
+			testimage.Reg("etc/passwd", "root\napp"),
+			testimage.Whiteout("var/cache/apt"),
+			testimage.Opaque("opt/app"),
+			testimage.Symlink("srv/data", "/data"),
+		),
+	}
+
+	index, err := BuildIndex(layers, len(layers), nil)
+	if err != nil {
+		t.Fatalf("BuildIndex() error = %v", err)
+	}
+
+	testCases := []struct {
+		path        string
+		wantHidden  bool
+		wantDeleted bool
+	}{
+		{"/etc/passwd", true, false},
+		{"/etc/hosts", false, false},
+		{"/var/cache/apt/pkgcache.bin", true, true},
+		{"/opt/app/config", true, true},
+		{"/srv/data/file", true, true},
+	}
+
+	for _, tc := range testCases {
+		removal, hidden := index.Shadowed(tc.path, 0)
+		if hidden != tc.wantHidden {
+			t.Errorf("Shadowed(%s) hidden = %v, want %v", tc.path, hidden, tc.wantHidden)
+			continue
+		}
+		if hidden && (removal.Layer != 1 || removal.Deleted != tc.wantDeleted) {
+			t.Errorf("Shadowed(%s) = %+v, want layer 1 deleted %v", tc.path, removal, tc.wantDeleted)
+		}
+	}
+
+	if index.Layers[0].Size != int64(len("root")+len("localhost")+len("cache")+len("config")+len("data")) {
+		t.Errorf("Unexpected layer size %d", index.Layers[0].Size)
+	}
+}
+
+func TestApply(t *testing.T) {
+	outside := t.TempDir()
+	executable := testimage.Reg("usr/bin/tool", "#!/bin/sh")
+	executable.Mode = 0755
+
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Dir("etc/"),
+			testimage.Reg("etc/passwd", "root"),
+			testimage.Reg("etc/hosts", "localhost"),
+			testimage.Reg("opt/app/old", "old"),
+			testimage.Reg("tmp/secret", "secret"),
+			executable,
+			testimage.Hardlink("usr/bin/tool-link", "usr/bin/tool"),
+			testimage.Symlink("escape", outside),
+			testimage.Symlink("bin", "usr/bin"),
+		),
+		testimage.Layer(
+			testimage.Reg("etc/passwd", "root\napp"),
+			testimage.Whiteout("tmp/secret"),
+			testimage.Reg("opt/app/new", "new"),
+			testimage.Opaque("opt/app"),
+			testimage.Reg("escape/pwned", "pwned"),
+			testimage.Reg("bin/other", "other"),
+		),
+	}
+
+	dest := t.TempDir()
+	result, err := Apply(layers, len(layers), dest, utils.Limits{})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	files := map[string]string{
+		"etc/passwd":        "root\napp",
+		"etc/hosts":         "localhost",
+		"opt/app/new":       "new",
+		"usr/bin/tool-link": "#!/bin/sh",
+		"usr/bin/other":     "other",
+		"opt/app/old":       "",
+		"tmp/secret":        "",
+	}
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s should not exist", name)
+			}
+			continue
+		}
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+
+	// Absolute symlinks resolve inside the root
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+		t.Errorf("Entry was written outside the root")
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, outside, "pwned")); err != nil || string(data) != "pwned" {
+		t.Errorf("Symlinked entry = %q, %v", data, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "usr/bin/tool"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Unexpected tool mode: %v, %v", info, err)
+	}
+	if !info.ModTime().Equal(testimage.Modified) {
+		t.Errorf("Unexpected tool mtime %v", info.ModTime())
+	}
+	linkInfo, err := os.Stat(filepath.Join(dest, "usr/bin/tool-link"))
+	if err != nil || !os.SameFile(info, linkInfo) {
+		t.Errorf("Hardlink does not share the source inode")
+	}
+
+	if result.Hardlinks != 1 || result.Symlinks != 2 || result.Removed != 2 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestApplyDirModesOutsideRoot(t *testing.T) {
+	// A directory that a later layer replaces with a symlink out of the root
+	// must not have its mode or times applied through the link
+	victim := t.TempDir()
+	if err := os.Mkdir(filepath.Join(victim, "nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(victim, 0700); err != nil {
+		t.Fatal(err)
+	}
+	open := testimage.Dir("a")
+	open.Mode = 0777
+	nested := testimage.Dir("b/nested")
+	nested.Mode = 0777
+
+	layers := testimage.Layers{
+		testimage.Layer(open, testimage.Dir("b"), nested, testimage.Dir("c")),
+		testimage.Layer(testimage.Symlink("a", victim), testimage.Whiteout("b")),
+		testimage.Layer(testimage.Symlink("b", victim), testimage.Whiteout("c"), testimage.Symlink("c", filepath.Join(victim, "nested"))),
+	}
+	if _, err := Apply(layers, len(layers), t.TempDir(), utils.Limits{}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	for _, dir := range []string{victim, filepath.Join(victim, "nested")} {
+		info, err := os.Stat(dir)
+		if err != nil || info.Mode().Perm() != 0700 || info.ModTime().Equal(testimage.Modified) {
+			t.Errorf("Apply() changed %s outside the root: %v, %v", dir, info.Mode(), err)
+		}
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(testimage.Dir("data"), testimage.Reg("data/a", strings.Repeat("a", 600))),
+		testimage.Layer(testimage.Reg("data/b", strings.Repeat("b", 600))),
+	}
+	tests := []struct {
+		name   string
+		limits utils.Limits
+	}{
+		{"total size", utils.Limits{MaxTotalSize: 1000}},
+		{"file size", utils.Limits{MaxFileSize: 500}},
+		{"entries", utils.Limits{MaxEntries: 2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Apply(layers, len(layers), t.TempDir(), tc.limits); !errors.Is(err, utils.ErrLimitExceeded) {
+				t.Errorf("Apply() error = %v, want ErrLimitExceeded", err)
+			}
+			if _, err := WriteMerged(layers, len(layers), io.Discard, tc.limits); !errors.Is(err, utils.ErrLimitExceeded) {
+				t.Errorf("WriteMerged() error = %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+
+	unlimited := utils.Limits{MaxTotalSize: -1, MaxFileSize: -1, MaxEntries: -1}
+	if _, err := Apply(layers, len(layers), t.TempDir(), unlimited); err != nil {
+		t.Errorf("Apply() without limits error = %v", err)
+	}
+}
+
+func TestWriteMerged(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Reg("etc/passwd", "root"),
+			testimage.Reg("etc/hosts", "localhost"),
+			testimage.Reg("tmp/secret", "secret"),
+			testimage.Hardlink("tmp/secret-link", "tmp/secret"),
+			testimage.Hardlink("etc/hosts-link", "etc/hosts"),
+		),
+		testimage.Layer(
+			testimage.Reg("etc/passwd", "root\napp"),
+			testimage.Whiteout("tmp"),
+			testimage.File{Name: "etc/attrs", Type: tar.TypeReg, Mode: 0600, UID: 1000, GID: 1000,
+				PAX: map[string]string{"SCHILY.xattr.user.note": "hello"}},
+		),
+	}
+
+	var buf bytes.Buffer
+	result, err := WriteMerged(layers, len(layers), &buf, utils.Limits{})
+	if err != nil {
+		t.Fatalf("WriteMerged() error = %v", err)
+	}
+
+	contents := make(map[string]string)
+	headers := make(map[string]*tar.Header)
+	err = Walk(&buf, func(hdr *tar.Header, r io.Reader) error {
+		data, _ := io.ReadAll(r)
+		contents[hdr.Name] = string(data)
+		headers[hdr.Name] = hdr
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	want := map[string]string{"etc/passwd": "root\napp", "etc/hosts": "localhost", "etc/hosts-link": "", "etc/attrs": ""}
+	if len(contents) != len(want) {
+		t.Errorf("Unexpected entries %v", contents)
+	}
+	for name, body := range want {
+		if got, ok := contents[name]; !ok || got != body {
+			t.Errorf("%s = %q, %v; want %q", name, got, ok, body)
+		}
+	}
+	if hdr := headers["etc/hosts-link"]; hdr == nil || hdr.Linkname != "etc/hosts" {
+		t.Errorf("Unexpected hardlink header %+v", hdr)
+	}
+	if hdr := headers["etc/attrs"]; hdr == nil || hdr.Uid != 1000 || hdr.PAXRecords["SCHILY.xattr.user.note"] != "hello" {
+		t.Errorf("Unexpected attrs header %+v", hdr)
+	}
+	if result.Files != 3 || result.Hardlinks != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestView(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Dir("usr/"),
+			testimage.Dir("usr/lib/"),
+			testimage.Reg("usr/lib/os-release", "ID=debian"),
+			testimage.Symlink("etc/os-release", "../usr/lib/os-release"),
+			testimage.Symlink("lib", "/usr/lib"),
+			testimage.Reg("tmp/cache/a", "a"),
+			testimage.Reg("opt/app/old", "old"),
+		),
+		testimage.Layer(
+			testimage.Whiteout("tmp/cache"),
+			testimage.Opaque("opt/app"),
+			testimage.Reg("opt/app/new", "new"),
+			testimage.Hardlink("opt/app/link", "opt/app/new"),
+		),
+	}
+
+	view, err := NewView(layers, len(layers))
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	for p, want := range map[string]string{
+		"/etc/os-release": "ID=debian",
+		"/lib/os-release": "ID=debian",
+		"/opt/app/link":   "new",
+		"opt/app/new":     "new",
+	} {
+		entry, err := view.Resolve(p)
+		if err != nil {
+			t.Errorf("Resolve(%s) error = %v", p, err)
+			continue
+		}
+		rc, err := view.Open(entry)
+		if err != nil {
+			t.Errorf("Open(%s) error = %v", p, err)
+			continue
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", p, data, want)
+		}
+	}
+
+	for _, p := range []string{"/tmp/cache/a", "/opt/app/old"} {
+		if _, err := view.Resolve(p); err == nil {
+			t.Errorf("%s should be hidden", p)
+		}
+	}
+
+	var names []string
+	for _, entry := range view.List("/", false) {
+		names = append(names, entry.Path)
+	}
+	if want := []string{"/etc", "/lib", "/opt", "/tmp", "/usr"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List(/) = %v, want %v", names, want)
+	}
+
+	var files []string
+	err = view.WalkFiles("/opt", func(entry Entry, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		files = append(files, entry.Path+"="+string(data))
+		return err
+	})
+	// Hardlinks are visited after the regular files, with the target's contents
+	if err != nil || !reflect.DeepEqual(files, []string{"/opt/app/new=new", "/opt/app/link=new"}) {
+		t.Errorf("WalkFiles() = %v, %v", files, err)
+	}
+
+	layerView, err := NewLayerView(layers, 1)
+	if err != nil {
+		t.Fatalf("NewLayerView() error = %v", err)
+	}
+	entry, ok := layerView.Lookup("/tmp/cache")
+	if !ok || !entry.Deleted {
+		t.Errorf("Expected a whiteout entry for /tmp/cache, got %+v", entry)
+	}
+	if entry, err := layerView.Resolve("/tmp/cache"); err != nil || !entry.Deleted || entry.Layer != 1 {
+		t.Errorf("Resolve(/tmp/cache) = %+v, %v, want the whiteout", entry, err)
+	}
+	if _, err := layerView.Resolve("/tmp/cache/a"); err == nil {
+		t.Error("Resolve(/tmp/cache/a) error = nil, want no such file below a whiteout")
+	}
+}
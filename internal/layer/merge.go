@@ -0,0 +1,136 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/archive"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// WriteMerged writes the final filesystem of count layers to w as a single
+// uncompressed tar stream. Entries hidden by later layers are left out, and
+// headers keep their ownership, modes, times and extended attributes. The
+// entries and bytes written are bounded by limits.
+func WriteMerged(o Opener, count int, w io.Writer, limits utils.Limits) (*ApplyResult, error) {
+	index, err := BuildIndex(o, count, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{OwnershipPreserved: true}
+	tw := tar.NewWriter(w)
+	written := make(map[string]bool)
+	b := budget{limits: limits}
+
+	for i := 0; i < count; i++ {
+		err := WalkLayer(o, i, func(hdr *tar.Header, r io.Reader) error {
+			if _, _, ok := ParseWhiteout(hdr.Name); ok {
+				return nil
+			}
+			name := Clean(hdr.Name)
+			if name == "/" {
+				return nil
+			}
+			if _, shadowed := index.Shadowed(name, i); shadowed {
+				return nil
+			}
+
+			out := mergedHeader(hdr, name)
+			if err := b.add(name, out.Size); err != nil {
+				return err
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				target := Clean(hdr.Linkname)
+				// The link target must already be in the output with the same contents
+				if !written[target] {
+					result.Skipped = append(result.Skipped, Skipped{Layer: i, Path: name,
+						Reason: fmt.Sprintf("hardlink target %s is not in the final filesystem", target)})
+					return nil
+				}
+				out.Linkname = strings.TrimPrefix(target, "/")
+			}
+
+			if err := tw.WriteHeader(out); err != nil {
+				return fmt.Errorf("failed to write header for %s: %w", name, err)
+			}
+			if out.Typeflag == tar.TypeReg {
+				if _, err := io.Copy(tw, r); err != nil {
+					return fmt.Errorf("failed to write %s: %w", name, err)
+				}
+			}
+			written[name] = true
+			countEntry(result, out.Typeflag)
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return result, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return result, nil
+}
+
+// mergedHeader copies hdr with a normalized relative name, keeping only the
+// PAX records that describe extended attributes
+func mergedHeader(hdr *tar.Header, name string) *tar.Header {
+	out := &tar.Header{
+		Typeflag:   hdr.Typeflag,
+		Name:       strings.TrimPrefix(name, "/"),
+		Linkname:   hdr.Linkname,
+		Size:       hdr.Size,
+		Mode:       hdr.Mode,
+		Uid:        hdr.Uid,
+		Gid:        hdr.Gid,
+		Uname:      hdr.Uname,
+		Gname:      hdr.Gname,
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+		Devmajor:   hdr.Devmajor,
+		Devminor:   hdr.Devminor,
+	}
+	if out.Typeflag == tar.TypeRegA {
+		out.Typeflag = tar.TypeReg
+	}
+	if out.Typeflag == tar.TypeDir {
+		out.Name += "/"
+	}
+	if out.Typeflag != tar.TypeReg {
+		out.Size = 0
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, archive.XattrPrefix) {
+			if out.PAXRecords == nil {
+				out.PAXRecords = make(map[string]string)
+			}
+			out.PAXRecords[key] = value
+		}
+	}
+	if out.PAXRecords != nil {
+		out.Format = tar.FormatPAX
+	}
+	return out
+}
+
+// countEntry adds an entry of the given type to the result totals
+func countEntry(result *ApplyResult, typeflag byte) {
+	switch typeflag {
+	case tar.TypeDir:
+		result.Dirs++
+	case tar.TypeReg:
+		result.Files++
+	case tar.TypeSymlink:
+		result.Symlinks++
+	case tar.TypeLink:
+		result.Hardlinks++
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		result.Devices++
+	}
+}
@@ -0,0 +1,330 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Entry is a file visible in a View
+type Entry struct {
+	// Path is the cleaned absolute path of the entry
+	Path string
+	// Layer is the index of the layer the entry comes from
+	Layer int
+	// Header is the tar header of the entry. It is nil for directories that
+	// are implied by their contents but have no entry of their own, and Layer
+	// is then the first layer that implied them.
+	Header *tar.Header
+	// Deleted is set for whiteouts in a single layer view
+	Deleted bool
+}
+
+// IsDir reports whether the entry is a directory
+func (e Entry) IsDir() bool {
+	return e.Header == nil || e.Header.Typeflag == tar.TypeDir
+}
+
+// View is a read-only filesystem built from layer headers. It is either the
+// merged view of several layers or the contents of a single layer, and file
+// contents are streamed from the layer blobs on demand.
+type View struct {
+	opener  Opener
+	entries map[string]Entry
+	layers  []int
+}
+
+// NewView returns the merged filesystem of the first count layers, as a
+// container would see it
+func NewView(o Opener, count int) (*View, error) {
+	index, err := BuildIndex(o, count, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &View{opener: o, entries: make(map[string]Entry)}
+	for i, changes := range index.Layers {
+		v.layers = append(v.layers, i)
+
+		// Whiteouts and opaque directories only hide lower layers
+		for target := range changes.Whiteouts {
+			v.removeTree(target, true)
+		}
+		for dir := range changes.Opaque {
+			v.removeTree(dir, false)
+		}
+		for name, hdr := range changes.Entries {
+			if existing, ok := v.entries[name]; ok && existing.IsDir() && hdr.Typeflag != tar.TypeDir {
+				v.removeTree(name, false)
+			}
+			v.entries[name] = Entry{Path: name, Layer: i, Header: hdr}
+			// Parent directories missing from the layer are created on extraction
+			// and stay behind when their contents are deleted
+			for _, dir := range Ancestors(name) {
+				if _, ok := v.entries[dir]; !ok {
+					v.entries[dir] = Entry{Path: dir, Layer: i}
+				}
+			}
+		}
+	}
+	return v, nil
+}
+
+// NewLayerView returns the entries of layer i alone, including its whiteouts
+func NewLayerView(o Opener, i int) (*View, error) {
+	v := &View{opener: o, entries: make(map[string]Entry), layers: []int{i}}
+
+	err := WalkLayer(o, i, func(hdr *tar.Header, r io.Reader) error {
+		if target, opaque, ok := ParseWhiteout(hdr.Name); ok {
+			if !opaque {
+				v.entries[target] = Entry{Path: target, Layer: i, Header: hdr, Deleted: true}
+			}
+			return nil
+		}
+		name := Clean(hdr.Name)
+		if name != "/" {
+			v.entries[name] = Entry{Path: name, Layer: i, Header: hdr}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// removeTree deletes the descendants of p, and p itself if self is set
+func (v *View) removeTree(p string, self bool) {
+	if self {
+		delete(v.entries, p)
+	}
+	prefix := p + "/"
+	for name := range v.entries {
+		if strings.HasPrefix(name, prefix) {
+			delete(v.entries, name)
+		}
+	}
+}
+
+// Lookup returns the entry at p without following symlinks
+func (v *View) Lookup(p string) (Entry, bool) {
+	p = Clean(p)
+	if p == "/" {
+		return Entry{Path: "/", Layer: -1}, true
+	}
+	if entry, ok := v.entries[p]; ok {
+		return entry, true
+	}
+	// Directories may only be implied by their contents
+	prefix := p + "/"
+	for name := range v.entries {
+		if strings.HasPrefix(name, prefix) {
+			return Entry{Path: p, Layer: -1}, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Resolve returns the entry at p, following symlinks in every component the
+// way the kernel would inside the container. In a single layer view, the
+// whiteout of p is returned as a Deleted entry.
+func (v *View) Resolve(p string) (Entry, error) {
+	current := "/"
+	remaining := strings.Split(strings.TrimPrefix(Clean(p), "/"), "/")
+	hops := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+		entry, ok := v.Lookup(next)
+		if ok && entry.Deleted && len(remaining) == 0 {
+			// The whiteout itself, so callers can tell which layer deleted p
+			return entry, nil
+		}
+		if !ok || entry.Deleted {
+			return Entry{}, fmt.Errorf("%s: no such file or directory", p)
+		}
+		if entry.Header == nil || entry.Header.Typeflag != tar.TypeSymlink {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return Entry{}, fmt.Errorf("%s: too many levels of symbolic links", p)
+		}
+		if path.IsAbs(entry.Header.Linkname) {
+			current = "/"
+		}
+		remaining = append(strings.Split(entry.Header.Linkname, "/"), remaining...)
+	}
+
+	entry, _ := v.Lookup(current)
+	return entry, nil
+}
+
+// List returns the entries inside dir sorted by path. Only direct children
+// are returned unless recursive is set.
+func (v *View) List(dir string, recursive bool) []Entry {
+	dir = Clean(dir)
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+
+	found := make(map[string]Entry)
+	for name, entry := range v.entries {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if recursive {
+			found[name] = entry
+			for _, parent := range Ancestors(name) {
+				if strings.HasPrefix(parent, prefix) {
+					if _, ok := found[parent]; !ok {
+						found[parent], _ = v.Lookup(parent)
+					}
+				}
+			}
+			continue
+		}
+
+		rest := strings.TrimPrefix(name, prefix)
+		if child, _, nested := strings.Cut(rest, "/"); nested {
+			childPath := prefix + child
+			if _, ok := found[childPath]; !ok {
+				found[childPath], _ = v.Lookup(childPath)
+			}
+			continue
+		}
+		found[name] = entry
+	}
+
+	entries := make([]Entry, 0, len(found))
+	for _, entry := range found {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// Open returns the contents of a regular file or hardlink entry
+func (v *View) Open(entry Entry) (io.ReadCloser, error) {
+	if entry.Deleted || entry.Header == nil {
+		return nil, fmt.Errorf("%s: not a regular file", entry.Path)
+	}
+	switch entry.Header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+	case tar.TypeLink:
+		target, ok := v.Lookup(entry.Header.Linkname)
+		if !ok || target.Header == nil || target.Header.Typeflag == tar.TypeLink {
+			return nil, fmt.Errorf("%s: hardlink target %s not found", entry.Path, entry.Header.Linkname)
+		}
+		return v.Open(target)
+	default:
+		return nil, fmt.Errorf("%s: not a regular file", entry.Path)
+	}
+
+	rc, err := v.opener.OpenLayer(entry.Layer)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("layer %d: error reading layer: %w", entry.Layer, err)
+		}
+		if Clean(hdr.Name) == entry.Path && hdr.Typeflag == entry.Header.Typeflag {
+			return &layerReader{Reader: tr, closers: []io.Closer{rc}}, nil
+		}
+	}
+	rc.Close()
+	return nil, fmt.Errorf("%s: not found in layer %d", entry.Path, entry.Layer)
+}
+
+// WalkFiles calls fn with the contents of every regular file in the view
+// under dir, reading each layer blob once. Files are visited in layer order,
+// then hardlinks are visited with the contents of their targets.
+func (v *View) WalkFiles(dir string, fn func(entry Entry, r io.Reader) error) error {
+	dir = Clean(dir)
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+
+	for _, i := range v.layers {
+		visited := make(map[string]bool)
+		err := WalkLayer(v.opener, i, func(hdr *tar.Header, r io.Reader) error {
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				return nil
+			}
+			name := Clean(hdr.Name)
+			if visited[name] || (name != dir && !strings.HasPrefix(name, prefix)) {
+				return nil
+			}
+			entry, ok := v.entries[name]
+			if !ok || entry.Layer != i || entry.Deleted {
+				return nil
+			}
+			visited[name] = true
+			return fn(entry, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var links []Entry
+	for name, entry := range v.entries {
+		if entry.Deleted || entry.Header == nil || entry.Header.Typeflag != tar.TypeLink {
+			continue
+		}
+		if name == dir || strings.HasPrefix(name, prefix) {
+			links = append(links, entry)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Path < links[j].Path
+	})
+	for _, entry := range links {
+		if err := v.walkLink(entry, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLink calls fn with the contents of the target of a hardlink. Links
+// whose target is missing or not a regular file are skipped.
+func (v *View) walkLink(entry Entry, fn func(entry Entry, r io.Reader) error) error {
+	target, ok := v.Lookup(entry.Header.Linkname)
+	if !ok || target.Header == nil || (target.Header.Typeflag != tar.TypeReg && target.Header.Typeflag != tar.TypeRegA) {
+		return nil
+	}
+	rc, err := v.Open(entry)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return fn(entry, rc)
+}
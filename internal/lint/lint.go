@@ -0,0 +1,133 @@
+// Package lint checks reconstructed Dockerfiles against best-practice rules.
+// Unlike a plain Dockerfile linter it also has the image config and layer
+// sizes, so rules can reason about what the image really contains.
+package lint
+
+import (
+	"sort"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+)
+
+// Level is the severity of a lint finding
+type Level string
+
+// Supported levels, matching the SARIF result levels
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelInfo    Level = "note"
+)
+
+// rank returns a sortable weight for the level
+func (l Level) rank() int {
+	switch l {
+	case LevelError:
+		return 3
+	case LevelWarning:
+		return 2
+	case LevelInfo:
+		return 1
+	}
+	return 0
+}
+
+// AtLeast reports whether l is as serious as other or more
+func (l Level) AtLeast(other Level) bool {
+	return l.rank() >= other.rank()
+}
+
+// ParseLevel converts a user supplied level name into a Level
+func ParseLevel(name string) (Level, bool) {
+	switch name {
+	case "error":
+		return LevelError, true
+	case "warning":
+		return LevelWarning, true
+	case "info", "note":
+		return LevelInfo, true
+	}
+	return "", false
+}
+
+// Context holds everything a rule can inspect
+type Context struct {
+	Metadata     *docker.ImageMetadata
+	Instructions []dockerfile.Instruction
+	// LayerSizes holds the uncompressed size of each layer, or nil if unknown
+	LayerSizes []int64
+
+	layerByHistory map[int]int
+}
+
+// NewContext prepares a lint context for an image
+func NewContext(metadata *docker.ImageMetadata, instructions []dockerfile.Instruction, layerSizes []int64) *Context {
+	ctx := &Context{
+		Metadata:       metadata,
+		Instructions:   instructions,
+		LayerSizes:     layerSizes,
+		layerByHistory: make(map[int]int),
+	}
+	for layer, historyIndex := range metadata.LayerHistoryIndexes() {
+		if historyIndex >= 0 {
+			ctx.layerByHistory[historyIndex] = layer
+		}
+	}
+	return ctx
+}
+
+// LayerSize returns the size of the layer created by an instruction
+func (c *Context) LayerSize(instruction dockerfile.Instruction) (int64, bool) {
+	layer, ok := c.layerByHistory[instruction.HistoryIndex]
+	if !ok || layer >= len(c.LayerSizes) {
+		return 0, false
+	}
+	return c.LayerSizes[layer], true
+}
+
+// Rule is a single lint check
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Level       Level
+	// Check returns findings for the rule; ID and Level are filled in by Run
+	Check func(ctx *Context) []Finding
+}
+
+// Finding is a single rule violation
+type Finding struct {
+	RuleID  string `json:"rule_id"`
+	Level   Level  `json:"level"`
+	Message string `json:"message"`
+	// Instruction is the index into Context.Instructions, or -1 for image-wide findings
+	Instruction int `json:"instruction"`
+	// Line is the line of the generated Dockerfile, or 0 for image-wide findings
+	Line int `json:"line,omitempty"`
+}
+
+// Run applies rules to the context and returns findings ordered by line
+func Run(ctx *Context, rules []Rule) []Finding {
+	lines := dockerfile.LineNumbers(ctx.Instructions)
+
+	var findings []Finding
+	for _, rule := range rules {
+		for _, finding := range rule.Check(ctx) {
+			finding.RuleID = rule.ID
+			if finding.Level == "" {
+				finding.Level = rule.Level
+			}
+			if finding.Instruction >= 0 && finding.Instruction < len(lines) {
+				finding.Line = lines[finding.Instruction]
+			}
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+
+	return findings
+}
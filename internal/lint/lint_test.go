@@ -0,0 +1,102 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+)
+
+func lintImage(metadata *docker.ImageMetadata, sizes []int64) ([]Finding, []dockerfile.Instruction) {
+	instructions := dockerfile.NewGenerator(metadata).Instructions()
+	return Run(NewContext(metadata, instructions, sizes), DefaultRules()), instructions
+}
+
+func ruleIDs(findings []Finding) map[string]int {
+	ids := make(map[string]int)
+	for _, finding := range findings {
+		ids[finding.RuleID]++
+	}
+	return ids
+}
+
+func TestRulesReportProblems(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"a", "b", "c"},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "FROM ubuntu:latest"},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "RUN /bin/sh -c apt-get update && apt-get install -y curl git # buildkit"},
+			{Created: "2024-01-01T00:00:02Z", CreatedBy: "RUN /bin/sh -c curl -fsSL https://example.com/install.sh | sh && sudo apk add jq # buildkit"},
+			{Created: "2024-01-01T00:00:03Z", CreatedBy: "ADD https://example.com/tool.tgz /opt/ # buildkit"},
+			{Created: "2024-01-01T00:00:04Z", CreatedBy: "ENV API_TOKEN=abcdef123456", EmptyLayer: true},
+		},
+	}
+
+	findings, _ := lintImage(metadata, []int64{1024, LargeLayerSize + 1, 10, 10})
+	ids := ruleIDs(findings)
+
+	for _, id := range []string{"PG001", "PG002", "PG003", "PG004", "PG005", "PG006", "PG007", "PG008", "PG009", "PG010", "PG011"} {
+		if ids[id] == 0 {
+			t.Errorf("Expected a %s finding, got %+v", id, findings)
+		}
+	}
+
+	for _, finding := range findings {
+		if finding.RuleID == "PG003" && finding.Line != dockerfile.HeaderLines+2 {
+			t.Errorf("Expected PG003 on line %d, got %d", dockerfile.HeaderLines+2, finding.Line)
+		}
+		if finding.RuleID == "PG008" && bytes.Contains([]byte(finding.Message), []byte("abcdef123456")) {
+			t.Errorf("Secret leaked in message: %s", finding.Message)
+		}
+	}
+}
+
+func TestRulesAcceptGoodPractice(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		Layers: []string{"a", "b"},
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "FROM debian:12.5-slim"},
+			{Created: "2024-01-01T00:00:01Z", CreatedBy: "RUN /bin/sh -c apt-get update && apt-get install -y --no-install-recommends curl=7.88.1-10 && rm -rf /var/lib/apt/lists/* # buildkit"},
+			{Created: "2024-01-01T00:00:02Z", CreatedBy: "USER app", EmptyLayer: true},
+		},
+		Config: docker.Config{User: "app"},
+	}
+
+	findings, _ := lintImage(metadata, []int64{1024, 2048})
+	if len(findings) != 0 {
+		t.Errorf("Expected no findings, got %+v", findings)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	metadata := &docker.ImageMetadata{
+		History: []docker.History{
+			{Created: "2024-01-01T00:00:00Z", CreatedBy: "FROM alpine"},
+		},
+	}
+	findings, _ := lintImage(metadata, nil)
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, "Dockerfile", "test", DefaultRules(), findings); err != nil {
+		t.Fatalf("WriteSARIF() error = %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("Invalid SARIF JSON: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Unexpected SARIF log: %s", buf.String())
+	}
+	run := log.Runs[0]
+	if len(run.Results) != len(findings) || len(run.Tool.Driver.Rules) != len(DefaultRules()) {
+		t.Errorf("Unexpected SARIF contents: %s", buf.String())
+	}
+	for _, result := range run.Results {
+		if result.Locations[0].PhysicalLocation.Region.StartLine < 1 {
+			t.Errorf("Invalid start line in %+v", result)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/raesene/pasgan/internal/dockerfile"
+)
+
+// WriteText writes findings in a compact, human readable form
+func WriteText(w io.Writer, artifact string, findings []Finding, instructions []dockerfile.Instruction) error {
+	if len(findings) == 0 {
+		_, err := fmt.Fprintln(w, "No problems found")
+		return err
+	}
+
+	for _, finding := range findings {
+		location := artifact
+		if finding.Line > 0 {
+			location = fmt.Sprintf("%s:%d", artifact, finding.Line)
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s: %s\n", location, finding.RuleID, finding.Level, finding.Message); err != nil {
+			return err
+		}
+		if finding.Instruction >= 0 && finding.Instruction < len(instructions) {
+			preview := instructionText(instructions[finding.Instruction])
+			if len(preview) > 100 {
+				preview = preview[:97] + "..."
+			}
+			if _, err := fmt.Fprintf(w, "  %s\n", preview); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SARIF 2.1.0 structures, limited to the fields pasgan produces
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level Level `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     Level           `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// WriteSARIF writes findings as a SARIF 2.1.0 log so they can be uploaded to
+// code scanning. artifact is the path of the generated Dockerfile.
+func WriteSARIF(w io.Writer, artifact, version string, rules []Rule, findings []Finding) error {
+	driver := sarifDriver{
+		Name:           "pasgan",
+		Version:        version,
+		InformationURI: "https://github.com/raesene/pasgan",
+		Rules:          []sarifRule{},
+	}
+	ruleIndex := make(map[string]int)
+	for i, rule := range rules {
+		ruleIndex[rule.ID] = i
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.ID,
+			Name:                 rule.Name,
+			ShortDescription:     sarifMessage{Text: rule.Description},
+			DefaultConfiguration: sarifConfiguration{Level: rule.Level},
+		})
+	}
+
+	results := []sarifResult{}
+	for _, finding := range findings {
+		location := sarifLocation{
+			PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: artifact},
+			},
+		}
+		// SARIF regions must start at line 1, so image-wide findings point at the first line
+		line := finding.Line
+		if line == 0 {
+			line = 1
+		}
+		location.PhysicalLocation.Region = &sarifRegion{StartLine: line}
+
+		results = append(results, sarifResult{
+			RuleID:    finding.RuleID,
+			RuleIndex: ruleIndex[finding.RuleID],
+			Level:     finding.Level,
+			Message:   sarifMessage{Text: finding.Message},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: driver},
+			Results: results,
+		}},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(log); err != nil {
+		return fmt.Errorf("failed to encode SARIF: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/raesene/pasgan/pkg/ast"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// LargeLayerSize is the layer size above which the large-layer rule reports
+const LargeLayerSize = 250 * 1024 * 1024
+
+var (
+	aptInstallRegex    = regexp.MustCompile(`\bapt(-get)?\s+(-\S+\s+)*install\b`)
+	aptListsCleanRegex = regexp.MustCompile(`rm\s+(-\S+\s+)*/var/lib/apt/lists`)
+	apkAddRegex        = regexp.MustCompile(`\bapk\s+(-\S+\s+)*add\b`)
+	pipInstallRegex    = regexp.MustCompile(`\bpip3?\s+(-\S+\s+)*install\b`)
+	pipeToShellRegex   = regexp.MustCompile(`\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(ba|z|da|k)?sh\b`)
+	sudoRegex          = regexp.MustCompile(`(^|[;&|]\s*)sudo\s`)
+	urlRegex           = regexp.MustCompile(`^https?://`)
+	commandSeparator   = regexp.MustCompile(`&&|\|\||;|\|`)
+)
+
+// DefaultRules returns the built-in rule set
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			ID:          "PG001",
+			Name:        "root-user",
+			Description: "The image runs as root; add a USER instruction with an unprivileged user",
+			Level:       LevelWarning,
+			Check:       checkRootUser,
+		},
+		{
+			ID:          "PG002",
+			Name:        "apt-no-install-recommends",
+			Description: "apt-get install without --no-install-recommends pulls in unneeded packages",
+			Level:       LevelInfo,
+			Check:       checkAptRecommends,
+		},
+		{
+			ID:          "PG003",
+			Name:        "apt-lists-not-removed",
+			Description: "apt package lists are not removed in the same RUN instruction",
+			Level:       LevelWarning,
+			Check:       checkAptLists,
+		},
+		{
+			ID:          "PG004",
+			Name:        "add-url",
+			Description: "ADD of a remote URL; use curl or wget in a RUN so the download can be verified",
+			Level:       LevelWarning,
+			Check:       checkAddURL,
+		},
+		{
+			ID:          "PG005",
+			Name:        "latest-base-tag",
+			Description: "The base image uses the latest tag or no tag at all",
+			Level:       LevelWarning,
+			Check:       checkLatestTag,
+		},
+		{
+			ID:          "PG006",
+			Name:        "pipe-to-shell",
+			Description: "A downloaded script is piped straight into a shell",
+			Level:       LevelError,
+			Check:       checkPipeToShell,
+		},
+		{
+			ID:          "PG007",
+			Name:        "unpinned-packages",
+			Description: "Packages are installed without pinned versions",
+			Level:       LevelInfo,
+			Check:       checkUnpinnedPackages,
+		},
+		{
+			ID:          "PG008",
+			Name:        "secret-in-build",
+			Description: "A secret is stored in the image config or build history",
+			Level:       LevelError,
+			Check:       checkSecrets,
+		},
+		{
+			ID:          "PG009",
+			Name:        "apk-cache",
+			Description: "apk add without --no-cache leaves the package index in the layer",
+			Level:       LevelInfo,
+			Check:       checkApkCache,
+		},
+		{
+			ID:          "PG010",
+			Name:        "sudo",
+			Description: "sudo is used in a RUN instruction, which already runs with the build user's privileges",
+			Level:       LevelWarning,
+			Check:       checkSudo,
+		},
+		{
+			ID:          "PG011",
+			Name:        "large-layer",
+			Description: "An instruction created a very large layer",
+			Level:       LevelInfo,
+			Check:       checkLargeLayer,
+		},
+	}
+}
+
+// runCommands returns the index and flattened command of every RUN instruction
+func runCommands(ctx *Context) map[int]string {
+	commands := make(map[int]string)
+	for i, instruction := range ctx.Instructions {
+		run, ok := instruction.Node.(*ast.Run)
+		if !ok {
+			continue
+		}
+		command := run.Command.Shell
+		if run.Command.IsExec() {
+			command = strings.Join(run.Command.Exec, " ")
+		}
+		commands[i] = strings.Join(strings.Fields(command), " ")
+	}
+	return commands
+}
+
+// eachRun calls fn for RUN instructions in order
+func eachRun(ctx *Context, fn func(i int, command string)) {
+	commands := runCommands(ctx)
+	for i := range ctx.Instructions {
+		if command, ok := commands[i]; ok {
+			fn(i, command)
+		}
+	}
+}
+
+// subcommands splits a shell command on &&, ||, ; and |
+func subcommands(command string) []string {
+	var parts []string
+	for _, part := range commandSeparator.Split(command, -1) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func checkRootUser(ctx *Context) []Finding {
+	user := strings.TrimSpace(ctx.Metadata.Config.User)
+	if user != "" && user != "root" && user != "0" && !strings.HasPrefix(user, "root:") && !strings.HasPrefix(user, "0:") {
+		return nil
+	}
+
+	// Point at the last USER instruction if there is one
+	index := -1
+	for i, instruction := range ctx.Instructions {
+		if _, ok := instruction.Node.(*ast.User); ok {
+			index = i
+		}
+	}
+
+	message := "No USER is set, so containers run as root"
+	if user != "" {
+		message = fmt.Sprintf("The image runs as %q", user)
+	}
+	return []Finding{{Message: message, Instruction: index}}
+}
+
+func checkAptRecommends(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		for _, part := range subcommands(command) {
+			if aptInstallRegex.MatchString(part) && !strings.Contains(part, "--no-install-recommends") {
+				findings = append(findings, Finding{
+					Message:     "apt-get install without --no-install-recommends",
+					Instruction: i,
+				})
+				return
+			}
+		}
+	})
+	return findings
+}
+
+func checkAptLists(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		if !aptInstallRegex.MatchString(command) || aptListsCleanRegex.MatchString(command) {
+			return
+		}
+		message := "apt-get install without removing /var/lib/apt/lists/*"
+		if size, ok := ctx.LayerSize(ctx.Instructions[i]); ok {
+			message += fmt.Sprintf(" (layer is %s)", utils.FormatSize(size))
+		}
+		findings = append(findings, Finding{Message: message, Instruction: i})
+	})
+	return findings
+}
+
+func checkAddURL(ctx *Context) []Finding {
+	var findings []Finding
+	for i, instruction := range ctx.Instructions {
+		add, ok := instruction.Node.(*ast.Add)
+		if !ok {
+			continue
+		}
+		for _, field := range add.Sources {
+			if urlRegex.MatchString(field) {
+				findings = append(findings, Finding{
+					Message:     fmt.Sprintf("ADD downloads %s", field),
+					Instruction: i,
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+func checkLatestTag(ctx *Context) []Finding {
+	for i, instruction := range ctx.Instructions {
+		from, ok := instruction.Node.(*ast.From)
+		if !ok {
+			continue
+		}
+		image := from.Image
+		if image == "scratch" || strings.Contains(image, "@") || strings.HasPrefix(image, "$") {
+			return nil
+		}
+
+		// A tag is the part after the last colon that is not part of a registry port
+		name := image[strings.LastIndex(image, "/")+1:]
+		tag := ""
+		if idx := strings.LastIndex(name, ":"); idx >= 0 {
+			tag = name[idx+1:]
+		}
+		if tag == "" || tag == "latest" {
+			return []Finding{{
+				Message:     fmt.Sprintf("Base image %s is not pinned to a specific tag", image),
+				Instruction: i,
+			}}
+		}
+		// Only the first FROM is the base image
+		return nil
+	}
+	return nil
+}
+
+func checkPipeToShell(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		if pipeToShellRegex.MatchString(command) {
+			findings = append(findings, Finding{
+				Message:     "Remote script piped into a shell without verification",
+				Instruction: i,
+			})
+		}
+	})
+	return findings
+}
+
+func checkUnpinnedPackages(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		var unpinned []string
+		for _, part := range subcommands(command) {
+			switch {
+			case aptInstallRegex.MatchString(part):
+				unpinned = append(unpinned, unpinnedArgs(aptInstallRegex, part, "=")...)
+			case apkAddRegex.MatchString(part):
+				unpinned = append(unpinned, unpinnedArgs(apkAddRegex, part, "=")...)
+			case pipInstallRegex.MatchString(part):
+				unpinned = append(unpinned, unpinnedArgs(pipInstallRegex, part, "==")...)
+			}
+		}
+		if len(unpinned) > 0 {
+			findings = append(findings, Finding{
+				Message:     fmt.Sprintf("Unpinned packages: %s", strings.Join(unpinned, ", ")),
+				Instruction: i,
+			})
+		}
+	})
+	return findings
+}
+
+// valueFlags are package manager flags whose next argument is not a package
+var valueFlags = map[string]bool{
+	"-r": true, "--requirement": true, "-c": true, "--constraint": true,
+	"-e": true, "--editable": true, "-t": true, "--target": true,
+	"-i": true, "--index-url": true, "--extra-index-url": true,
+	"-X": true, "--repository": true, "--virtual": true, "-o": true,
+}
+
+// unpinnedArgs returns the package arguments after the install command that lack a version
+func unpinnedArgs(installRegex *regexp.Regexp, command, separator string) []string {
+	loc := installRegex.FindStringIndex(command)
+	var unpinned []string
+	skipNext := false
+	for _, arg := range strings.Fields(command[loc[1]:]) {
+		if skipNext {
+			skipNext = false
+			continue
+		}
+		if valueFlags[arg] {
+			skipNext = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "$") || strings.ContainsAny(arg, "/<>") {
+			continue
+		}
+		if !strings.Contains(arg, separator) {
+			unpinned = append(unpinned, arg)
+		}
+	}
+	return unpinned
+}
+
+func checkSecrets(ctx *Context) []Finding {
+	// Map history entries to the instructions generated from them
+	byHistory := make(map[int]int)
+	for i, instruction := range ctx.Instructions {
+		if _, ok := byHistory[instruction.HistoryIndex]; !ok && instruction.HistoryIndex >= 0 {
+			byHistory[instruction.HistoryIndex] = i
+		}
+	}
+
+	var findings []Finding
+	for _, secret := range secrets.NewScanner().Scan(ctx.Metadata) {
+		index := -1
+		if secret.HistoryIndex >= 0 {
+			if i, ok := byHistory[secret.HistoryIndex]; ok {
+				index = i
+			}
+		}
+		findings = append(findings, Finding{
+			Message:     fmt.Sprintf("%s in %s: %s", secret.Description, secret.Source, secret.Context),
+			Instruction: index,
+		})
+	}
+	return findings
+}
+
+func checkApkCache(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		for _, part := range subcommands(command) {
+			if apkAddRegex.MatchString(part) && !strings.Contains(part, "--no-cache") && !strings.Contains(command, "/var/cache/apk") {
+				findings = append(findings, Finding{
+					Message:     "apk add without --no-cache",
+					Instruction: i,
+				})
+				return
+			}
+		}
+	})
+	return findings
+}
+
+func checkSudo(ctx *Context) []Finding {
+	var findings []Finding
+	eachRun(ctx, func(i int, command string) {
+		if sudoRegex.MatchString(command) {
+			findings = append(findings, Finding{
+				Message:     "sudo used in RUN",
+				Instruction: i,
+			})
+		}
+	})
+	return findings
+}
+
+func checkLargeLayer(ctx *Context) []Finding {
+	var findings []Finding
+	for i, instruction := range ctx.Instructions {
+		if size, ok := ctx.LayerSize(instruction); ok && size >= LargeLayerSize {
+			findings = append(findings, Finding{
+				Message:     fmt.Sprintf("%s created a %s layer", instruction.Node.Keyword(), utils.FormatSize(size)),
+				Instruction: i,
+			})
+		}
+	}
+	return findings
+}
+
+// instructionText returns a single-line preview of an instruction
+func instructionText(instruction dockerfile.Instruction) string {
+	text := strings.ReplaceAll(dockerfile.FormatInstruction(instruction), "\\\n", " ")
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,188 @@
+package packages
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ChangeType describes how a package changed between two images
+type ChangeType string
+
+// Supported change types, in the order they are reported
+const (
+	Added      ChangeType = "added"
+	Removed    ChangeType = "removed"
+	Upgraded   ChangeType = "upgraded"
+	Downgraded ChangeType = "downgraded"
+)
+
+// Change is a single package difference between two inventories
+type Change struct {
+	Type       ChangeType `json:"type"`
+	Ecosystem  string     `json:"ecosystem"`
+	Name       string     `json:"name"`
+	Location   string     `json:"location,omitempty"`
+	OldVersion string     `json:"old_version,omitempty"`
+	NewVersion string     `json:"new_version,omitempty"`
+	// OldLayer and NewLayer are the layers that installed each version, or -1
+	OldLayer int `json:"old_layer"`
+	NewLayer int `json:"new_layer"`
+}
+
+// Changelog lists the packages added, removed, upgraded and downgraded between two inventories
+func Changelog(old, new *Inventory) []Change {
+	oldByKey := make(map[string]Package)
+	for _, pkg := range old.Packages {
+		oldByKey[pkg.Key()] = pkg
+	}
+	newByKey := make(map[string]Package)
+	for _, pkg := range new.Packages {
+		newByKey[pkg.Key()] = pkg
+	}
+
+	var changes []Change
+	for key, newPkg := range newByKey {
+		oldPkg, ok := oldByKey[key]
+		if !ok {
+			changes = append(changes, Change{
+				Type: Added, Ecosystem: newPkg.Ecosystem, Name: newPkg.Name, Location: newPkg.Location,
+				NewVersion: newPkg.Version, OldLayer: -1, NewLayer: newPkg.Layer,
+			})
+			continue
+		}
+
+		result := CompareVersions(newPkg.Ecosystem, oldPkg.Version, newPkg.Version)
+		if result == 0 {
+			continue
+		}
+		change := Change{
+			Type: Upgraded, Ecosystem: newPkg.Ecosystem, Name: newPkg.Name, Location: newPkg.Location,
+			OldVersion: oldPkg.Version, NewVersion: newPkg.Version, OldLayer: oldPkg.Layer, NewLayer: newPkg.Layer,
+		}
+		if result > 0 {
+			change.Type = Downgraded
+		}
+		changes = append(changes, change)
+	}
+	for key, oldPkg := range oldByKey {
+		if _, ok := newByKey[key]; !ok {
+			changes = append(changes, Change{
+				Type: Removed, Ecosystem: oldPkg.Ecosystem, Name: oldPkg.Name, Location: oldPkg.Location,
+				OldVersion: oldPkg.Version, OldLayer: oldPkg.Layer, NewLayer: -1,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Type != b.Type {
+			return typeOrder(a.Type) < typeOrder(b.Type)
+		}
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Location < b.Location
+	})
+
+	return changes
+}
+
+func typeOrder(t ChangeType) int {
+	switch t {
+	case Upgraded:
+		return 0
+	case Downgraded:
+		return 1
+	case Added:
+		return 2
+	}
+	return 3
+}
+
+// LayerDescriber returns a short description of a layer, such as the
+// instruction that created it
+type LayerDescriber func(layer int) string
+
+// WriteMarkdown writes a changelog suitable for release notes
+func WriteMarkdown(w io.Writer, title string, changes []Change, oldLayer, newLayer LayerDescriber) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## %s\n\n", title)
+	if len(changes) == 0 {
+		b.WriteString("No package changes.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	counts := make(map[ChangeType]int)
+	for _, change := range changes {
+		counts[change.Type]++
+	}
+	fmt.Fprintf(&b, "%d upgraded, %d downgraded, %d added, %d removed.\n",
+		counts[Upgraded], counts[Downgraded], counts[Added], counts[Removed])
+
+	sections := []struct {
+		changeType ChangeType
+		title      string
+	}{
+		{Upgraded, "Upgraded"},
+		{Downgraded, "Downgraded"},
+		{Added, "Added"},
+		{Removed, "Removed"},
+	}
+	for _, section := range sections {
+		if counts[section.changeType] == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s (%d)\n\n", section.title, counts[section.changeType])
+		b.WriteString("| Package | Ecosystem | Old version | New version | Layer |\n")
+		b.WriteString("|---|---|---|---|---|\n")
+		for _, change := range changes {
+			if change.Type != section.changeType {
+				continue
+			}
+			name := change.Name
+			if change.Location != "" {
+				name = fmt.Sprintf("%s (%s)", change.Name, change.Location)
+			}
+			// Removed packages are attributed to the old image, everything else to the new one
+			layerNote := describeLayer(newLayer, change.NewLayer)
+			if change.Type == Removed {
+				layerNote = describeLayer(oldLayer, change.OldLayer)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n",
+				escapeCell(name), change.Ecosystem, orDash(change.OldVersion), orDash(change.NewVersion), escapeCell(layerNote))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func describeLayer(describe LayerDescriber, layer int) string {
+	if layer < 0 {
+		return "-"
+	}
+	if describe == nil {
+		return fmt.Sprintf("%d", layer)
+	}
+	return describe(layer)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeCell keeps table cells on one line and escapes column separators
+func escapeCell(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "|", "\\|")
+}
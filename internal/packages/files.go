@@ -0,0 +1,51 @@
+package packages
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// ReadFileList adds the files listed by the package database file at name to
+// files. dpkg .list files and the apk installed database list the files of
+// their packages; other names are ignored.
+func ReadFileList(name string, r io.Reader, files map[string]bool) error {
+	switch {
+	case strings.HasPrefix(name, "/var/lib/dpkg/info/") && strings.HasSuffix(name, ".list"):
+		return readDpkgList(r, files)
+	case name == "/lib/apk/db/installed":
+		return readApkFiles(r, files)
+	}
+	return nil
+}
+
+// readDpkgList adds the paths of a dpkg .list file, one per line
+func readDpkgList(r io.Reader, files map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line != "/." {
+			files[path.Clean(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// readApkFiles adds the files of the apk installed database, where F: lines
+// name a directory and the R: lines that follow name its files
+func readApkFiles(r io.Reader, files map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	dir := "/"
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "F:"):
+			dir = "/" + strings.TrimPrefix(line, "F:")
+		case strings.HasPrefix(line, "R:"):
+			files[path.Join(dir, strings.TrimPrefix(line, "R:"))] = true
+		case line == "":
+			dir = "/"
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,56 @@
+package packages
+
+import "strings"
+
+// InstallCommands returns shell commands that install pkgs at their versions
+// with the package manager of each ecosystem, OS packages first
+func InstallCommands(pkgs []Package) []string {
+	byEcosystem := make(map[string][]string)
+	for _, pkg := range pkgs {
+		byEcosystem[pkg.Ecosystem] = append(byEcosystem[pkg.Ecosystem], pin(pkg))
+	}
+
+	var commands []string
+	if deb := byEcosystem[EcosystemDeb]; len(deb) > 0 {
+		commands = append(commands,
+			"apt-get update",
+			"apt-get install -y --no-install-recommends "+strings.Join(deb, " "),
+			"rm -rf /var/lib/apt/lists/*")
+	}
+	if apk := byEcosystem[EcosystemApk]; len(apk) > 0 {
+		commands = append(commands, "apk add --no-cache "+strings.Join(apk, " "))
+	}
+	if rpm := byEcosystem[EcosystemRpm]; len(rpm) > 0 {
+		commands = append(commands, "dnf install -y "+strings.Join(rpm, " "), "dnf clean all")
+	}
+	if pypi := byEcosystem[EcosystemPyPI]; len(pypi) > 0 {
+		commands = append(commands, "pip install --no-cache-dir "+strings.Join(pypi, " "))
+	}
+	if npm := byEcosystem[EcosystemNpm]; len(npm) > 0 {
+		commands = append(commands, "npm install -g "+strings.Join(npm, " "))
+	}
+	if gem := byEcosystem[EcosystemGem]; len(gem) > 0 {
+		commands = append(commands, "gem install "+strings.Join(gem, " "))
+	}
+	return commands
+}
+
+// pin returns the package manager argument installing a package at its version
+func pin(pkg Package) string {
+	if pkg.Version == "" {
+		return pkg.Name
+	}
+	switch pkg.Ecosystem {
+	case EcosystemDeb, EcosystemApk:
+		return pkg.Name + "=" + pkg.Version
+	case EcosystemRpm:
+		return pkg.Name + "-" + pkg.Version
+	case EcosystemPyPI:
+		return pkg.Name + "==" + pkg.Version
+	case EcosystemNpm:
+		return pkg.Name + "@" + pkg.Version
+	case EcosystemGem:
+		return pkg.Name + ":" + pkg.Version
+	}
+	return pkg.Name
+}
@@ -0,0 +1,195 @@
+// Package packages reads OS package databases and language package manifests
+// from image layers and tracks which layer installed each package.
+package packages
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/layer"
+)
+
+// maxManifestSize is the largest package database file that is parsed
+const maxManifestSize = 64 << 20
+
+// Package is a single installed package
+type Package struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// Location is the install directory for language packages, empty for OS packages
+	Location string `json:"location,omitempty"`
+	// Layer is the index of the layer that installed this version
+	Layer int `json:"layer"`
+}
+
+// Key identifies a package independently of its version
+func (p Package) Key() string {
+	return p.Ecosystem + "|" + p.Location + "|" + p.Name
+}
+
+// Inventory lists the packages present in an image's final filesystem
+type Inventory struct {
+	Packages []Package `json:"packages"`
+	// Warnings describes package databases that were found but could not be read
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Find returns the package with the given ecosystem and name, if present
+func (inv *Inventory) Find(ecosystem, name string) (Package, bool) {
+	for _, pkg := range inv.Packages {
+		if pkg.Ecosystem == ecosystem && pkg.Name == name {
+			return pkg, true
+		}
+	}
+	return Package{}, false
+}
+
+// InstalledBy returns the packages whose current version was installed by layer
+func (inv *Inventory) InstalledBy(layerIndex int) []Package {
+	var installed []Package
+	for _, pkg := range inv.Packages {
+		if pkg.Layer == layerIndex {
+			installed = append(installed, pkg)
+		}
+	}
+	return installed
+}
+
+// Scan walks count layers in order and returns the packages in the final
+// filesystem. Each package records the layer that installed its current version.
+func Scan(o layer.Opener, count int) (*Inventory, error) {
+	// files maps database paths to the packages they currently describe
+	files := make(map[string][]Package)
+	rpmDatabases := make(map[string]bool)
+
+	for i := 0; i < count; i++ {
+		// before records the layer that installed each package version present so far
+		before := make(map[string]int)
+		for _, pkgs := range files {
+			for _, pkg := range pkgs {
+				before[pkg.Key()+"|"+pkg.Version] = pkg.Layer
+			}
+		}
+
+		pending := make(map[string][]Package)
+		var whiteouts, opaques []string
+
+		err := layer.WalkLayer(o, i, func(hdr *tar.Header, r io.Reader) error {
+			if target, opaque, ok := layer.ParseWhiteout(hdr.Name); ok {
+				if opaque {
+					opaques = append(opaques, target)
+				} else {
+					whiteouts = append(whiteouts, target)
+				}
+				return nil
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				return nil
+			}
+
+			name := layer.Clean(hdr.Name)
+			if rpmDatabaseRegex.MatchString(name) {
+				rpmDatabases[name] = true
+				return nil
+			}
+
+			p, ok := parserFor(name)
+			if !ok || hdr.Size > maxManifestSize {
+				return nil
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			pending[name] = p.parse(name, data)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Whiteouts only apply to lower layers, so remove before adding this layer's files
+		for tracked := range files {
+			if removedBy(tracked, whiteouts, opaques) {
+				delete(files, tracked)
+			}
+		}
+		for tracked := range rpmDatabases {
+			if removedBy(tracked, whiteouts, opaques) {
+				delete(rpmDatabases, tracked)
+			}
+		}
+
+		for name, pkgs := range pending {
+			for j := range pkgs {
+				// Rewriting a database does not reinstall the packages already in it
+				pkgs[j].Layer = i
+				if earlier, ok := before[pkgs[j].Key()+"|"+pkgs[j].Version]; ok {
+					pkgs[j].Layer = earlier
+				}
+			}
+			files[name] = pkgs
+		}
+	}
+
+	inventory := &Inventory{}
+	seen := make(map[string]bool)
+	for _, pkgs := range files {
+		for _, pkg := range pkgs {
+			key := pkg.Key() + "|" + pkg.Version
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			inventory.Packages = append(inventory.Packages, pkg)
+		}
+	}
+	sort.Slice(inventory.Packages, func(i, j int) bool {
+		a, b := inventory.Packages[i], inventory.Packages[j]
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Location < b.Location
+	})
+
+	hasRpmManifest := false
+	for _, pkg := range inventory.Packages {
+		if pkg.Ecosystem == EcosystemRpm {
+			hasRpmManifest = true
+			break
+		}
+	}
+	if len(rpmDatabases) > 0 && !hasRpmManifest {
+		var paths []string
+		for p := range rpmDatabases {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		inventory.Warnings = append(inventory.Warnings,
+			fmt.Sprintf("rpm database found at %s but binary rpm databases are not supported; rpm packages are not listed", strings.Join(paths, ", ")))
+	}
+
+	return inventory, nil
+}
+
+// removedBy reports whether p is deleted by any of the whiteouts or opaque directories
+func removedBy(p string, whiteouts, opaques []string) bool {
+	for _, target := range whiteouts {
+		if p == target || strings.HasPrefix(p, target+"/") {
+			return true
+		}
+	}
+	for _, dir := range opaques {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package packages
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ shared libraries
+
+Package: curl
+Status: install ok installed
+Version: 7.88.1-10
+
+Package: oldpkg
+Status: deinstall ok config-files
+Version: 1.0-1
+`
+
+func TestScan(t *testing.T) {
+	layers := testimage.Layers{
+		testimage.Layer(
+			testimage.Reg("var/lib/dpkg/status", "Package: libc6\nStatus: install ok installed\nVersion: 2.36-9+deb12u4\n"),
+		),
+		testimage.Layer(
+			testimage.Reg("var/lib/dpkg/status", dpkgStatus),
+			testimage.Reg("app/node_modules/express/package.json", `{"name": "express", "version": "4.18.2"}`),
+			testimage.Reg("app/node_modules/@types/node/package.json", `{"name": "@types/node", "version": "20.1.0"}`),
+			testimage.Reg("app/node_modules/express/lib/package.json", `{"name": "not-a-package", "version": "1.0.0"}`),
+			testimage.Reg("usr/lib/python3/dist-packages/requests-2.31.0.dist-info/METADATA", "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\n\nDescription\nName: ignored\n"),
+			testimage.Reg("usr/lib/ruby/gems/3.1.0/specifications/nokogiri-1.15.4-x86_64-linux.gemspec", ""),
+		),
+		testimage.Layer(
+			testimage.Whiteout("app/node_modules/express"),
+			testimage.Reg("var/lib/rpm/rpmdb.sqlite", "binary"),
+		),
+	}
+
+	inventory, err := Scan(layers, len(layers))
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	got := make(map[string]Package)
+	for _, pkg := range inventory.Packages {
+		got[pkg.Ecosystem+":"+pkg.Name] = pkg
+	}
+
+	expected := map[string]struct {
+		version string
+		layer   int
+	}{
+		"deb:libc6":       {"2.36-9+deb12u4", 0},
+		"deb:curl":        {"7.88.1-10", 1},
+		"npm:@types/node": {"20.1.0", 1},
+		"pypi:requests":   {"2.31.0", 1},
+		"gem:nokogiri":    {"1.15.4-x86_64-linux", 1},
+	}
+	for key, want := range expected {
+		pkg, ok := got[key]
+		if !ok {
+			t.Errorf("Expected package %s, got %+v", key, inventory.Packages)
+			continue
+		}
+		if pkg.Version != want.version || pkg.Layer != want.layer {
+			t.Errorf("Package %s = %s in layer %d, want %s in layer %d", key, pkg.Version, pkg.Layer, want.version, want.layer)
+		}
+	}
+	if len(inventory.Packages) != len(expected) {
+		t.Errorf("Expected %d packages, got %+v", len(expected), inventory.Packages)
+	}
+	if len(inventory.Warnings) != 1 || !strings.Contains(inventory.Warnings[0], "rpmdb.sqlite") {
+		t.Errorf("Expected an rpm database warning, got %v", inventory.Warnings)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		ecosystem string
+		a, b      string
+		want      int
+	}{
+		{EcosystemDeb, "1.0-1", "1.0-2", -1},
+		{EcosystemDeb, "1:1.0", "2.0", 1},
+		{EcosystemDeb, "1.0~rc1", "1.0", -1},
+		{EcosystemDeb, "2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{EcosystemDeb, "1.10", "1.9", 1},
+		{EcosystemApk, "1.2.4-r2", "1.2.4-r10", -1},
+		{EcosystemNpm, "4.18.2", "4.18.10", -1},
+		{EcosystemNpm, "1.0.0-rc.1", "1.0.0", -1},
+		{EcosystemNpm, "1.0.0", "1.0.0+build5", 0},
+		{EcosystemPyPI, "2.0", "2.0.1", -1},
+		{EcosystemPyPI, "2.0b1", "2.0", -1},
+	}
+
+	for _, tc := range testCases {
+		if got := CompareVersions(tc.ecosystem, tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareVersions(%s, %s, %s) = %d, want %d", tc.ecosystem, tc.a, tc.b, got, tc.want)
+		}
+		if got := CompareVersions(tc.ecosystem, tc.b, tc.a); got != -tc.want {
+			t.Errorf("CompareVersions(%s, %s, %s) = %d, want %d", tc.ecosystem, tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
+
+func TestChangelog(t *testing.T) {
+	old := &Inventory{Packages: []Package{
+		{Ecosystem: EcosystemDeb, Name: "openssl", Version: "3.0.11-1", Layer: 0},
+		{Ecosystem: EcosystemDeb, Name: "curl", Version: "7.88.1-10", Layer: 1},
+		{Ecosystem: EcosystemNpm, Name: "lodash", Version: "4.17.21", Location: "/app/node_modules", Layer: 2},
+		{Ecosystem: EcosystemApk, Name: "legacy", Version: "1.0-r0", Layer: 1},
+	}}
+	new := &Inventory{Packages: []Package{
+		{Ecosystem: EcosystemDeb, Name: "openssl", Version: "3.0.13-1", Layer: 0},
+		{Ecosystem: EcosystemDeb, Name: "curl", Version: "7.88.1-10", Layer: 1},
+		{Ecosystem: EcosystemNpm, Name: "lodash", Version: "4.17.20", Location: "/app/node_modules", Layer: 2},
+		{Ecosystem: EcosystemPyPI, Name: "requests", Version: "2.31.0", Location: "/usr/lib/python3/dist-packages", Layer: 3},
+	}}
+
+	changes := Changelog(old, new)
+	var summary []string
+	for _, change := range changes {
+		summary = append(summary, string(change.Type)+":"+change.Name)
+	}
+	want := "upgraded:openssl,downgraded:lodash,added:requests,removed:legacy"
+	if strings.Join(summary, ",") != want {
+		t.Errorf("Changelog() = %v, want %s", summary, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, "Changes", changes, nil, nil); err != nil {
+		t.Fatalf("WriteMarkdown() error = %v", err)
+	}
+	output := buf.String()
+	for _, expect := range []string{"## Changes", "### Upgraded (1)", "| openssl | deb | 3.0.11-1 | 3.0.13-1 | 0 |", "| legacy | apk | 1.0-r0 | - | 1 |"} {
+		if !strings.Contains(output, expect) {
+			t.Errorf("Expected %q in output:\n%s", expect, output)
+		}
+	}
+}
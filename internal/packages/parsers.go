@@ -0,0 +1,220 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Ecosystems recognized by the parsers
+const (
+	EcosystemDeb  = "deb"
+	EcosystemApk  = "apk"
+	EcosystemRpm  = "rpm"
+	EcosystemNpm  = "npm"
+	EcosystemPyPI = "pypi"
+	EcosystemGem  = "gem"
+)
+
+// parser extracts packages from the contents of a matching file
+type parser struct {
+	// match reports whether the parser handles the file at p
+	match func(p string) bool
+	parse func(p string, data []byte) []Package
+}
+
+var (
+	npmManifestRegex  = regexp.MustCompile(`/node_modules/(@[^/]+/)?[^/@]+/package\.json$`)
+	pythonMetaRegex   = regexp.MustCompile(`/(site|dist)-packages/[^/]+\.(dist-info/METADATA|egg-info/PKG-INFO)$`)
+	gemSpecRegex      = regexp.MustCompile(`/specifications/[^/]+\.gemspec$`)
+	dpkgStatusDRegex  = regexp.MustCompile(`^/var/lib/dpkg/status\.d/[^/]+$`)
+	rpmDatabaseRegex  = regexp.MustCompile(`^/(var/lib|usr/lib/sysimage)/rpm/(Packages|Packages\.db|rpmdb\.sqlite)$`)
+	rpmManifestPrefix = "/var/lib/rpmmanifest/"
+)
+
+// parsers lists every supported package database and manifest
+var parsers = []parser{
+	{
+		match: func(p string) bool {
+			return p == "/var/lib/dpkg/status" || (dpkgStatusDRegex.MatchString(p) && !strings.HasSuffix(p, ".md5sums"))
+		},
+		parse: parseDpkg,
+	},
+	{
+		match: func(p string) bool {
+			return p == "/lib/apk/db/installed"
+		},
+		parse: parseApk,
+	},
+	{
+		match: func(p string) bool {
+			return strings.HasPrefix(p, rpmManifestPrefix) && strings.HasPrefix(path.Base(p), "container-manifest")
+		},
+		parse: parseRpmManifest,
+	},
+	{
+		match: npmManifestRegex.MatchString,
+		parse: parseNpm,
+	},
+	{
+		match: pythonMetaRegex.MatchString,
+		parse: parsePythonMetadata,
+	},
+	{
+		match: gemSpecRegex.MatchString,
+		parse: parseGemSpec,
+	},
+}
+
+// parserFor returns the parser handling p, if any
+func parserFor(p string) (parser, bool) {
+	for _, candidate := range parsers {
+		if candidate.match(p) {
+			return candidate, true
+		}
+	}
+	return parser{}, false
+}
+
+// stanzas splits RFC 822 style records separated by blank lines into key/value maps
+func stanzas(data []byte) []map[string]string {
+	var records []map[string]string
+	record := make(map[string]string)
+	lastKey := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(record) > 0 {
+				records = append(records, record)
+				record = make(map[string]string)
+			}
+			continue
+		}
+		// Continuation lines belong to the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey != "" {
+				record[lastKey] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		lastKey = key
+		if _, exists := record[key]; !exists {
+			record[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(record) > 0 {
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func parseDpkg(p string, data []byte) []Package {
+	var packages []Package
+	for _, record := range stanzas(data) {
+		name, version := record["Package"], record["Version"]
+		if name == "" || version == "" {
+			continue
+		}
+		// Packages that were removed but not purged stay in the status file
+		if status, ok := record["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		packages = append(packages, Package{Ecosystem: EcosystemDeb, Name: name, Version: version})
+	}
+	return packages
+}
+
+func parseApk(p string, data []byte) []Package {
+	var packages []Package
+	// apk records use single letter keys, e.g. "P:musl" and "V:1.2.4-r2"
+	for _, record := range stanzas(data) {
+		name, version := record["P"], record["V"]
+		if name == "" || version == "" {
+			continue
+		}
+		packages = append(packages, Package{Ecosystem: EcosystemApk, Name: name, Version: version})
+	}
+	return packages
+}
+
+// parseRpmManifest reads the plain text rpm manifest written by CBL-Mariner and
+// Azure Linux images. Each line starts with the name and version-release, separated by tabs.
+func parseRpmManifest(p string, data []byte) []Package {
+	var packages []Package
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			continue
+		}
+		packages = append(packages, Package{Ecosystem: EcosystemRpm, Name: fields[0], Version: fields[1]})
+	}
+	return packages
+}
+
+func parseNpm(p string, data []byte) []Package {
+	var manifest struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Name == "" || manifest.Version == "" {
+		return nil
+	}
+	return []Package{{
+		Ecosystem: EcosystemNpm,
+		Name:      manifest.Name,
+		Version:   manifest.Version,
+		Location:  npmLocation(p),
+	}}
+}
+
+// npmLocation returns the node_modules directory a package.json lives in
+func npmLocation(p string) string {
+	idx := strings.LastIndex(p, "/node_modules/")
+	return p[:idx+len("/node_modules")]
+}
+
+func parsePythonMetadata(p string, data []byte) []Package {
+	// Only the header block matters; the description follows the first blank line
+	records := stanzas(data)
+	if len(records) == 0 {
+		return nil
+	}
+	name, version := records[0]["Name"], records[0]["Version"]
+	if name == "" || version == "" {
+		return nil
+	}
+	return []Package{{
+		Ecosystem: EcosystemPyPI,
+		Name:      name,
+		Version:   version,
+		Location:  path.Dir(path.Dir(p)),
+	}}
+}
+
+func parseGemSpec(p string, data []byte) []Package {
+	// The file name is <name>-<version>[-<platform>].gemspec and the name may
+	// contain dashes, so the version starts at the first part beginning with a digit
+	parts := strings.Split(strings.TrimSuffix(path.Base(p), ".gemspec"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" && parts[i][0] >= '0' && parts[i][0] <= '9' {
+			return []Package{{
+				Ecosystem: EcosystemGem,
+				Name:      strings.Join(parts[:i], "-"),
+				Version:   strings.Join(parts[i:], "-"),
+				Location:  path.Dir(path.Dir(p)),
+			}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package packages
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two versions using the rules of the ecosystem.
+// It returns -1 if a is older than b, 1 if it is newer and 0 if they are equal.
+func CompareVersions(ecosystem, a, b string) int {
+	if a == b {
+		return 0
+	}
+	switch ecosystem {
+	case EcosystemDeb, EcosystemApk, EcosystemRpm:
+		return compareDebian(a, b)
+	}
+	return compareGeneric(a, b)
+}
+
+// compareDebian implements the dpkg version comparison algorithm, which is
+// also a close enough match for apk and rpm versions
+func compareDebian(a, b string) int {
+	epochA, upstreamA, revisionA := splitDebian(a)
+	epochB, upstreamB, revisionB := splitDebian(b)
+
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+	if result := verrevcmp(upstreamA, upstreamB); result != 0 {
+		return result
+	}
+	return verrevcmp(revisionA, revisionB)
+}
+
+// splitDebian splits [epoch:]upstream[-revision]
+func splitDebian(version string) (int, string, string) {
+	epoch := 0
+	if idx := strings.Index(version, ":"); idx >= 0 {
+		epoch, _ = strconv.Atoi(version[:idx])
+		version = version[idx+1:]
+	}
+	revision := ""
+	if idx := strings.LastIndex(version, "-"); idx >= 0 {
+		revision = version[idx+1:]
+		version = version[:idx]
+	}
+	return epoch, version, revision
+}
+
+// order gives the dpkg sort weight of a non-digit character
+func order(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	}
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// verrevcmp compares version fragments the way dpkg does: non-digit runs are
+// compared character by character and digit runs numerically
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := 0, 0
+			if i < len(a) {
+				ac = order(a[i])
+			}
+			if j < len(b) {
+				bc = order(b[j])
+			}
+			if ac != bc {
+				if ac < bc {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			if firstDiff < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareGeneric compares dotted versions such as semver and PEP 440. Numeric
+// parts compare numerically, and a trailing pre-release tag such as "rc1" or
+// "beta" sorts before the release it precedes.
+func compareGeneric(a, b string) int {
+	partsA := versionParts(a)
+	partsB := versionParts(b)
+
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if result := comparePart(partsA[i], partsB[i]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(partsA) == len(partsB):
+		return 0
+	case len(partsA) > len(partsB):
+		if isNumber(partsA[len(partsB)]) {
+			return 1
+		}
+		return -1
+	default:
+		if isNumber(partsB[len(partsA)]) {
+			return -1
+		}
+		return 1
+	}
+}
+
+// versionParts splits a version into alternating numeric and alphabetic parts
+func versionParts(version string) []string {
+	version = strings.TrimPrefix(strings.ToLower(version), "v")
+	// Build metadata does not affect precedence
+	if idx := strings.Index(version, "+"); idx >= 0 {
+		version = version[:idx]
+	}
+
+	var parts []string
+	start := -1
+	for i := 0; i <= len(version); i++ {
+		boundary := i == len(version) || !isAlnum(version[i])
+		if !boundary && start >= 0 && isDigit(version[i]) != isDigit(version[start]) {
+			boundary = true
+			parts = append(parts, version[start:i])
+			start = i
+			continue
+		}
+		if boundary {
+			if start >= 0 {
+				parts = append(parts, version[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	return parts
+}
+
+func comparePart(a, b string) int {
+	aNum, bNum := isNumber(a), isNumber(b)
+	switch {
+	case aNum && bNum:
+		x, _ := strconv.ParseUint(a, 10, 64)
+		y, _ := strconv.ParseUint(b, 10, 64)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case aNum:
+		// A release number is newer than a pre-release tag
+		return 1
+	case bNum:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z')
+}
+
+func isNumber(s string) bool {
+	return s != "" && isDigit(s[0])
+}
@@ -0,0 +1,282 @@
+// Package rootfs infers how a flat root filesystem, such as docker export
+// writes, was built: the packages installed on top of its base image, the
+// files no package owns and the user it runs as.
+package rootfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/packages"
+)
+
+// maxFileSize bounds the package databases and passwd file that are read
+const maxFileSize = 64 << 20
+
+// Evidence is what a root filesystem reveals about how it was built
+type Evidence struct {
+	// Packages are the packages thought to be installed on top of the base image
+	Packages []packages.Package `json:"packages,omitempty"`
+	// BasePackages is the number of packages left to the base image
+	BasePackages int `json:"base_packages"`
+	// Groups are the directories and top-level files holding files that no package owns
+	Groups []Group `json:"groups,omitempty"`
+	// User is a login user from /etc/passwd, empty if there are only system users
+	User string `json:"user,omitempty"`
+	// Warnings describes evidence that could not be used
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Group is a directory, or a top-level file, of files that no package owns
+type Group struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	IsDir bool   `json:"is_dir"`
+}
+
+// alpineBase lists the packages in the world of the alpine base image
+var alpineBase = map[string]bool{
+	"alpine-baselayout": true, "alpine-baselayout-data": true, "alpine-keys": true, "alpine-release": true,
+	"apk-tools": true, "busybox": true, "busybox-binsh": true, "ca-certificates-bundle": true,
+	"libc-utils": true, "musl": true, "musl-utils": true, "scanelf": true, "ssl_client": true, "zlib": true,
+}
+
+// systemDirs hold files created by the base image and by running the
+// container, rather than copied in by a build
+var systemDirs = []string{
+	"/etc", "/var", "/proc", "/sys", "/dev", "/run", "/tmp", "/boot", "/root", "/lib/apk",
+	"/usr/share/doc", "/usr/share/man", "/usr/share/info", "/usr/lib/locale",
+}
+
+// databases are the files read besides the package file lists
+type databases struct {
+	dpkgStatus     string
+	extendedStates string
+	apkWorld       string
+	passwd         string
+}
+
+// Analyze reads the single layer of a flat root filesystem
+func Analyze(o layer.Opener) (*Evidence, error) {
+	inventory, err := packages.Scan(o, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packages: %w", err)
+	}
+
+	var db databases
+	owned := make(map[string]bool)
+	index, err := layer.BuildIndex(o, 1, func(_ int, hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxFileSize {
+			return nil
+		}
+		name := layer.Clean(hdr.Name)
+		var target *string
+		switch name {
+		case "/var/lib/dpkg/status":
+			target = &db.dpkgStatus
+		case "/var/lib/apt/extended_states":
+			target = &db.extendedStates
+		case "/etc/apk/world":
+			target = &db.apkWorld
+		case "/etc/passwd":
+			target = &db.passwd
+		default:
+			return packages.ReadFileList(name, r, owned)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		*target = string(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the filesystem: %w", err)
+	}
+
+	evidence := &Evidence{Warnings: inventory.Warnings}
+	evidence.selectPackages(inventory.Packages, db)
+	evidence.groupFiles(index.Layers[0], owned)
+	evidence.User = loginUser(db.passwd, evidence.Groups)
+	return evidence, nil
+}
+
+// selectPackages keeps the packages that are neither part of a minimal base
+// image nor dependencies the package manager installs on its own
+func (e *Evidence) selectPackages(pkgs []packages.Package, db databases) {
+	base := make(map[string]bool)
+	for _, stanza := range stanzas(db.dpkgStatus) {
+		if priority := stanza["Priority"]; priority == "required" || priority == "important" {
+			base[stanza["Package"]] = true
+		}
+	}
+	for _, stanza := range stanzas(db.extendedStates) {
+		if stanza["Auto-Installed"] == "1" {
+			base[stanza["Package"]] = true
+		}
+	}
+	world := make(map[string]bool)
+	for _, line := range strings.Fields(db.apkWorld) {
+		// World entries may carry a version constraint, e.g. curl=8.5.0-r0
+		name := strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune("=<>~", r) })
+		if len(name) > 0 && !alpineBase[name[0]] {
+			world[name[0]] = true
+		}
+	}
+
+	for _, pkg := range pkgs {
+		keep := false
+		switch pkg.Ecosystem {
+		case packages.EcosystemDeb:
+			keep = !base[pkg.Name]
+		case packages.EcosystemApk:
+			keep = world[pkg.Name]
+		case packages.EcosystemRpm:
+			// rpm databases do not record why a package was installed
+			keep = false
+		default:
+			// Language packages installed globally; the rest belong to the application
+			keep = strings.HasPrefix(pkg.Location, "/usr/local/")
+		}
+		if keep {
+			e.Packages = append(e.Packages, pkg)
+		} else {
+			e.BasePackages++
+		}
+	}
+}
+
+// groupFiles collects the files no package owns into directories that are
+// likely to have been copied in by a build
+func (e *Evidence) groupFiles(changes *layer.Changes, owned map[string]bool) {
+	var installed []string
+	for _, pkg := range e.Packages {
+		if pkg.Location != "" {
+			installed = append(installed, pkg.Location)
+		}
+	}
+
+	counts := make(map[string]int)
+	dirs := make(map[string]bool)
+	for name, hdr := range changes.Entries {
+		switch {
+		case hdr.Typeflag == tar.TypeDir, owned[name], under(name, systemDirs), under(name, installed):
+			continue
+		case hdr.Typeflag == tar.TypeSymlink && (path.Dir(name) == "/" || strings.HasPrefix(hdr.Linkname, "/etc/alternatives/")):
+			// Merged /usr links and alternatives are set up by the base image and packages
+			continue
+		case strings.HasSuffix(name, ".pyc") || strings.Contains(name, "/__pycache__/") || name == "/.dockerenv":
+			continue
+		}
+		group, isDir := groupOf(name)
+		counts[group]++
+		dirs[group] = isDir
+	}
+
+	for group, count := range counts {
+		e.Groups = append(e.Groups, Group{Path: group, Files: count, IsDir: dirs[group]})
+	}
+	sort.Slice(e.Groups, func(i, j int) bool {
+		return e.Groups[i].Path < e.Groups[j].Path
+	})
+}
+
+// groupOf returns the directory a file is copied with: the top-level
+// directory, or the application directory under /opt, /home, /srv, /usr and
+// /usr/local. Top-level files are their own group.
+func groupOf(p string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	depth := 1
+	switch parts[0] {
+	case "opt", "home", "srv", "mnt", "usr":
+		depth = 2
+		if parts[0] == "usr" && len(parts) > 1 && parts[1] == "local" {
+			depth = 3
+		}
+	}
+	if depth >= len(parts) {
+		depth = len(parts) - 1
+	}
+	if depth == 0 {
+		return p, false
+	}
+	return "/" + strings.Join(parts[:depth], "/"), true
+}
+
+// loginUser returns the user the container most likely runs as: the only
+// regular user, or a user whose home is a copied directory
+func loginUser(passwd string, groups []Group) string {
+	homes := make(map[string]bool)
+	for _, group := range groups {
+		if group.IsDir {
+			homes[group.Path] = true
+		}
+	}
+	var regular []string
+	for _, line := range strings.Split(passwd, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil || uid == 0 {
+			continue
+		}
+		if homes[fields[5]] {
+			return fields[0]
+		}
+		if uid >= 1000 && uid < 65534 {
+			regular = append(regular, fields[0])
+		}
+	}
+	if len(regular) == 1 {
+		return regular[0]
+	}
+	return ""
+}
+
+// under reports whether p is one of dirs or inside one of them
+func under(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// stanzas parses the blank line separated "Key: value" records of dpkg and
+// apt state files, ignoring continuation lines
+func stanzas(data string) []map[string]string {
+	var records []map[string]string
+	current := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				records = append(records, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			current[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(current) > 0 {
+		records = append(records, current)
+	}
+	return records
+}
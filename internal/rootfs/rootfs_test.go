@@ -0,0 +1,94 @@
+package rootfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Priority: required
+Version: 2.36-9
+
+Package: curl
+Status: install ok installed
+Priority: optional
+Version: 7.88.1-10
+
+Package: libcurl4
+Status: install ok installed
+Priority: optional
+Version: 7.88.1-10
+`
+
+func TestAnalyze(t *testing.T) {
+	layers := testimage.Layers{testimage.Layer(
+		testimage.Reg("etc/passwd", "root:x:0:0:root:/root:/bin/bash\nwww-data:x:33:33::/var/www:/usr/sbin/nologin\napp:x:999:999::/srv/app:/bin/sh\n"),
+		testimage.Reg("var/lib/dpkg/status", dpkgStatus),
+		testimage.Reg("var/lib/apt/extended_states", "Package: libcurl4\nArchitecture: amd64\nAuto-Installed: 1\n"),
+		testimage.Reg("var/lib/dpkg/info/curl.list", "/.\n/usr\n/usr/bin\n/usr/bin/curl\n"),
+		testimage.Reg("usr/bin/curl", "curl"),
+		testimage.Symlink("bin", "usr/bin"),
+		testimage.Symlink("usr/bin/awk", "/etc/alternatives/awk"),
+		testimage.Reg("srv/app/server.py", "print('hi')\n"),
+		testimage.Reg("srv/app/__pycache__/server.cpython-311.pyc", "bytecode"),
+		testimage.Reg("srv/app/static/index.html", "<html>"),
+		testimage.Reg("usr/local/bin/entrypoint", "#!/bin/sh\n"),
+		testimage.Reg("usr/local/lib/python3.11/site-packages/flask/__init__.py", ""),
+		testimage.Reg("usr/local/lib/python3.11/site-packages/flask-3.0.0.dist-info/METADATA", "Name: flask\nVersion: 3.0.0\n"),
+		testimage.Reg("entrypoint.sh", "#!/bin/sh\n"),
+	)}
+
+	evidence, err := Analyze(layers)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+
+	var got []string
+	for _, pkg := range evidence.Packages {
+		got = append(got, pkg.Ecosystem+":"+pkg.Name)
+	}
+	if strings.Join(got, " ") != "deb:curl pypi:flask" || evidence.BasePackages != 2 {
+		t.Errorf("Packages = %v with %d base packages, want deb:curl pypi:flask with 2", got, evidence.BasePackages)
+	}
+
+	var groups []string
+	for _, group := range evidence.Groups {
+		groups = append(groups, group.Path)
+	}
+	if strings.Join(groups, " ") != "/entrypoint.sh /srv/app /usr/local/bin" {
+		t.Errorf("Groups = %v, want /entrypoint.sh /srv/app /usr/local/bin", groups)
+	}
+	if evidence.Groups[1].Files != 2 || !evidence.Groups[1].IsDir || evidence.Groups[0].IsDir {
+		t.Errorf("Groups = %+v, want 2 files in /srv/app and a top-level file", evidence.Groups)
+	}
+	if evidence.User != "app" {
+		t.Errorf("User = %q, want app, whose home is a copied directory", evidence.User)
+	}
+}
+
+func TestAnalyzeAlpine(t *testing.T) {
+	layers := testimage.Layers{testimage.Layer(
+		testimage.Reg("etc/apk/world", "alpine-baselayout\nbusybox\nnginx=1.24.0-r6\n"),
+		testimage.Reg("lib/apk/db/installed", "P:busybox\nV:1.36.1-r2\nF:bin\nR:busybox\n\nP:nginx\nV:1.24.0-r6\nF:usr/sbin\nR:nginx\n\nP:pcre2\nV:10.42-r1\n"),
+		testimage.Reg("bin/busybox", "busybox"),
+		testimage.Reg("usr/sbin/nginx", "nginx"),
+		testimage.Reg("etc/passwd", "root:x:0:0:root:/root:/bin/sh\nnginx:x:101:101::/var/lib/nginx:/sbin/nologin\nbob:x:1000:1000::/home/bob:/bin/sh\n"),
+	)}
+
+	evidence, err := Analyze(layers)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if len(evidence.Packages) != 1 || evidence.Packages[0].Name != "nginx" || evidence.BasePackages != 2 {
+		t.Errorf("Packages = %+v with %d base packages, want nginx with 2", evidence.Packages, evidence.BasePackages)
+	}
+	if len(evidence.Groups) != 0 {
+		t.Errorf("Groups = %+v, want none as every file is owned", evidence.Groups)
+	}
+	if evidence.User != "bob" {
+		t.Errorf("User = %q, want the only regular user bob", evidence.User)
+	}
+}
@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"archive/tar"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/layer"
+)
+
+// MaxContentSize is the largest file whose contents are checked by content rules
+const MaxContentSize = 1 << 20
+
+// FileRule describes a check for credential files inside image layers
+type FileRule struct {
+	ID          string
+	Description string
+	Severity    Severity
+	// Path is matched against the absolute path of each file (nil matches every file)
+	Path *regexp.Regexp
+	// Content, when set, must also match the start of the file contents
+	Content *regexp.Regexp
+}
+
+// DefaultFileRules returns the built-in filesystem rule set
+func DefaultFileRules() []FileRule {
+	return []FileRule{
+		{
+			ID:          "ssh-private-key",
+			Description: "SSH private key",
+			Severity:    SeverityCritical,
+			Path:        regexp.MustCompile(`/\.ssh/id_(rsa|dsa|ecdsa|ed25519)(_sk)?$`),
+		},
+		{
+			ID:          "aws-credentials",
+			Description: "AWS credentials file",
+			Severity:    SeverityCritical,
+			Path:        regexp.MustCompile(`/\.aws/credentials$`),
+			Content:     regexp.MustCompile(`(?i)aws_secret_access_key\s*=\s*\S+`),
+		},
+		{
+			ID:          "git-credentials",
+			Description: "Git credential store",
+			Severity:    SeverityCritical,
+			Path:        regexp.MustCompile(`/\.git-credentials$`),
+			Content:     regexp.MustCompile(`://[^:\s/]+:[^@\s]+@`),
+		},
+		{
+			ID:          "npmrc-auth",
+			Description: "npm configuration with auth token",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.npmrc$`),
+			Content:     regexp.MustCompile(`(_authToken|_auth|_password)\s*=\s*\S+`),
+		},
+		{
+			ID:          "docker-config-auth",
+			Description: "Docker client configuration with registry credentials",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.docker/config\.json$`),
+			Content:     regexp.MustCompile(`"auth"\s*:\s*"[^"]+"`),
+		},
+		{
+			ID:          "pypirc-password",
+			Description: "PyPI configuration with password",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.pypirc$`),
+			Content:     regexp.MustCompile(`(?i)password\s*[:=]\s*\S+`),
+		},
+		{
+			ID:          "netrc-password",
+			Description: "netrc file with password",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.netrc$`),
+			Content:     regexp.MustCompile(`(?i)\bpassword\s+\S+`),
+		},
+		{
+			ID:          "kubeconfig-credentials",
+			Description: "Kubernetes configuration with client credentials",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.kube/config$`),
+			Content:     regexp.MustCompile(`(client-key-data|token):\s*\S+`),
+		},
+		{
+			ID:          "dotenv-secret",
+			Description: "Environment file with credentials",
+			Severity:    SeverityHigh,
+			Path:        regexp.MustCompile(`/\.env(\.[A-Za-z0-9_-]+)?$`),
+			Content:     regexp.MustCompile(`(?im)^\s*(export\s+)?` + sensitiveKey + `\s*=\s*\S+`),
+		},
+		{
+			ID:          "private-key-content",
+			Description: "File containing private key material",
+			Severity:    SeverityHigh,
+			Content:     regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED) )?PRIVATE KEY-----`),
+		},
+	}
+}
+
+// FileFinding is a credential file found in a layer
+type FileFinding struct {
+	RuleID      string   `json:"rule_id"`
+	Description string   `json:"description"`
+	Severity    Severity `json:"severity"`
+	Path        string   `json:"path"`
+	// Layer is the index of the layer that added the file
+	Layer  int    `json:"layer"`
+	DiffID string `json:"diff_id,omitempty"`
+	// HistoryIndex and Instruction describe the build step that added the file
+	HistoryIndex int    `json:"history_index"`
+	Instruction  string `json:"instruction,omitempty"`
+	// Visible reports whether the file is still present in the final rootfs
+	Visible bool `json:"visible"`
+	// HiddenByLayer is the layer that deleted or overwrote the file, or -1
+	HiddenByLayer int `json:"hidden_by_layer"`
+	// Overwritten is true when the file was replaced rather than deleted
+	Overwritten bool `json:"overwritten,omitempty"`
+}
+
+// FileScanner runs filesystem rules over every layer of an image
+type FileScanner struct {
+	Rules []FileRule
+}
+
+// NewFileScanner creates a scanner with the given rules, or the default rules if none are given
+func NewFileScanner(rules ...FileRule) *FileScanner {
+	if len(rules) == 0 {
+		rules = DefaultFileRules()
+	}
+	return &FileScanner{Rules: rules}
+}
+
+// ScanLayers checks the files of every layer, including files that a later
+// layer deleted, and reports whether each finding survives to the final rootfs.
+func (s *FileScanner) ScanLayers(o layer.Opener, metadata *docker.ImageMetadata) ([]FileFinding, error) {
+	var findings []FileFinding
+
+	index, err := layer.BuildIndex(o, len(metadata.Layers), func(layerIndex int, hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		name := layer.Clean(hdr.Name)
+
+		var content []byte
+		contentRead := false
+		for _, rule := range s.Rules {
+			if rule.Path != nil && !rule.Path.MatchString(name) {
+				continue
+			}
+			if rule.Content != nil {
+				if hdr.Size > MaxContentSize {
+					continue
+				}
+				if !contentRead {
+					data, err := io.ReadAll(io.LimitReader(r, MaxContentSize))
+					if err != nil {
+						return err
+					}
+					content = data
+					contentRead = true
+				}
+				if !rule.Content.Match(content) {
+					continue
+				}
+			}
+
+			findings = append(findings, FileFinding{
+				RuleID:      rule.ID,
+				Description: rule.Description,
+				Severity:    rule.Severity,
+				Path:        name,
+				Layer:       layerIndex,
+			})
+			// One finding per file is enough
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	historyIndexes := metadata.LayerHistoryIndexes()
+	for i := range findings {
+		finding := &findings[i]
+
+		if finding.Layer < len(metadata.RootFS.DiffIDs) {
+			finding.DiffID = metadata.RootFS.DiffIDs[finding.Layer]
+		}
+		finding.HistoryIndex = historyIndexes[finding.Layer]
+		if finding.HistoryIndex >= 0 {
+			finding.Instruction = dockerfile.HistoryInstruction(metadata.History[finding.HistoryIndex].CreatedBy)
+		}
+
+		finding.HiddenByLayer = -1
+		finding.Visible = true
+		if removal, hidden := index.Shadowed(finding.Path, finding.Layer); hidden {
+			finding.Visible = false
+			finding.HiddenByLayer = removal.Layer
+			finding.Overwritten = !removal.Deleted
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity.rank() > findings[j].Severity.rank()
+	})
+
+	return findings, nil
+}
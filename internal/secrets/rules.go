@@ -0,0 +1,207 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Severity describes how serious a finding is
+type Severity string
+
+// Supported severities, from least to most serious
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// rank returns a sortable weight for the severity
+func (s Severity) rank() int {
+	switch s {
+	case SeverityCritical:
+		return 4
+	case SeverityHigh:
+		return 3
+	case SeverityMedium:
+		return 2
+	case SeverityLow:
+		return 1
+	}
+	return 0
+}
+
+// AtLeast reports whether s is as serious as other or more
+func (s Severity) AtLeast(other Severity) bool {
+	return s.rank() >= other.rank()
+}
+
+// Rule describes a single secret detection check
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity
+	// Pattern is matched against each scanned string
+	Pattern *regexp.Regexp
+	// SecretGroup is the capture group holding the secret value (0 means the whole match)
+	SecretGroup int
+	// MinEntropy discards matches whose secret has a lower Shannon entropy (bits per char)
+	MinEntropy float64
+}
+
+// sensitiveKey matches variable names that usually hold credentials
+const sensitiveKey = `[A-Za-z0-9_]*(?:TOKEN|SECRET|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?|AUTHORIZATION)[A-Za-z0-9_]*`
+
+// DefaultRules returns the built-in rule set
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			ID:          "private-key",
+			Description: "Private key material",
+			Severity:    SeverityCritical,
+			Pattern:     regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+		},
+		{
+			ID:          "aws-access-key-id",
+			Description: "AWS access key ID",
+			Severity:    SeverityCritical,
+			Pattern:     regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "github-token",
+			Description: "GitHub token",
+			Severity:    SeverityCritical,
+			Pattern:     regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "slack-token",
+			Description: "Slack token",
+			Severity:    SeverityHigh,
+			Pattern:     regexp.MustCompile(`\b(xox[abprs]-[A-Za-z0-9-]{10,})\b`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "url-credentials",
+			Description: "Credentials embedded in a URL",
+			Severity:    SeverityHigh,
+			Pattern:     regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@'"]+:([^/\s@'"$]+)@`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "curl-basic-auth",
+			Description: "Basic auth credentials passed to curl or wget",
+			Severity:    SeverityHigh,
+			Pattern:     regexp.MustCompile(`(?:curl|wget)\b[^|;&]*?(?:\s-u\s*|\s--user[ =]|\s--password[ =])['"]?([^\s'"]+)`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "sensitive-assignment",
+			Description: "Credential-like variable assigned a value",
+			Severity:    SeverityHigh,
+			Pattern:     regexp.MustCompile(`(?i)\b` + sensitiveKey + `=("[^"$]+"|'[^'$]+'|[^\s"'$]+)`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "sensitive-env",
+			Description: "Credential-like variable set with legacy ENV syntax",
+			Severity:    SeverityHigh,
+			Pattern:     regexp.MustCompile(`(?i)\bENV\s+` + sensitiveKey + `\s+([^\s=$][^\s$]*)`),
+			SecretGroup: 1,
+		},
+		{
+			ID:          "high-entropy-assignment",
+			Description: "High-entropy value assigned to a variable",
+			Severity:    SeverityMedium,
+			Pattern:     regexp.MustCompile(`\b[A-Za-z_][A-Za-z0-9_]*=([A-Za-z0-9+/_\-]{20,}={0,2})`),
+			SecretGroup: 1,
+			MinEntropy:  4.3,
+		},
+	}
+}
+
+// ruleFile is the on-disk representation of a custom rule
+type ruleFile struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Severity    Severity `json:"severity"`
+	Pattern     string   `json:"pattern"`
+	SecretGroup int      `json:"secret_group"`
+	MinEntropy  float64  `json:"min_entropy"`
+}
+
+// LoadRules reads a JSON array of rules, as used by the --rules flag
+func LoadRules(r io.Reader) ([]Rule, error) {
+	var entries []ruleFile
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ID == "" {
+			return nil, fmt.Errorf("rule without an id")
+		}
+		pattern, err := regexp.Compile(entry.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", entry.ID, err)
+		}
+		if entry.SecretGroup > pattern.NumSubexp() {
+			return nil, fmt.Errorf("rule %s: secret_group %d out of range", entry.ID, entry.SecretGroup)
+		}
+		if entry.Severity == "" {
+			entry.Severity = SeverityMedium
+		}
+		if entry.Severity.rank() == 0 {
+			return nil, fmt.Errorf("rule %s: unknown severity %q", entry.ID, entry.Severity)
+		}
+		rules = append(rules, Rule{
+			ID:          entry.ID,
+			Description: entry.Description,
+			Severity:    entry.Severity,
+			Pattern:     pattern,
+			SecretGroup: entry.SecretGroup,
+			MinEntropy:  entry.MinEntropy,
+		})
+	}
+
+	return rules, nil
+}
+
+// Entropy returns the Shannon entropy of s in bits per character
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// isPlaceholder reports whether a value is obviously not a real secret
+func isPlaceholder(value string) bool {
+	v := strings.ToLower(strings.Trim(value, `"'`))
+	switch v {
+	case "", "true", "false", "yes", "no", "none", "null", "changeme", "xxx", "****", "redacted":
+		return true
+	}
+	// Variable references and file paths are not secrets themselves
+	if strings.HasPrefix(v, "$") || strings.HasPrefix(v, "/") {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/docker"
+)
+
+// Mask replaces secret values in redacted output
+const Mask = "****"
+
+// Source identifies which part of the image metadata a finding came from
+type Source string
+
+// Metadata sources that are scanned
+const (
+	SourceHistory     Source = "history"
+	SourceEnv         Source = "env"
+	SourceLabel       Source = "label"
+	SourceHealthcheck Source = "healthcheck"
+)
+
+// Finding is a single suspected secret
+type Finding struct {
+	RuleID      string   `json:"rule_id"`
+	Description string   `json:"description"`
+	Severity    Severity `json:"severity"`
+	Source      Source   `json:"source"`
+	// HistoryIndex is the index into ImageMetadata.History, or -1 for config findings
+	HistoryIndex int `json:"history_index"`
+	// Key is the env variable or label name for config findings
+	Key string `json:"key,omitempty"`
+	// Context is the scanned text with the secret masked
+	Context string `json:"context"`
+	// Secret is the raw matched value; it is never serialized
+	Secret string `json:"-"`
+}
+
+// Scanner runs a rule set over image metadata
+type Scanner struct {
+	Rules []Rule
+}
+
+// NewScanner creates a scanner with the given rules, or the default rules if none are given
+func NewScanner(rules ...Rule) *Scanner {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	return &Scanner{Rules: rules}
+}
+
+// Scan checks history, env, labels and healthcheck commands for secrets
+func (s *Scanner) Scan(metadata *docker.ImageMetadata) []Finding {
+	var findings []Finding
+
+	for i, entry := range metadata.History {
+		findings = append(findings, s.ScanText(entry.CreatedBy, SourceHistory, i, "")...)
+	}
+
+	for _, env := range metadata.Config.Env {
+		key := env
+		if idx := strings.Index(env, "="); idx >= 0 {
+			key = env[:idx]
+		}
+		findings = append(findings, s.ScanText(env, SourceEnv, -1, key)...)
+	}
+
+	// Sort label keys so results are stable
+	keys := make([]string, 0, len(metadata.Config.Labels))
+	for key := range metadata.Config.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text := key + "=" + metadata.Config.Labels[key]
+		findings = append(findings, s.ScanText(text, SourceLabel, -1, key)...)
+	}
+
+	if hc := metadata.Config.Healthcheck; hc != nil && len(hc.Test) > 0 {
+		findings = append(findings, s.ScanText(strings.Join(hc.Test, " "), SourceHealthcheck, -1, "")...)
+	}
+
+	return findings
+}
+
+// ScanText runs every rule over a single string. When several rules match the
+// same secret only the most severe finding is kept.
+func (s *Scanner) ScanText(text string, source Source, historyIndex int, key string) []Finding {
+	var findings []Finding
+	seen := make(map[string]int)
+
+	for _, rule := range s.Rules {
+		for _, match := range rule.Pattern.FindAllStringSubmatch(text, -1) {
+			if rule.SecretGroup >= len(match) {
+				continue
+			}
+			secret := match[rule.SecretGroup]
+			if rule.SecretGroup > 0 {
+				secret = strings.Trim(secret, `"'`)
+			}
+			if isPlaceholder(secret) {
+				continue
+			}
+			if rule.MinEntropy > 0 && Entropy(secret) < rule.MinEntropy {
+				continue
+			}
+
+			finding := Finding{
+				RuleID:       rule.ID,
+				Description:  rule.Description,
+				Severity:     rule.Severity,
+				Source:       source,
+				HistoryIndex: historyIndex,
+				Key:          key,
+				Context:      strings.ReplaceAll(text, secret, Mask),
+				Secret:       secret,
+			}
+
+			if idx, ok := seen[secret]; ok {
+				if rule.Severity.rank() > findings[idx].Severity.rank() {
+					findings[idx] = finding
+				}
+				continue
+			}
+			seen[secret] = len(findings)
+			findings = append(findings, finding)
+		}
+	}
+
+	// Mask every secret in the context, not just the one each finding is about
+	if len(findings) > 1 {
+		context := text
+		for _, finding := range findings {
+			context = strings.ReplaceAll(context, finding.Secret, Mask)
+		}
+		for i := range findings {
+			findings[i].Context = context
+		}
+	}
+
+	return findings
+}
+
+// Redact returns a copy of metadata with every detected secret masked. The
+// secret values are replaced everywhere they occur, so a token found in an
+// ENV entry is also masked in the history line that set it.
+func (s *Scanner) Redact(metadata *docker.ImageMetadata) *docker.ImageMetadata {
+	findings := s.Scan(metadata)
+
+	var secrets []string
+	for _, finding := range findings {
+		secrets = append(secrets, finding.Secret)
+	}
+	// Replace longer secrets first so overlapping matches are fully masked
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	mask := func(text string) string {
+		for _, secret := range secrets {
+			text = strings.ReplaceAll(text, secret, Mask)
+		}
+		return text
+	}
+	maskAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		masked := make([]string, len(values))
+		for i, value := range values {
+			masked[i] = mask(value)
+		}
+		return masked
+	}
+
+	redacted := *metadata
+
+	redacted.History = make([]docker.History, len(metadata.History))
+	for i, entry := range metadata.History {
+		entry.CreatedBy = mask(entry.CreatedBy)
+		entry.Comment = mask(entry.Comment)
+		redacted.History[i] = entry
+	}
+
+	redacted.Config.Env = maskAll(metadata.Config.Env)
+	redacted.Config.Cmd = maskAll(metadata.Config.Cmd)
+	redacted.Config.Entrypoint = maskAll(metadata.Config.Entrypoint)
+
+	if metadata.Config.Labels != nil {
+		redacted.Config.Labels = make(map[string]string, len(metadata.Config.Labels))
+		for key, value := range metadata.Config.Labels {
+			redacted.Config.Labels[key] = mask(value)
+		}
+	}
+
+	if hc := metadata.Config.Healthcheck; hc != nil {
+		copied := *hc
+		copied.Test = maskAll(hc.Test)
+		redacted.Config.Healthcheck = &copied
+	}
+
+	return &redacted
+}
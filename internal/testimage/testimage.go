@@ -0,0 +1,103 @@
+// Package testimage builds small in-memory layers and image archives for tests.
+package testimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// Modified is the modification time given to every generated entry
+var Modified = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// File describes a single layer entry
+type File struct {
+	Name     string
+	Body     string
+	Type     byte
+	Mode     int64
+	Linkname string
+	UID      int
+	GID      int
+	PAX      map[string]string
+}
+
+// Dir returns a directory entry
+func Dir(name string) File {
+	return File{Name: name, Type: tar.TypeDir, Mode: 0755}
+}
+
+// Reg returns a regular file entry
+func Reg(name, body string) File {
+	return File{Name: name, Body: body, Type: tar.TypeReg, Mode: 0644}
+}
+
+// Symlink returns a symbolic link entry
+func Symlink(name, target string) File {
+	return File{Name: name, Type: tar.TypeSymlink, Linkname: target, Mode: 0777}
+}
+
+// Hardlink returns a hard link entry
+func Hardlink(name, target string) File {
+	return File{Name: name, Type: tar.TypeLink, Linkname: target, Mode: 0644}
+}
+
+// Whiteout returns an entry deleting p from lower layers
+func Whiteout(p string) File {
+	dir, base := path.Split(p)
+	return Reg(path.Join(dir, ".wh."+base), "")
+}
+
+// Opaque returns an entry hiding the lower contents of dir
+func Opaque(dir string) File {
+	return Reg(path.Join(dir, ".wh..wh..opq"), "")
+}
+
+// Layer returns an uncompressed layer tar containing files
+func Layer(files ...File) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:       file.Name,
+			Typeflag:   file.Type,
+			Mode:       file.Mode,
+			Linkname:   file.Linkname,
+			Uid:        file.UID,
+			Gid:        file.GID,
+			ModTime:    Modified,
+			PAXRecords: file.PAX,
+		}
+		if file.Type == tar.TypeReg {
+			hdr.Size = int64(len(file.Body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			panic(fmt.Sprintf("testimage: %v", err))
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(file.Body)); err != nil {
+				panic(fmt.Sprintf("testimage: %v", err))
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		panic(fmt.Sprintf("testimage: %v", err))
+	}
+	return buf.Bytes()
+}
+
+// Layers is an in-memory layer.Opener
+type Layers [][]byte
+
+// OpenLayer returns the contents of layer i
+func (l Layers) OpenLayer(i int) (io.ReadCloser, error) {
+	if i < 0 || i >= len(l) {
+		return nil, fmt.Errorf("layer %d out of range", i)
+	}
+	return io.NopCloser(bytes.NewReader(l[i])), nil
+}
@@ -0,0 +1,50 @@
+package verify
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteText writes a human readable report with one line per check
+func WriteText(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	for _, check := range report.Checks {
+		fmt.Fprintf(&b, "  %-10s  %s\n", strings.ToUpper(string(check.Status)), check)
+	}
+
+	if len(report.Attachments) > 0 {
+		b.WriteString("\nSignatures and attestations:\n")
+		for _, attachment := range report.Attachments {
+			fmt.Fprintf(&b, "  %-10s  %s\n", strings.ToUpper(string(attachment.Status)), attachment)
+		}
+	}
+
+	failures := report.Failures()
+	fmt.Fprintf(&b, "\n%d checked, %d ok, %d unverified, %d failed\n",
+		len(report.Checks), report.Count(OK), report.Count(Unverified), len(failures))
+	unsigned := false
+	for _, check := range failures {
+		if check.Subject == signatureCheck {
+			unsigned = true
+		}
+	}
+	switch {
+	case unsigned && len(failures) == 1:
+		b.WriteString("Every recorded digest matches, but the image is not signed with the given key\n")
+	case unsigned:
+		b.WriteString("The image does not match its digests and is not signed with the given key\n")
+	case len(failures) > 0:
+		b.WriteString("The image does not match its digests: it may have been tampered with or truncated\n")
+	case report.Count(OK) == 0:
+		b.WriteString("The image records no digests, so nothing could be verified\n")
+	case report.Count(Unverified) > 0:
+		b.WriteString("Every recorded digest matches, but some blobs have no digest to check\n")
+	default:
+		b.WriteString("Every blob matches its digest\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,451 @@
+package verify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/source"
+)
+
+// Media types and annotations of the artifacts cosign attaches to images
+const (
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mediaTypeDSSE          = "application/vnd.dsse.envelope.v1+json"
+	mediaTypeBundle        = "application/vnd.dev.sigstore.bundle"
+	annotationSignature    = "dev.cosignproject.cosign/signature"
+	annotationPredicate    = "predicateType"
+	// payloadTypeInToto is the only payload type of the envelopes verified
+	payloadTypeInToto = "application/vnd.in-toto+json"
+	// predicateCosignSign is the predicate of signatures in the sigstore
+	// bundle format, which are in-toto statements rather than simple signing
+	predicateCosignSign = "https://sigstore.dev/cosign/sign/v1"
+	// signatureCheck is the subject of the check that a signature verified
+	signatureCheck = "signature"
+)
+
+// Kind is what an attachment holds
+type Kind string
+
+const (
+	// Signature signs the image digest
+	Signature Kind = "signature"
+	// Attestation is a signed in-toto statement about the image, such as
+	// SLSA provenance or an SBOM
+	Attestation Kind = "attestation"
+	// SBOM is an SBOM attached unsigned with cosign attach sbom
+	SBOM Kind = "sbom"
+)
+
+// Attachment is a signature, attestation or SBOM stored alongside an image
+type Attachment struct {
+	Kind Kind `json:"kind"`
+	// Source is the tag the artifact is stored under or, for referrers, the
+	// digest of its manifest
+	Source string `json:"source"`
+	// Digest is the digest of the blob holding the signature or statement
+	Digest        string `json:"digest"`
+	Subject       string `json:"subject"`
+	PredicateType string `json:"predicateType,omitempty"`
+	MediaType     string `json:"mediaType,omitempty"`
+	// Status is OK if the signature verifies with the key and names the image
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (a Attachment) String() string {
+	s := string(a.Kind)
+	if a.PredicateType != "" {
+		s += " " + a.PredicateType
+	} else if a.Kind == SBOM {
+		s += " " + a.MediaType
+	}
+	s += " (" + a.Source + ")"
+	if a.Detail != "" {
+		s += ": " + a.Detail
+	}
+	return s
+}
+
+// LoadKey reads a PEM encoded public key, such as the cosign.pub written by
+// cosign generate-key-pair
+func LoadKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s holds no PEM encoded public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// Attached adds the signatures, attestations and SBOMs stored alongside the
+// image read from src to report. If key is not nil their signatures are
+// verified with it offline, and report fails unless a signature verifies
+// and names the image. A signature may name an index the image was selected
+// from only if the index matches its digest. Certificates and transparency
+// logs are not checked.
+func Attached(ctx context.Context, src source.ImageSource, key crypto.PublicKey, report *Report) error {
+	var artifacts []source.Artifact
+	attached, ok := src.(source.Attached)
+	if ok {
+		var err error
+		artifacts, err = attached.Artifacts()
+		if err != nil {
+			return fmt.Errorf("failed to find signatures: %w", err)
+		}
+	}
+	bound := boundDigests(src)
+	for _, artifact := range artifacts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Attachments = append(report.Attachments, readArtifact(attached, artifact, key, bound)...)
+	}
+
+	if key == nil {
+		return nil
+	}
+	check := Check{Subject: signatureCheck, Status: OK}
+	verified := 0
+	for _, attachment := range report.Attachments {
+		if attachment.Kind == Signature && attachment.Status == OK {
+			verified++
+		}
+	}
+	switch {
+	case !ok:
+		check.Status, check.Detail = Failed, "the source does not store signatures"
+	case verified == 0 && report.count(Signature) == 0:
+		check.Status, check.Detail = Failed, "no signatures are stored alongside the image"
+	case verified == 0:
+		check.Status, check.Detail = Failed, "no signature verifies with the key"
+	default:
+		check.Actual = fmt.Sprintf("%d of %d signatures verified with the key", verified, report.count(Signature))
+	}
+	report.Checks = append(report.Checks, check)
+	return nil
+}
+
+// boundDigests returns the digests that a signature of the image may name:
+// its manifest, and the indexes it was selected from whose blobs match their
+// digests, since only then do they prove to lead to the manifest
+func boundDigests(src source.ImageSource) []string {
+	var digests []string
+	if stored, ok := src.(source.Stored); ok {
+		if descriptor, manifest := stored.StoredManifest(); manifest != nil && descriptor.Digest != "" {
+			digests = append(digests, descriptor.Digest)
+		}
+	}
+	if indexed, ok := src.(source.Indexed); ok {
+		for _, index := range indexed.StoredIndexes() {
+			if checkBlob("index", index.Descriptor, index.Data).Status == OK {
+				digests = append(digests, index.Descriptor.Digest)
+			}
+		}
+	}
+	return digests
+}
+
+// count returns the number of attachments of kind
+func (r *Report) count(kind Kind) int {
+	count := 0
+	for _, attachment := range r.Attachments {
+		if attachment.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// artifactManifest is the part of an artifact manifest that holds signatures
+type artifactManifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"layers"`
+}
+
+// readArtifact returns an attachment for each layer of artifact. Signatures
+// only verify if the subject they name is among bound.
+func readArtifact(attached source.Attached, artifact source.Artifact, key crypto.PublicKey, bound []string) []Attachment {
+	name := artifact.Tag
+	if name == "" {
+		name = "referrer " + artifact.Descriptor.Digest
+	}
+	var manifest artifactManifest
+	if err := json.Unmarshal(artifact.Manifest, &manifest); err != nil {
+		return nil
+	}
+
+	var attachments []Attachment
+	for _, layer := range manifest.Layers {
+		attachment := Attachment{Source: name, Digest: layer.Digest, Subject: artifact.Subject, MediaType: layer.MediaType}
+		switch {
+		case layer.MediaType == mediaTypeSimpleSigning:
+			attachment.Kind = Signature
+		case layer.MediaType == mediaTypeDSSE:
+			attachment.Kind = Attestation
+			attachment.PredicateType = layer.Annotations[annotationPredicate]
+		case strings.HasPrefix(layer.MediaType, mediaTypeBundle):
+			// Bundles hold signatures or attestations, told apart once read
+			attachment.Kind = Attestation
+		case strings.HasSuffix(artifact.Tag, ".sbom"):
+			attachment.Kind = SBOM
+		default:
+			continue
+		}
+
+		blob, err := attached.ReadBlob(layer.Digest)
+		if err == nil && !matches(layer.Digest, blob) {
+			err = fmt.Errorf("the blob does not match its digest")
+		}
+		switch {
+		case err != nil:
+			attachment.Status, attachment.Detail = Failed, err.Error()
+		case attachment.Kind == SBOM:
+			attachment.Status, attachment.Detail = Unverified, "attached unsigned"
+		case layer.MediaType == mediaTypeSimpleSigning:
+			verifySimpleSigning(&attachment, blob, layer.Annotations[annotationSignature], key)
+		case layer.MediaType == mediaTypeDSSE:
+			var envelope dsseEnvelope
+			if err := json.Unmarshal(blob, &envelope); err != nil {
+				attachment.Status, attachment.Detail = Failed, fmt.Sprintf("failed to parse envelope: %v", err)
+			} else {
+				verifyEnvelope(&attachment, envelope, key)
+			}
+		default:
+			verifyBundle(&attachment, blob, key)
+		}
+		if attachment.Status == OK && !slices.Contains(bound, attachment.Subject) {
+			attachment.Status = Mismatch
+			attachment.Detail = fmt.Sprintf("signed for %s, which is not verified to lead to the image", attachment.Subject)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// verifySimpleSigning verifies a cosign signature, whose payload is a simple
+// signing document naming the image digest
+func verifySimpleSigning(attachment *Attachment, payload []byte, signature string, key crypto.PublicKey) {
+	var document struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &document); err != nil {
+		attachment.Status, attachment.Detail = Failed, fmt.Sprintf("failed to parse payload: %v", err)
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) == 0 {
+		attachment.Status, attachment.Detail = Failed, "the signature annotation is missing or invalid"
+		return
+	}
+	verifySigned(attachment, payload, [][]byte{sig}, []string{document.Critical.Image.Digest}, key)
+}
+
+// dsseEnvelope is a DSSE envelope, which signs an in-toto statement
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid,omitempty"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// verifyEnvelope verifies an attestation: the envelope against key and the
+// subjects of its statement against the image
+func verifyEnvelope(attachment *Attachment, envelope dsseEnvelope, key crypto.PublicKey) {
+	if envelope.PayloadType != payloadTypeInToto {
+		attachment.Status, attachment.Detail = Failed, fmt.Sprintf("unsupported envelope payload type %q", envelope.PayloadType)
+		return
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		attachment.Status, attachment.Detail = Failed, "the envelope payload is not base64"
+		return
+	}
+	var statement struct {
+		PredicateType string `json:"predicateType"`
+		Subject       []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+	}
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		attachment.Status, attachment.Detail = Failed, fmt.Sprintf("failed to parse statement: %v", err)
+		return
+	}
+	attachment.PredicateType = statement.PredicateType
+	if statement.PredicateType == predicateCosignSign {
+		attachment.Kind, attachment.PredicateType = Signature, ""
+	}
+	var subjects []string
+	for _, subject := range statement.Subject {
+		for algorithm, hash := range subject.Digest {
+			subjects = append(subjects, algorithm+":"+hash)
+		}
+	}
+	var sigs [][]byte
+	for _, signature := range envelope.Signatures {
+		if sig, err := base64.StdEncoding.DecodeString(signature.Sig); err == nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	verifySigned(attachment, pae(envelope.PayloadType, payload), sigs, subjects, key)
+}
+
+// pae is the DSSE pre-authentication encoding that envelope signatures sign
+func pae(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// verifyBundle verifies a sigstore bundle holding a DSSE envelope, or a
+// message signature. A message signature has no subject, so it only names the
+// image when the message it signs is the image manifest itself.
+func verifyBundle(attachment *Attachment, data []byte, key crypto.PublicKey) {
+	var bundle struct {
+		MessageSignature *struct {
+			MessageDigest struct {
+				Algorithm string `json:"algorithm"`
+				Digest    []byte `json:"digest"`
+			} `json:"messageDigest"`
+			Signature []byte `json:"signature"`
+		} `json:"messageSignature"`
+		DSSEEnvelope *dsseEnvelope `json:"dsseEnvelope"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		attachment.Status, attachment.Detail = Failed, fmt.Sprintf("failed to parse bundle: %v", err)
+		return
+	}
+	switch {
+	case bundle.DSSEEnvelope != nil:
+		verifyEnvelope(attachment, *bundle.DSSEEnvelope, key)
+	case bundle.MessageSignature != nil && bundle.MessageSignature.MessageDigest.Algorithm == "SHA2_256":
+		attachment.Kind = Signature
+		message := bundle.MessageSignature
+		signed := "sha256:" + hex.EncodeToString(message.MessageDigest.Digest)
+		verifyDigestSigned(attachment, message.MessageDigest.Digest, message.Signature, signed, key)
+	default:
+		attachment.Status, attachment.Detail = Unverified, "unsupported bundle content"
+	}
+}
+
+// verifySigned checks that one of sigs signs message with key and that the
+// signed document names the image among subjects
+func verifySigned(attachment *Attachment, message []byte, sigs [][]byte, subjects []string, key crypto.PublicKey) {
+	if key == nil {
+		attachment.Status, attachment.Detail = Unverified, "no key given"
+		return
+	}
+	verified := false
+	for _, sig := range sigs {
+		if verifySignature(key, message, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		attachment.Status, attachment.Detail = Unverified, "not signed with the given key"
+		return
+	}
+	for _, subject := range subjects {
+		if subject == attachment.Subject {
+			attachment.Status = OK
+			return
+		}
+	}
+	attachment.Status = Mismatch
+	attachment.Detail = fmt.Sprintf("signed for %s, not for %s", strings.Join(subjects, ", "), attachment.Subject)
+}
+
+// verifyDigestSigned checks that sig signs digest with key, the digest of
+// the message signed, and that the message is the subject's manifest. Any
+// other message does not name the image, so the signature proves nothing
+// about it.
+func verifyDigestSigned(attachment *Attachment, digest, sig []byte, signed string, key crypto.PublicKey) {
+	if key == nil {
+		attachment.Status, attachment.Detail = Unverified, "no key given"
+		return
+	}
+	var err error
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			err = errInvalidSignature
+		}
+	case *rsa.PublicKey:
+		err = verifyRSA(k, digest, sig)
+	default:
+		err = fmt.Errorf("%T keys cannot verify a digest", key)
+	}
+	if err != nil {
+		attachment.Status, attachment.Detail = Unverified, "not signed with the given key"
+		return
+	}
+	if signed == attachment.Subject {
+		attachment.Status, attachment.Detail = OK, ""
+	} else {
+		attachment.Status = Unverified
+		attachment.Detail = fmt.Sprintf("signs the message %s, which does not name the image", signed)
+	}
+}
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// verifySignature verifies sig over message as cosign signs it: ECDSA and
+// RSA keys sign the SHA-256 digest and Ed25519 keys the message itself
+func verifySignature(key crypto.PublicKey, message, sig []byte) error {
+	digest := sha256.Sum256(message)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errInvalidSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		return verifyRSA(k, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, message, sig) {
+			return errInvalidSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", key)
+}
+
+// verifyRSA accepts PKCS #1 v1.5 and PSS signatures over a SHA-256 digest
+func verifyRSA(key *rsa.PublicKey, digest, sig []byte) error {
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil {
+		return nil
+	}
+	return rsa.VerifyPSS(key, crypto.SHA256, digest, sig, nil)
+}
@@ -0,0 +1,345 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+	"github.com/raesene/pasgan/pkg/source"
+)
+
+// signedLayout writes an OCI layout holding an image with a cosign
+// signature, an attestation and a sigstore bundle referrer
+type signedLayout struct {
+	dir   string
+	image string
+}
+
+func (l *signedLayout) blob(t *testing.T, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	if err := os.MkdirAll(filepath.Join(l.dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(l.dir, "blobs", "sha256", hex.EncodeToString(sum[:])), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return digest(data)
+}
+
+func (l *signedLayout) json(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.blob(t, data)
+}
+
+// artifact stores a manifest with a single layer
+func (l *signedLayout) artifact(t *testing.T, mediaType string, data []byte, annotations map[string]string, subject string) string {
+	t.Helper()
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"config":        map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": l.blob(t, []byte("{}"))},
+		"layers":        []map[string]any{{"mediaType": mediaType, "digest": l.blob(t, data), "size": len(data), "annotations": annotations}},
+	}
+	if subject != "" {
+		manifest["subject"] = map[string]any{"digest": subject}
+		manifest["artifactType"] = mediaType
+	}
+	return l.json(t, manifest)
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	t.Helper()
+	sum := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeSigned writes the layout, signed with key. signedFor is the digest
+// the signature names, the image if empty.
+func writeSigned(t *testing.T, key *ecdsa.PrivateKey, signedFor string) *signedLayout {
+	t.Helper()
+	l := &signedLayout{dir: t.TempDir()}
+	layer := testimage.Layer(testimage.Reg("app", "binary"))
+	config := l.blob(t, []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["`+digest(layer)+`"]}}`))
+	l.image = l.json(t, map[string]any{
+		"schemaVersion": 2,
+		"config":        map[string]any{"digest": config},
+		"layers":        []map[string]any{{"digest": l.blob(t, layer), "size": len(layer)}},
+	})
+	if signedFor == "" {
+		signedFor = l.image
+	}
+	tag := strings.Replace(l.image, ":", "-", 1)
+
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"example.com/app"},"image":{"docker-manifest-digest":"` + signedFor + `"},"type":"cosign container image signature"},"optional":null}`)
+	signature := l.artifact(t, mediaTypeSimpleSigning, payload,
+		map[string]string{annotationSignature: base64.StdEncoding.EncodeToString(sign(t, key, payload))}, "")
+
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v1","predicateType":"https://slsa.dev/provenance/v1",` +
+		`"subject":[{"name":"example.com/app","digest":{"sha256":"` + strings.TrimPrefix(l.image, "sha256:") + `"}}],"predicate":{}}`)
+	envelope, _ := json.Marshal(map[string]any{
+		"payloadType": "application/vnd.in-toto+json",
+		"payload":     base64.StdEncoding.EncodeToString(statement),
+		"signatures":  []map[string]string{{"sig": base64.StdEncoding.EncodeToString(sign(t, key, pae("application/vnd.in-toto+json", statement)))}},
+	})
+	attestation := l.artifact(t, mediaTypeDSSE, envelope, map[string]string{annotationPredicate: "https://slsa.dev/provenance/v1"}, "")
+
+	hash, _ := hex.DecodeString(strings.TrimPrefix(l.image, "sha256:"))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, hash)
+	bundle, _ := json.Marshal(map[string]any{
+		"mediaType":        "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"messageSignature": map[string]any{"messageDigest": map[string]any{"algorithm": "SHA2_256", "digest": hash}, "signature": sig},
+	})
+	referrer := l.artifact(t, "application/vnd.dev.sigstore.bundle.v0.3+json", bundle, nil, l.image)
+
+	index := map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{
+			{"digest": l.image, "annotations": map[string]string{"org.opencontainers.image.ref.name": "1.0"}},
+			{"digest": signature, "annotations": map[string]string{"org.opencontainers.image.ref.name": tag + ".sig"}},
+			{"digest": attestation, "annotations": map[string]string{"org.opencontainers.image.ref.name": tag + ".att"}},
+			{"digest": referrer},
+			// Signatures of other images are ignored
+			{"digest": signature, "annotations": map[string]string{"org.opencontainers.image.ref.name": "sha256-" + strings.Repeat("0", 64) + ".sig"}},
+		},
+	}
+	data, _ := json.Marshal(index)
+	if err := os.WriteFile(filepath.Join(l.dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// writeIndexed writes an image selected through a nested index, tagged 1.0,
+// with the extra manifests in the top-level index. It returns the digest and
+// contents of the nested index.
+func (l *signedLayout) writeIndexed(t *testing.T, extra ...map[string]any) (string, []byte) {
+	t.Helper()
+	layer := testimage.Layer(testimage.Reg("app", "binary"))
+	config := l.blob(t, []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["`+digest(layer)+`"]}}`))
+	l.image = l.json(t, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        map[string]any{"digest": config},
+		"layers":        []map[string]any{{"digest": l.blob(t, layer), "size": len(layer)}},
+	})
+	nested, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest":    l.image,
+			"platform":  map[string]string{"os": "linux", "architecture": "amd64"},
+		}},
+	})
+	index := l.blob(t, nested)
+	manifests := append([]map[string]any{{
+		"mediaType":   "application/vnd.oci.image.index.v1+json",
+		"digest":      index,
+		"size":        len(nested),
+		"annotations": map[string]string{"org.opencontainers.image.ref.name": "1.0"},
+	}}, extra...)
+	data, _ := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": manifests})
+	if err := os.WriteFile(filepath.Join(l.dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return index, nested
+}
+
+func attached(t *testing.T, dir string, key *ecdsa.PrivateKey) *Report {
+	t.Helper()
+	src, err := source.Open("oci:" + dir + ":1.0")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	report := &Report{}
+	var public any
+	if key != nil {
+		public = &key.PublicKey
+	}
+	if err := Attached(context.Background(), src, public, report); err != nil {
+		t.Fatalf("Attached() error = %v", err)
+	}
+	return report
+}
+
+func attachments(report *Report) string {
+	var s []string
+	for _, attachment := range report.Attachments {
+		s = append(s, string(attachment.Kind)+" "+attachment.PredicateType+"="+string(attachment.Status))
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestAttached(t *testing.T) {
+	key := newKey(t)
+	l := writeSigned(t, key, "")
+
+	report := attached(t, l.dir, nil)
+	if got := attachments(report); got != "signature =unverified, attestation https://slsa.dev/provenance/v1=unverified, signature =unverified" {
+		t.Errorf("Attached() without a key = %s", got)
+	}
+	if len(report.Checks) != 0 {
+		t.Errorf("Attached() without a key checks = %+v, want none", report.Checks)
+	}
+
+	report = attached(t, l.dir, key)
+	if got := attachments(report); got != "signature =ok, attestation https://slsa.dev/provenance/v1=ok, signature =ok" {
+		t.Errorf("Attached() = %s", got)
+	}
+	if !report.OK() || report.Checks[0].Actual != "2 of 2 signatures verified with the key" {
+		t.Errorf("Attached() checks = %+v, want the signatures verified", report.Checks)
+	}
+	if report.Attachments[0].Source != strings.Replace(l.image, ":", "-", 1)+".sig" || !strings.HasPrefix(report.Attachments[2].Source, "referrer sha256:") {
+		t.Errorf("Attached() sources = %+v", report.Attachments)
+	}
+
+	// A key that signed nothing
+	report = attached(t, l.dir, newKey(t))
+	if report.OK() || report.Checks[0].Detail != "no signature verifies with the key" {
+		t.Errorf("Attached() with another key checks = %+v, want a failure", report.Checks)
+	}
+}
+
+func TestAttachedReplayed(t *testing.T) {
+	// A signature of another image copied next to this one
+	key := newKey(t)
+	l := writeSigned(t, key, "sha256:"+strings.Repeat("1", 64))
+	report := attached(t, l.dir, key)
+	if report.Attachments[0].Status != Mismatch || !strings.HasPrefix(report.Attachments[0].Detail, "signed for sha256:111") {
+		t.Errorf("Attached() = %+v, want the replayed signature rejected", report.Attachments[0])
+	}
+	if !report.OK() {
+		t.Errorf("Attached() checks = %+v, want the bundle signature to verify", report.Checks)
+	}
+}
+
+func TestAttachedIndex(t *testing.T) {
+	// cosign signs multi-platform images by the digest of their index
+	key := newKey(t)
+	l := &signedLayout{dir: t.TempDir()}
+	index, nested := l.writeIndexed(t)
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"example.com/app"},"image":{"docker-manifest-digest":"` + index + `"},"type":"cosign container image signature"},"optional":null}`)
+	signature := l.artifact(t, mediaTypeSimpleSigning, payload,
+		map[string]string{annotationSignature: base64.StdEncoding.EncodeToString(sign(t, key, payload))}, "")
+	// The signature is tagged after the index, so index.json is written again
+	l.writeIndexed(t, map[string]any{"digest": signature, "annotations": map[string]string{"org.opencontainers.image.ref.name": strings.Replace(index, ":", "-", 1) + ".sig"}})
+
+	report := attached(t, l.dir, key)
+	if got := attachments(report); got != "signature =ok" || !report.OK() {
+		t.Errorf("Attached() = %s, %+v, want the index signature verified", got, report.Checks)
+	}
+
+	// A rewritten index could lead anywhere, so its signature proves nothing
+	tampered := bytes.Replace(nested, []byte(`"schemaVersion":2`), []byte(`"schemaVersion": 2`), 1)
+	if err := os.WriteFile(filepath.Join(l.dir, "blobs", "sha256", strings.TrimPrefix(index, "sha256:")), tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report = attached(t, l.dir, key)
+	if report.OK() || report.Attachments[0].Status != Mismatch || !strings.Contains(report.Attachments[0].Detail, "not verified to lead to the image") {
+		t.Errorf("Attached() = %+v, want the signature of the tampered index rejected", report.Attachments)
+	}
+}
+
+func TestVerifyEnvelopePayloadType(t *testing.T) {
+	key := newKey(t)
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"digest":{"sha256":"` + strings.Repeat("1", 64) + `"}}],"predicate":{}}`)
+	for _, payloadType := range []string{payloadTypeInToto, "text/plain"} {
+		attachment := Attachment{Subject: "sha256:" + strings.Repeat("1", 64)}
+		envelope := dsseEnvelope{PayloadType: payloadType, Payload: base64.StdEncoding.EncodeToString(statement)}
+		envelope.Signatures = append(envelope.Signatures, struct {
+			KeyID string `json:"keyid,omitempty"`
+			Sig   string `json:"sig"`
+		}{Sig: base64.StdEncoding.EncodeToString(sign(t, key, pae(payloadType, statement)))})
+		verifyEnvelope(&attachment, envelope, &key.PublicKey)
+
+		want := OK
+		if payloadType != payloadTypeInToto {
+			want = Failed
+		}
+		if attachment.Status != want {
+			t.Errorf("verifyEnvelope() of %s = %s %s, want %s", payloadType, attachment.Status, attachment.Detail, want)
+		}
+	}
+}
+
+func TestVerifyBundleMessage(t *testing.T) {
+	// A message signature names no subject, so it only covers the image when
+	// the message it signs is the manifest
+	key := newKey(t)
+	manifest := []byte(`{"schemaVersion":2}`)
+	tests := []struct {
+		name    string
+		message []byte
+		want    Status
+	}{
+		{name: "manifest", message: manifest, want: OK},
+		{name: "other blob", message: []byte("release.tar.gz"), want: Unverified},
+	}
+	for _, tt := range tests {
+		hash := sha256.Sum256(tt.message)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, _ := json.Marshal(map[string]any{
+			"messageSignature": map[string]any{"messageDigest": map[string]any{"algorithm": "SHA2_256", "digest": hash[:]}, "signature": sig},
+		})
+
+		attachment := Attachment{Subject: digest(manifest)}
+		verifyBundle(&attachment, bundle, &key.PublicKey)
+		if attachment.Status != tt.want || attachment.Kind != Signature {
+			t.Errorf("verifyBundle() of %s = %s %s %s, want %s", tt.name, attachment.Kind, attachment.Status, attachment.Detail, tt.want)
+		}
+		if tt.want == Unverified && !strings.Contains(attachment.Detail, "does not name the image") {
+			t.Errorf("verifyBundle() of %s detail = %q", tt.name, attachment.Detail)
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := newKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if loaded, err := LoadKey(path); err != nil || !key.PublicKey.Equal(loaded) {
+		t.Errorf("LoadKey() = %v, %v, want the public key", loaded, err)
+	}
+
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: der}), 0644)
+	if _, err := LoadKey(path); err == nil {
+		t.Error("LoadKey() of a private key error = nil")
+	}
+}
@@ -0,0 +1,319 @@
+// Package verify checks that the blobs of an image match the digests that
+// refer to them, so that tampered or truncated archives are detected before
+// their contents are trusted.
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/pkg/source"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	// OK means the blob matches its digest
+	OK Status = "ok"
+	// Mismatch means the blob does not match its digest or size
+	Mismatch Status = "mismatch"
+	// Failed means the blob could not be read, as when it is truncated
+	Failed Status = "failed"
+	// Unverified means the image records no digest to check the blob against
+	Unverified Status = "unverified"
+)
+
+// zstdMagic starts zstd compressed layers, whose diffIDs cannot be checked
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Check is the verification of a single blob against a digest
+type Check struct {
+	// Subject names what was checked, such as "layer 0 diffID"
+	Subject  string `json:"subject"`
+	Path     string `json:"path,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Status   Status `json:"status"`
+	// Detail explains a status other than OK
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c Check) String() string {
+	switch c.Status {
+	case Mismatch:
+		if c.Detail != "" {
+			return fmt.Sprintf("%s: %s", c.Subject, c.Detail)
+		}
+		return fmt.Sprintf("%s: expected %s, got %s", c.Subject, c.Expected, c.Actual)
+	case OK:
+		return fmt.Sprintf("%s: %s", c.Subject, c.Actual)
+	}
+	return fmt.Sprintf("%s: %s", c.Subject, c.Detail)
+}
+
+// Report is the outcome of verifying an image
+type Report struct {
+	Checks []Check `json:"checks"`
+	// Attachments are the signatures, attestations and SBOMs stored
+	// alongside the image, set by Attached
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Failures returns the checks that found a mismatch or could not read a blob
+func (r *Report) Failures() []Check {
+	var failures []Check
+	for _, check := range r.Checks {
+		if check.Status == Mismatch || check.Status == Failed {
+			failures = append(failures, check)
+		}
+	}
+	return failures
+}
+
+// OK reports whether every digest recorded in the image matched
+func (r *Report) OK() bool {
+	return len(r.Failures()) == 0
+}
+
+// Count returns the number of checks with status
+func (r *Report) Count(status Status) int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Image verifies the indexes, manifest, config and layers of src: each blob
+// against the digest that refers to it, the config against the image ID and
+// each uncompressed layer against its diffID in the config. Blobs that cannot
+// be read are reported as failed checks rather than errors.
+func Image(ctx context.Context, src source.ImageSource) (*Report, error) {
+	report := &Report{}
+
+	config, err := src.Config()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	// The indexes that led to the manifest must be the ones their digests name
+	if indexed, ok := src.(source.Indexed); ok {
+		for i, index := range indexed.StoredIndexes() {
+			report.Checks = append(report.Checks, checkBlob(fmt.Sprintf("index %d", i), index.Descriptor, index.Data))
+		}
+	}
+	if stored, ok := src.(source.Stored); ok {
+		if descriptor, manifest := stored.StoredManifest(); manifest != nil {
+			report.Checks = append(report.Checks, checkBlob("manifest", descriptor, manifest))
+		}
+		descriptor, raw := stored.StoredConfig()
+		if raw != nil {
+			config = raw
+			check := checkBlob("config", descriptor, raw)
+			if check.Status == Unverified {
+				check.Detail = "the archive records no image ID"
+			}
+			report.Checks = append(report.Checks, check)
+		} else {
+			report.Checks = append(report.Checks, Check{Subject: "config", Status: Unverified, Detail: "the config is rebuilt from legacy layer metadata"})
+		}
+	} else {
+		report.Checks = append(report.Checks, Check{Subject: "config", Status: Unverified, Detail: "the source does not keep the stored config"})
+	}
+
+	var image struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := json.Unmarshal(config, &image); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	diffIDs := image.RootFS.DiffIDs
+
+	layers, err := src.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers: %w", err)
+	}
+	if len(diffIDs) > 0 && len(diffIDs) != len(layers) {
+		report.Checks = append(report.Checks, Check{
+			Subject: "layers",
+			Status:  Mismatch,
+			Detail:  fmt.Sprintf("the config lists %d diffIDs for %d layers", len(diffIDs), len(layers)),
+		})
+	}
+	for i, descriptor := range layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		diffID := ""
+		if i < len(diffIDs) {
+			diffID = diffIDs[i]
+		}
+		report.Checks = append(report.Checks, checkLayer(ctx, src, i, descriptor, diffID)...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkBlob checks data against the digest and size of descriptor
+func checkBlob(subject string, descriptor source.Descriptor, data []byte) Check {
+	check := Check{Subject: subject, Path: descriptor.Path, Expected: descriptor.Digest}
+	if descriptor.Digest == "" {
+		check.Status = Unverified
+		check.Detail = "no digest recorded"
+		return check
+	}
+	h, err := newHash(descriptor.Digest)
+	if err != nil {
+		check.Status = Unverified
+		check.Detail = err.Error()
+		return check
+	}
+	h.Write(data)
+	return compare(check, h, descriptor.Size, int64(len(data)))
+}
+
+// checkLayer hashes the blob of layer i as stored and uncompressed, reading
+// it once, and checks both digests
+func checkLayer(ctx context.Context, src source.ImageSource, i int, descriptor source.Descriptor, diffID string) []Check {
+	blobCheck := Check{Subject: fmt.Sprintf("layer %d blob", i), Path: descriptor.Path, Expected: descriptor.Digest}
+	diffCheck := Check{Subject: fmt.Sprintf("layer %d diffID", i), Path: descriptor.Path, Expected: diffID}
+	failed := func(err error) []Check {
+		blobCheck.Status, blobCheck.Detail = Failed, err.Error()
+		diffCheck.Status, diffCheck.Detail = Failed, err.Error()
+		return []Check{blobCheck, diffCheck}
+	}
+
+	blobHash, err := newHash(descriptor.Digest)
+	if descriptor.Digest == "" {
+		blobHash = sha256.New()
+	} else if err != nil {
+		blobCheck.Status, blobCheck.Detail = Unverified, err.Error()
+		blobHash = sha256.New()
+	}
+	diffHash, err := newHash(diffID)
+	if diffID == "" {
+		diffHash = sha256.New()
+	} else if err != nil {
+		diffCheck.Status, diffCheck.Detail = Unverified, err.Error()
+		diffHash = sha256.New()
+	}
+
+	blob, err := src.OpenLayer(i)
+	if err != nil {
+		return failed(err)
+	}
+	defer blob.Close()
+	counted := &countingReader{r: utils.ContextReader(ctx, blob)}
+	stored := bufio.NewReader(io.TeeReader(counted, blobHash))
+
+	// The blob is hashed as the decompressed stream is read, then drained so
+	// that trailing data is hashed too
+	if magic, _ := stored.Peek(4); bytes.HasPrefix(magic, zstdMagic) {
+		diffCheck.Status, diffCheck.Detail = Unverified, "zstd compressed layers are not supported"
+	} else if uncompressed, err := layer.Decompress(stored); err != nil {
+		diffCheck.Status, diffCheck.Detail = Failed, err.Error()
+	} else {
+		_, err = io.Copy(diffHash, uncompressed)
+		uncompressed.Close()
+		if err != nil {
+			diffCheck.Status, diffCheck.Detail = Failed, fmt.Sprintf("failed to decompress layer: %v", err)
+		}
+	}
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return failed(fmt.Errorf("failed to read layer: %w", err))
+	}
+
+	if blobCheck.Status == "" {
+		if descriptor.Digest == "" {
+			blobCheck.Status, blobCheck.Detail = Unverified, "no digest recorded"
+			blobCheck.Actual = digestOf(blobHash)
+		} else {
+			blobCheck = compare(blobCheck, blobHash, descriptor.Size, counted.n)
+		}
+	}
+	if diffCheck.Status == "" {
+		if diffID == "" {
+			diffCheck.Status, diffCheck.Detail = Unverified, "the config lists no diffID"
+			diffCheck.Actual = digestOf(diffHash)
+		} else {
+			diffCheck = compare(diffCheck, diffHash, 0, 0)
+		}
+	}
+	return []Check{blobCheck, diffCheck}
+}
+
+// compare completes check with the digest in h and, if size is known, the
+// number of bytes read
+func compare(check Check, h hash.Hash, size, read int64) Check {
+	check.Actual = digestOf(h)
+	switch {
+	case check.Actual != check.Expected:
+		check.Status = Mismatch
+	case size > 0 && read != size:
+		check.Status = Mismatch
+		check.Detail = fmt.Sprintf("%d bytes, but %d recorded", read, size)
+	default:
+		check.Status = OK
+	}
+	return check
+}
+
+// newHash returns a hash for the algorithm of digest
+func newHash(digest string) (hash.Hash, error) {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+}
+
+// matches reports whether data has digest
+func matches(digest string, data []byte) bool {
+	h, err := newHash(digest)
+	if err != nil {
+		return false
+	}
+	h.Write(data)
+	return digestOf(h) == digest
+}
+
+// digestOf formats the sum of h as a digest
+func digestOf(h hash.Hash) string {
+	algorithm := "sha256"
+	if h.Size() == sha512.Size {
+		algorithm = "sha512"
+	}
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
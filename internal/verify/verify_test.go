@@ -0,0 +1,182 @@
+package verify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+	"github.com/raesene/pasgan/pkg/source"
+)
+
+// memorySource is an image held in memory, with its digests
+type memorySource struct {
+	blobs      testimage.Layers
+	config     []byte
+	descriptor source.Descriptor
+	layers     []source.Descriptor
+}
+
+func (m *memorySource) OpenLayer(i int) (io.ReadCloser, error) { return m.blobs.OpenLayer(i) }
+func (m *memorySource) Config() ([]byte, error)                { return m.config, nil }
+func (m *memorySource) Layers() ([]source.Descriptor, error)   { return m.layers, nil }
+func (m *memorySource) Annotations() map[string]string         { return nil }
+func (m *memorySource) Platform() source.Platform              { return source.Platform{} }
+func (m *memorySource) Close() error                           { return nil }
+func (m *memorySource) StoredConfig() (source.Descriptor, []byte) {
+	return m.descriptor, m.config
+}
+func (m *memorySource) StoredManifest() (source.Descriptor, []byte) {
+	return source.Descriptor{}, nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// newSource returns an image with an uncompressed and a gzip layer whose
+// digests all match
+func newSource() *memorySource {
+	base := testimage.Layer(testimage.Reg("etc/os-release", "ID=alpine\n"))
+	app := testimage.Layer(testimage.Reg("app", "binary"))
+	compressed := gzipped(app)
+	config := []byte(fmt.Sprintf(`{"rootfs":{"type":"layers","diff_ids":[%q,%q]}}`, digest(base), digest(app)))
+	return &memorySource{
+		blobs:      testimage.Layers{base, compressed},
+		config:     config,
+		descriptor: source.Descriptor{Digest: digest(config), Size: int64(len(config))},
+		layers: []source.Descriptor{
+			{Digest: digest(base), Size: int64(len(base))},
+			{Digest: digest(compressed), Size: int64(len(compressed))},
+		},
+	}
+}
+
+func statuses(report *Report) string {
+	var s []string
+	for _, check := range report.Checks {
+		s = append(s, check.Subject+"="+string(check.Status))
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *memorySource)
+		want   string
+	}{
+		{"valid", func(m *memorySource) {},
+			"config=ok, layer 0 blob=ok, layer 0 diffID=ok, layer 1 blob=ok, layer 1 diffID=ok"},
+		{"tampered layer", func(m *memorySource) {
+			m.blobs[0] = testimage.Layer(testimage.Reg("etc/os-release", "ID=debian\n"))
+		}, "config=ok, layer 0 blob=mismatch, layer 0 diffID=mismatch, layer 1 blob=ok, layer 1 diffID=ok"},
+		{"truncated gzip layer", func(m *memorySource) {
+			m.blobs[1] = m.blobs[1][:len(m.blobs[1])/2]
+		}, "config=ok, layer 0 blob=ok, layer 0 diffID=ok, layer 1 blob=mismatch, layer 1 diffID=failed"},
+		{"wrong diffID", func(m *memorySource) {
+			m.config = bytes.Replace(m.config, []byte(digest(m.blobs[0])), []byte(digest(nil)), 1)
+			m.descriptor = source.Descriptor{Digest: digest(m.config)}
+		}, "config=ok, layer 0 blob=ok, layer 0 diffID=mismatch, layer 1 blob=ok, layer 1 diffID=ok"},
+		{"tampered config", func(m *memorySource) {
+			m.config = append(m.config, ' ')
+		}, "config=mismatch, layer 0 blob=ok, layer 0 diffID=ok, layer 1 blob=ok, layer 1 diffID=ok"},
+		{"no digests", func(m *memorySource) {
+			m.descriptor = source.Descriptor{}
+			m.layers = []source.Descriptor{{}, {Digest: "md5:abc"}}
+		}, "config=unverified, layer 0 blob=unverified, layer 0 diffID=ok, layer 1 blob=unverified, layer 1 diffID=ok"},
+		{"missing layer", func(m *memorySource) {
+			m.layers = m.layers[:1]
+		}, "config=ok, layers=mismatch, layer 0 blob=ok, layer 0 diffID=ok"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src := newSource()
+			tc.change(src)
+			report, err := Image(context.Background(), src)
+			if err != nil {
+				t.Fatalf("Image() error = %v", err)
+			}
+			if got := statuses(report); got != tc.want {
+				t.Errorf("Image() = %s, want %s", got, tc.want)
+			}
+			if report.OK() != (report.Count(Mismatch)+report.Count(Failed) == 0) {
+				t.Errorf("OK() = %v with %d failures", report.OK(), len(report.Failures()))
+			}
+		})
+	}
+}
+
+func TestImageNestedIndex(t *testing.T) {
+	l := &signedLayout{dir: t.TempDir()}
+	index, nested := l.writeIndexed(t)
+
+	verifyLayout := func() string {
+		t.Helper()
+		src, err := source.Open("oci:" + l.dir + ":1.0")
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer src.Close()
+		report, err := Image(context.Background(), src)
+		if err != nil {
+			t.Fatalf("Image() error = %v", err)
+		}
+		return statuses(report)
+	}
+	if got := verifyLayout(); got != "index 0=ok, manifest=ok, config=ok, layer 0 blob=ok, layer 0 diffID=ok" {
+		t.Errorf("Image() = %s", got)
+	}
+
+	// An index rewritten in place still leads to a valid manifest
+	tampered := bytes.Replace(nested, []byte(`"schemaVersion":2`), []byte(`"schemaVersion": 2`), 1)
+	if err := os.WriteFile(filepath.Join(l.dir, "blobs", "sha256", strings.TrimPrefix(index, "sha256:")), tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyLayout(); got != "index 0=mismatch, manifest=ok, config=ok, layer 0 blob=ok, layer 0 diffID=ok" {
+		t.Errorf("Image() of a tampered index = %s", got)
+	}
+}
+
+func TestImageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Image(ctx, newSource()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Image() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	src := newSource()
+	src.blobs[0] = src.blobs[1]
+	report, err := Image(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := WriteText(&b, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "MISMATCH    layer 0 blob: expected "+digest(testimage.Layer(testimage.Reg("etc/os-release", "ID=alpine\n")))) ||
+		!strings.Contains(b.String(), "5 checked, 3 ok, 0 unverified, 2 failed") {
+		t.Errorf("WriteText() =\n%s", b.String())
+	}
+}
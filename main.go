@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/raesene/pasgan/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -24,10 +30,45 @@ It works by analyzing the JSON metadata in Docker images and reconstructing the
 build instructions.`,
 }
 
+// interruptGrace is how long an interrupted command has to stop on its own
+// before its temporary files are removed and pasgan exits
+const interruptGrace = 5 * time.Second
+
 // Execute adds all child commands to the root command and sets flags appropriately.
+// The first interrupt cancels the command, which stops and cleans up; a second
+// interrupt, or a command that does not stop in time, removes the temporary
+// directories and exits at once.
 func execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "Interrupted, cleaning up")
+		cancel()
+		select {
+		case <-signals:
+		case <-time.After(interruptGrace):
+		}
+		utils.RemoveTempDirs()
+		os.Exit(130)
+	}()
+	
+	err := rootCmd.ExecuteContext(ctx)
+	signal.Stop(signals)
+	stopTimeout()
+	utils.RemoveTempDirs()
+	
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			fmt.Fprintf(os.Stderr, "Error: timed out after %s: %s\n", timeout, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
 		os.Exit(1)
 	}
 }
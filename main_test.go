@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
 )
 
 // TestMainIntegration tests the complete workflow of the tool
@@ -65,4 +69,77 @@ func TestMainIntegration(t *testing.T) {
 			t.Logf("Successfully analyzed %s, Dockerfile generated at %s", tarFile, outputFile)
 		})
 	}
-}
\ No newline at end of file
+}
+// TestLintMatchesAnalyze checks that lint reports the lines of the Dockerfile
+// written by analyze with the same flags
+func TestLintMatchesAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	base := testimage.Layer(testimage.Dir("etc"), testimage.Reg("etc/os-release", "ID=debian\nVERSION_ID=12\nVERSION_CODENAME=bookworm\n"))
+	app := testimage.Layer(testimage.Dir("app"), testimage.Reg("app/server", "binary"))
+	config := `{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {"Cmd": ["/app/server"]},
+		"history": [
+			{"created_by": "/bin/sh -c #(nop) ADD file:0123 in / "},
+			{"created_by": "/bin/sh -c #(nop)  CMD [\"bash\"]", "empty_layer": true},
+			{"created_by": "RUN /bin/sh -c apt-get update && apt-get install -y curl # buildkit"},
+			{"created_by": "ADD https://example.com/server /app/server # buildkit"}
+		]
+	}`
+	archive := filepath.Join(dir, "image.tar")
+	data := testimage.Layer(
+		testimage.Reg("manifest.json", `[{"Config":"config.json","RepoTags":["example/app:1.0"],"Layers":["a/layer.tar","b/layer.tar","c/layer.tar"]}]`),
+		testimage.Reg("config.json", config),
+		testimage.Reg("a/layer.tar", string(base)),
+		testimage.Reg("b/layer.tar", string(app)),
+		testimage.Reg("c/layer.tar", string(app)),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerfilePath := filepath.Join(dir, "Dockerfile")
+	analyze := createAnalyzeCmd()
+	analyze.SetArgs([]string{archive, "--from-boundary", "1", "-o", dockerfilePath})
+	if err := analyze.Execute(); err != nil {
+		t.Fatalf("analyze error = %v", err)
+	}
+	lintPath := filepath.Join(dir, "lint.txt")
+	lint := createLintCmd()
+	lint.SetArgs([]string{archive, "--from-boundary", "1", "-o", lintPath})
+	if err := lint.Execute(); err != nil {
+		t.Fatalf("lint error = %v", err)
+	}
+	fromBoundary, outputFile, lintOutput = "", "", ""
+
+	dockerfile, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := os.ReadFile(lintPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(dockerfile), "\n")
+	reportLines := strings.Split(string(report), "\n")
+	checked := 0
+	for i, line := range reportLines {
+		var number int
+		if _, err := fmt.Sscanf(line, "Dockerfile:%d ", &number); err != nil || i+1 >= len(reportLines) {
+			continue
+		}
+		preview := strings.TrimSuffix(strings.TrimSpace(reportLines[i+1]), "...")
+		if number < 1 || number > len(lines) {
+			t.Errorf("lint reports line %d, the Dockerfile has %d lines", number, len(lines))
+			continue
+		}
+		if !strings.HasPrefix(preview, strings.Join(strings.Fields(strings.TrimSuffix(lines[number-1], "\\")), " ")) {
+			t.Errorf("lint reports %q at line %d, the Dockerfile has %q there:\n%s", preview, number, lines[number-1], dockerfile)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Errorf("lint reported no line findings:\n%s", report)
+	}
+}
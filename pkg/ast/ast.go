@@ -0,0 +1,269 @@
+// Package ast is a syntax tree for Dockerfiles. The generator builds its
+// reconstruction from these nodes, Parse reads existing Dockerfiles into
+// them and Format writes them back, so a Dockerfile can be changed
+// programmatically before it is written.
+//
+// Comments are attached to the instruction that follows them. Arguments are
+// kept as they are written, unexpanded: Env values may hold $VAR references,
+// and a literal dollar sign is written \$.
+package ast
+
+import "strings"
+
+// File is a Dockerfile
+type File struct {
+	// Directives are the parser directives at the top, such as syntax and escape
+	Directives []Directive
+	// Header is the comment block at the top that a blank line separates
+	// from the first instruction
+	Header []string
+	// Nodes are the instructions in order
+	Nodes []Node
+	// Footer is the comments after the last instruction
+	Footer []string
+}
+
+// Escape returns the escape character set by the escape directive, a
+// backslash by default
+func (f *File) Escape() rune {
+	for _, directive := range f.Directives {
+		if strings.EqualFold(directive.Name, "escape") && len(directive.Value) == 1 {
+			return rune(directive.Value[0])
+		}
+	}
+	return '\\'
+}
+
+// Directive is a parser directive, written # name=value
+type Directive struct {
+	Name  string
+	Value string
+}
+
+// Node is a Dockerfile instruction. The node types are the pointer types in
+// this package that embed Base.
+type Node interface {
+	// Keyword returns the instruction name in upper case, such as RUN
+	Keyword() string
+	base() *Base
+}
+
+// Base holds what every instruction has besides its arguments
+type Base struct {
+	// Blank is set if a blank line is written before the comments
+	Blank bool
+	// Comments are the comment lines written before the instruction, without
+	// the leading #
+	Comments []string
+}
+
+func (b *Base) base() *Base { return b }
+
+// CommentsOf returns the comments attached to node
+func CommentsOf(node Node) []string {
+	return node.base().Comments
+}
+
+// Attach adds comment lines before node, after those it already has
+func Attach(node Node, comments ...string) {
+	b := node.base()
+	b.Comments = append(b.Comments, comments...)
+}
+
+// Flag is an option such as --from=build, written without a value if Value
+// is empty
+type Flag struct {
+	Name  string
+	Value string
+}
+
+// Pair is a key and value of ENV or LABEL
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Heredoc is a here-document read by RUN, COPY or ADD. Its marker, such as
+// <<EOF, is part of the command or sources.
+type Heredoc struct {
+	// Name is the delimiter word
+	Name string
+	// Content is the text up to the delimiter line, ending with a newline
+	Content string
+	// Quoted is set if the delimiter was quoted, which disables expansion
+	Quoted bool
+	// Chomp is set for <<-, which strips leading tabs
+	Chomp bool
+}
+
+// Command is the command of RUN, CMD, ENTRYPOINT and HEALTHCHECK, in exec
+// form if Exec is not nil and in shell form otherwise
+type Command struct {
+	Exec []string
+	// Shell is the shell form. Each newline is written as a line continuation,
+	// except inside the bodies of here-documents that are not in Heredocs.
+	Shell string
+}
+
+// IsExec reports whether the command is in exec form
+func (c Command) IsExec() bool {
+	return c.Exec != nil
+}
+
+// From is a FROM instruction
+type From struct {
+	Base
+	Platform string
+	Image    string
+	// Name is the stage name given with AS
+	Name string
+}
+
+// Run is a RUN instruction
+type Run struct {
+	Base
+	Flags    []Flag
+	Command  Command
+	Heredocs []Heredoc
+}
+
+// Cmd is a CMD instruction
+type Cmd struct {
+	Base
+	Command Command
+}
+
+// Entrypoint is an ENTRYPOINT instruction
+type Entrypoint struct {
+	Base
+	Command Command
+}
+
+// Copy is a COPY instruction
+type Copy struct {
+	Base
+	Flags    []Flag
+	Sources  []string
+	Dest     string
+	Heredocs []Heredoc
+}
+
+// Add is an ADD instruction
+type Add struct {
+	Base
+	Flags    []Flag
+	Sources  []string
+	Dest     string
+	Heredocs []Heredoc
+}
+
+// Env is an ENV instruction
+type Env struct {
+	Base
+	Pairs []Pair
+}
+
+// Label is a LABEL instruction
+type Label struct {
+	Base
+	Pairs []Pair
+}
+
+// ArgVar is a variable declared by ARG
+type ArgVar struct {
+	Name string
+	// Default is the default value, used if HasDefault is set
+	Default    string
+	HasDefault bool
+}
+
+// Arg is an ARG instruction
+type Arg struct {
+	Base
+	Vars []ArgVar
+}
+
+// Expose is an EXPOSE instruction
+type Expose struct {
+	Base
+	Ports []string
+}
+
+// Workdir is a WORKDIR instruction
+type Workdir struct {
+	Base
+	Path string
+}
+
+// User is a USER instruction
+type User struct {
+	Base
+	User string
+}
+
+// Volume is a VOLUME instruction
+type Volume struct {
+	Base
+	Paths []string
+}
+
+// Shell is a SHELL instruction
+type Shell struct {
+	Base
+	Shell []string
+}
+
+// StopSignal is a STOPSIGNAL instruction
+type StopSignal struct {
+	Base
+	Signal string
+}
+
+// Healthcheck is a HEALTHCHECK instruction. None disables the health check
+// of the base image.
+type Healthcheck struct {
+	Base
+	None    bool
+	Flags   []Flag
+	Command Command
+}
+
+// Onbuild is an ONBUILD instruction
+type Onbuild struct {
+	Base
+	Node Node
+}
+
+// Maintainer is the deprecated MAINTAINER instruction
+type Maintainer struct {
+	Base
+	Name string
+}
+
+// Raw is an instruction kept as text, for unknown instructions and
+// arguments that could not be parsed
+type Raw struct {
+	Base
+	Name      string
+	Arguments string
+}
+
+func (*From) Keyword() string        { return "FROM" }
+func (*Run) Keyword() string         { return "RUN" }
+func (*Cmd) Keyword() string         { return "CMD" }
+func (*Entrypoint) Keyword() string  { return "ENTRYPOINT" }
+func (*Copy) Keyword() string        { return "COPY" }
+func (*Add) Keyword() string         { return "ADD" }
+func (*Env) Keyword() string         { return "ENV" }
+func (*Label) Keyword() string       { return "LABEL" }
+func (*Arg) Keyword() string         { return "ARG" }
+func (*Expose) Keyword() string      { return "EXPOSE" }
+func (*Workdir) Keyword() string     { return "WORKDIR" }
+func (*User) Keyword() string        { return "USER" }
+func (*Volume) Keyword() string      { return "VOLUME" }
+func (*Shell) Keyword() string       { return "SHELL" }
+func (*StopSignal) Keyword() string  { return "STOPSIGNAL" }
+func (*Healthcheck) Keyword() string { return "HEALTHCHECK" }
+func (*Onbuild) Keyword() string     { return "ONBUILD" }
+func (*Maintainer) Keyword() string  { return "MAINTAINER" }
+func (r *Raw) Keyword() string       { return strings.ToUpper(r.Name) }
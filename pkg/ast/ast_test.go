@@ -0,0 +1,191 @@
+package ast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, file *File) string {
+	t.Helper()
+	var b strings.Builder
+	if err := Format(&b, file); err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	return b.String()
+}
+
+func parse(t *testing.T, text string) *File {
+	t.Helper()
+	file, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return file
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"generated", "# Generated by Pasgan\n# This is a best-effort reconstruction and may require manual adjustments\n\n" +
+			"# Base image identified as node:20 from history\nFROM node:20\n" +
+			"RUN apt-get update \\\n    && apt-get install -y curl\n" +
+			"COPY . /app\nCMD [\"node\",\"server.js\"]\n"},
+		{"stages", "# syntax=docker/dockerfile:1\n\nFROM --platform=$BUILDPLATFORM golang:1.22 AS build\n" +
+			"ARG VERSION=1.0 TARGETOS\nWORKDIR /src\n" +
+			"RUN --mount=type=cache,target=/root/.cache/go-build go build -o /out/app .\n\n" +
+			"FROM gcr.io/distroless/static\nCOPY --from=build --chmod=755 /out/app /app\n" +
+			"USER 65532:65532\nEXPOSE 8080/tcp 9090\nENTRYPOINT [\"/app\"]\n"},
+		{"values", "FROM scratch\nENV PATH=/usr/local/bin:$PATH GREETING=\"hello world\" PRICE=\"\\$5\" EMPTY=\"\"\n" +
+			"LABEL \"org.example.key=odd\"=1 description=\"say \\\"hi\\\"\"\n"},
+		{"heredocs", "FROM alpine\nRUN <<EOF\nset -e\n# not a comment\napk add curl\nEOF\n" +
+			"COPY <<-\"CONF\" /etc/app.conf\n\tkey = $value\nCONF\n" +
+			"RUN python3 <<PY > /out \\\n    && cat /out\nprint(1)\nPY\n"},
+		{"others", "FROM alpine\nSHELL [\"/bin/ash\",\"-eo\",\"pipefail\",\"-c\"]\nVOLUME /data /cache\nVOLUME [\"/with space\"]\n" +
+			"STOPSIGNAL SIGTERM\nHEALTHCHECK --interval=30s CMD wget -q -O- localhost:8080\nHEALTHCHECK NONE\n" +
+			"ONBUILD COPY . /src\nMAINTAINER someone\nCMD []\nFROBNICATE the widget\n# trailing\n"},
+		{"newlines", "FROM scratch\nLABEL description=\"line one\\\nline two\" version=1\nARG GREETING=\"hello\\\nworld\"\n"},
+		{"escape", "# escape=`\n\nFROM mcr.microsoft.com/windows/servercore\nRUN dir C:\\ `\n    && echo done\nENV DIR=\"C:\\Program Files\" Q=\"a`\"b\"\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := parse(t, tc.text)
+			if got := format(t, file); got != tc.text {
+				t.Errorf("Format(Parse()) =\n%s\nwant\n%s", got, tc.text)
+			}
+			if again := parse(t, format(t, file)); !reflect.DeepEqual(again, file) {
+				t.Errorf("Parse(Format()) = %+v, want %+v", again, file)
+			}
+		})
+	}
+}
+
+func TestParseNodes(t *testing.T) {
+	file := parse(t, `# syntax=docker/dockerfile:1
+# Build stage
+from golang:1.22 as build
+run go build \
+    # comments inside continuations are dropped
+    -o /app .
+
+copy --from=build /app /srv/
+env LEGACY some value
+ARG FLAG
+CMD ["a", "b"]
+`)
+	want := []Node{
+		&From{Base: Base{Comments: []string{"Build stage"}}, Image: "golang:1.22", Name: "build"},
+		&Run{Command: Command{Shell: "go build\n-o /app ."}},
+		&Copy{Base: Base{Blank: true}, Flags: []Flag{{Name: "from", Value: "build"}}, Sources: []string{"/app"}, Dest: "/srv/"},
+		&Env{Pairs: []Pair{{Key: "LEGACY", Value: "some value"}}},
+		&Arg{Vars: []ArgVar{{Name: "FLAG"}}},
+		&Cmd{Command: Command{Exec: []string{"a", "b"}}},
+	}
+	if !reflect.DeepEqual(file.Nodes, want) {
+		t.Errorf("Parse() nodes = %#v, want %#v", file.Nodes, want)
+	}
+	if len(file.Directives) != 1 || file.Directives[0].Value != "docker/dockerfile:1" || file.Escape() != '\\' {
+		t.Errorf("Parse() directives = %v", file.Directives)
+	}
+	if lines := file.Lines(); !reflect.DeepEqual(lines, []int{3, 4, 7, 8, 9, 10}) {
+		t.Errorf("Lines() = %v, want the line of each keyword", lines)
+	}
+}
+
+func TestFormatNodes(t *testing.T) {
+	run := &Run{Command: Command{Shell: "apt-get update\n&& apt-get install -y curl"}}
+	Attach(run, "Install curl", "two\nlines")
+	file := &File{
+		Header: []string{"Generated"},
+		Nodes: []Node{
+			&From{Image: "debian:12"},
+			run,
+			&Env{Pairs: []Pair{{Key: "A", Value: "x y"}, {Key: "B", Value: `C:\dir`}}},
+			&Copy{Sources: []string{"my file"}, Dest: "/app/"},
+			&Raw{Name: "HEALTHCHECK", Arguments: "&{[CMD-SHELL true]}"},
+		},
+		Footer: []string{"CMD [\"...\"]"},
+	}
+	want := "# Generated\n\nFROM debian:12\n# Install curl\n# two\n# lines\n" +
+		"RUN apt-get update \\\n    && apt-get install -y curl\n" +
+		"ENV A=\"x y\" B=\"C:\\\\dir\"\nCOPY [\"my file\",\"/app/\"]\nHEALTHCHECK &{[CMD-SHELL true]}\n# CMD [\"...\"]\n"
+	if got := format(t, file); got != want {
+		t.Errorf("Format() =\n%s\nwant\n%s", got, want)
+	}
+	if got := FormatNode(run); got != "RUN apt-get update \\\n    && apt-get install -y curl" {
+		t.Errorf("FormatNode() = %q", got)
+	}
+	if got := CommentsOf(run); len(got) != 2 {
+		t.Errorf("CommentsOf() = %v", got)
+	}
+}
+
+func TestFormatValues(t *testing.T) {
+	label := &Label{Pairs: []Pair{{Key: "description", Value: "line one\nline two"}}}
+	text := FormatNode(label)
+	if text != "LABEL description=\"line one\\\nline two\"" {
+		t.Errorf("FormatNode() = %q, want the newline as a continuation", text)
+	}
+	node, err := ParseInstruction(text)
+	if err != nil {
+		t.Fatalf("ParseInstruction() error = %v", err)
+	}
+	if !reflect.DeepEqual(node, label) {
+		t.Errorf("ParseInstruction(FormatNode()) = %#v, want %#v", node, label)
+	}
+}
+
+func TestFormatInlineHeredocs(t *testing.T) {
+	run := &Run{Command: Command{Shell: "cat <<-EOF > /etc/motd\n\t  hello\n\tEOF"}}
+	text := FormatNode(run)
+	if text != "RUN cat <<-EOF > /etc/motd\n\t  hello\n\tEOF" {
+		t.Errorf("FormatNode() = %q, want the body without continuations", text)
+	}
+	node, err := ParseInstruction(text)
+	if err != nil {
+		t.Fatalf("ParseInstruction() error = %v", err)
+	}
+	want := &Run{Command: Command{Shell: "cat <<-EOF > /etc/motd"}, Heredocs: []Heredoc{{Name: "EOF", Content: "\t  hello\n", Chomp: true}}}
+	if !reflect.DeepEqual(node, want) {
+		t.Errorf("ParseInstruction(FormatNode()) = %#v, want %#v", node, want)
+	}
+}
+
+func TestParseInstruction(t *testing.T) {
+	node, err := ParseInstruction("ADD file:0123 in /")
+	if err != nil {
+		t.Fatalf("ParseInstruction() error = %v", err)
+	}
+	if add, ok := node.(*Add); !ok || add.Dest != "/" || len(add.Sources) != 2 {
+		t.Errorf("ParseInstruction() = %#v, want an ADD", node)
+	}
+	if node, err := ParseInstruction("HEALTHCHECK &{...}"); err == nil {
+		t.Errorf("ParseInstruction() = %#v, want an error", node)
+	}
+	if _, err := ParseInstruction("FROM a\nFROM b"); err == nil {
+		t.Error("ParseInstruction() of two instructions error = nil")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"FROM\n",
+		"FROM --output=x alpine\n",
+		"COPY onlyone\n",
+		"ENV A=\"unterminated\n",
+		"ENV A=1 B\n",
+		"SHELL /bin/sh\n",
+		"RUN <<EOF\nnever ends\n",
+		"ONBUILD FROM alpine\n",
+		"HEALTHCHECK --interval=1s\n",
+	}
+	for _, text := range tests {
+		if file, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", text, file.Nodes)
+		} else if !strings.HasPrefix(err.Error(), "line 1: ") {
+			t.Errorf("Parse(%q) error = %v, want the line", text, err)
+		}
+	}
+}
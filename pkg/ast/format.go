@@ -0,0 +1,278 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// continuationIndent is written at the start of continuation lines
+const continuationIndent = "    "
+
+// Format writes file as a Dockerfile
+func Format(w io.Writer, file *File) error {
+	p := newPrinter(file)
+	p.file(file)
+	_, err := io.WriteString(w, p.b.String())
+	return err
+}
+
+// FormatNode returns node as it is written in a Dockerfile, without its
+// comments, using the default escape character
+func FormatNode(node Node) string {
+	return formatNode(node, '\\')
+}
+
+// Lines returns the 1-based line on which each node starts when file is
+// formatted, after its comments
+func (f *File) Lines() []int {
+	p := newPrinter(f)
+	p.file(f)
+	return p.lines
+}
+
+// printer formats a file, counting lines as it goes
+type printer struct {
+	b      strings.Builder
+	escape rune
+	line   int
+	lines  []int
+}
+
+func newPrinter(file *File) *printer {
+	return &printer{escape: file.Escape()}
+}
+
+func (p *printer) writeLine(s string) {
+	p.b.WriteString(s)
+	p.b.WriteByte('\n')
+	p.line += strings.Count(s, "\n") + 1
+}
+
+func (p *printer) comments(comments []string) {
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			if line == "" {
+				p.writeLine("#")
+			} else {
+				p.writeLine("# " + line)
+			}
+		}
+	}
+}
+
+func (p *printer) file(f *File) {
+	for _, directive := range f.Directives {
+		p.writeLine("# " + directive.Name + "=" + directive.Value)
+	}
+	if len(f.Header) > 0 {
+		p.comments(f.Header)
+		p.writeLine("")
+	}
+	for _, node := range f.Nodes {
+		b := node.base()
+		if b.Blank {
+			p.writeLine("")
+		}
+		p.comments(b.Comments)
+		p.lines = append(p.lines, p.line+1)
+		p.writeLine(formatNode(node, p.escape))
+	}
+	p.comments(f.Footer)
+}
+
+// formatNode renders the instruction of node, with the here-documents it reads
+func formatNode(node Node, escape rune) string {
+	var words []string
+	var heredocs []Heredoc
+	switch n := node.(type) {
+	case *From:
+		if n.Platform != "" {
+			words = append(words, "--platform="+n.Platform)
+		}
+		words = append(words, n.Image)
+		if n.Name != "" {
+			words = append(words, "AS", n.Name)
+		}
+	case *Run:
+		// Bodies in the shell form are only kept as they are if Heredocs is empty
+		words = append(formatFlags(n.Flags), formatCommand(n.Command, escape, len(n.Heredocs) == 0))
+		heredocs = n.Heredocs
+	case *Cmd:
+		words = append(words, formatCommand(n.Command, escape, false))
+	case *Entrypoint:
+		words = append(words, formatCommand(n.Command, escape, false))
+	case *Copy:
+		words = append(formatFlags(n.Flags), formatPaths(append(append([]string{}, n.Sources...), n.Dest)))
+		heredocs = n.Heredocs
+	case *Add:
+		words = append(formatFlags(n.Flags), formatPaths(append(append([]string{}, n.Sources...), n.Dest)))
+		heredocs = n.Heredocs
+	case *Env:
+		words = formatPairs(n.Pairs, escape)
+	case *Label:
+		words = formatPairs(n.Pairs, escape)
+	case *Arg:
+		for _, v := range n.Vars {
+			if v.HasDefault {
+				words = append(words, v.Name+"="+quote(v.Default, escape, false))
+			} else {
+				words = append(words, v.Name)
+			}
+		}
+	case *Expose:
+		words = n.Ports
+	case *Workdir:
+		words = append(words, n.Path)
+	case *User:
+		words = append(words, n.User)
+	case *Volume:
+		words = append(words, formatPaths(n.Paths))
+	case *Shell:
+		words = append(words, jsonList(n.Shell))
+	case *StopSignal:
+		words = append(words, n.Signal)
+	case *Healthcheck:
+		if n.None {
+			words = append(words, "NONE")
+		} else {
+			words = append(formatFlags(n.Flags), "CMD", formatCommand(n.Command, escape, false))
+		}
+	case *Maintainer:
+		words = append(words, n.Name)
+	case *Onbuild:
+		if n.Node != nil {
+			words = append(words, formatNode(n.Node, escape))
+		}
+	case *Raw:
+		return strings.TrimRight(n.Name+" "+n.Arguments, " ")
+	}
+
+	var b strings.Builder
+	b.WriteString(node.Keyword())
+	for _, word := range words {
+		if word != "" {
+			b.WriteString(" " + word)
+		}
+	}
+	for _, heredoc := range heredocs {
+		b.WriteString("\n" + heredoc.Content)
+		if heredoc.Content != "" && !strings.HasSuffix(heredoc.Content, "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(heredoc.Name)
+	}
+	return b.String()
+}
+
+func formatFlags(flags []Flag) []string {
+	words := make([]string, 0, len(flags)+1)
+	for _, flag := range flags {
+		if flag.Value == "" {
+			words = append(words, "--"+flag.Name)
+		} else {
+			words = append(words, "--"+flag.Name+"="+flag.Value)
+		}
+	}
+	return words
+}
+
+// formatCommand writes the exec form as JSON and the lines of the shell
+// form as continuation lines. If inline is set, the here-document bodies
+// that follow a <<EOF marker in the shell form are written as they are, up
+// to their delimiter lines.
+func formatCommand(command Command, escape rune, inline bool) string {
+	if command.IsExec() {
+		return jsonList(command.Exec)
+	}
+	var b strings.Builder
+	var pending []Heredoc
+	for i, line := range strings.Split(command.Shell, "\n") {
+		if i > 0 {
+			if len(pending) > 0 {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" " + string(escape) + "\n" + continuationIndent)
+			}
+		}
+		b.WriteString(line)
+		if len(pending) > 0 {
+			if line == pending[0].Name || (pending[0].Chomp && strings.TrimLeft(line, "\t") == pending[0].Name) {
+				pending = pending[1:]
+			}
+			continue
+		}
+		if inline {
+			for _, match := range heredocRegex.FindAllStringSubmatch(line, -1) {
+				pending = append(pending, Heredoc{Name: match[3], Chomp: match[1] == "-"})
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatPaths writes paths separated by spaces, or as JSON if one has spaces
+func formatPaths(paths []string) string {
+	for _, path := range paths {
+		if path == "" || strings.ContainsAny(path, " \t\n") {
+			return jsonList(paths)
+		}
+	}
+	return strings.Join(paths, " ")
+}
+
+func formatPairs(pairs []Pair, escape rune) []string {
+	words := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		words = append(words, quote(pair.Key, escape, true)+"="+quote(pair.Value, escape, false))
+	}
+	return words
+}
+
+// quote double-quotes a key or value if it is empty or has spaces, quotes or
+// escapes. A \$ in the value stays an escaped dollar sign, and a newline is
+// written as a line continuation inside the quotes, which Parse reads back
+// as a newline. Blank lines in the value and the spaces around its lines
+// are not kept.
+func quote(s string, escape rune, key bool) string {
+	special := " \t\n\"'\\" + string(escape)
+	if key {
+		special += "="
+	}
+	if s != "" && !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range s {
+		switch {
+		case r == '\\' && strings.HasPrefix(s[i+1:], "$"):
+			b.WriteRune(escape)
+		case r == '"' || r == escape:
+			b.WriteRune(escape)
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteRune(escape)
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// jsonList renders an exec form or path list as JSON
+func jsonList(values []string) string {
+	if values == nil {
+		values = []string{}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(values); err != nil {
+		return "[]"
+	}
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,510 @@
+package ast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// directiveRegex matches a parser directive such as # syntax=docker/dockerfile:1
+var directiveRegex = regexp.MustCompile(`^#\s*(syntax|escape|check)\s*=\s*(.*?)\s*$`)
+
+// heredocRegex matches a here-document marker such as <<EOF, <<-EOF or <<"EOF"
+var heredocRegex = regexp.MustCompile(`<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+
+// Parse reads a Dockerfile. Comments are attached to the instruction that
+// follows them, and blank lines are kept only as the Blank flag of the next
+// instruction. Comments and blank lines between continuation lines are
+// dropped, as they are by Docker.
+func Parse(r io.Reader) (*File, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+
+	file := &File{}
+	p := &parser{lines: lines}
+	// Directives must come before anything else
+	for ; p.pos < len(lines); p.pos++ {
+		match := directiveRegex.FindStringSubmatch(strings.TrimSpace(lines[p.pos]))
+		if match == nil {
+			break
+		}
+		file.Directives = append(file.Directives, Directive{Name: match[1], Value: match[2]})
+	}
+	p.escape = file.Escape()
+
+	var comments []string
+	blank := false
+	for p.pos < len(lines) {
+		line := strings.TrimSpace(lines[p.pos])
+		switch {
+		case line == "":
+			p.pos++
+			// The first comment block, if followed by a blank line, is the header
+			if len(file.Nodes) == 0 && file.Header == nil && len(comments) > 0 {
+				file.Header, comments, blank = comments, nil, false
+				continue
+			}
+			blank = true
+			continue
+		case strings.HasPrefix(line, "#"):
+			comments = append(comments, commentText(line))
+			p.pos++
+			continue
+		}
+
+		node, err := p.instruction()
+		if err != nil {
+			return nil, err
+		}
+		b := node.base()
+		b.Blank, b.Comments = blank, comments
+		file.Nodes = append(file.Nodes, node)
+		comments, blank = nil, false
+	}
+	file.Footer = comments
+	return file, nil
+}
+
+// ParseInstruction parses a single instruction, which may continue over
+// several lines
+func ParseInstruction(text string) (Node, error) {
+	file, err := Parse(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nodes) != 1 || len(file.Directives) > 0 || len(file.Header) > 0 || len(file.Footer) > 0 || len(file.Nodes[0].base().Comments) > 0 {
+		return nil, fmt.Errorf("expected a single instruction in %q", text)
+	}
+	return file.Nodes[0], nil
+}
+
+// commentText returns a comment line without the # and the space after it
+func commentText(line string) string {
+	text := strings.TrimPrefix(line, "#")
+	return strings.TrimPrefix(text, " ")
+}
+
+// parser reads instructions line by line
+type parser struct {
+	lines  []string
+	pos    int
+	escape rune
+}
+
+// instruction reads the instruction starting on the current line, with its
+// continuation lines and here-documents
+func (p *parser) instruction() (Node, error) {
+	start := p.pos + 1
+	var segments []string
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		p.pos++
+		trimmed := strings.TrimSpace(line)
+		// Comments and blank lines inside a continued instruction are skipped
+		if len(segments) > 0 && (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		continued := strings.HasSuffix(trimmed, string(p.escape))
+		if continued {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, string(p.escape)))
+		}
+		segments = append(segments, trimmed)
+		if !continued {
+			break
+		}
+	}
+
+	keyword, rest := cutSpace(segments[0])
+	var args []string
+	if rest != "" {
+		args = append(args, rest)
+	}
+	for _, segment := range segments[1:] {
+		if segment != "" {
+			args = append(args, segment)
+		}
+	}
+	text := strings.Join(args, "\n")
+
+	var heredocs []Heredoc
+	switch strings.ToUpper(keyword) {
+	case "RUN", "COPY", "ADD":
+		if !strings.HasPrefix(text, "[") {
+			var err error
+			if heredocs, err = p.heredocs(text); err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+		}
+	}
+
+	node, err := parseNode(keyword, text, heredocs, p.escape)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", start, err)
+	}
+	return node, nil
+}
+
+// heredocs reads the content of the here-documents marked in text, in order
+func (p *parser) heredocs(text string) ([]Heredoc, error) {
+	var heredocs []Heredoc
+	for _, match := range heredocRegex.FindAllStringSubmatch(text, -1) {
+		if match[2] != match[4] {
+			return nil, fmt.Errorf("mismatched quotes in here-document %s", match[0])
+		}
+		heredoc := Heredoc{Name: match[3], Quoted: match[2] != "", Chomp: match[1] == "-"}
+		var content strings.Builder
+		for {
+			if p.pos >= len(p.lines) {
+				return nil, fmt.Errorf("here-document %s is not terminated", heredoc.Name)
+			}
+			line := p.lines[p.pos]
+			p.pos++
+			if line == heredoc.Name || (heredoc.Chomp && strings.TrimLeft(line, "\t") == heredoc.Name) {
+				break
+			}
+			content.WriteString(line + "\n")
+		}
+		heredoc.Content = content.String()
+		heredocs = append(heredocs, heredoc)
+	}
+	return heredocs, nil
+}
+
+// parseNode builds the node for an instruction. Newlines in text separate
+// continuation lines, which are kept in shell commands.
+func parseNode(keyword, text string, heredocs []Heredoc, escape rune) (Node, error) {
+	upper := strings.ToUpper(keyword)
+	line := strings.Join(strings.Fields(text), " ")
+	switch upper {
+	case "FROM":
+		flags, rest := splitFlags(text)
+		from := &From{}
+		for _, flag := range flags {
+			if flag.Name != "platform" {
+				return nil, fmt.Errorf("FROM does not support --%s", flag.Name)
+			}
+			from.Platform = flag.Value
+		}
+		fields := strings.Fields(rest)
+		switch {
+		case len(fields) == 1:
+		case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+			from.Name = fields[2]
+		default:
+			return nil, fmt.Errorf("FROM requires an image and an optional AS name, got %q", rest)
+		}
+		from.Image = fields[0]
+		return from, nil
+	case "RUN":
+		flags, rest := splitFlags(text)
+		if rest == "" {
+			return nil, fmt.Errorf("RUN requires a command")
+		}
+		return &Run{Flags: flags, Command: parseCommand(rest), Heredocs: heredocs}, nil
+	case "CMD":
+		return &Cmd{Command: parseCommand(text)}, nil
+	case "ENTRYPOINT":
+		return &Entrypoint{Command: parseCommand(text)}, nil
+	case "COPY", "ADD":
+		flags, rest := splitFlags(text)
+		paths := parseList(rest)
+		if len(paths) < 2 {
+			return nil, fmt.Errorf("%s requires a source and a destination", upper)
+		}
+		sources, dest := paths[:len(paths)-1], paths[len(paths)-1]
+		if upper == "ADD" {
+			return &Add{Flags: flags, Sources: sources, Dest: dest, Heredocs: heredocs}, nil
+		}
+		return &Copy{Flags: flags, Sources: sources, Dest: dest, Heredocs: heredocs}, nil
+	case "ENV", "LABEL":
+		pairs, err := parsePairs(text, escape)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", upper, err)
+		}
+		if upper == "LABEL" {
+			return &Label{Pairs: pairs}, nil
+		}
+		return &Env{Pairs: pairs}, nil
+	case "ARG":
+		words, err := splitWords(text, escape)
+		if err != nil {
+			return nil, fmt.Errorf("ARG: %w", err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("ARG requires a name")
+		}
+		arg := &Arg{}
+		for _, word := range words {
+			i := indexUnquoted(word, '=', escape)
+			if i < 0 {
+				arg.Vars = append(arg.Vars, ArgVar{Name: word})
+				continue
+			}
+			value, err := unquote(word[i+1:], escape)
+			if err != nil {
+				return nil, fmt.Errorf("ARG: %w", err)
+			}
+			arg.Vars = append(arg.Vars, ArgVar{Name: word[:i], Default: value, HasDefault: true})
+		}
+		return arg, nil
+	case "EXPOSE":
+		return &Expose{Ports: strings.Fields(line)}, nil
+	case "VOLUME":
+		return &Volume{Paths: parseList(line)}, nil
+	case "SHELL":
+		var shell []string
+		if err := json.Unmarshal([]byte(line), &shell); err != nil || len(shell) == 0 {
+			return nil, fmt.Errorf("SHELL requires a JSON array, got %q", line)
+		}
+		return &Shell{Shell: shell}, nil
+	case "WORKDIR", "USER", "STOPSIGNAL", "MAINTAINER":
+		if line == "" {
+			return nil, fmt.Errorf("%s requires an argument", upper)
+		}
+		switch upper {
+		case "WORKDIR":
+			return &Workdir{Path: line}, nil
+		case "USER":
+			return &User{User: line}, nil
+		case "STOPSIGNAL":
+			return &StopSignal{Signal: line}, nil
+		}
+		return &Maintainer{Name: line}, nil
+	case "HEALTHCHECK":
+		flags, rest := splitFlags(text)
+		if strings.EqualFold(strings.TrimSpace(rest), "NONE") && len(flags) == 0 {
+			return &Healthcheck{None: true}, nil
+		}
+		word, command := cutSpace(rest)
+		if !strings.EqualFold(word, "CMD") || strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("HEALTHCHECK requires NONE or CMD and a command, got %q", rest)
+		}
+		return &Healthcheck{Flags: flags, Command: parseCommand(command)}, nil
+	case "ONBUILD":
+		inner, rest := cutSpace(text)
+		switch strings.ToUpper(inner) {
+		case "", "ONBUILD", "FROM", "MAINTAINER":
+			return nil, fmt.Errorf("ONBUILD cannot trigger %q", inner)
+		}
+		node, err := parseNode(inner, rest, heredocs, escape)
+		if err != nil {
+			return nil, fmt.Errorf("ONBUILD: %w", err)
+		}
+		return &Onbuild{Node: node}, nil
+	}
+	return &Raw{Name: keyword, Arguments: text}, nil
+}
+
+// cutSpace splits text at the first space, trimming both parts
+func cutSpace(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+// splitFlags returns the leading --name=value flags and the rest of text
+func splitFlags(text string) ([]Flag, string) {
+	var flags []Flag
+	rest := strings.TrimLeftFunc(text, unicode.IsSpace)
+	for strings.HasPrefix(rest, "--") {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		name, value, _ := strings.Cut(rest[2:end], "=")
+		flags = append(flags, Flag{Name: name, Value: value})
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return flags, rest
+}
+
+// parseCommand reads a JSON array as the exec form, and anything else as
+// the shell form
+func parseCommand(text string) Command {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") {
+		var exec []string
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(text, "\n", " ")), &exec); err == nil {
+			if exec == nil {
+				exec = []string{}
+			}
+			return Command{Exec: exec}
+		}
+	}
+	return Command{Shell: text}
+}
+
+// parseList reads a JSON array, or words separated by spaces
+func parseList(text string) []string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(text, "\n", " ")), &list); err == nil {
+			return list
+		}
+	}
+	return strings.Fields(text)
+}
+
+// parsePairs reads key=value pairs, or the legacy key value form. A line
+// continuation inside quotes is kept as a newline in the value.
+func parsePairs(text string, escape rune) ([]Pair, error) {
+	words, err := splitWords(text, escape)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no key=value pairs")
+	}
+	if indexUnquoted(words[0], '=', escape) < 0 {
+		key, err := unquote(words[0], escape)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.Join(strings.Fields(text), " ")
+		value := strings.TrimSpace(strings.TrimPrefix(line, words[0]))
+		if value == "" {
+			return nil, fmt.Errorf("%q has no value", key)
+		}
+		return []Pair{{Key: key, Value: value}}, nil
+	}
+
+	pairs := make([]Pair, 0, len(words))
+	for _, word := range words {
+		i := indexUnquoted(word, '=', escape)
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not key=value", word)
+		}
+		key, err := unquote(word[:i], escape)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unquote(word[i+1:], escape)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, Pair{Key: key, Value: value})
+	}
+	return pairs, nil
+}
+
+// splitWords splits text on spaces outside quotes, keeping the quotes
+func splitWords(text string, escape rune) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	escaped, inWord := false, false
+	for _, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case r == escape && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(r)
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", text)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// indexUnquoted returns the index of the first c outside quotes in word, or -1
+func indexUnquoted(word string, c rune, escape rune) int {
+	var quote rune
+	escaped := false
+	for i, r := range word {
+		switch {
+		case escaped:
+			escaped = false
+		case r == escape && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// unquote removes the quotes and escapes from a word, except that an
+// escaped dollar sign is kept as \$ so it stays distinct from a variable
+func unquote(word string, escape rune) (string, error) {
+	var b strings.Builder
+	var quote rune
+	runes := []rune(word)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == escape && i+1 < len(runes):
+			next := runes[i+1]
+			if quote == '"' && next != '"' && next != '$' && next != escape {
+				// Inside double quotes only quotes, dollars and escapes are escaped
+				b.WriteRune(r)
+				continue
+			}
+			i++
+			if next == '$' {
+				b.WriteString(`\$`)
+			} else {
+				b.WriteRune(next)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated quote in %q", word)
+	}
+	return b.String(), nil
+}
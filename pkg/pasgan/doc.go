@@ -0,0 +1,21 @@
+// Package pasgan is the Go API of Pasgan: it reconstructs how a container
+// image was built, as the pasgan analyze command does, without shelling out
+// to the command line tool.
+//
+// Analyze reads an image from a source.ImageSource, AnalyzeURI from a path or
+// source URI such as oci:layout:tag, and AnalyzeMetadata from image metadata
+// alone, such as docker image inspect output read with ParseMetadata. The
+// Result holds the metadata, the reconstructed instructions and whatever was
+// detected along the way, and writes the Dockerfile, the native build
+// configuration or the metadata JSON. The Dockerfile is an ast.File that can
+// be changed before it is written.
+//
+// # Compatibility
+//
+// This package, pkg/source and pkg/ast follow semantic versioning: within a major
+// version, exported identifiers are not removed or changed incompatibly.
+// New fields, options and functions may be added in minor versions, so
+// construct Options and Result with field names. The guarantee does not cover
+// the internal packages, nor the text of warnings and generated comments:
+// these may change in any release.
+package pasgan
@@ -0,0 +1,147 @@
+package pasgan_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/raesene/pasgan/pkg/ast"
+	"github.com/raesene/pasgan/pkg/pasgan"
+	"github.com/raesene/pasgan/pkg/source"
+)
+
+// storeImage is an image held in memory, standing in for an artifact store
+type storeImage struct {
+	config []byte
+	layers [][]byte
+}
+
+func (s *storeImage) Config() ([]byte, error) { return s.config, nil }
+
+func (s *storeImage) Layers() ([]source.Descriptor, error) {
+	descriptors := make([]source.Descriptor, len(s.layers))
+	for i, layer := range s.layers {
+		descriptors[i] = source.Descriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar",
+			Digest:    fmt.Sprintf("store:layer-%d", i),
+			Size:      int64(len(layer)),
+		}
+	}
+	return descriptors, nil
+}
+
+func (s *storeImage) OpenLayer(i int) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.layers[i])), nil
+}
+
+func (s *storeImage) Annotations() map[string]string { return nil }
+func (s *storeImage) Platform() source.Platform {
+	return source.Platform{OS: "linux", Architecture: "amd64"}
+}
+func (s *storeImage) Close() error { return nil }
+
+// layerTar returns a layer holding files
+func layerTar(files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))})
+		tw.Write([]byte(body))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+var config = []byte(`{
+	"architecture": "amd64",
+	"os": "linux",
+	"config": {"Env": ["PATH=/usr/bin"], "WorkingDir": "/app", "Cmd": ["./server"]},
+	"history": [
+		{"created_by": "/bin/sh -c #(nop) ADD file:4b2c1f9e in / "},
+		{"created_by": "/bin/sh -c #(nop)  CMD [\"/bin/sh\"]", "empty_layer": true},
+		{"created_by": "WORKDIR /app", "empty_layer": true},
+		{"created_by": "COPY server /app/server # buildkit"},
+		{"created_by": "CMD [\"./server\"]", "empty_layer": true}
+	]
+}`)
+
+// Analyze reads an image from any source, here one held in memory. Sources
+// can also be registered under a URI scheme with source.Register.
+func ExampleAnalyze() {
+	image := &storeImage{
+		config: config,
+		layers: [][]byte{
+			layerTar(map[string]string{"etc/os-release": "ID=alpine\nVERSION_ID=3.19.1\n"}),
+			layerTar(map[string]string{"app/server": "binary"}),
+		},
+	}
+
+	result, err := pasgan.Analyze(context.Background(), image, pasgan.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Distribution:", result.Distro)
+
+	// The Dockerfile is a syntax tree that can be changed before it is written
+	for _, node := range result.Dockerfile.Nodes {
+		if from, ok := node.(*ast.From); ok {
+			from.Image = "alpine:" + result.Distro.Version
+		}
+	}
+	if err := result.WriteDockerfile(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// Distribution: alpine 3.19.1
+	// # Generated by Pasgan
+	// # This is a best-effort reconstruction and may require manual adjustments
+	//
+	// # Base image not recorded in the history; detected alpine 3.19.1 from /etc/os-release
+	// # The following official image is a plausible base, but the exact tag may differ
+	// # 2 history entries of the base image were omitted (root filesystem of the base image):
+	// #   ADD file:4b2c1f9e in /
+	// #   CMD ["/bin/sh"]
+	// FROM alpine:3.19.1
+	// WORKDIR /app
+	// COPY server /app/server
+	// CMD ["./server"]
+}
+
+// AnalyzeMetadata works from docker history output when the image itself is
+// not available
+func ExampleAnalyzeMetadata() {
+	history := []byte(`{"CreatedAt":"2024-03-01T10:00:00Z","CreatedBy":"CMD [\"node\" \"server.js\"]","Size":"0B"}
+{"CreatedAt":"2024-03-01T09:59:00Z","CreatedBy":"RUN /bin/sh -c npm ci --omit=dev # buildkit","Size":"42MB"}
+{"CreatedAt":"2024-03-01T09:58:00Z","CreatedBy":"COPY . /app # buildkit","Size":"1.2MB"}
+{"CreatedAt":"2024-02-20T08:00:00Z","CreatedBy":"/bin/sh -c #(nop) ADD file:1d2f3e in / ","Size":"7.4MB"}`)
+
+	metadata, kind, err := pasgan.ParseMetadata(history)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := pasgan.AnalyzeMetadata(context.Background(), metadata, pasgan.Options{Lint: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Read", kind, "output")
+	if err := result.WriteDockerfile(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	for _, finding := range result.LintFindings {
+		fmt.Printf("%s: %s\n", finding.RuleID, finding.Message)
+	}
+	// Output:
+	// Read docker history output
+	// # Generated by Pasgan
+	// # This is a best-effort reconstruction and may require manual adjustments
+	//
+	// ADD file:1d2f3e in /
+	// COPY . /app
+	// RUN /bin/sh -c npm ci --omit=dev
+	// CMD ["node","server.js"]
+	// PG001: No USER is set, so containers run as root
+}
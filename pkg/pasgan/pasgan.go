@@ -0,0 +1,385 @@
+package pasgan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/raesene/pasgan/internal/builder"
+	"github.com/raesene/pasgan/internal/distro"
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockercommit"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/efficiency"
+	"github.com/raesene/pasgan/internal/fingerprint"
+	"github.com/raesene/pasgan/internal/layer"
+	"github.com/raesene/pasgan/internal/lint"
+	"github.com/raesene/pasgan/internal/rootfs"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/raesene/pasgan/internal/verify"
+	"github.com/raesene/pasgan/pkg/ast"
+	"github.com/raesene/pasgan/pkg/source"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// ErrNoNative is returned by Result.WriteNative when the builder has no
+// configuration format of its own
+var ErrNoNative = builder.ErrNoNative
+
+// ErrLimitExceeded is returned, wrapped, when an image archive is larger
+// than Options.Limits allow
+var ErrLimitExceeded = utils.ErrLimitExceeded
+
+// ErrVerificationFailed is returned, wrapped, when Options.Verify is set and
+// a blob of the image does not match its digest
+var ErrVerificationFailed = errors.New("image verification failed")
+
+// Options controls an analysis. The zero value analyzes the image as the
+// analyze command does without flags.
+type Options struct {
+	// FromBoundary omits the base image history up to a boundary: "auto", a
+	// number of base layers, or the diffID of the last base layer. The full
+	// history is kept if it is empty.
+	FromBoundary string
+	// Redact masks detected secrets in the metadata and instructions
+	Redact bool
+	// Lint runs the lint rules over the reconstructed instructions
+	Lint bool
+	// Secrets scans the metadata and, when the layers are available, the
+	// layer files for secrets
+	Secrets bool
+	// Efficiency scores the space wasted across layers, when they are available
+	Efficiency bool
+	// EOLTable replaces the embedded end-of-life table if set
+	EOLTable *EOLTable
+	// Fingerprints replaces the embedded base image fingerprints if set
+	Fingerprints *Fingerprints
+	// Now is when the support status is evaluated, the current time if zero
+	Now time.Time
+	// Limits bound what AnalyzeURI extracts from image archives. Zero fields
+	// take the defaults of DefaultLimits.
+	Limits Limits
+	// Verify checks every blob of the image against its digest before it is
+	// analyzed, and fails with ErrVerificationFailed if one does not match
+	Verify bool
+}
+
+// Result is the outcome of an analysis
+type Result struct {
+	// Metadata is the image metadata, with secrets masked if Options.Redact is set
+	Metadata *Metadata
+	// Dockerfile is the reconstructed Dockerfile. Changes to it are written
+	// by WriteDockerfile.
+	Dockerfile *ast.File
+	// Instructions are the instructions of Dockerfile, sharing its nodes,
+	// with the history entries they came from
+	Instructions []Instruction
+	// Distro is the distribution detected in the filesystem, nil if unknown
+	Distro *Release
+	// Support is the end-of-life status of Distro, nil if unknown
+	Support *SupportStatus
+	// BaseImage is the official image proposed for Distro
+	BaseImage string
+	// Fingerprint is the official base image identified from the history
+	Fingerprint *BaseImageMatch
+	// Builder is the tool that built the image, nil for flat filesystems
+	Builder *Builder
+	// Commits are the layers created by docker commit
+	Commits []*CommittedLayer
+	// RootFS is the evidence read from a flat docker export filesystem
+	RootFS *RootFSEvidence
+	// LintFindings are set when Options.Lint is
+	LintFindings []LintFinding
+	// SecretFindings and SecretFileFindings are set when Options.Secrets is
+	SecretFindings     []SecretFinding
+	SecretFileFindings []SecretFileFinding
+	// Efficiency is set when Options.Efficiency is and the layers are available
+	Efficiency *EfficiencyReport
+	// Verification is set when Options.Verify is
+	Verification *VerifyReport
+	// Warnings describe what could not be analyzed
+	Warnings []string
+}
+
+// Analyze reads the image from src and reconstructs how it was built. It
+// does not close src.
+func Analyze(ctx context.Context, src source.ImageSource, opts Options) (*Result, error) {
+	parser := docker.NewSourceParser(src)
+	metadata, err := parser.ParseContext(ctx, source.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image: %w", err)
+	}
+	return analyze(ctx, metadata, parser, opts)
+}
+
+// AnalyzeURI reads the image at uri, a path or a source URI such as
+// oci:layout:tag, and reconstructs how it was built. Paths may also be flat
+// filesystems written by docker export.
+func AnalyzeURI(ctx context.Context, uri string, opts Options) (*Result, error) {
+	parser, err := docker.NewParser(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parser: %w", err)
+	}
+	defer parser.Cleanup()
+
+	metadata, err := parser.ParseContext(ctx, source.Options{Limits: utils.Limits(opts.Limits)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image: %w", err)
+	}
+	return analyze(ctx, metadata, parser, opts)
+}
+
+// AnalyzeMetadata reconstructs how an image was built from its metadata
+// alone. The distribution, packages, files, committed layers and builders
+// recognised from files are not analyzed.
+func AnalyzeMetadata(ctx context.Context, metadata *Metadata, opts Options) (*Result, error) {
+	if metadata == nil {
+		return nil, fmt.Errorf("no metadata provided")
+	}
+	return analyze(ctx, metadata.internal(), nil, opts)
+}
+
+// analyze runs the analysis of the analyze command. parser is nil when only
+// the metadata is available.
+func analyze(ctx context.Context, metadata *docker.ImageMetadata, parser *docker.Parser, opts Options) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var opener layer.Opener
+	if parser != nil {
+		opener = parser
+	}
+	original := metadata
+
+	// Check the image is the one its digests describe before trusting it
+	var verification *verify.Report
+	if opts.Verify {
+		var err error
+		verification, err = verifyImage(ctx, metadata, parser)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Mask secrets before anything is generated
+	if opts.Redact {
+		metadata = secrets.NewScanner().Redact(metadata)
+	}
+	result := &Result{Metadata: newMetadata(metadata), Verification: newVerifyReport(verification)}
+	options := &dockerfile.Options{}
+	if parser != nil {
+		for _, skipped := range parser.Skipped() {
+			result.warn("skipped %s in the image archive: %s", skipped.Path, skipped.Reason)
+		}
+	}
+
+	table := distro.DefaultTable()
+	if opts.EOLTable != nil {
+		table = opts.EOLTable.table
+	}
+	database := fingerprint.DefaultDatabase()
+	if opts.Fingerprints != nil {
+		database = opts.Fingerprints.database
+	}
+
+	// Detect the distribution, used to propose a base image
+	if opener != nil {
+		view, err := layer.NewView(opener, len(metadata.Layers))
+		if err != nil {
+			result.warn("could not detect the distribution: failed to read layers: %v", err)
+		} else if release := distro.Detect(view); release != nil {
+			options.Distro = release
+			options.BaseImage = table.BaseImage(release)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Identify an official base image whose history can be collapsed into one FROM
+	options.Fingerprint = database.Identify(metadata, options.Distro)
+
+	var err error
+	if metadata.Flat {
+		// docker export keeps only the filesystem, so everything is guessed from files
+		result.warn("the image is a flat filesystem with no metadata, the Dockerfile is a low-confidence guess")
+		options.RootFS, err = rootfs.Analyze(opener)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze the filesystem: %w", err)
+		}
+	} else {
+		// Recognise builders such as Jib and ko that do not use a Dockerfile
+		options.Builder, err = builder.Detect(metadata, opener)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect the builder: %w", err)
+		}
+
+		// Find layers made by docker commit, which record no instructions
+		if opener != nil && !options.Builder.Dockerless() {
+			options.Commits, err = dockercommit.Detect(metadata, opener)
+			if err != nil {
+				return nil, fmt.Errorf("failed to detect committed layers: %w", err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Cut the history at the end of the base image if asked to
+	if opts.FromBoundary != "" {
+		options.Boundary, err = dockerfile.ParseBoundary(opts.FromBoundary, metadata, options.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if options.Boundary == nil {
+			result.warn("no base image boundary detected, keeping the full history")
+		}
+	}
+
+	result.Distro = (*Release)(options.Distro)
+	result.BaseImage = options.BaseImage
+	result.Fingerprint = (*BaseImageMatch)(options.Fingerprint)
+	result.Builder = newBuilder(options.Builder)
+	result.Commits = newCommittedLayers(options.Commits)
+	result.RootFS = newRootFSEvidence(options.RootFS)
+	if result.Distro != nil {
+		now := opts.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if status, ok := table.Lookup(options.Distro, now); ok {
+			result.Support = (*SupportStatus)(&status)
+		}
+	}
+	var instructions []dockerfile.Instruction
+	result.Dockerfile, instructions = dockerfile.NewGeneratorWithOptions(metadata, *options).File()
+	result.Instructions = newInstructions(instructions)
+
+	if err := result.findings(ctx, original, instructions, opener, opts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Verify checks the manifest, config and layers of the image read from src
+// against their digests. Mismatches are reported, not returned as errors.
+func Verify(ctx context.Context, src source.ImageSource) (*VerifyReport, error) {
+	report, err := verify.Image(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifyReport(report), nil
+}
+
+// verifyImage verifies the image read by parser for Options.Verify
+func verifyImage(ctx context.Context, metadata *docker.ImageMetadata, parser *docker.Parser) (*verify.Report, error) {
+	if parser == nil {
+		return nil, fmt.Errorf("image metadata alone cannot be verified, the image blobs are needed")
+	}
+	if metadata.Flat {
+		return nil, fmt.Errorf("a flat filesystem cannot be verified, it records no digests")
+	}
+	report, err := verify.Image(ctx, parser.Source())
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify image: %w", err)
+	}
+	if failures := report.Failures(); len(failures) > 0 {
+		messages := make([]string, 0, len(failures))
+		for _, check := range failures {
+			messages = append(messages, check.String())
+		}
+		return nil, fmt.Errorf("%w: %s", ErrVerificationFailed, strings.Join(messages, "; "))
+	}
+	return report, nil
+}
+
+// findings runs the optional lint, secret and efficiency checks
+func (r *Result) findings(ctx context.Context, original *docker.ImageMetadata, instructions []dockerfile.Instruction, opener layer.Opener, opts Options) error {
+	if opts.Lint {
+		var sizes []int64
+		if opener != nil {
+			var err error
+			sizes, err = layer.Sizes(opener, len(original.Layers))
+			if err != nil {
+				return fmt.Errorf("failed to read layers: %w", err)
+			}
+		}
+		// The secret rule sees the original metadata through the context
+		r.LintFindings = newLintFindings(lint.Run(lint.NewContext(original, instructions, sizes), lint.DefaultRules()))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Secrets {
+		scanner := secrets.NewScanner()
+		r.SecretFindings = newSecretFindings(scanner.Scan(original))
+		if opener != nil {
+			// Use redacted metadata so the reported instructions do not leak secrets
+			findings, err := secrets.NewFileScanner().ScanLayers(opener, scanner.Redact(original))
+			if err != nil {
+				return fmt.Errorf("failed to scan layers: %w", err)
+			}
+			r.SecretFileFindings = newSecretFileFindings(findings)
+		} else {
+			r.warn("the layers are not available, only the metadata was scanned for secrets")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Efficiency {
+		if opener == nil {
+			r.warn("the layers are not available, efficiency was not analyzed")
+			return nil
+		}
+		report, err := efficiency.Analyze(opener, original)
+		if err != nil {
+			return fmt.Errorf("failed to analyze efficiency: %w", err)
+		}
+		r.Efficiency = newEfficiencyReport(report)
+	}
+	return nil
+}
+
+func (r *Result) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// WriteDockerfile writes the reconstructed Dockerfile
+func (r *Result) WriteDockerfile(w io.Writer) error {
+	if r.Dockerfile == nil {
+		return fmt.Errorf("no Dockerfile reconstructed")
+	}
+	return ast.Format(w, r.Dockerfile)
+}
+
+// WriteNative writes the configuration of the tool that built the image, such
+// as a Jib plugin section or a project.toml. It returns ErrNoNative for images
+// built from a Dockerfile.
+func (r *Result) WriteNative(w io.Writer) error {
+	if r.Builder == nil {
+		return fmt.Errorf("the native format needs the image metadata, which a flat filesystem does not have")
+	}
+	baseImage := r.BaseImage
+	if r.Fingerprint != nil {
+		baseImage = r.Fingerprint.Image
+	}
+	return builder.WriteNative(w, r.Builder.internal(), r.Metadata.internal(), baseImage)
+}
+
+// WriteJSON writes the image metadata as indented JSON
+func (r *Result) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r.Metadata); err != nil {
+		return fmt.Errorf("failed to encode metadata as JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package pasgan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+const testConfig = `{
+	"architecture": "amd64",
+	"os": "linux",
+	"config": {"Env": ["PATH=/usr/bin", "AWS_SECRET_ACCESS_KEY=wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"], "Cmd": ["/app/server"]},
+	"history": [
+		{"created_by": "/bin/sh -c #(nop) ADD file:0123 in / "},
+		{"created_by": "COPY . /app # buildkit"},
+		{"created_by": "RUN /bin/sh -c rm -rf /app/cache # buildkit"}
+	]
+}`
+
+// writeImage writes a docker save archive whose last layer deletes a cache
+// left by the layer before, with a secret in the environment and in a file
+func writeImage(t *testing.T) string {
+	t.Helper()
+	base := testimage.Layer(testimage.Dir("etc"), testimage.Reg("etc/os-release", "ID=debian\nVERSION_ID=12\nVERSION_CODENAME=bookworm\n"))
+	app := testimage.Layer(
+		testimage.Dir("app"),
+		testimage.Reg("app/server", "binary"),
+		testimage.Reg("app/cache/blob", strings.Repeat("x", 4096)),
+		testimage.Reg("app/.env", "AWS_SECRET_ACCESS_KEY=wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY\n"),
+	)
+	cleanup := testimage.Layer(testimage.Whiteout("app/cache"))
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	data := testimage.Layer(
+		testimage.Reg("manifest.json", `[{"Config":"config.json","RepoTags":["example/app:1.0"],"Layers":["a/layer.tar","b/layer.tar","c/layer.tar"]}]`),
+		testimage.Reg("config.json", testConfig),
+		testimage.Reg("a/layer.tar", string(base)),
+		testimage.Reg("b/layer.tar", string(app)),
+		testimage.Reg("c/layer.tar", string(cleanup)),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestAnalyzeURI(t *testing.T) {
+	archive := writeImage(t)
+	result, err := AnalyzeURI(context.Background(), archive, Options{Lint: true, Secrets: true, Efficiency: true})
+	if err != nil {
+		t.Fatalf("AnalyzeURI() error = %v", err)
+	}
+
+	if result.Distro == nil || result.Distro.ID != "debian" || result.BaseImage == "" || result.Support == nil {
+		t.Errorf("AnalyzeURI() distro = %v, base image %q, support %v, want debian 12", result.Distro, result.BaseImage, result.Support)
+	}
+	if result.Builder == nil || result.Builder.Kind != BuilderDockerfile || len(result.Instructions) == 0 {
+		t.Errorf("AnalyzeURI() = builder %v, %d instructions, want a Dockerfile build", result.Builder, len(result.Instructions))
+	}
+	if len(result.LintFindings) == 0 {
+		t.Error("AnalyzeURI() found no lint findings, want at least the missing USER")
+	}
+	if len(result.SecretFindings) == 0 || len(result.SecretFileFindings) == 0 {
+		t.Errorf("AnalyzeURI() secrets = %d in metadata, %d in files, want both", len(result.SecretFindings), len(result.SecretFileFindings))
+	}
+	if result.Efficiency == nil || result.Efficiency.WastedSize < 4096 || result.Efficiency.Wasted[0].Reason != WasteDeleted {
+		t.Errorf("AnalyzeURI() efficiency = %+v, want the deleted cache as waste", result.Efficiency)
+	}
+
+	var dockerfile bytes.Buffer
+	if err := result.WriteDockerfile(&dockerfile); err != nil {
+		t.Fatalf("WriteDockerfile() error = %v", err)
+	}
+	if !strings.Contains(dockerfile.String(), "FROM debian:12-slim") || !strings.Contains(dockerfile.String(), "COPY . /app") {
+		t.Errorf("WriteDockerfile() = %s, want the proposed base image and the instructions", dockerfile.String())
+	}
+	if err := result.WriteNative(&bytes.Buffer{}); !errors.Is(err, ErrNoNative) {
+		t.Errorf("WriteNative() error = %v, want ErrNoNative for a Dockerfile build", err)
+	}
+}
+
+func TestAnalyzeTables(t *testing.T) {
+	table, err := LoadEOLTable(strings.NewReader(`{"debian": {"image": "mirror.example.com/debian:{major}", "cycles": [{"cycle": "12", "eol": "2026-06-10"}]}}`))
+	if err != nil {
+		t.Fatalf("LoadEOLTable() error = %v", err)
+	}
+	merged := DefaultEOLTable()
+	merged.Merge(table)
+	if _, err := LoadFingerprints(strings.NewReader(`[{"name": "broken"}]`)); err == nil {
+		t.Error("LoadFingerprints() error = nil, want an error for a fingerprint without a repository")
+	}
+
+	result, err := AnalyzeURI(context.Background(), writeImage(t), Options{EOLTable: merged, Fingerprints: DefaultFingerprints()})
+	if err != nil {
+		t.Fatalf("AnalyzeURI() error = %v", err)
+	}
+	if result.BaseImage != "mirror.example.com/debian:12" || result.Support == nil || result.Support.EOL != "2026-06-10" {
+		t.Errorf("AnalyzeURI() base image = %q, support %+v, want the merged table", result.BaseImage, result.Support)
+	}
+}
+
+func TestAnalyzeLimits(t *testing.T) {
+	archive := writeImage(t)
+	if _, err := AnalyzeURI(context.Background(), archive, Options{Limits: Limits{MaxFileSize: 64}}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("AnalyzeURI() error = %v, want ErrLimitExceeded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AnalyzeURI(ctx, archive, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("AnalyzeURI() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestAnalyzeVerify(t *testing.T) {
+	archive := writeImage(t)
+	result, err := AnalyzeURI(context.Background(), archive, Options{Verify: true})
+	if err != nil {
+		t.Fatalf("AnalyzeURI() error = %v", err)
+	}
+	// The test archive records no digests, so nothing fails or matches
+	if result.Verification == nil || !result.Verification.OK() || len(result.Verification.Checks) != 7 {
+		t.Errorf("AnalyzeURI() verification = %+v, want 7 unverified checks", result.Verification)
+	}
+
+	if _, err := AnalyzeMetadata(context.Background(), &Metadata{}, Options{Verify: true}); err == nil {
+		t.Error("AnalyzeMetadata() error = nil, want an error as metadata cannot be verified")
+	}
+}
+
+func TestAnalyzeRedact(t *testing.T) {
+	result, err := AnalyzeURI(context.Background(), writeImage(t), Options{Redact: true, Secrets: true})
+	if err != nil {
+		t.Fatalf("AnalyzeURI() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := result.WriteDockerfile(&out); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "wJalrXUtnFEMI") {
+		t.Errorf("output = %s, want the secret masked", out.String())
+	}
+	// Secrets are still found in the original metadata
+	if len(result.SecretFindings) == 0 {
+		t.Error("AnalyzeURI() found no secrets with Redact set")
+	}
+}
+
+func TestAnalyzeMetadata(t *testing.T) {
+	metadata, _, err := ParseMetadata([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	result, err := AnalyzeMetadata(context.Background(), metadata, Options{Secrets: true, Efficiency: true})
+	if err != nil {
+		t.Fatalf("AnalyzeMetadata() error = %v", err)
+	}
+	if result.Distro != nil || result.Efficiency != nil || len(result.SecretFindings) == 0 {
+		t.Errorf("AnalyzeMetadata() = %+v, want metadata findings only", result)
+	}
+	if len(result.Warnings) != 2 {
+		t.Errorf("AnalyzeMetadata() warnings = %v, want the skipped file scan and efficiency", result.Warnings)
+	}
+
+	var out bytes.Buffer
+	if err := result.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Metadata
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded.History) != 3 {
+		t.Errorf("WriteJSON() = %s, %v, want the metadata", out.String(), err)
+	}
+
+	if _, err := AnalyzeMetadata(context.Background(), nil, Options{}); err == nil {
+		t.Error("AnalyzeMetadata(nil) error = nil")
+	}
+}
+
+// TestMetadataJSON checks the public metadata encodes as the internal one does
+func TestMetadataJSON(t *testing.T) {
+	config := `{
+		"id": "sha256:0123",
+		"architecture": "arm64",
+		"os": "linux",
+		"created": "2024-01-02T03:04:05Z",
+		"config": {
+			"User": "app",
+			"ExposedPorts": {"8080/tcp": {}},
+			"Labels": {"a": "b"},
+			"Volumes": {"/data": {}},
+			"Healthcheck": {"Test": ["CMD", "true"], "Interval": 30000000000, "Retries": 3}
+		},
+		"history": [{"created": "2024-01-02T03:04:05Z", "created_by": "RUN true", "empty_layer": true, "comment": "c"}],
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:4567"]}
+	}`
+	metadata, _, err := ParseMetadata([]byte(config))
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	public, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal, err := json.Marshal(metadata.internal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(public, internal) {
+		t.Errorf("Metadata JSON = %s, want %s", public, internal)
+	}
+	if roundTrip := newMetadata(metadata.internal()); !reflect.DeepEqual(roundTrip, metadata) {
+		t.Errorf("newMetadata(internal()) = %+v, want %+v", roundTrip, metadata)
+	}
+}
+
+func TestAnalyzeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AnalyzeURI(ctx, writeImage(t), Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("AnalyzeURI() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,964 @@
+package pasgan
+
+import (
+	"io"
+	"time"
+
+	"github.com/raesene/pasgan/internal/builder"
+	"github.com/raesene/pasgan/internal/distro"
+	"github.com/raesene/pasgan/internal/docker"
+	"github.com/raesene/pasgan/internal/dockercommit"
+	"github.com/raesene/pasgan/internal/dockerfile"
+	"github.com/raesene/pasgan/internal/efficiency"
+	"github.com/raesene/pasgan/internal/fingerprint"
+	"github.com/raesene/pasgan/internal/lint"
+	"github.com/raesene/pasgan/internal/packages"
+	"github.com/raesene/pasgan/internal/rootfs"
+	"github.com/raesene/pasgan/internal/secrets"
+	"github.com/raesene/pasgan/internal/verify"
+	"github.com/raesene/pasgan/pkg/ast"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// Metadata is the config, history and layers of an image
+type Metadata struct {
+	ID            string    `json:"id,omitempty"`
+	Author        string    `json:"author,omitempty"`
+	Config        Config    `json:"config"`
+	RepoTags      []string  `json:"RepoTags"`
+	Architecture  string    `json:"architecture"`
+	OS            string    `json:"os"`
+	Created       time.Time `json:"created"`
+	DockerVersion string    `json:"docker_version"`
+	History       []History `json:"history"`
+	RootFS        RootFS    `json:"rootfs"`
+	// Layers are the paths of the layer blobs in the image archive
+	Layers []string `json:"layers"`
+	// Annotations are the OCI manifest annotations, when the archive has an index.json
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Flat is set for filesystems written by docker export, which have a
+	// single layer and no image config or history
+	Flat bool `json:"flat,omitempty"`
+	// Legacy is set for v1 docker save archives, whose history is rebuilt
+	// from the parent chain of layer json files
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// Config is the runtime configuration stored in an image config
+type Config struct {
+	Hostname     string              `json:"Hostname"`
+	Domainname   string              `json:"Domainname"`
+	User         string              `json:"User"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	Env          []string            `json:"Env"`
+	Cmd          []string            `json:"Cmd"`
+	WorkingDir   string              `json:"WorkingDir"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Labels       map[string]string   `json:"Labels"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"`
+}
+
+// HealthConfig is the HEALTHCHECK of an image config. Durations are in
+// nanoseconds.
+type HealthConfig struct {
+	Test        []string `json:"Test,omitempty"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
+}
+
+// History is an entry of the image history
+type History struct {
+	Created    string `json:"created"`
+	CreatedBy  string `json:"created_by"`
+	Author     string `json:"author,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+// RootFS lists the diffIDs of the uncompressed layers
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// Merge fills the fields of m that are empty from other, such as the history
+// of docker history output into docker image inspect output
+func (m *Metadata) Merge(other *Metadata) {
+	merged := m.internal()
+	merged.Merge(other.internal())
+	*m = *newMetadata(merged)
+}
+
+// TruncatedHistory reports whether any history command was shortened by
+// docker history run without --no-trunc
+func (m *Metadata) TruncatedHistory() bool {
+	return m.internal().TruncatedHistory()
+}
+
+// Instruction is a reconstructed Dockerfile instruction and the history
+// entry it came from
+type Instruction struct {
+	// Node is the instruction, with the comments written before it
+	Node       ast.Node
+	Time       time.Time
+	EmptyLayer bool
+	// HistoryIndex is the index of the history entry the instruction came
+	// from, or -1
+	HistoryIndex int
+}
+
+// Release is a distribution release detected from /etc/os-release
+type Release struct {
+	// ID is the lower-case distribution identifier, e.g. "debian"
+	ID         string   `json:"id"`
+	IDLike     []string `json:"id_like,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	PrettyName string   `json:"pretty_name,omitempty"`
+	// Version is the most precise version found, e.g. "12.5" or "3.19.1"
+	Version  string `json:"version,omitempty"`
+	Codename string `json:"codename,omitempty"`
+	// Sources lists the files the release was read from
+	Sources []string `json:"sources"`
+}
+
+// String returns a human readable description of the release
+func (r *Release) String() string {
+	return (*distro.Release)(r).String()
+}
+
+// SupportStatus is the end-of-life status of a Release
+type SupportStatus struct {
+	Cycle string `json:"cycle"`
+	EOL   string `json:"eol"`
+	// EndOfLife is true when the EOL date has passed
+	EndOfLife bool `json:"end_of_life"`
+	// DaysLeft is the number of days until the EOL date, negative once it has passed
+	DaysLeft int `json:"days_left"`
+}
+
+// BaseImageMatch is an official base image identified from the history
+type BaseImageMatch struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	Version string `json:"version"`
+	// Evidence lists the environment variables and history entries that matched
+	Evidence []string `json:"evidence"`
+	Score    int      `json:"score"`
+	// BaseHistoryCount is the number of leading history entries that belong
+	// to the base image, or 0 if the boundary could not be found
+	BaseHistoryCount int `json:"base_history_count"`
+}
+
+// LintFinding is a lint rule violation in the reconstructed Dockerfile
+type LintFinding struct {
+	RuleID string `json:"rule_id"`
+	// Level is "error", "warning" or "note"
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// Instruction is the index into Result.Instructions, or -1 for image-wide findings
+	Instruction int `json:"instruction"`
+	// Line is the line of the generated Dockerfile, or 0 for image-wide findings
+	Line int `json:"line,omitempty"`
+}
+
+// SecretFinding is a suspected secret in the image metadata
+type SecretFinding struct {
+	RuleID      string `json:"rule_id"`
+	Description string `json:"description"`
+	// Severity is "low", "medium", "high" or "critical"
+	Severity string `json:"severity"`
+	// Source is where the secret was found: "history", "env", "label" or
+	// "healthcheck"
+	Source string `json:"source"`
+	// HistoryIndex is the index into Metadata.History, or -1 for config findings
+	HistoryIndex int `json:"history_index"`
+	// Key is the env variable or label name for config findings
+	Key string `json:"key,omitempty"`
+	// Context is the scanned text with the secret masked
+	Context string `json:"context"`
+	// Secret is the raw matched value; it is never serialized
+	Secret string `json:"-"`
+}
+
+// SecretFileFinding is a suspected secret in a layer file
+type SecretFileFinding struct {
+	RuleID      string `json:"rule_id"`
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	Path        string `json:"path"`
+	// Layer is the index of the layer that added the file
+	Layer  int    `json:"layer"`
+	DiffID string `json:"diff_id,omitempty"`
+	// HistoryIndex and Instruction describe the build step that added the file
+	HistoryIndex int    `json:"history_index"`
+	Instruction  string `json:"instruction,omitempty"`
+	// Visible reports whether the file is still present in the final rootfs
+	Visible bool `json:"visible"`
+	// HiddenByLayer is the layer that deleted or overwrote the file, or -1
+	HiddenByLayer int `json:"hidden_by_layer"`
+	// Overwritten is true when the file was replaced rather than deleted
+	Overwritten bool `json:"overwritten,omitempty"`
+}
+
+// VerifyStatus is the outcome of a VerifyCheck
+type VerifyStatus string
+
+const (
+	// VerifyOK means the blob matches its digest
+	VerifyOK VerifyStatus = "ok"
+	// VerifyMismatch means the blob does not match its digest or size
+	VerifyMismatch VerifyStatus = "mismatch"
+	// VerifyFailed means the blob could not be read, as when it is truncated
+	VerifyFailed VerifyStatus = "failed"
+	// VerifyUnverified means the image records no digest to check the blob against
+	VerifyUnverified VerifyStatus = "unverified"
+)
+
+// VerifyReport is the outcome of checking the blobs of an image against
+// their digests
+type VerifyReport struct {
+	Checks []VerifyCheck `json:"checks"`
+	// Attachments are the signatures, attestations and SBOMs stored
+	// alongside the image
+	Attachments []VerifyAttachment `json:"attachments,omitempty"`
+}
+
+// Failures returns the checks that found a mismatch or could not read a blob
+func (r *VerifyReport) Failures() []VerifyCheck {
+	var failures []VerifyCheck
+	for _, check := range r.Checks {
+		if check.Status == VerifyMismatch || check.Status == VerifyFailed {
+			failures = append(failures, check)
+		}
+	}
+	return failures
+}
+
+// OK reports whether every digest recorded in the image matched
+func (r *VerifyReport) OK() bool {
+	return len(r.Failures()) == 0
+}
+
+// Count returns the number of checks with status
+func (r *VerifyReport) Count(status VerifyStatus) int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// VerifyCheck is the check of a single blob against a digest
+type VerifyCheck struct {
+	// Subject names what was checked, such as "layer 0 diffID"
+	Subject  string       `json:"subject"`
+	Path     string       `json:"path,omitempty"`
+	Expected string       `json:"expected,omitempty"`
+	Actual   string       `json:"actual,omitempty"`
+	Status   VerifyStatus `json:"status"`
+	// Detail explains a status other than VerifyOK
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c VerifyCheck) String() string {
+	return c.internal().String()
+}
+
+// VerifyAttachment is a signature, attestation or SBOM stored alongside
+// an image
+type VerifyAttachment struct {
+	// Kind is "signature", "attestation" or "sbom"
+	Kind string `json:"kind"`
+	// Source is the tag the artifact is stored under or, for referrers, the
+	// digest of its manifest
+	Source string `json:"source"`
+	// Digest is the digest of the blob holding the signature or statement
+	Digest        string `json:"digest"`
+	Subject       string `json:"subject"`
+	PredicateType string `json:"predicateType,omitempty"`
+	MediaType     string `json:"mediaType,omitempty"`
+	// Status is VerifyOK if the signature verifies and names the image
+	Status VerifyStatus `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+// Limits bound the bytes, entries and file sizes extracted from an archive.
+// Zero fields take the defaults of DefaultLimits and negative ones disable
+// the limit.
+type Limits struct {
+	// MaxTotalSize is the number of bytes written across all files
+	MaxTotalSize int64
+	// MaxEntries is the number of tar entries read
+	MaxEntries int
+	// MaxFileSize is the size of the largest file
+	MaxFileSize int64
+}
+
+// EOLTable holds the end-of-life dates of distribution releases and the
+// official images that provide them
+type EOLTable struct {
+	table distro.Table
+}
+
+// DefaultEOLTable returns the embedded end-of-life table
+func DefaultEOLTable() *EOLTable {
+	return &EOLTable{table: distro.DefaultTable()}
+}
+
+// LoadEOLTable reads an end-of-life table in the JSON format of the embedded one
+func LoadEOLTable(r io.Reader) (*EOLTable, error) {
+	table, err := distro.LoadTable(r)
+	if err != nil {
+		return nil, err
+	}
+	return &EOLTable{table: table}, nil
+}
+
+// Merge adds the distributions and release cycles of other to t. Cycles and
+// images in other replace existing ones.
+func (t *EOLTable) Merge(other *EOLTable) {
+	if t.table == nil {
+		t.table = distro.Table{}
+	}
+	t.table.Merge(other.table)
+}
+
+// Fingerprints are the signs by which official base images are identified
+type Fingerprints struct {
+	database fingerprint.Database
+}
+
+// DefaultFingerprints returns the embedded base image fingerprints
+func DefaultFingerprints() *Fingerprints {
+	return &Fingerprints{database: fingerprint.DefaultDatabase()}
+}
+
+// LoadFingerprints reads base image fingerprints in the JSON format of the
+// embedded ones
+func LoadFingerprints(r io.Reader) (*Fingerprints, error) {
+	database, err := fingerprint.LoadDatabase(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Fingerprints{database: database}, nil
+}
+
+// Merge adds the fingerprints of other to f. Fingerprints with the same name
+// replace existing ones.
+func (f *Fingerprints) Merge(other *Fingerprints) {
+	f.database.Merge(other.database)
+}
+
+// BuilderKind is the tool an image was built with
+type BuilderKind string
+
+// Supported builders
+const (
+	BuilderDockerfile BuilderKind = "dockerfile"
+	BuilderKaniko     BuilderKind = "kaniko"
+	BuilderJib        BuilderKind = "jib"
+	BuilderKo         BuilderKind = "ko"
+	BuilderBazel      BuilderKind = "bazel"
+	BuilderNix        BuilderKind = "nix"
+	BuilderApko       BuilderKind = "apko"
+	BuilderBuildpacks BuilderKind = "buildpacks"
+)
+
+// Builder is the tool that built the image, such as Jib, ko or buildpacks
+type Builder struct {
+	Kind BuilderKind `json:"kind"`
+	// Tool is the name the builder records, e.g. jib-maven-plugin
+	Tool    string `json:"tool,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Evidence lists the history entries, labels and files that identified the builder
+	Evidence []string `json:"evidence"`
+	// LayerRoots is the deepest directory holding every file of each layer.
+	// It is only set when the layers were read.
+	LayerRoots []string `json:"layer_roots,omitempty"`
+	// Packages and Repositories are the apk world and repositories of apko images
+	Packages     []string `json:"packages,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+	// StorePaths are the top-level /nix/store paths of Nix images
+	StorePaths []string `json:"store_paths,omitempty"`
+	// LayerOwners describes what each layer holds, for builders that record it
+	LayerOwners []string `json:"layer_owners,omitempty"`
+	// Buildpacks is the build recorded by the buildpacks lifecycle
+	Buildpacks *BuildpacksBuild `json:"buildpacks,omitempty"`
+}
+
+// Dockerless reports whether the image was built without a Dockerfile
+func (b *Builder) Dockerless() bool {
+	return b.internal().Dockerless()
+}
+
+// Name describes the builder for humans, e.g. "jib-maven-plugin 3.4.0"
+func (b *Builder) Name() string {
+	return b.internal().Name()
+}
+
+// BuildpacksBuild is the build recorded in the labels of a buildpacks image
+type BuildpacksBuild struct {
+	StackID         string   `json:"stack_id,omitempty"`
+	RunImage        string   `json:"run_image,omitempty"`
+	RunImageMirrors []string `json:"run_image_mirrors,omitempty"`
+	// Builder is inferred from the run image, as the lifecycle does not record it
+	Builder         string             `json:"builder,omitempty"`
+	Buildpacks      []Buildpack        `json:"buildpacks"`
+	Processes       []BuildpackProcess `json:"processes,omitempty"`
+	LauncherVersion string             `json:"launcher_version,omitempty"`
+	SourceRepo      string             `json:"source_repository,omitempty"`
+	SourceCommit    string             `json:"source_commit,omitempty"`
+	// BaseLayers is the number of layers that come from the run image
+	BaseLayers int `json:"base_layers"`
+}
+
+// PackCommand returns the pack build command line that reproduces the build
+func (b *BuildpacksBuild) PackCommand(image string) string {
+	return b.internal().PackCommand(image)
+}
+
+// Buildpack is a buildpack that took part in the build
+type Buildpack struct {
+	ID       string `json:"id"`
+	Version  string `json:"version,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+}
+
+// BuildpackProcess is a process type the buildpacks launcher can start
+type BuildpackProcess struct {
+	Type        string   `json:"type"`
+	Command     []string `json:"command"`
+	Args        []string `json:"args,omitempty"`
+	Direct      bool     `json:"direct"`
+	Default     bool     `json:"default,omitempty"`
+	BuildpackID string   `json:"buildpack_id,omitempty"`
+}
+
+// CommittedLayer is a layer created by docker commit
+type CommittedLayer struct {
+	LayerIndex int `json:"layer"`
+	// HistoryIndex is the history entry of the layer, or -1 if it has none
+	HistoryIndex int `json:"history_index"`
+	// Command is the container command recorded by docker commit
+	Command string `json:"command,omitempty"`
+	// Message is the commit message, recorded as the history comment
+	Message string `json:"message,omitempty"`
+	// Reason explains why the layer is thought to be committed
+	Reason string `json:"reason"`
+	// Packages are the packages the layer installed or upgraded
+	Packages []Package `json:"packages,omitempty"`
+	// Added, Modified and Deleted are the changed paths that no package owns,
+	// leaving out caches, logs and package databases
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Deleted  []string `json:"deleted,omitempty"`
+	// Root is the deepest directory holding every added and modified file
+	Root string `json:"root"`
+}
+
+// Summary describes the changes in a few words, e.g. "installed 2 deb packages, added 3 files"
+func (c *CommittedLayer) Summary() string {
+	return c.internal().Summary()
+}
+
+// Package is an OS or language package installed in a layer
+type Package struct {
+	// Ecosystem is the package manager, e.g. "deb", "apk" or "npm"
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// Location is the install directory for language packages, empty for OS packages
+	Location string `json:"location,omitempty"`
+	// Layer is the index of the layer that installed this version
+	Layer int `json:"layer"`
+}
+
+// RootFSEvidence is what a flat docker export filesystem reveals about how
+// it was built
+type RootFSEvidence struct {
+	// Packages are the packages thought to be installed on top of the base image
+	Packages []Package `json:"packages,omitempty"`
+	// BasePackages is the number of packages left to the base image
+	BasePackages int `json:"base_packages"`
+	// Groups are the directories and top-level files holding files that no package owns
+	Groups []RootFSGroup `json:"groups,omitempty"`
+	// User is a login user from /etc/passwd, empty if there are only system users
+	User string `json:"user,omitempty"`
+	// Warnings describes evidence that could not be used
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// RootFSGroup is a directory, or a top-level file, of files that no package owns
+type RootFSGroup struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	IsDir bool   `json:"is_dir"`
+}
+
+// WasteReason describes why the space of a file is wasted
+type WasteReason string
+
+const (
+	// WasteDeleted means a later layer deleted the file
+	WasteDeleted WasteReason = "deleted"
+	// WasteOverwritten means a later layer replaced the file
+	WasteOverwritten WasteReason = "overwritten"
+	// WasteDuplicate means an earlier layer added an identical file
+	WasteDuplicate WasteReason = "duplicate"
+)
+
+// EfficiencyReport scores the space wasted across layers
+type EfficiencyReport struct {
+	// TotalSize is the size of the regular files in every layer
+	TotalSize int64 `json:"total_size"`
+	// WastedSize is the size of deleted, overwritten and duplicated files
+	WastedSize int64 `json:"wasted_size"`
+	// Score is the fraction of TotalSize that is not wasted, between 0 and 1
+	Score       float64                `json:"score"`
+	Wasted      []WastedFile           `json:"wasted"`
+	Layers      []LayerEfficiency      `json:"layers"`
+	Suggestions []EfficiencySuggestion `json:"suggestions"`
+}
+
+// WastedFile is a file whose bytes are stored in a layer but do not
+// contribute to the final filesystem
+type WastedFile struct {
+	Path        string      `json:"path"`
+	Size        int64       `json:"size"`
+	Reason      WasteReason `json:"reason"`
+	Layer       int         `json:"layer"`
+	Instruction string      `json:"instruction,omitempty"`
+	// ByLayer is the layer that deleted or overwrote the file, or that added
+	// the duplicate. ByPath is the earlier identical file for duplicates.
+	ByLayer       int    `json:"by_layer"`
+	ByInstruction string `json:"by_instruction,omitempty"`
+	ByPath        string `json:"by_path,omitempty"`
+}
+
+// LayerEfficiency is the size and waste of a single layer
+type LayerEfficiency struct {
+	Layer       int    `json:"layer"`
+	Instruction string `json:"instruction,omitempty"`
+	Size        int64  `json:"size"`
+	Wasted      int64  `json:"wasted"`
+}
+
+// EfficiencySuggestion is a change to the build that would save space
+type EfficiencySuggestion struct {
+	Layer   int    `json:"layer"`
+	Message string `json:"message"`
+	Savings int64  `json:"savings"`
+}
+
+// DefaultLimits returns the limits used for the zero fields of Limits
+func DefaultLimits() Limits {
+	return Limits(utils.DefaultLimits)
+}
+
+// FormatInstruction renders an instruction as it appears in a Dockerfile,
+// without its comments
+func FormatInstruction(instruction Instruction) string {
+	return dockerfile.FormatInstruction(dockerfile.Instruction{Node: instruction.Node})
+}
+
+// ParseMetadata reads image metadata from docker image inspect, docker
+// history --no-trunc --format '{{json .}}' or image config JSON, for
+// AnalyzeMetadata. It returns the kind of JSON that was recognised.
+func ParseMetadata(data []byte) (*Metadata, string, error) {
+	metadata, kind, err := docker.ParseJSON(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return newMetadata(metadata), kind, nil
+}
+
+// newMetadata converts metadata read by the internal packages. Slices and
+// maps are shared, not copied.
+func newMetadata(m *docker.ImageMetadata) *Metadata {
+	if m == nil {
+		return nil
+	}
+	metadata := &Metadata{
+		ID:     m.ID,
+		Author: m.Author,
+		Config: Config{
+			Hostname:     m.Config.Hostname,
+			Domainname:   m.Config.Domainname,
+			User:         m.Config.User,
+			ExposedPorts: m.Config.ExposedPorts,
+			Env:          m.Config.Env,
+			Cmd:          m.Config.Cmd,
+			WorkingDir:   m.Config.WorkingDir,
+			Entrypoint:   m.Config.Entrypoint,
+			Labels:       m.Config.Labels,
+			StopSignal:   m.Config.StopSignal,
+			Volumes:      m.Config.Volumes,
+		},
+		RepoTags:      m.RepoTags,
+		Architecture:  m.Architecture,
+		OS:            m.OS,
+		Created:       m.Created,
+		DockerVersion: m.DockerVersion,
+		RootFS:        RootFS(m.RootFS),
+		Layers:        m.Layers,
+		Annotations:   m.Annotations,
+		Flat:          m.Flat,
+		Legacy:        m.Legacy,
+	}
+	if m.Config.Healthcheck != nil {
+		health := HealthConfig(*m.Config.Healthcheck)
+		metadata.Config.Healthcheck = &health
+	}
+	if m.History != nil {
+		metadata.History = make([]History, len(m.History))
+		for i, entry := range m.History {
+			metadata.History[i] = History(entry)
+		}
+	}
+	return metadata
+}
+
+// internal converts m for the internal packages
+func (m *Metadata) internal() *docker.ImageMetadata {
+	metadata := &docker.ImageMetadata{
+		ID:     m.ID,
+		Author: m.Author,
+		Config: docker.Config{
+			Hostname:     m.Config.Hostname,
+			Domainname:   m.Config.Domainname,
+			User:         m.Config.User,
+			ExposedPorts: m.Config.ExposedPorts,
+			Env:          m.Config.Env,
+			Cmd:          m.Config.Cmd,
+			WorkingDir:   m.Config.WorkingDir,
+			Entrypoint:   m.Config.Entrypoint,
+			Labels:       m.Config.Labels,
+			StopSignal:   m.Config.StopSignal,
+			Volumes:      m.Config.Volumes,
+		},
+		RepoTags:      m.RepoTags,
+		Architecture:  m.Architecture,
+		OS:            m.OS,
+		Created:       m.Created,
+		DockerVersion: m.DockerVersion,
+		RootFS:        docker.RootFS(m.RootFS),
+		Layers:        m.Layers,
+		Annotations:   m.Annotations,
+		Flat:          m.Flat,
+		Legacy:        m.Legacy,
+	}
+	if m.Config.Healthcheck != nil {
+		health := docker.HealthConfig(*m.Config.Healthcheck)
+		metadata.Config.Healthcheck = &health
+	}
+	if m.History != nil {
+		metadata.History = make([]docker.History, len(m.History))
+		for i, entry := range m.History {
+			metadata.History[i] = docker.History(entry)
+		}
+	}
+	return metadata
+}
+
+func newInstructions(instructions []dockerfile.Instruction) []Instruction {
+	converted := make([]Instruction, len(instructions))
+	for i, instruction := range instructions {
+		converted[i] = Instruction{
+			Node:         instruction.Node,
+			Time:         instruction.Time,
+			EmptyLayer:   instruction.EmptyLayer,
+			HistoryIndex: instruction.HistoryIndex,
+		}
+	}
+	return converted
+}
+
+func newLintFindings(findings []lint.Finding) []LintFinding {
+	if findings == nil {
+		return nil
+	}
+	converted := make([]LintFinding, len(findings))
+	for i, finding := range findings {
+		converted[i] = LintFinding{
+			RuleID:      finding.RuleID,
+			Level:       string(finding.Level),
+			Message:     finding.Message,
+			Instruction: finding.Instruction,
+			Line:        finding.Line,
+		}
+	}
+	return converted
+}
+
+func newSecretFindings(findings []secrets.Finding) []SecretFinding {
+	if findings == nil {
+		return nil
+	}
+	converted := make([]SecretFinding, len(findings))
+	for i, finding := range findings {
+		converted[i] = SecretFinding{
+			RuleID:       finding.RuleID,
+			Description:  finding.Description,
+			Severity:     string(finding.Severity),
+			Source:       string(finding.Source),
+			HistoryIndex: finding.HistoryIndex,
+			Key:          finding.Key,
+			Context:      finding.Context,
+			Secret:       finding.Secret,
+		}
+	}
+	return converted
+}
+
+func newSecretFileFindings(findings []secrets.FileFinding) []SecretFileFinding {
+	if findings == nil {
+		return nil
+	}
+	converted := make([]SecretFileFinding, len(findings))
+	for i, finding := range findings {
+		converted[i] = SecretFileFinding{
+			RuleID:        finding.RuleID,
+			Description:   finding.Description,
+			Severity:      string(finding.Severity),
+			Path:          finding.Path,
+			Layer:         finding.Layer,
+			DiffID:        finding.DiffID,
+			HistoryIndex:  finding.HistoryIndex,
+			Instruction:   finding.Instruction,
+			Visible:       finding.Visible,
+			HiddenByLayer: finding.HiddenByLayer,
+			Overwritten:   finding.Overwritten,
+		}
+	}
+	return converted
+}
+
+func newVerifyReport(r *verify.Report) *VerifyReport {
+	if r == nil {
+		return nil
+	}
+	report := &VerifyReport{Checks: make([]VerifyCheck, len(r.Checks))}
+	for i, check := range r.Checks {
+		report.Checks[i] = VerifyCheck{
+			Subject:  check.Subject,
+			Path:     check.Path,
+			Expected: check.Expected,
+			Actual:   check.Actual,
+			Status:   VerifyStatus(check.Status),
+			Detail:   check.Detail,
+		}
+	}
+	for _, attachment := range r.Attachments {
+		report.Attachments = append(report.Attachments, VerifyAttachment{
+			Kind:          string(attachment.Kind),
+			Source:        attachment.Source,
+			Digest:        attachment.Digest,
+			Subject:       attachment.Subject,
+			PredicateType: attachment.PredicateType,
+			MediaType:     attachment.MediaType,
+			Status:        VerifyStatus(attachment.Status),
+			Detail:        attachment.Detail,
+		})
+	}
+	return report
+}
+
+func (c VerifyCheck) internal() verify.Check {
+	return verify.Check{
+		Subject:  c.Subject,
+		Path:     c.Path,
+		Expected: c.Expected,
+		Actual:   c.Actual,
+		Status:   verify.Status(c.Status),
+		Detail:   c.Detail,
+	}
+}
+
+func newBuilder(b *builder.Builder) *Builder {
+	if b == nil {
+		return nil
+	}
+	converted := &Builder{
+		Kind:         BuilderKind(b.Kind),
+		Tool:         b.Tool,
+		Version:      b.Version,
+		Evidence:     b.Evidence,
+		LayerRoots:   b.LayerRoots,
+		Packages:     b.Packages,
+		Repositories: b.Repositories,
+		StorePaths:   b.StorePaths,
+		LayerOwners:  b.LayerOwners,
+	}
+	if info := b.Buildpacks; info != nil {
+		converted.Buildpacks = &BuildpacksBuild{
+			StackID:         info.StackID,
+			RunImage:        info.RunImage,
+			RunImageMirrors: info.RunImageMirrors,
+			Builder:         info.Builder,
+			LauncherVersion: info.LauncherVersion,
+			SourceRepo:      info.SourceRepo,
+			SourceCommit:    info.SourceCommit,
+			BaseLayers:      info.BaseLayers,
+		}
+		if info.Buildpacks != nil {
+			converted.Buildpacks.Buildpacks = make([]Buildpack, len(info.Buildpacks))
+			for i, buildpack := range info.Buildpacks {
+				converted.Buildpacks.Buildpacks[i] = Buildpack(buildpack)
+			}
+		}
+		for _, process := range info.Processes {
+			converted.Buildpacks.Processes = append(converted.Buildpacks.Processes, BuildpackProcess(process))
+		}
+	}
+	return converted
+}
+
+// internal converts b for the internal packages
+func (b *Builder) internal() *builder.Builder {
+	return &builder.Builder{
+		Kind:         builder.Kind(b.Kind),
+		Tool:         b.Tool,
+		Version:      b.Version,
+		Evidence:     b.Evidence,
+		LayerRoots:   b.LayerRoots,
+		Packages:     b.Packages,
+		Repositories: b.Repositories,
+		StorePaths:   b.StorePaths,
+		LayerOwners:  b.LayerOwners,
+		Buildpacks:   b.Buildpacks.internal(),
+	}
+}
+
+// internal converts b for the internal packages. It returns nil if b is nil.
+func (b *BuildpacksBuild) internal() *builder.BuildpacksBuild {
+	if b == nil {
+		return nil
+	}
+	info := &builder.BuildpacksBuild{
+		StackID:         b.StackID,
+		RunImage:        b.RunImage,
+		RunImageMirrors: b.RunImageMirrors,
+		Builder:         b.Builder,
+		LauncherVersion: b.LauncherVersion,
+		SourceRepo:      b.SourceRepo,
+		SourceCommit:    b.SourceCommit,
+		BaseLayers:      b.BaseLayers,
+	}
+	if b.Buildpacks != nil {
+		info.Buildpacks = make([]builder.Buildpack, len(b.Buildpacks))
+		for i, buildpack := range b.Buildpacks {
+			info.Buildpacks[i] = builder.Buildpack(buildpack)
+		}
+	}
+	for _, process := range b.Processes {
+		info.Processes = append(info.Processes, builder.Process(process))
+	}
+	return info
+}
+
+func newCommittedLayers(layers []*dockercommit.Layer) []*CommittedLayer {
+	if layers == nil {
+		return nil
+	}
+	converted := make([]*CommittedLayer, len(layers))
+	for i, c := range layers {
+		converted[i] = &CommittedLayer{
+			LayerIndex:   c.LayerIndex,
+			HistoryIndex: c.HistoryIndex,
+			Command:      c.Command,
+			Message:      c.Message,
+			Reason:       c.Reason,
+			Packages:     newPackages(c.Packages),
+			Added:        c.Added,
+			Modified:     c.Modified,
+			Deleted:      c.Deleted,
+			Root:         c.Root,
+		}
+	}
+	return converted
+}
+
+// internal converts c for the internal packages
+func (c *CommittedLayer) internal() *dockercommit.Layer {
+	layer := &dockercommit.Layer{
+		LayerIndex:   c.LayerIndex,
+		HistoryIndex: c.HistoryIndex,
+		Command:      c.Command,
+		Message:      c.Message,
+		Reason:       c.Reason,
+		Added:        c.Added,
+		Modified:     c.Modified,
+		Deleted:      c.Deleted,
+		Root:         c.Root,
+	}
+	for _, pkg := range c.Packages {
+		layer.Packages = append(layer.Packages, packages.Package(pkg))
+	}
+	return layer
+}
+
+func newPackages(pkgs []packages.Package) []Package {
+	if pkgs == nil {
+		return nil
+	}
+	converted := make([]Package, len(pkgs))
+	for i, pkg := range pkgs {
+		converted[i] = Package(pkg)
+	}
+	return converted
+}
+
+func newRootFSEvidence(e *rootfs.Evidence) *RootFSEvidence {
+	if e == nil {
+		return nil
+	}
+	evidence := &RootFSEvidence{
+		Packages:     newPackages(e.Packages),
+		BasePackages: e.BasePackages,
+		User:         e.User,
+		Warnings:     e.Warnings,
+	}
+	for _, group := range e.Groups {
+		evidence.Groups = append(evidence.Groups, RootFSGroup(group))
+	}
+	return evidence
+}
+
+func newEfficiencyReport(r *efficiency.Report) *EfficiencyReport {
+	if r == nil {
+		return nil
+	}
+	report := &EfficiencyReport{
+		TotalSize:   r.TotalSize,
+		WastedSize:  r.WastedSize,
+		Score:       r.Score,
+		Wasted:      make([]WastedFile, len(r.Wasted)),
+		Layers:      make([]LayerEfficiency, len(r.Layers)),
+		Suggestions: make([]EfficiencySuggestion, len(r.Suggestions)),
+	}
+	for i, waste := range r.Wasted {
+		report.Wasted[i] = WastedFile{
+			Path:          waste.Path,
+			Size:          waste.Size,
+			Reason:        WasteReason(waste.Reason),
+			Layer:         waste.Layer,
+			Instruction:   waste.Instruction,
+			ByLayer:       waste.ByLayer,
+			ByInstruction: waste.ByInstruction,
+			ByPath:        waste.ByPath,
+		}
+	}
+	for i, summary := range r.Layers {
+		report.Layers[i] = LayerEfficiency(summary)
+	}
+	for i, suggestion := range r.Suggestions {
+		report.Suggestions[i] = EfficiencySuggestion(suggestion)
+	}
+	return report
+}
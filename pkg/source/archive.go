@@ -0,0 +1,143 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+func init() {
+	RegisterContext(DockerArchive, func(ctx context.Context, ref string, opts Options) (ImageSource, error) {
+		return openArchive(ctx, ref, opts, loadDir)
+	})
+	RegisterContext(OCIArchive, func(ctx context.Context, ref string, opts Options) (ImageSource, error) {
+		archive, name := splitRef(ref)
+		return openArchive(ctx, archive, opts, func(dir string) (*layout, error) {
+			return loadOCI(dir, name)
+		})
+	})
+	Register(OCI, func(ref string) (ImageSource, error) {
+		dir, name := splitRef(ref)
+		return loadOCI(dir, name)
+	})
+	Register(Dir, func(ref string) (ImageSource, error) {
+		if info, err := os.Stat(ref); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("image directory not found: %s", ref)
+		}
+		return loadDir(ref)
+	})
+	RegisterContext(Stdin, func(ctx context.Context, _ string, opts Options) (ImageSource, error) {
+		return ReadArchiveContext(ctx, os.Stdin, opts)
+	})
+}
+
+// splitRef splits an OCI reference path:name into the layout path and the
+// name of the image in it, as skopeo does. A path that exists is never split.
+func splitRef(ref string) (string, string) {
+	if _, err := os.Stat(ref); err == nil {
+		return ref, ""
+	}
+	if i := strings.LastIndex(ref, ":"); i > 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// openArchive extracts the image archive at archivePath to a temporary
+// directory, removed when the source is closed, and loads it
+func openArchive(ctx context.Context, archivePath string, opts Options, load func(dir string) (*layout, error)) (ImageSource, error) {
+	if _, err := os.Stat(archivePath); err != nil {
+		return nil, fmt.Errorf("image file not found: %s", archivePath)
+	}
+
+	workDir, err := utils.TempDir("pasgan-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	result, err := utils.ExtractTarContext(ctx, archivePath, workDir, opts.Limits)
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, fmt.Errorf("failed to extract image archive: %w", err)
+	}
+
+	l, err := load(workDir)
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, err
+	}
+	l.temp = workDir
+	l.skipped = result.Skipped
+	return l, nil
+}
+
+// ReadArchive reads an image archive, in docker save or OCI format, from r.
+// The archive is copied to a temporary file, removed when the source is
+// closed, since its parts are not stored in a fixed order.
+func ReadArchive(r io.Reader) (ImageSource, error) {
+	return ReadArchiveContext(context.Background(), r, Options{})
+}
+
+// ReadArchiveContext is like ReadArchive, but stops once ctx is done and
+// reads no more than opts.Limits allow
+func ReadArchiveContext(ctx context.Context, r io.Reader, opts Options) (ImageSource, error) {
+	workDir, err := utils.TempDir("pasgan-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	archivePath := filepath.Join(workDir, "image.tar")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	err = spool(ctx, file, r, opts.Limits.TotalSize())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, fmt.Errorf("failed to read image archive: %w", err)
+	}
+
+	imageDir := filepath.Join(workDir, "image")
+	result, err := utils.ExtractTarContext(ctx, archivePath, imageDir, opts.Limits)
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, fmt.Errorf("failed to extract image archive: %w", err)
+	}
+	l, err := loadDir(imageDir)
+	if err != nil {
+		utils.RemoveTempDir(workDir)
+		return nil, err
+	}
+	l.temp = workDir
+	l.skipped = result.Skipped
+	return l, nil
+}
+
+// spool copies r to w, failing if it holds more than max bytes, unless max
+// is negative
+func spool(ctx context.Context, w io.Writer, r io.Reader, max int64) error {
+	r = utils.ContextReader(ctx, r)
+	if max < 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	n, err := io.CopyN(w, r, max+1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n > max {
+		return fmt.Errorf("%w: more than %s in total", utils.ErrLimitExceeded, utils.FormatSize(max))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package source
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Annotations that cosign save writes to the index.json of an OCI layout to
+// tell the image from its signatures and attestations
+const (
+	annotationCosignKind = "kind"
+	cosignKindSignatures = "dev.cosignproject.cosign/sigs"
+	cosignKindAtts       = "dev.cosignproject.cosign/atts"
+)
+
+// artifactSuffixes are the tags cosign gives the artifacts of an image after
+// its digest: signatures, attestations and attached SBOMs
+var artifactSuffixes = []string{".sig", ".att", ".sbom"}
+
+// Artifacts returns the manifests of an OCI layout that are attached to the
+// image, by tag, by the kind annotation of cosign save or by subject
+func (l *layout) Artifacts() ([]Artifact, error) {
+	if len(l.digests) == 0 {
+		return nil, nil
+	}
+	index, err := readIndex(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []Artifact
+	for _, descriptor := range index.Manifests {
+		if slices.Contains(l.digests, descriptor.Digest) {
+			continue
+		}
+		if artifact, ok := l.artifact(descriptor); ok {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+// artifact reads the manifest of descriptor if it is attached to the image
+func (l *layout) artifact(descriptor ociDescriptor) (Artifact, bool) {
+	artifact := Artifact{
+		Tag:        layoutTag(descriptor.Annotations),
+		Descriptor: Descriptor{MediaType: descriptor.MediaType, Digest: descriptor.Digest, Size: descriptor.Size, Path: blobPath(descriptor.Digest)},
+	}
+	for _, digest := range l.digests {
+		prefix := strings.Replace(digest, ":", "-", 1)
+		for _, suffix := range artifactSuffixes {
+			if artifact.Tag == prefix+suffix {
+				artifact.Subject = digest
+			}
+		}
+	}
+	// cosign save writes a single image, so its signatures need no tag
+	if kind := descriptor.Annotations[annotationCosignKind]; kind == cosignKindSignatures || kind == cosignKindAtts {
+		artifact.Subject = l.digests[len(l.digests)-1]
+	}
+
+	data, err := l.readBlob(descriptor.Digest)
+	if err != nil {
+		return Artifact{}, false
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Artifact{}, false
+	}
+	if artifact.Subject == "" && manifest.Subject != nil && slices.Contains(l.digests, manifest.Subject.Digest) {
+		artifact.Subject = manifest.Subject.Digest
+	}
+	if artifact.Subject == "" {
+		return Artifact{}, false
+	}
+	artifact.Manifest = data
+	artifact.ArtifactType = manifest.ArtifactType
+	if artifact.ArtifactType == "" {
+		artifact.ArtifactType = manifest.Config.MediaType
+	}
+	return artifact, true
+}
+
+// layoutTag returns the tag of an index.json entry, from its ref name or
+// the tag of its containerd image name
+func layoutTag(annotations map[string]string) string {
+	if tag := annotations[annotationRefName]; tag != "" {
+		return tag
+	}
+	name := annotations[annotationContainerdName]
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// ReadBlob reads a blob of the OCI layout by digest
+func (l *layout) ReadBlob(digest string) ([]byte, error) {
+	return l.readBlob(digest)
+}
@@ -0,0 +1,447 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// Media types of OCI and Docker image indexes, which list a manifest per platform
+const (
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationRefName        = "org.opencontainers.image.ref.name"
+	annotationContainerdName = "io.containerd.image.name"
+)
+
+// layout is an image stored in a directory: an extracted docker save archive,
+// a legacy v1 docker save archive or an OCI image layout
+type layout struct {
+	dir         string
+	config      []byte
+	layers      []Descriptor
+	annotations map[string]string
+	platform    Platform
+	tags        []string
+	legacy      bool
+	// temp is removed on Close, for archives extracted to a temporary directory
+	temp string
+	// skipped are the archive entries that were not extracted
+	skipped []utils.SkippedEntry
+	// storedConfig and storedManifest are the blobs as written in the image,
+	// with the descriptors that refer to them
+	storedConfig       []byte
+	configDescriptor   Descriptor
+	storedManifest     []byte
+	manifestDescriptor Descriptor
+	// indexes are the image indexes read on the way to the manifest
+	indexes []StoredBlob
+	// digests are the digests the image is known by in an OCI layout: its
+	// manifest and the index it was selected from
+	digests []string
+}
+
+// manifestItem is an image in the manifest.json of docker save archives
+type manifestItem struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ociDescriptor is a descriptor in an OCI index or manifest
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// ociIndex is an OCI index.json, or an image index blob
+type ociIndex struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest
+type ociManifest struct {
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Subject      *ociDescriptor    `json:"subject,omitempty"`
+	Config       ociDescriptor     `json:"config"`
+	Layers       []ociDescriptor   `json:"layers"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// loadDir reads the image in dir in whichever format it is stored: docker
+// save archives, including legacy ones, are preferred to an OCI layout since
+// docker save writes both
+func loadDir(dir string) (*layout, error) {
+	for _, name := range []string{"manifest.json", "repositories"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return loadDocker(dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return loadOCI(dir, "")
+	}
+	return nil, fmt.Errorf("%s holds no manifest.json, repositories or index.json, it is not an image", dir)
+}
+
+// loadDocker reads a docker save archive extracted to dir
+func loadDocker(dir string) (*layout, error) {
+	manifestData, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if os.IsNotExist(err) {
+		return loadLegacy(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest.json: %w", err)
+	}
+
+	var manifest []manifestItem
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	if len(manifest) == 0 {
+		return nil, fmt.Errorf("manifest.json contains no images")
+	}
+
+	// Get the first image from the manifest
+	item := manifest[0]
+	l := &layout{dir: dir, tags: item.RepoTags}
+	l.config, err = l.readFile(item.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	l.storedConfig = l.config
+	l.configDescriptor = Descriptor{Path: item.Config, Digest: configDigest(item.Config), Size: int64(len(l.config))}
+	for _, layerPath := range item.Layers {
+		descriptor := Descriptor{Path: layerPath, Digest: blobDigest(layerPath)}
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(layerPath))); err == nil {
+			descriptor.Size = info.Size()
+		}
+		l.layers = append(l.layers, descriptor)
+	}
+
+	// Older archives keep their tags in repositories and may have no history
+	if len(l.tags) == 0 && len(item.Layers) > 0 {
+		if tags, err := readRepositories(dir); err == nil {
+			// repositories refers to the image by the directory of its top layer
+			l.tags = tags[path.Base(path.Dir(item.Layers[len(item.Layers)-1]))]
+		}
+	}
+	if history := l.v1History(); history != nil {
+		l.config, err = withHistory(l.config, history)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Docker 25 and later also write an OCI index.json with the annotations
+	if index, err := readIndex(dir); err == nil && len(index.Manifests) > 0 {
+		l.annotations = l.manifestAnnotations(index.Manifests[0])
+	}
+	l.platform = configPlatform(l.config)
+	return l, nil
+}
+
+// loadOCI reads an OCI image layout in dir. ref selects the manifest by its
+// org.opencontainers.image.ref.name annotation; the first is used if empty.
+func loadOCI(dir, ref string) (*layout, error) {
+	index, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &layout{dir: dir}
+	descriptor, err := selectManifest(index.Manifests, ref)
+	if err != nil {
+		return nil, err
+	}
+	// Multi-platform images list a manifest per platform in a nested index,
+	// but the image name is recorded on the index
+	named := descriptor
+	for descriptor.MediaType == mediaTypeOCIIndex || descriptor.MediaType == mediaTypeDockerList {
+		data, err := l.readBlob(descriptor.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index: %w", err)
+		}
+		var nested ociIndex
+		if err := json.Unmarshal(data, &nested); err != nil {
+			return nil, fmt.Errorf("failed to parse image index %s: %w", descriptor.Digest, err)
+		}
+		l.indexes = append(l.indexes, StoredBlob{
+			Descriptor: Descriptor{MediaType: descriptor.MediaType, Digest: descriptor.Digest, Size: descriptor.Size, Path: blobPath(descriptor.Digest)},
+			Data:       data,
+		})
+		descriptor, err = selectPlatform(nested.Manifests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := l.readBlob(descriptor.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image manifest: %w", err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse image manifest %s: %w", descriptor.Digest, err)
+	}
+	l.storedManifest = data
+	l.digests = append(l.digests, descriptor.Digest)
+	if named.Digest != descriptor.Digest {
+		l.digests = append(l.digests, named.Digest)
+	}
+	l.manifestDescriptor = Descriptor{MediaType: descriptor.MediaType, Digest: descriptor.Digest, Size: descriptor.Size, Path: blobPath(descriptor.Digest)}
+	l.config, err = l.readBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	l.storedConfig = l.config
+	l.configDescriptor = Descriptor{
+		MediaType: manifest.Config.MediaType,
+		Digest:    manifest.Config.Digest,
+		Size:      manifest.Config.Size,
+		Path:      blobPath(manifest.Config.Digest),
+	}
+	for _, blob := range manifest.Layers {
+		l.layers = append(l.layers, Descriptor{
+			MediaType: blob.MediaType,
+			Digest:    blob.Digest,
+			Size:      blob.Size,
+			Path:      blobPath(blob.Digest),
+		})
+	}
+
+	l.annotations = l.manifestAnnotations(descriptor)
+	if named.Digest != descriptor.Digest && len(named.Annotations) > 0 {
+		if l.annotations == nil {
+			l.annotations = make(map[string]string)
+		}
+		for key, value := range named.Annotations {
+			if _, ok := l.annotations[key]; !ok {
+				l.annotations[key] = value
+			}
+		}
+	}
+	if name := l.annotations[annotationContainerdName]; name != "" {
+		l.tags = []string{name}
+	}
+	l.platform = configPlatform(l.config)
+	if descriptor.Platform != nil {
+		l.platform = *descriptor.Platform
+	}
+	return l, nil
+}
+
+// readIndex reads the index.json of an OCI layout
+func readIndex(dir string) (*ociIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index.json: %w", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+	return &index, nil
+}
+
+// selectManifest returns the manifest named ref, or the first manifest
+func selectManifest(manifests []ociDescriptor, ref string) (ociDescriptor, error) {
+	if len(manifests) == 0 {
+		return ociDescriptor{}, fmt.Errorf("index.json lists no images")
+	}
+	if ref == "" {
+		return manifests[0], nil
+	}
+	var names []string
+	for _, descriptor := range manifests {
+		name := descriptor.Annotations[annotationRefName]
+		if name == ref || descriptor.Annotations[annotationContainerdName] == ref {
+			return descriptor, nil
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("no image named %q in index.json, it has %s", ref, strings.Join(names, ", "))
+}
+
+// selectPlatform returns the manifest for the platform pasgan runs on, or
+// else the first manifest for a real platform. Attestation manifests are
+// listed with the platform unknown/unknown.
+func selectPlatform(manifests []ociDescriptor) (ociDescriptor, error) {
+	var first *ociDescriptor
+	for i, descriptor := range manifests {
+		platform := descriptor.Platform
+		if platform == nil {
+			platform = &Platform{}
+		}
+		if platform.OS == "unknown" {
+			continue
+		}
+		if platform.OS == "linux" && platform.Architecture == runtime.GOARCH {
+			return descriptor, nil
+		}
+		if first == nil {
+			first = &manifests[i]
+		}
+	}
+	if first == nil {
+		return ociDescriptor{}, fmt.Errorf("the image index lists no image manifests")
+	}
+	return *first, nil
+}
+
+// manifestAnnotations merges the annotations of an index descriptor with
+// those of the manifest it points to, or returns nil if there are none
+func (l *layout) manifestAnnotations(descriptor ociDescriptor) map[string]string {
+	annotations := make(map[string]string)
+	for key, value := range descriptor.Annotations {
+		annotations[key] = value
+	}
+	var manifest ociManifest
+	if data, err := l.readBlob(descriptor.Digest); err == nil && json.Unmarshal(data, &manifest) == nil {
+		for key, value := range manifest.Annotations {
+			annotations[key] = value
+		}
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// configPlatform reads the platform recorded in an image config
+func configPlatform(config []byte) Platform {
+	var platform Platform
+	json.Unmarshal(config, &platform)
+	return platform
+}
+
+// blobPath returns the path of a blob in an OCI layout, or "" for digests
+// that could escape the blobs directory
+func blobPath(digest string) string {
+	algorithm, hash, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hash == "" || strings.ContainsAny(algorithm+hash, "/\\.") {
+		return ""
+	}
+	return path.Join("blobs", algorithm, hash)
+}
+
+// blobDigest returns the digest of a blob stored at blobs/<algorithm>/<hash>,
+// as docker save writes since Docker 25, or "" for other paths
+func blobDigest(blobPath string) string {
+	parts := strings.Split(blobPath, "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return ""
+	}
+	return parts[1] + ":" + parts[2]
+}
+
+// configDigest returns the digest of a docker save config from its path:
+// blobs/sha256/<hash> since Docker 25 and <hash>.json before, where the hash
+// is the image ID
+func configDigest(configPath string) string {
+	if digest := blobDigest(configPath); digest != "" {
+		return digest
+	}
+	hash, ok := strings.CutSuffix(configPath, ".json")
+	if !ok || len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return ""
+	}
+	return "sha256:" + hash
+}
+
+// readBlob reads a blob of an OCI layout by digest
+func (l *layout) readBlob(digest string) ([]byte, error) {
+	blob := blobPath(digest)
+	if blob == "" {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	return l.readFile(blob)
+}
+
+// readFile reads a file of the image by its slash separated path, which
+// must stay within the image directory
+func (l *layout) readFile(name string) ([]byte, error) {
+	file, err := l.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (l *layout) open(name string) (*os.File, error) {
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return nil, fmt.Errorf("%s is outside the image", name)
+	}
+	return os.Open(filepath.Join(l.dir, local))
+}
+
+func (l *layout) Config() ([]byte, error) {
+	return l.config, nil
+}
+
+func (l *layout) Layers() ([]Descriptor, error) {
+	return l.layers, nil
+}
+
+func (l *layout) OpenLayer(i int) (io.ReadCloser, error) {
+	if i < 0 || i >= len(l.layers) {
+		return nil, fmt.Errorf("layer %d out of range", i)
+	}
+	if l.layers[i].Path == "" {
+		return nil, fmt.Errorf("layer %d has an invalid digest %q", i, l.layers[i].Digest)
+	}
+	return l.open(l.layers[i].Path)
+}
+
+func (l *layout) Annotations() map[string]string {
+	return l.annotations
+}
+
+func (l *layout) Platform() Platform {
+	return l.platform
+}
+
+func (l *layout) RepoTags() []string {
+	return l.tags
+}
+
+func (l *layout) Legacy() bool {
+	return l.legacy
+}
+
+func (l *layout) StoredConfig() (Descriptor, []byte) {
+	return l.configDescriptor, l.storedConfig
+}
+
+func (l *layout) StoredManifest() (Descriptor, []byte) {
+	return l.manifestDescriptor, l.storedManifest
+}
+
+func (l *layout) StoredIndexes() []StoredBlob {
+	return l.indexes
+}
+
+func (l *layout) Skipped() []utils.SkippedEntry {
+	return l.skipped
+}
+
+func (l *layout) Close() error {
+	if l.temp == "" {
+		return nil
+	}
+	return utils.RemoveTempDir(l.temp)
+}
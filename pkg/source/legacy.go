@@ -0,0 +1,230 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// v1Layer is the json file of a layer directory in docker save archives.
+// Archives from Docker before 1.10 have no manifest.json, and the json of
+// their top layer is also the image config.
+type v1Layer struct {
+	ID              string    `json:"id"`
+	Parent          string    `json:"parent,omitempty"`
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ContainerConfig struct {
+		// Cmd is the command of the container the layer was committed from
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// v1HistoryEntry is a history entry of an image config
+type v1HistoryEntry struct {
+	Created   string `json:"created"`
+	CreatedBy string `json:"created_by"`
+	Author    string `json:"author,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+// history returns the history entry recorded by the layer
+func (v *v1Layer) history() v1HistoryEntry {
+	return v1HistoryEntry{
+		Created:   v.Created.UTC().Format(time.RFC3339Nano),
+		CreatedBy: strings.Join(v.ContainerConfig.Cmd, " "),
+		Author:    v.Author,
+		Comment:   v.Comment,
+	}
+}
+
+// readRepositories reads the repositories file of docker save archives,
+// which maps repositories and tags to the ID of their top layer. It returns
+// the tags of each ID.
+func readRepositories(dir string) (map[string][]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "repositories"))
+	if err != nil {
+		return nil, err
+	}
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(data, &repositories); err != nil {
+		return nil, fmt.Errorf("failed to parse repositories: %w", err)
+	}
+
+	tags := make(map[string][]string)
+	for repository, tagged := range repositories {
+		for tag, id := range tagged {
+			tags[id] = append(tags[id], repository+":"+tag)
+		}
+	}
+	for id := range tags {
+		sort.Strings(tags[id])
+	}
+	return tags, nil
+}
+
+// loadLegacy reads a v1 docker save archive, written by Docker before 1.10,
+// which has no manifest.json. Each layer is a directory with a json file
+// naming its parent; the history is rebuilt by following the parents of the
+// top layer, and the tags come from the repositories file.
+func loadLegacy(dir string) (*layout, error) {
+	tags, err := readRepositories(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest.json or a legacy repositories file: %w", err)
+	}
+
+	layers, err := readV1Layers(dir)
+	if err != nil {
+		return nil, err
+	}
+	top, err := v1Top(layers, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow the parents from the top layer down to the base
+	var chain []string
+	seen := make(map[string]bool)
+	for id := top; id != ""; id = layers[id].Parent {
+		if _, ok := layers[id]; !ok {
+			return nil, fmt.Errorf("layer %s is missing from the archive", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("layer %s is its own ancestor", id)
+		}
+		seen[id] = true
+		chain = append([]string{id}, chain...)
+	}
+
+	l := &layout{dir: dir, tags: tags[top], legacy: true}
+	var history []v1HistoryEntry
+	for _, id := range chain {
+		l.layers = append(l.layers, Descriptor{Path: path.Join(id, "layer.tar")})
+		history = append(history, layers[id].history())
+	}
+	for i := range l.layers {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(l.layers[i].Path))); err == nil {
+			l.layers[i].Size = info.Size()
+		}
+	}
+
+	// The top layer json is also the image config
+	config, err := l.readFile(path.Join(top, "json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config of layer %s: %w", top, err)
+	}
+	l.config, err = withHistory(config, history)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the config of layer %s: %w", top, err)
+	}
+	l.platform = configPlatform(l.config)
+	return l, nil
+}
+
+// readV1Layers reads the json file of every layer directory in the
+// archive, keyed by the directory name that parents refer to
+func readV1Layers(dir string) (map[string]*v1Layer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the image archive: %w", err)
+	}
+
+	layers := make(map[string]*v1Layer)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "json"))
+		if err != nil {
+			continue
+		}
+		var layer v1Layer
+		if err := json.Unmarshal(data, &layer); err != nil {
+			return nil, fmt.Errorf("failed to parse the json of layer %s: %w", entry.Name(), err)
+		}
+		layers[entry.Name()] = &layer
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("the archive has no manifest.json and no legacy layer directories")
+	}
+	return layers, nil
+}
+
+// v1Top returns the top layer of the image: the layer of the first tag,
+// or else the only layer that is no other layer's parent
+func v1Top(layers map[string]*v1Layer, tags map[string][]string) (string, error) {
+	var tagged []string
+	for id := range tags {
+		if _, ok := layers[id]; ok {
+			tagged = append(tagged, id)
+		}
+	}
+	if len(tagged) > 0 {
+		sort.Slice(tagged, func(i, j int) bool {
+			return tags[tagged[i]][0] < tags[tagged[j]][0]
+		})
+		return tagged[0], nil
+	}
+
+	parents := make(map[string]bool)
+	for _, layer := range layers {
+		parents[layer.Parent] = true
+	}
+	var tops []string
+	for id := range layers {
+		if !parents[id] {
+			tops = append(tops, id)
+		}
+	}
+	if len(tops) != 1 {
+		return "", fmt.Errorf("the archive has %d untagged images, expected one", len(tops))
+	}
+	return tops[0], nil
+}
+
+// v1History rebuilds the history of a manifest.json archive whose config
+// has none from the layer json files that Docker 1.10 to 24 still write.
+// It returns nil if the config has a history or a layer has no json file,
+// since the history must line up with the layers.
+func (l *layout) v1History() []v1HistoryEntry {
+	var config struct {
+		History []json.RawMessage `json:"history"`
+	}
+	if json.Unmarshal(l.config, &config) != nil || len(config.History) > 0 {
+		return nil
+	}
+
+	var history []v1HistoryEntry
+	for _, descriptor := range l.layers {
+		data, err := l.readFile(path.Join(path.Dir(descriptor.Path), "json"))
+		if err != nil {
+			return nil
+		}
+		var layer v1Layer
+		if json.Unmarshal(data, &layer) != nil || len(layer.ContainerConfig.Cmd) == 0 {
+			return nil
+		}
+		history = append(history, layer.history())
+	}
+	return history
+}
+
+// withHistory sets the history of an image config
+func withHistory(config []byte, history []v1HistoryEntry) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %w", err)
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	fields["history"] = data
+	return json.Marshal(fields)
+}
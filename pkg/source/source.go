@@ -0,0 +1,252 @@
+// Package source reads images from where they are stored. An ImageSource
+// gives access to the config, layers, annotations and platform of a single
+// image; sources are chosen from a URI scheme such as oci:path, and new
+// schemes can be registered by other packages.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+// Schemes of the built-in sources
+const (
+	// DockerArchive is a tarball written by docker save, including archives
+	// from Docker before 1.10 that have no manifest.json
+	DockerArchive = "docker-archive"
+	// OCI is an OCI image layout directory
+	OCI = "oci"
+	// OCIArchive is a tarball of an OCI image layout
+	OCIArchive = "oci-archive"
+	// Dir is a directory holding an extracted docker save archive or OCI layout
+	Dir = "dir"
+	// Stdin reads an image archive from standard input, also written as "-"
+	Stdin = "stdin"
+)
+
+// ImageSource gives access to a single image
+type ImageSource interface {
+	// Config returns the image config JSON
+	Config() ([]byte, error)
+	// Layers describes the layers of the image, base layer first
+	Layers() ([]Descriptor, error)
+	// OpenLayer returns the blob of layer i as stored, which may be compressed
+	OpenLayer(i int) (io.ReadCloser, error)
+	// Annotations returns the manifest annotations, nil if there are none
+	Annotations() map[string]string
+	// Platform returns the platform the image was selected for or built for
+	Platform() Platform
+	// Close releases the resources held by the source, such as temporary files
+	Close() error
+}
+
+// Tagged is implemented by sources that know the tags of their image
+type Tagged interface {
+	RepoTags() []string
+}
+
+// Legacy is implemented by sources that can read v1 docker save archives,
+// whose config and history are rebuilt from the layer parent chain
+type Legacy interface {
+	Legacy() bool
+}
+
+// Extracted is implemented by sources read from an archive, to report the
+// entries of the archive that were not extracted, such as paths outside it
+type Extracted interface {
+	Skipped() []utils.SkippedEntry
+}
+
+// Stored is implemented by sources that keep the manifest and config as they
+// were written, with the digests that refer to them, so they can be verified
+type Stored interface {
+	// StoredConfig returns the config as stored, before any changes made to
+	// read it, and its descriptor. The digest is empty if none was recorded.
+	StoredConfig() (Descriptor, []byte)
+	// StoredManifest returns the image manifest and its descriptor, or an
+	// empty descriptor and nil if the image has no manifest blob
+	StoredManifest() (Descriptor, []byte)
+}
+
+// Indexed is implemented by sources that reach the image manifest through
+// image indexes, such as the nested index of a multi-platform image
+type Indexed interface {
+	// StoredIndexes returns the indexes read on the way to the manifest,
+	// outermost first, as stored and with the descriptors that refer to them
+	StoredIndexes() []StoredBlob
+}
+
+// StoredBlob is a blob as it was written, with the descriptor that refers to it
+type StoredBlob struct {
+	Descriptor Descriptor
+	Data       []byte
+}
+
+// Attached is implemented by sources that store artifacts alongside the
+// image, such as the signatures and attestations that cosign writes to OCI
+// layouts and registries
+type Attached interface {
+	// Artifacts returns the manifests attached to the image: those tagged
+	// sha256-<hash>.sig, .att or .sbom after its digest and the referrers
+	// whose subject is the image
+	Artifacts() ([]Artifact, error)
+	// ReadBlob reads a blob of an artifact by digest
+	ReadBlob(digest string) ([]byte, error)
+}
+
+// Artifact is a manifest stored alongside an image that refers to it
+type Artifact struct {
+	// Tag is the name the artifact is stored under, such as
+	// sha256-<hash>.sig, and empty for referrers found by their subject
+	Tag string `json:"tag,omitempty"`
+	// Subject is the digest of the image manifest or index it refers to
+	Subject string `json:"subject"`
+	// ArtifactType is the artifact type of the manifest, or else the media
+	// type of its config
+	ArtifactType string     `json:"artifactType,omitempty"`
+	Descriptor   Descriptor `json:"descriptor"`
+	// Manifest is the artifact manifest as stored
+	Manifest []byte `json:"-"`
+}
+
+// Descriptor describes a blob, such as a layer
+type Descriptor struct {
+	MediaType string `json:"mediaType,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	// Path is the location of the blob within the source, if it has one
+	Path string `json:"path,omitempty"`
+}
+
+// Name returns the path of the blob, or its digest if it has no path
+func (d Descriptor) Name() string {
+	if d.Path != "" {
+		return d.Path
+	}
+	return d.Digest
+}
+
+// Platform is the operating system and architecture of an image
+type Platform struct {
+	OS           string `json:"os,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	parts := []string{p.OS, p.Architecture}
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return strings.Join(parts, "/")
+}
+
+// OpenFunc opens the image at ref, the part of a URI after the scheme
+type OpenFunc func(ref string) (ImageSource, error)
+
+// ContextOpenFunc opens the image at ref and stops once ctx is done. Sources
+// that extract archives keep within opts.Limits.
+type ContextOpenFunc func(ctx context.Context, ref string, opts Options) (ImageSource, error)
+
+// Options controls how a source is opened
+type Options struct {
+	// Limits bound the files extracted from archives, utils.DefaultLimits
+	// for zero fields
+	Limits utils.Limits
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ContextOpenFunc)
+)
+
+// Register makes a source available under scheme, so that Open reads
+// scheme:ref URIs with it. It panics if the scheme is already registered.
+func Register(scheme string, open OpenFunc) {
+	if open == nil {
+		panic("source: Register open function is nil")
+	}
+	RegisterContext(scheme, func(_ context.Context, ref string, _ Options) (ImageSource, error) {
+		return open(ref)
+	})
+}
+
+// RegisterContext is like Register for sources that can be cancelled and
+// limited
+func RegisterContext(scheme string, open ContextOpenFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if open == nil {
+		panic("source: Register open function is nil")
+	}
+	if _, dup := registry[scheme]; dup {
+		panic("source: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = open
+}
+
+// Schemes returns the registered schemes in order
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Split returns the scheme and reference of uri. The scheme is empty when
+// uri does not start with a registered scheme, as for plain paths.
+func Split(uri string) (string, string) {
+	scheme, ref, ok := strings.Cut(uri, ":")
+	if !ok {
+		return "", uri
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if _, registered := registry[scheme]; !registered {
+		return "", uri
+	}
+	return scheme, ref
+}
+
+// Open opens the image at uri. A uri without a registered scheme is a path:
+// "-" reads standard input, a directory is read as dir: and any other file as
+// docker-archive:, which also accepts OCI archives.
+func Open(uri string) (ImageSource, error) {
+	return OpenContext(context.Background(), uri, Options{})
+}
+
+// OpenContext is like Open, but stops once ctx is done and extracts
+// archives within opts.Limits
+func OpenContext(ctx context.Context, uri string, opts Options) (ImageSource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	scheme, ref := Split(uri)
+	if scheme == "" {
+		scheme = DockerArchive
+		if ref == "-" {
+			scheme = Stdin
+		} else if info, err := os.Stat(ref); err == nil && info.IsDir() {
+			scheme = Dir
+		}
+	}
+
+	registryMu.RLock()
+	open := registry[scheme]
+	registryMu.RUnlock()
+	if open == nil {
+		return nil, fmt.Errorf("no image source registered for %s:", scheme)
+	}
+	return open(ctx, ref, opts)
+}
@@ -0,0 +1,459 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+	"github.com/raesene/pasgan/pkg/utils"
+)
+
+const testConfig = `{"architecture":"amd64","os":"linux","config":{"Cmd":["/app"]},"history":[{"created_by":"COPY app /app"}]}`
+
+var testLayer = testimage.Layer(testimage.Reg("app", "binary"))
+
+// writeArchive writes a docker save archive with one layer
+func writeArchive(t *testing.T, dir string) string {
+	t.Helper()
+	archive := filepath.Join(dir, "image.tar")
+	data := testimage.Layer(
+		testimage.Reg("manifest.json", `[{"Config":"config.json","RepoTags":["example/app:1.0"],"Layers":["abc/layer.tar"]}]`),
+		testimage.Reg("config.json", testConfig),
+		testimage.Reg("abc/layer.tar", string(testLayer)),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+// writeBlob stores data in the blobs of an OCI layout and returns its digest
+func writeBlob(t *testing.T, dir string, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", hash), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return "sha256:" + hash
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func writeJSON(t *testing.T, dir string, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeBlob(t, dir, data)
+}
+
+// writeLayout writes an OCI layout holding a multi-platform image named 1.0,
+// with an attestation manifest listed before the image
+func writeLayout(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	config := writeBlob(t, dir, []byte(testConfig))
+	layer := writeBlob(t, dir, testLayer)
+	manifest := writeJSON(t, dir, map[string]any{
+		"schemaVersion": 2,
+		"config":        map[string]any{"digest": config},
+		"layers":        []map[string]any{{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": layer, "size": len(testLayer)}},
+		"annotations":   map[string]string{"org.opencontainers.image.source": "https://example.com/app"},
+	})
+	attestation := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "config": map[string]any{"digest": config}})
+	index := writeJSON(t, dir, map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{
+			{"digest": attestation, "platform": map[string]string{"os": "unknown", "architecture": "unknown"}},
+			{"digest": manifest, "platform": map[string]string{"os": "linux", "architecture": runtime.GOARCH}},
+		},
+	})
+	top := map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{{
+			"mediaType":   mediaTypeOCIIndex,
+			"digest":      index,
+			"annotations": map[string]string{annotationRefName: "1.0", annotationContainerdName: "example.com/app:1.0"},
+		}},
+	}
+	data, _ := json.Marshal(top)
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// checkImage checks that src reads the test image
+func checkImage(t *testing.T, src ImageSource, tag string) {
+	t.Helper()
+	config, err := src.Config()
+	if err != nil || string(config) != testConfig {
+		t.Errorf("Config() = %s, %v, want the test config", config, err)
+	}
+	layers, err := src.Layers()
+	if err != nil || len(layers) != 1 {
+		t.Fatalf("Layers() = %v, %v, want one layer", layers, err)
+	}
+	rc, err := src.OpenLayer(0)
+	if err != nil {
+		t.Fatalf("OpenLayer(0) error = %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != string(testLayer) {
+		t.Errorf("OpenLayer(0) returned %d bytes, want the layer blob", len(data))
+	}
+	if _, err := src.OpenLayer(1); err == nil {
+		t.Error("OpenLayer(1) error = nil, want out of range")
+	}
+	if platform := src.Platform(); platform.OS != "linux" {
+		t.Errorf("Platform() = %v, want linux", platform)
+	}
+	tagged, ok := src.(Tagged)
+	if !ok || len(tagged.RepoTags()) != 1 || tagged.RepoTags()[0] != tag {
+		t.Errorf("RepoTags() of %T does not return %s", src, tag)
+	}
+}
+
+func TestOpenDockerArchive(t *testing.T) {
+	archive := writeArchive(t, t.TempDir())
+	for _, uri := range []string{archive, "docker-archive:" + archive} {
+		src, err := Open(uri)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", uri, err)
+		}
+		checkImage(t, src, "example/app:1.0")
+		if src.Annotations() != nil {
+			t.Errorf("Annotations() = %v, want none without index.json", src.Annotations())
+		}
+
+		// The extracted archive is removed on Close
+		temp := src.(*layout).temp
+		if err := src.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		if _, err := os.Stat(temp); !os.IsNotExist(err) {
+			t.Errorf("Close() left %s behind", temp)
+		}
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.tar")); err == nil {
+		t.Error("Open() error = nil, want an error for a missing archive")
+	}
+}
+
+func TestOpenOCI(t *testing.T) {
+	dir := writeLayout(t)
+	for _, uri := range []string{"oci:" + dir, "oci:" + dir + ":1.0", "dir:" + dir, dir} {
+		src, err := Open(uri)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", uri, err)
+		}
+		checkImage(t, src, "example.com/app:1.0")
+		annotations := src.Annotations()
+		if annotations["org.opencontainers.image.source"] != "https://example.com/app" || annotations[annotationRefName] != "1.0" {
+			t.Errorf("Open(%s).Annotations() = %v, want the manifest and index annotations", uri, annotations)
+		}
+		layers, _ := src.Layers()
+		if layers[0].MediaType == "" || layers[0].Digest == "" || layers[0].Size != int64(len(testLayer)) {
+			t.Errorf("Open(%s).Layers() = %+v, want the manifest descriptor", uri, layers)
+		}
+		stored := src.(Stored)
+		if descriptor, config := stored.StoredConfig(); descriptor.Digest != digestOf([]byte(testConfig)) || string(config) != testConfig {
+			t.Errorf("Open(%s).StoredConfig() = %+v, want the config and its digest", uri, descriptor)
+		}
+		if descriptor, manifest := stored.StoredManifest(); descriptor.Digest != digestOf(manifest) {
+			t.Errorf("Open(%s).StoredManifest() = %+v, want the manifest and its digest", uri, descriptor)
+		}
+		src.Close()
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("Close() removed the layout: %v", err)
+		}
+	}
+
+	if _, err := Open("oci:" + dir + ":2.0"); err == nil {
+		t.Error("Open() error = nil, want an error for an unknown image name")
+	}
+
+	// The same layout in a tarball
+	archive := filepath.Join(t.TempDir(), "layout.tar")
+	var files []testimage.File
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			data, _ := os.ReadFile(path)
+			name, _ := filepath.Rel(dir, path)
+			files = append(files, testimage.Reg(filepath.ToSlash(name), string(data)))
+		}
+		return nil
+	})
+	if err := os.WriteFile(archive, testimage.Layer(files...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := Open("oci-archive:" + archive + ":1.0")
+	if err != nil {
+		t.Fatalf("Open(oci-archive) error = %v", err)
+	}
+	checkImage(t, src, "example.com/app:1.0")
+	src.Close()
+}
+
+func TestStoredConfig(t *testing.T) {
+	// Before Docker 25 the config is named after the image ID
+	id := strings.TrimPrefix(digestOf([]byte(testConfig)), "sha256:")
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	data := testimage.Layer(
+		testimage.Reg("manifest.json", `[{"Config":"`+id+`.json","Layers":["abc/layer.tar"]}]`),
+		testimage.Reg(id+".json", testConfig),
+		testimage.Reg("abc/layer.tar", string(testLayer)),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := Open(archive)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	stored := src.(Stored)
+	if descriptor, _ := stored.StoredConfig(); descriptor.Digest != "sha256:"+id {
+		t.Errorf("StoredConfig() = %+v, want the image ID as digest", descriptor)
+	}
+	if descriptor, manifest := stored.StoredManifest(); manifest != nil || descriptor.Digest != "" {
+		t.Errorf("StoredManifest() = %+v, want none for docker save", descriptor)
+	}
+
+	// Other config names record no digest
+	src, err = Open(writeArchive(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	if descriptor, config := src.(Stored).StoredConfig(); descriptor.Digest != "" || string(config) != testConfig {
+		t.Errorf("StoredConfig() = %+v, want the config without a digest", descriptor)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	config := writeBlob(t, dir, []byte(testConfig))
+	image := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "config": map[string]any{"digest": config}})
+	tagged := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "config": map[string]any{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": config}})
+	saved := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "config": map[string]any{"digest": config}, "layers": []any{}})
+	referrer := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "artifactType": "application/spdx+json", "config": map[string]any{"digest": config}, "subject": map[string]any{"digest": image}})
+	other := writeJSON(t, dir, map[string]any{"schemaVersion": 2, "config": map[string]any{"digest": config}, "subject": map[string]any{"digest": config}})
+	index := map[string]any{
+		"schemaVersion": 2,
+		"manifests": []map[string]any{
+			{"digest": image, "annotations": map[string]string{"kind": "dev.cosignproject.cosign/image"}},
+			{"digest": tagged, "annotations": map[string]string{annotationContainerdName: "example.com:5000/app:" + strings.Replace(image, ":", "-", 1) + ".att"}},
+			{"digest": saved, "annotations": map[string]string{"kind": "dev.cosignproject.cosign/sigs"}},
+			{"digest": referrer},
+			{"digest": other},
+		},
+	}
+	data, _ := json.Marshal(index)
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Open("oci:" + dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	artifacts, err := src.(Attached).Artifacts()
+	if err != nil {
+		t.Fatalf("Artifacts() error = %v", err)
+	}
+	var got []string
+	for _, artifact := range artifacts {
+		if artifact.Subject != image || len(artifact.Manifest) == 0 {
+			t.Errorf("Artifacts() = %+v, want the image as subject", artifact)
+		}
+		got = append(got, artifact.Descriptor.Digest+" "+artifact.ArtifactType)
+	}
+	want := []string{tagged + " application/vnd.oci.image.config.v1+json", saved + " ", referrer + " application/spdx+json"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Artifacts() = %v, want %v", got, want)
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	file, err := os.Open(writeArchive(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	src, err := ReadArchive(file)
+	if err != nil {
+		t.Fatalf("ReadArchive() error = %v", err)
+	}
+	defer src.Close()
+	checkImage(t, src, "example/app:1.0")
+}
+
+func TestLayerOutsideImage(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `[{"Config":"config.json","Layers":["../outside/layer.tar"]}]`
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Open("dir:" + dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := src.OpenLayer(0); err == nil {
+		t.Error("OpenLayer(0) error = nil, want an error for a layer outside the image")
+	}
+}
+
+// storeSource is an image held by a third-party store
+type storeSource struct {
+	ref string
+}
+
+func (s *storeSource) Config() ([]byte, error) { return []byte(testConfig), nil }
+func (s *storeSource) Layers() ([]Descriptor, error) {
+	return []Descriptor{{Digest: "sha256:abc"}}, nil
+}
+func (s *storeSource) Annotations() map[string]string { return map[string]string{"ref": s.ref} }
+func (s *storeSource) Platform() Platform             { return Platform{OS: "linux", Architecture: "arm64"} }
+func (s *storeSource) Close() error                   { return nil }
+func (s *storeSource) OpenLayer(i int) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("layer %d is not stored", i)
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-store", func(ref string) (ImageSource, error) {
+		return &storeSource{ref: ref}, nil
+	})
+
+	src, err := Open("test-store:team/app@v1")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got := src.Annotations()["ref"]; got != "team/app@v1" {
+		t.Errorf("Open() passed ref %q, want team/app@v1", got)
+	}
+	if got := src.Platform().String(); got != "linux/arm64" {
+		t.Errorf("Platform() = %s, want linux/arm64", got)
+	}
+
+	// Only registered schemes are split off, so Windows drive letters stay paths
+	if scheme, ref := Split(`C:\images\app.tar`); scheme != "" || ref != `C:\images\app.tar` {
+		t.Errorf("Split() = %q, %q, want a plain path", scheme, ref)
+	}
+	if scheme, ref := Split("oci:layout:1.0"); scheme != OCI || ref != "layout:1.0" {
+		t.Errorf("Split() = %q, %q, want oci and layout:1.0", scheme, ref)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() did not panic for a scheme registered twice")
+		}
+	}()
+	Register(OCI, func(string) (ImageSource, error) { return nil, nil })
+}
+
+func TestOpenLimits(t *testing.T) {
+	archive := writeArchive(t, t.TempDir())
+	tests := []struct {
+		name   string
+		limits utils.Limits
+	}{
+		{"file size", utils.Limits{MaxFileSize: 100}},
+		{"total size", utils.Limits{MaxTotalSize: 1000}},
+		{"entries", utils.Limits{MaxEntries: 2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := OpenContext(context.Background(), archive, Options{Limits: tc.limits})
+			if !errors.Is(err, utils.ErrLimitExceeded) {
+				if src != nil {
+					src.Close()
+				}
+				t.Fatalf("OpenContext() error = %v, want ErrLimitExceeded", err)
+			}
+
+			file, err := os.Open(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if _, err := ReadArchiveContext(context.Background(), file, Options{Limits: tc.limits}); !errors.Is(err, utils.ErrLimitExceeded) {
+				t.Errorf("ReadArchiveContext() error = %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+
+	src, err := OpenContext(context.Background(), archive, Options{Limits: utils.Limits{MaxTotalSize: -1, MaxEntries: 3}})
+	if err != nil {
+		t.Fatalf("OpenContext() within the limits error = %v", err)
+	}
+	src.Close()
+}
+
+func TestOpenSkipped(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "image.tar")
+	data := testimage.Layer(
+		testimage.Reg("manifest.json", `[{"Config":"config.json","Layers":["abc/layer.tar"]}]`),
+		testimage.Reg("config.json", testConfig),
+		testimage.Reg("abc/layer.tar", string(testLayer)),
+		testimage.Reg("../outside", "x"),
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Open(archive)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer src.Close()
+	extracted, ok := src.(Extracted)
+	if !ok {
+		t.Fatal("archive source does not report skipped entries")
+	}
+	if skipped := extracted.Skipped(); len(skipped) != 1 || skipped[0].Path != "../outside" {
+		t.Errorf("Skipped() = %v, want ../outside", skipped)
+	}
+}
+
+func TestOpenCancelled(t *testing.T) {
+	archive := writeArchive(t, t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OpenContext(ctx, archive, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("OpenContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := ReadArchiveContext(ctx, strings.NewReader("not read"), Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadArchiveContext() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FormatSize renders a byte count using binary units, e.g. "12.3 MiB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < 0 {
+		return "-" + FormatSize(-size)
+	}
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseSize reads a byte count written as a number and an optional binary
+// unit, such as "512", "100MiB" or "1.5G". The units K, KB and KiB all mean
+// 1024 bytes. Negative counts are returned as they are.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, unicode.IsLetter)
+	unit := strings.ToUpper(strings.TrimSpace(s[len(number):]))
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+	multiplier := float64(1)
+	if unit != "" {
+		exp := strings.Index("KMGTPE", unit)
+		if len(unit) != 1 || exp < 0 {
+			return 0, fmt.Errorf("invalid size unit in %q", s)
+		}
+		multiplier = math.Pow(1024, float64(exp+1))
+	}
+	size := value * multiplier
+	if size > math.MaxInt64 || size < math.MinInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(size), nil
+}
@@ -2,102 +2,501 @@ package utils
 
 import (
 	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/raesene/pasgan/internal/archive"
 )
 
-// ExtractTar extracts a tar file to a destination directory
+// sparsePrefix is the PAX record prefix of GNU sparse files
+const sparsePrefix = "GNU.sparse."
+
+// ErrLimitExceeded is returned when an archive is larger than the limits
+// allow
+var ErrLimitExceeded = errors.New("archive exceeds extraction limit")
+
+// Limits bound what is extracted from an archive. A zero field takes its
+// value from DefaultLimits and a negative field removes the limit.
+type Limits struct {
+	// MaxTotalSize is the number of bytes written across all files
+	MaxTotalSize int64
+	// MaxEntries is the number of tar entries read
+	MaxEntries int
+	// MaxFileSize is the size of the largest file
+	MaxFileSize int64
+}
+
+// DefaultLimits are generous enough for any real image archive while
+// stopping one that would fill the disk
+var DefaultLimits = Limits{
+	MaxTotalSize: 64 << 30,
+	MaxEntries:   1000000,
+	MaxFileSize:  16 << 30,
+}
+
+// withDefaults fills the zero fields of l from DefaultLimits
+func (l Limits) withDefaults() Limits {
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultLimits.MaxEntries
+	}
+	if l.MaxFileSize == 0 {
+		l.MaxFileSize = DefaultLimits.MaxFileSize
+	}
+	return l
+}
+
+// TotalSize returns the total size limit in bytes, or -1 if there is none
+func (l Limits) TotalSize() int64 {
+	if size := l.withDefaults().MaxTotalSize; size > 0 {
+		return size
+	}
+	return -1
+}
+
+// FileSize returns the largest file size in bytes, or -1 if there is no limit
+func (l Limits) FileSize() int64 {
+	if size := l.withDefaults().MaxFileSize; size > 0 {
+		return size
+	}
+	return -1
+}
+
+// Entries returns the most entries to read, or -1 if there is no limit
+func (l Limits) Entries() int {
+	if entries := l.withDefaults().MaxEntries; entries > 0 {
+		return entries
+	}
+	return -1
+}
+
+// ContextReader returns a reader that fails with the error of ctx once ctx
+// is done, so that long reads can be cancelled
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// SkippedEntry is an archive entry, or part of one, that was not extracted
+type SkippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ExtractResult counts the entries written by ExtractTarContext
+type ExtractResult struct {
+	Files     int
+	Dirs      int
+	Symlinks  int
+	Hardlinks int
+	// Skipped are the entries left out, such as those that would be written
+	// outside the destination, and the attributes that could not be set
+	Skipped []SkippedEntry
+}
+
+// ExtractTar extracts a tar file to a destination directory with the
+// default limits
 func ExtractTar(tarPath, destDir string) error {
+	_, err := ExtractTarContext(context.Background(), tarPath, destDir, Limits{})
+	return err
+}
+
+// ExtractTarContext extracts a tar file to a destination directory. Every
+// file is written through an os.Root for destDir, so no entry can be written
+// outside it, even through symlinks already on disk. Entries with absolute
+// paths or paths leaving destDir, symlinks pointing outside it and entry
+// types other than files, directories and links are skipped and reported in
+// the result.
+//
+// Modes, modification times, extended attributes and the holes of sparse
+// files are kept. The owner can always read and write what is extracted, so
+// that it can be read and removed. Extraction stops with the error of ctx
+// once ctx is done, and with ErrLimitExceeded when the archive is larger
+// than limits allow.
+func ExtractTarContext(ctx context.Context, tarPath, destDir string, limits Limits) (*ExtractResult, error) {
 	file, err := os.Open(tarPath)
 	if err != nil {
-		return fmt.Errorf("failed to open tar file: %w", err)
+		return nil, fmt.Errorf("failed to open tar file: %w", err)
 	}
 	defer file.Close()
 
-	tr := tar.NewReader(file)
-	
 	// Create destination directory if it doesn't exist
 	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+		return nil, fmt.Errorf("failed to create destination directory: %w", err)
 	}
-
-	// Get absolute path for destination directory to check for path traversal
-	destDirAbs, err := filepath.Abs(destDir)
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open destination directory: %w", err)
+	}
+	defer root.Close()
+	dir, err := filepath.Abs(destDir)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for destination: %w", err)
+		return nil, fmt.Errorf("failed to get absolute path for destination: %w", err)
 	}
 
+	x := &extractor{
+		root:   root,
+		dir:    dir,
+		limits: limits.withDefaults(),
+		result: &ExtractResult{},
+		dirs:   make(map[string]*tar.Header),
+	}
+	tr := tar.NewReader(ContextReader(ctx, file))
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("error reading tar: %w", err)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return x.result, ctxErr
+			}
+			return x.result, fmt.Errorf("error reading tar: %w", err)
+		}
+		if err := x.extract(header, tr); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return x.result, ctxErr
+			}
+			return x.result, err
 		}
+	}
 
-		// Skip entries with absolute paths or path traversal attempts
-		if filepath.IsAbs(header.Name) || strings.Contains(header.Name, "..") {
-			continue
+	x.finishDirs()
+	return x.result, nil
+}
+
+// extractor writes the entries of one archive below root
+type extractor struct {
+	root    *os.Root
+	dir     string
+	limits  Limits
+	result  *ExtractResult
+	entries int
+	total   int64
+	// dirs are the headers of the directories, whose modes and times are set
+	// last so that their entries can still be written
+	dirs map[string]*tar.Header
+}
+
+// skip records an entry that was not fully extracted
+func (x *extractor) skip(name, reason string) {
+	x.result.Skipped = append(x.result.Skipped, SkippedEntry{Path: name, Reason: reason})
+}
+
+// entryPath returns the slash-separated path of an entry relative to the
+// root, or a reason to skip it
+func entryPath(name string) (string, string) {
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", "absolute path"
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", "path outside the destination"
+	}
+	return clean, ""
+}
+
+// extract writes a single entry
+func (x *extractor) extract(header *tar.Header, r io.Reader) error {
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, x.limits.MaxEntries)
+	}
+
+	name, reason := entryPath(header.Name)
+	if reason != "" {
+		x.skip(header.Name, reason)
+		return nil
+	}
+	if name == "." {
+		return nil
+	}
+	target := filepath.FromSlash(name)
+
+	// Parents missing from the archive are created through the root, which
+	// refuses paths that leave it through symlinks already on disk
+	if parent := filepath.Dir(target); parent != "." {
+		if err := x.root.MkdirAll(parent, 0755); err != nil {
+			x.skip(name, fmt.Sprintf("parent directory: %v", err))
+			return nil
 		}
+	}
 
-		// Construct target path
-		target := filepath.Join(destDir, header.Name)
-		
-		// Check for path traversal
-		targetAbs, err := filepath.Abs(target)
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if info, err := x.root.Lstat(target); err == nil && !info.IsDir() {
+			if err := x.root.Remove(target); err != nil {
+				x.skip(name, err.Error())
+				return nil
+			}
+		}
+		if err := x.root.MkdirAll(target, 0755); err != nil {
+			x.skip(name, err.Error())
+			return nil
+		}
+		x.dirs[target] = header
+		x.result.Dirs++
+		x.setXattrs(name, target, header)
+		return nil
+
+	case tar.TypeReg, tar.TypeGNUSparse:
+		// Check the size in the header first so that nothing is written
+		// for a file that is too large
+		if x.limits.MaxFileSize > 0 && header.Size > x.limits.MaxFileSize {
+			return fmt.Errorf("%w: %s is larger than %s", ErrLimitExceeded, name, FormatSize(x.limits.MaxFileSize))
+		}
+		if x.limits.MaxTotalSize > 0 && x.total+header.Size > x.limits.MaxTotalSize {
+			return fmt.Errorf("%w: more than %s in total", ErrLimitExceeded, FormatSize(x.limits.MaxTotalSize))
+		}
+		x.total += header.Size
+		if err := x.replace(target); err != nil {
+			x.skip(name, err.Error())
+			return nil
+		}
+		file, err := x.root.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 		if err != nil {
-			return fmt.Errorf("error resolving path: %w", err)
+			x.skip(name, err.Error())
+			return nil
 		}
-		if !strings.HasPrefix(targetAbs, destDirAbs) {
-			continue // Skip this file for security
+		if err := writeFile(file, header, r); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
 		}
+		x.result.Files++
 
-		// Create parent directories if needed
-		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory: %w", err)
+	case tar.TypeSymlink:
+		if reason := x.symlinkReason(name, header.Linkname); reason != "" {
+			x.skip(name, reason)
+			return nil
+		}
+		if err := x.replace(target); err != nil {
+			x.skip(name, err.Error())
+			return nil
 		}
+		if err := x.root.Symlink(filepath.FromSlash(header.Linkname), target); err != nil {
+			x.skip(name, fmt.Sprintf("symlink: %v", err))
+			return nil
+		}
+		x.result.Symlinks++
+		// Modes and times of symlinks are not portable, so stop here
+		return nil
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
-			}
-		case tar.TypeReg:
-			outFile, err := os.Create(target)
-			if err != nil {
-				return fmt.Errorf("failed to create file: %w", err)
-			}
-			
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
-				return fmt.Errorf("failed to write file: %w", err)
-			}
-			outFile.Close()
-		case tar.TypeSymlink:
-			// Create symlinks only if they point inside the destination
-			linkTarget := filepath.Join(filepath.Dir(target), header.Linkname)
-			linkTargetAbs, err := filepath.Abs(linkTarget)
-			if err != nil {
-				continue
-			}
-			if !strings.HasPrefix(linkTargetAbs, destDirAbs) {
-				continue
-			}
-			
-			// Create the symlink
-			if err := os.Symlink(header.Linkname, target); err != nil {
-				// Non-fatal, just continue
-				continue
+	case tar.TypeLink:
+		source, reason := entryPath(header.Linkname)
+		if reason != "" {
+			x.skip(name, "hardlink target: "+reason)
+			return nil
+		}
+		// A hardlink to a symlink would copy the link to another directory,
+		// where it could lead somewhere else
+		if info, err := x.root.Lstat(filepath.FromSlash(source)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			x.skip(name, "hardlink to the symlink "+source)
+			return nil
+		}
+		if err := x.replace(target); err != nil {
+			x.skip(name, err.Error())
+			return nil
+		}
+		if err := x.root.Link(filepath.FromSlash(source), target); err != nil {
+			x.skip(name, fmt.Sprintf("hardlink to %s: %v", header.Linkname, err))
+			return nil
+		}
+		x.result.Hardlinks++
+		// A hardlink shares its inode with the source, so there is nothing more to set
+		return nil
+
+	default:
+		x.skip(name, fmt.Sprintf("unsupported entry type %q", header.Typeflag))
+		return nil
+	}
+
+	x.setXattrs(name, target, header)
+	if err := x.root.Chmod(target, fileMode(header, 0600)); err != nil {
+		x.skip(name, fmt.Sprintf("mode: %v", err))
+	}
+	x.setTimes(name, target, header)
+	return nil
+}
+
+// symlinkReason returns a reason to skip a symlink at name to target, or ""
+// if the link can never lead outside the root. The root alone cannot ensure
+// that, since the extracted files are read with plain paths afterwards, so a
+// link must be relative, may only climb above its own directory with leading
+// "..", never past the root, and may not be written below another symlink,
+// which would change what its ".." means.
+func (x *extractor) symlinkReason(name, target string) string {
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "absolute symlink target " + target
+	}
+
+	depth := 0
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if info, err := x.root.Lstat(filepath.FromSlash(dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "symlink below the symlink " + dir
+		}
+		depth++
+	}
+
+	up, climbing := 0, true
+	for _, part := range strings.Split(target, "/") {
+		switch {
+		case part == "" || part == ".":
+		case part == "..":
+			if !climbing {
+				return "symlink target " + target + " climbs after descending"
 			}
+			up++
 		default:
-			// Skip other types for simplicity
-			continue
+			climbing = false
 		}
 	}
+	if up > depth {
+		return "symlink target " + target + " outside the destination"
+	}
+	return ""
+}
 
-	return nil
-}
\ No newline at end of file
+// replace removes what an earlier entry wrote at target, except directories
+func (x *extractor) replace(target string) error {
+	info, err := x.root.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return x.root.RemoveAll(target)
+	}
+	return x.root.Remove(target)
+}
+
+// writeFile writes the contents of a regular file and closes it. The holes
+// of sparse files are skipped rather than written as zeros.
+func writeFile(file *os.File, header *tar.Header, r io.Reader) error {
+	var err error
+	if isSparse(header) {
+		err = copySparse(file, r, header.Size)
+	} else {
+		_, err = io.Copy(file, r)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isSparse reports whether header describes a GNU sparse file, whose holes
+// the tar reader fills with zeros
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, sparsePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sparseBlock is the size of the zero runs that copySparse leaves as holes
+const sparseBlock = 4096
+
+// copySparse writes r to file, seeking over blocks of zeros, and sets the
+// file to size so that a trailing hole is kept
+func copySparse(file *os.File, r io.Reader, size int64) error {
+	buf := make([]byte, sparseBlock)
+	zero := make([]byte, sparseBlock)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zero[:n]) {
+				if _, err := file.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := file.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return file.Truncate(size)
+}
+
+// fileMode returns the permissions of header with the owner bits in owner
+// added, so the extracted files can be read and removed
+func fileMode(header *tar.Header, owner os.FileMode) os.FileMode {
+	return header.FileInfo().Mode().Perm() | owner
+}
+
+// setTimes sets the access and modification times of target
+func (x *extractor) setTimes(name, target string, header *tar.Header) {
+	if header.ModTime.IsZero() {
+		return
+	}
+	access := header.AccessTime
+	if access.IsZero() {
+		access = header.ModTime
+	}
+	if err := x.root.Chtimes(target, access, header.ModTime); err != nil {
+		x.skip(name, fmt.Sprintf("times: %v", err))
+	}
+}
+
+// setXattrs applies extended attributes recorded in PAX headers
+func (x *extractor) setXattrs(name, target string, header *tar.Header) {
+	for _, attr := range archive.Xattrs(header) {
+		// The target was created through the root, so its path stays inside
+		if err := archive.SetXattr(filepath.Join(x.dir, target), attr.Name, attr.Value); err != nil {
+			x.skip(name, fmt.Sprintf("xattr %s: %v", attr.Name, err))
+		}
+	}
+}
+
+// finishDirs applies directory modes and times, deepest first
+func (x *extractor) finishDirs() {
+	dirs := make([]string, 0, len(x.dirs))
+	for dir := range x.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	for _, dir := range dirs {
+		header := x.dirs[dir]
+		name := filepath.ToSlash(dir)
+		if info, err := x.root.Lstat(dir); err != nil || !info.IsDir() {
+			// Replaced by a later entry
+			continue
+		}
+		if err := x.root.Chmod(dir, fileMode(header, 0700)); err != nil {
+			x.skip(name, fmt.Sprintf("mode: %v", err))
+		}
+		x.setTimes(name, dir, header)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	tempMu   sync.Mutex
+	tempDirs = make(map[string]bool)
+)
+
+// TempDir creates a temporary directory, as os.MkdirTemp does, and records
+// it so that RemoveTempDirs can remove it if the program is interrupted
+// before it is cleaned up
+func TempDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	tempMu.Lock()
+	tempDirs[dir] = true
+	tempMu.Unlock()
+	return dir, nil
+}
+
+// RemoveTempDir removes a directory created by TempDir
+func RemoveTempDir(dir string) error {
+	tempMu.Lock()
+	delete(tempDirs, dir)
+	tempMu.Unlock()
+	return os.RemoveAll(dir)
+}
+
+// RemoveTempDirs removes every directory created by TempDir that has not
+// been removed yet
+func RemoveTempDirs() error {
+	tempMu.Lock()
+	dirs := make([]string, 0, len(tempDirs))
+	for dir := range tempDirs {
+		dirs = append(dirs, dir)
+	}
+	tempDirs = make(map[string]bool)
+	tempMu.Unlock()
+
+	var errs []error
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/pasgan/internal/testimage"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"-1", -1},
+		{"1K", 1024},
+		{"100MiB", 100 << 20},
+		{"1.5 GB", 3 << 29},
+		{"2t", 2 << 40},
+	}
+	for _, tc := range tests {
+		if got, err := ParseSize(tc.in); err != nil || got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "GiB", "10 parsecs", "1e30G"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestExtractTarContext(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "files.tar")
+	readOnly := testimage.Dir("etc")
+	readOnly.Mode = 0555
+	script := testimage.Reg("etc/run.sh", "#!/bin/sh")
+	script.Mode = 0750
+	data := testimage.Layer(readOnly, testimage.Reg("etc/hostname", "pasgan"), script,
+		testimage.Hardlink("etc/hostname.bak", "etc/hostname"), testimage.Symlink("hostname", "etc/hostname"),
+		testimage.Reg("big", "0123456789"))
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	all := filepath.Join(dir, "all")
+	result, err := ExtractTarContext(context.Background(), archive, all, Limits{})
+	if err != nil {
+		t.Fatalf("ExtractTarContext() error = %v", err)
+	}
+	defer os.Chmod(filepath.Join(all, "etc"), 0755)
+	if result.Files != 3 || result.Dirs != 1 || result.Hardlinks != 1 || result.Symlinks != 1 || len(result.Skipped) != 0 {
+		t.Errorf("ExtractTarContext() = %+v, want 3 files, a directory and two links", result)
+	}
+	for _, name := range []string{"etc/hostname", "etc/hostname.bak", "hostname"} {
+		if body, err := os.ReadFile(filepath.Join(all, name)); err != nil || string(body) != "pasgan" {
+			t.Errorf("extracted %s = %q, %v", name, body, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(all, "etc", "run.sh")); err != nil || info.Mode().Perm() != 0750 || !info.ModTime().Equal(testimage.Modified) {
+		t.Errorf("etc/run.sh = %v, %v, want mode 0750 and the archive time", info.Mode(), err)
+	}
+	if info, err := os.Stat(filepath.Join(all, "etc")); err != nil || info.Mode().Perm() != 0755 || !info.ModTime().Equal(testimage.Modified) {
+		t.Errorf("etc = %v, %v, want the owner bits added to 0555 and the archive time", info.Mode(), err)
+	}
+
+	// The oversized file is refused before anything is written for it
+	_, err = ExtractTarContext(context.Background(), archive, filepath.Join(dir, "small"), Limits{MaxFileSize: 8})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ExtractTarContext() error = %v, want ErrLimitExceeded", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "small", "big")); !os.IsNotExist(err) {
+		t.Errorf("file over the limit was written: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ExtractTarContext(ctx, archive, filepath.Join(dir, "cancelled"), Limits{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ExtractTarContext() error = %v, want context.Canceled", err)
+	}
+}
+
+// extractAdversarial extracts files into dir/pasgan-1 next to an outside
+// directory dir/pasgan-10, which must stay empty, and returns the paths of
+// the skipped entries
+func extractAdversarial(t *testing.T, prepare func(dest, outside string), files ...testimage.File) map[string]bool {
+	t.Helper()
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "pasgan-1")
+	outside := filepath.Join(dir, "pasgan-10")
+	for _, d := range []string{dest, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prepare != nil {
+		prepare(dest, outside)
+	}
+	archive := filepath.Join(dir, "evil.tar")
+	if err := os.WriteFile(archive, testimage.Layer(files...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ExtractTarContext(context.Background(), archive, dest, Limits{})
+	if err != nil {
+		t.Fatalf("ExtractTarContext() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("ExtractTarContext() wrote %v outside the destination", entries)
+	}
+	skipped := make(map[string]bool)
+	for _, entry := range result.Skipped {
+		skipped[entry.Path] = true
+	}
+	return skipped
+}
+
+func TestExtractTarZipSlip(t *testing.T) {
+	skipped := extractAdversarial(t, nil,
+		testimage.Reg("../pasgan-10/pwned", "x"),
+		testimage.Reg("a/../../pasgan-10/pwned", "x"),
+		testimage.Reg("/abs", "x"),
+		testimage.Reg("./ok", "fine"),
+	)
+	for _, name := range []string{"../pasgan-10/pwned", "a/../../pasgan-10/pwned", "/abs"} {
+		if !skipped[name] {
+			t.Errorf("%s was not reported as skipped, got %v", name, skipped)
+		}
+	}
+	if skipped["ok"] {
+		t.Error("ok was skipped")
+	}
+}
+
+func TestExtractTarSymlinkEscape(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []testimage.File
+		skipped []string
+	}{
+		// A sibling whose name starts with the destination's is still outside
+		{"sibling prefix", []testimage.File{testimage.Symlink("link", "../pasgan-10"), testimage.Reg("link/pwned", "x")}, []string{"link"}},
+		{"absolute", []testimage.File{testimage.Symlink("etc", "/etc"), testimage.Reg("etc/pwned", "x")}, []string{"etc"}},
+		{"climb after descending", []testimage.File{testimage.Dir("d"), testimage.Symlink("up", "d/../..")}, []string{"up"}},
+		{"below a symlink", []testimage.File{testimage.Symlink("here", "."), testimage.Symlink("here/up", "..")}, []string{"here/up"}},
+		{"hardlink to symlink", []testimage.File{testimage.Symlink("a/up", "../b"), testimage.Hardlink("moved", "a/up")}, []string{"moved"}},
+		{"hardlink outside", []testimage.File{testimage.Hardlink("passwd", "../pasgan-10/passwd")}, []string{"passwd"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			skipped := extractAdversarial(t, nil, tc.files...)
+			for _, name := range tc.skipped {
+				if !skipped[name] {
+					t.Errorf("%s was not reported as skipped, got %v", name, skipped)
+				}
+			}
+		})
+	}
+
+	// Symlinks already in the destination are not followed outside it
+	skipped := extractAdversarial(t, func(dest, outside string) {
+		if err := os.Symlink(outside, filepath.Join(dest, "escape")); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}, testimage.Reg("escape/pwned", "x"))
+	if !skipped["escape/pwned"] {
+		t.Errorf("escape/pwned was not reported as skipped, got %v", skipped)
+	}
+}
+
+// sparseArchive returns a tar holding name as a PAX GNU sparse file of size
+// bytes with data at offset, which tar.Writer cannot write itself
+func sparseArchive(name, data string, offset, size int64) []byte {
+	records := ""
+	for _, kv := range [][2]string{
+		{"GNU.sparse.numblocks", "1"},
+		{"GNU.sparse.map", fmt.Sprintf("%d,%d", offset, len(data))},
+		{"GNU.sparse.size", fmt.Sprint(size)},
+	} {
+		record := " " + kv[0] + "=" + kv[1] + "\n"
+		length := len(record) + 1
+		for len(fmt.Sprint(length))+len(record) != length {
+			length++
+		}
+		records += fmt.Sprint(length) + record
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "PaxHeaders/" + name, Typeflag: tar.TypeReg, Size: int64(len(records)), Mode: 0644, Format: tar.FormatUSTAR})
+	tw.Write([]byte(records))
+	tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(data)), Mode: 0644, Format: tar.FormatUSTAR})
+	tw.Write([]byte(data))
+	tw.Close()
+
+	// Turn the first entry into the PAX header of the second
+	archive := buf.Bytes()
+	archive[156] = tar.TypeXHeader
+	copy(archive[148:156], "        ")
+	sum := 0
+	for _, b := range archive[:512] {
+		sum += int(b)
+	}
+	copy(archive[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return archive
+}
+
+func TestExtractTarSparse(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "sparse.tar")
+	if err := os.WriteFile(archive, sparseArchive("disk.img", "data", 1<<20, 2<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractTarContext(context.Background(), archive, filepath.Join(dir, "out"), Limits{}); err != nil {
+		t.Fatalf("ExtractTarContext() error = %v", err)
+	}
+
+	body, err := os.ReadFile(filepath.Join(dir, "out", "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 2<<20)
+	copy(want[1<<20:], "data")
+	if !bytes.Equal(body, want) {
+		t.Errorf("extracted sparse file has %d bytes, want the data at 1 MiB in 2 MiB", len(body))
+	}
+}
+
+func TestRemoveTempDirs(t *testing.T) {
+	kept, err := TempDir("pasgan-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := TempDir("pasgan-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveTempDir(removed); err != nil {
+		t.Fatalf("RemoveTempDir() error = %v", err)
+	}
+
+	if err := RemoveTempDirs(); err != nil {
+		t.Fatalf("RemoveTempDirs() error = %v", err)
+	}
+	for _, dir := range []string{kept, removed} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", dir, err)
+		}
+	}
+}